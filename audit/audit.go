@@ -0,0 +1,341 @@
+// Package audit keeps an append-only log of security events: logins, failed
+// authentication, API token use, permission denials and task changes. It
+// answers questions like "who deleted task 42 and from where".
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/logger"
+)
+
+type Event string
+
+const (
+	Login            Event = "login"
+	LoginFailed      Event = "login.failed"
+	Logout           Event = "logout"
+	AuthFailed       Event = "auth.failed"
+	TokenUsed        Event = "token.used"
+	PermissionDenied Event = "permission.denied"
+	TaskCreated      Event = "task.created"
+	TaskUpdated      Event = "task.updated"
+	TaskDeleted      Event = "task.deleted"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+	// ThrottleInterval is how often RecordThrottled writes the same entry
+	ThrottleInterval = time.Minute
+	// maxThrottled caps the entries RecordThrottled remembers
+	maxThrottled = 10000
+)
+
+// Entry is one line of the log. Middleware only knows the user id, handlers
+// also set the user name.
+type Entry struct {
+	Id        int       `json:"id"`
+	Time      time.Time `json:"time"`
+	Event     Event     `json:"event"`
+	UserId    string    `json:"userId,omitempty"`
+	UserName  string    `json:"userName,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	RequestId string    `json:"requestId,omitempty"`
+	// Target is what the event is about, like "task 42"
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// Repeated counts the same entries RecordThrottled left out before this one
+	Repeated int `json:"repeated,omitempty"`
+}
+
+// Request is where a request came from, Record copies it into every entry
+type Request struct {
+	IP        string
+	UserAgent string
+	RequestId string
+}
+
+type requestKey struct{}
+
+// WithRequest sets the origin of the request recorded with its entries
+func WithRequest(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// RequestFrom returns the origin set by WithRequest
+func RequestFrom(ctx context.Context) (Request, bool) {
+	request, ok := ctx.Value(requestKey{}).(Request)
+	return request, ok
+}
+
+// Query selects entries, zero fields match everything
+type Query struct {
+	// Event matches the event and, without a dot, its group: "task" matches
+	// task.created, task.updated and task.deleted
+	Event Event
+	// User matches the user id or name
+	User      string
+	Target    string
+	RequestId string
+	Since     time.Time
+	Until     time.Time
+	// Limit caps Query, Export writes all matches
+	Limit int
+}
+
+type InvalidQueryError struct {
+	Param  string
+	Reason string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Reason)
+}
+
+// Validate checks the limit and time range
+func (q Query) Validate() error {
+	if q.Limit < 0 || q.Limit > MaxQueryLimit {
+		return &InvalidQueryError{Param: "limit", Reason: fmt.Sprintf("must be between 0 and %d", MaxQueryLimit)}
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return &InvalidQueryError{Param: "until", Reason: "before since"}
+	}
+	return nil
+}
+
+func (q Query) matches(entry Entry) bool {
+	switch {
+	case q.Event != "" && entry.Event != q.Event && !strings.HasPrefix(string(entry.Event), string(q.Event)+"."):
+		return false
+	case q.User != "" && entry.UserId != q.User && entry.UserName != q.User:
+		return false
+	case q.Target != "" && entry.Target != q.Target:
+		return false
+	case q.RequestId != "" && entry.RequestId != q.RequestId:
+		return false
+	case !q.Since.IsZero() && entry.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !entry.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// Log appends entries as JSON lines to its file, nothing is ever changed or
+// removed. Queries read the file, so the log isn't kept in memory. A nil Log
+// records nothing.
+type Log struct {
+	file      *os.File
+	path      string
+	lastId    int
+	throttled map[string]*throttle
+	mu        sync.Mutex
+}
+
+// throttle is when RecordThrottled last wrote an entry and how many it left
+// out since
+type throttle struct {
+	recorded time.Time
+	skipped  int
+}
+
+// Open opens the log at path, creating it when missing
+func Open(path string) (*Log, error) {
+	l := &Log{path: path, throttled: map[string]*throttle{}}
+	err := l.scan(func(entry Entry) bool {
+		l.lastId = entry.Id
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l.file, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.endLine(); err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// endLine ends the line of a write cut off by a crash, so the next entry
+// starts on its own line
+func (l *Log) endLine() error {
+	info, err := l.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := l.file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = l.file.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// Record appends entry with the time and the origin of the request in ctx.
+// Errors are logged, a broken audit log doesn't fail requests.
+func (l *Log) Record(ctx context.Context, entry Entry) {
+	if l == nil {
+		return
+	}
+	if request, ok := RequestFrom(ctx); ok {
+		entry.IP = request.IP
+		entry.UserAgent = request.UserAgent
+		entry.RequestId = request.RequestId
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.Id = l.lastId + 1
+	entry.Time = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error.Printf("audit: error encoding %s entry: %v", entry.Event, err)
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		logger.Error.Printf("audit: error writing %s entry: %v", entry.Event, err)
+		return
+	}
+	l.lastId = entry.Id
+}
+
+// RecordThrottled is Record for events every request can cause, like failed
+// authentication. The same event, user, detail and client address is written
+// once per ThrottleInterval, the next entry written counts the ones left out
+// in Repeated.
+func (l *Log) RecordThrottled(ctx context.Context, entry Entry) {
+	if l == nil {
+		return
+	}
+	request, _ := RequestFrom(ctx)
+	key := strings.Join([]string{string(entry.Event), entry.UserId, entry.Detail, request.IP}, "\x00")
+	now := time.Now()
+	l.mu.Lock()
+	last, ok := l.throttled[key]
+	if ok && now.Sub(last.recorded) < ThrottleInterval {
+		last.skipped++
+		l.mu.Unlock()
+		return
+	}
+	if ok {
+		entry.Repeated = last.skipped
+	}
+	if len(l.throttled) >= maxThrottled {
+		for key, last := range l.throttled {
+			if now.Sub(last.recorded) >= ThrottleInterval {
+				delete(l.throttled, key)
+			}
+		}
+	}
+	// when still full, entries of new keys are all written
+	if ok || len(l.throttled) < maxThrottled {
+		l.throttled[key] = &throttle{recorded: now}
+	}
+	l.mu.Unlock()
+	l.Record(ctx, entry)
+}
+
+// Query returns the newest entries matching query, newest first
+func (l *Log) Query(query Query) ([]Entry, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	limit := query.Limit
+	if limit == 0 {
+		limit = DefaultQueryLimit
+	}
+	// a ring of the last limit matches
+	ring := make([]Entry, 0, limit)
+	next := 0
+	err := l.scan(func(entry Entry) bool {
+		if !query.matches(entry) {
+			return true
+		}
+		if len(ring) < limit {
+			ring = append(ring, entry)
+		} else {
+			ring[next] = entry
+		}
+		next = (next + 1) % limit
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(ring))
+	for i := range entries {
+		entries[i] = ring[(next-1-i+2*len(ring))%len(ring)]
+	}
+	return entries, nil
+}
+
+// Export writes the entries matching query as JSON lines, oldest first
+func (l *Log) Export(w io.Writer, query Query) error {
+	if err := query.Validate(); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	var writeErr error
+	err := l.scan(func(entry Entry) bool {
+		if query.matches(entry) {
+			writeErr = encoder.Encode(entry)
+		}
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// Close closes the file, later entries are dropped with an error
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// scan calls visit with every entry in the file until visit returns false.
+// Entries are complete lines, the last line of a crashed write is skipped.
+func (l *Log) scan(visit func(Entry) bool) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logger.Error.Printf("audit: skipping unreadable entry in %s: %v", l.path, err)
+			continue
+		}
+		if !visit(entry) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openLog(t *testing.T, path string) *Log {
+	t.Helper()
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func TestLog_RecordQuery(t *testing.T) {
+	log := openLog(t, filepath.Join(t.TempDir(), "audit.jsonl"))
+	ctx := WithRequest(context.Background(), Request{IP: "10.0.0.7", UserAgent: "curl/8.0", RequestId: "req-1"})
+
+	log.Record(ctx, Entry{Event: Login, UserId: "id-jane", UserName: "jane"})
+	log.Record(ctx, Entry{Event: TaskCreated, UserId: "id-jane", UserName: "jane", Target: "task 42"})
+	log.Record(context.Background(), Entry{Event: AuthFailed, Detail: "no credentials"})
+	log.Record(ctx, Entry{Event: TaskDeleted, UserId: "id-joe", UserName: "joe", Target: "task 42"})
+
+	tests := []struct {
+		name    string
+		query   Query
+		wantIds []int
+	}{
+		{"all newest first", Query{}, []int{4, 3, 2, 1}},
+		{"event", Query{Event: TaskDeleted}, []int{4}},
+		{"event group", Query{Event: "task"}, []int{4, 2}},
+		{"group needs a whole word", Query{Event: "tas"}, nil},
+		{"user name", Query{User: "jane"}, []int{2, 1}},
+		{"user id", Query{User: "id-joe"}, []int{4}},
+		{"target", Query{Target: "task 42"}, []int{4, 2}},
+		{"request id", Query{RequestId: "req-1"}, []int{4, 2, 1}},
+		{"limit keeps the newest", Query{Limit: 3}, []int{4, 3, 2}},
+		{"since", Query{Since: time.Now().Add(-time.Minute)}, []int{4, 3, 2, 1}},
+		{"until is exclusive", Query{Until: time.Now().Add(-time.Minute)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := log.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			var ids []int
+			for _, entry := range entries {
+				ids = append(ids, entry.Id)
+			}
+			if len(ids) != len(tt.wantIds) {
+				t.Fatalf("Query() ids = %v, want %v", ids, tt.wantIds)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIds[i] {
+					t.Fatalf("Query() ids = %v, want %v", ids, tt.wantIds)
+				}
+			}
+		})
+	}
+
+	deleted, _ := log.Query(Query{Event: TaskDeleted})
+	entry := deleted[0]
+	if entry.UserName != "joe" || entry.IP != "10.0.0.7" || entry.UserAgent != "curl/8.0" || entry.RequestId != "req-1" || entry.Time.IsZero() {
+		t.Errorf("Expected the deletion with user and origin, got %+v", entry)
+	}
+}
+
+func TestLog_Export(t *testing.T) {
+	log := openLog(t, filepath.Join(t.TempDir(), "audit.jsonl"))
+	for _, event := range []Event{Login, TaskUpdated, Logout} {
+		log.Record(context.Background(), Entry{Event: event, UserName: "jane"})
+	}
+
+	var buf bytes.Buffer
+	if err := log.Export(&buf, Query{Event: "task", Limit: 1}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if buf.String() == "" || bytes.Count(buf.Bytes(), []byte("\n")) != 1 {
+		t.Fatalf("Expected one line, got %q", buf.String())
+	}
+
+	buf.Reset()
+	log.Export(&buf, Query{})
+	var events []Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Unexpected error decoding line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, entry.Event)
+	}
+	if len(events) != 3 || events[0] != Login || events[2] != Logout {
+		t.Errorf("Expected all events oldest first, got %v", events)
+	}
+}
+
+func TestLog_RecordThrottled(t *testing.T) {
+	log := openLog(t, filepath.Join(t.TempDir(), "audit.jsonl"))
+	ctx := WithRequest(context.Background(), Request{IP: "10.0.0.7"})
+	other := WithRequest(context.Background(), Request{IP: "10.0.0.8"})
+	failed := Entry{Event: AuthFailed, Detail: "no credentials"}
+
+	for i := 0; i < 3; i++ {
+		log.RecordThrottled(ctx, failed)
+	}
+	log.RecordThrottled(other, failed)
+	log.RecordThrottled(ctx, Entry{Event: TokenUsed, UserId: "id-jane", Detail: "scopes read"})
+	// the interval passed
+	for _, last := range log.throttled {
+		last.recorded = time.Now().Add(-ThrottleInterval)
+	}
+	log.RecordThrottled(ctx, failed)
+
+	entries, _ := log.Query(Query{Event: AuthFailed})
+	if len(entries) != 3 || entries[0].Repeated != 2 || entries[0].IP != "10.0.0.7" || entries[1].IP != "10.0.0.8" || entries[2].Repeated != 0 {
+		t.Errorf("Expected one entry per address and interval counting the repeats, got %+v", entries)
+	}
+	if used, _ := log.Query(Query{Event: TokenUsed}); len(used) != 1 {
+		t.Errorf("Expected token use recorded once, got %+v", used)
+	}
+}
+
+func TestLog_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, _ := Open(path)
+	log.Record(context.Background(), Entry{Event: Login})
+	log.Record(context.Background(), Entry{Event: Logout})
+	log.Close()
+
+	// a crash cut off the last write
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"id":3,"event":"log`)
+	file.Close()
+
+	reopened := openLog(t, path)
+	reopened.Record(context.Background(), Entry{Event: Login})
+	entries, err := reopened.Query(Query{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(entries) != 3 || entries[0].Id != 3 || entries[0].Event != Login {
+		t.Errorf("Expected the new entry after the kept ones, got %+v", entries)
+	}
+}
+
+func TestQuery_Validate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		query     Query
+		wantParam string
+	}{
+		{"empty", Query{}, ""},
+		{"max limit", Query{Limit: MaxQueryLimit}, ""},
+		{"negative limit", Query{Limit: -1}, "limit"},
+		{"limit too high", Query{Limit: MaxQueryLimit + 1}, "limit"},
+		{"until before since", Query{Since: now, Until: now.Add(-time.Hour)}, "until"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Validate()
+			var queryErr *InvalidQueryError
+			if tt.wantParam == "" && err != nil {
+				t.Fatalf("Validate() error = %v, want nil", err)
+			}
+			if tt.wantParam != "" && (!errors.As(err, &queryErr) || queryErr.Param != tt.wantParam) {
+				t.Fatalf("Validate() error = %v, want invalid %s", err, tt.wantParam)
+			}
+		})
+	}
+}
+
+func TestLog_NilRecordsNothing(t *testing.T) {
+	var log *Log
+	log.Record(context.Background(), Entry{Event: Login})
+}
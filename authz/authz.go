@@ -0,0 +1,117 @@
+// Package authz decides what a user may do with tasks, controllers check it
+// before calling the task service
+package authz
+
+import (
+	"fmt"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type Action string
+
+const (
+	CreateTask       Action = "create tasks"
+	EditTask         Action = "edit this task"
+	DeleteTask       Action = "delete tasks"
+	ManageTemplates  Action = "manage templates"
+	ManageEquipment  Action = "manage equipment"
+	ManageInventory  Action = "manage inventory"
+	RecordStock      Action = "record stock movements"
+	ManageRoles      Action = "manage roles"
+	ManageWorkspaces Action = "manage workspaces"
+	ManageUsers      Action = "manage users"
+	ManageWebhooks   Action = "manage webhooks"
+	ViewAudit        Action = "view the audit log"
+)
+
+// PublicDefaultWorkspace lets anonymous requests read the tasks of the
+// default workspace, main sets it. Without it only members see them.
+var PublicDefaultWorkspace bool
+
+type ForbiddenError struct {
+	Role   users.Role
+	Action Action
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("role %s may not %s", e.Role, e.Action)
+}
+
+// Can reports whether user may do action, task is the current task for
+// EditTask and DeleteTask and ignored otherwise.
+//
+//   - admins may do everything
+//   - managers create and edit any task and manage templates, equipment and
+//     inventory
+//   - brewers create tasks, edit tasks they created or are assigned to and
+//     record stock movements
+//   - viewers only read
+func Can(user users.User, action Action, task *internal.Task) bool {
+	switch user.EffectiveRole() {
+	case users.RoleAdmin:
+		return true
+	case users.RoleManager:
+		switch action {
+		case CreateTask, EditTask, ManageTemplates, ManageEquipment, ManageInventory, RecordStock:
+			return true
+		}
+	case users.RoleBrewer:
+		switch action {
+		case CreateTask, RecordStock:
+			return true
+		case EditTask:
+			return task != nil && isOwner(user, *task)
+		}
+	}
+	return false
+}
+
+// Check is Can returning a ForbiddenError
+func Check(user users.User, action Action, task *internal.Task) error {
+	if !Can(user, action, task) {
+		return &ForbiddenError{Role: user.EffectiveRole(), Action: action}
+	}
+	return nil
+}
+
+// isOwner matches the assignee by id, a user taking the name of a deleted
+// or renamed user doesn't get the tasks assigned to it
+func isOwner(user users.User, task internal.Task) bool {
+	if task.CreatedBy.UserId == user.UserId {
+		return true
+	}
+	return task.AssigneeId != "" && task.AssigneeId == user.UserId.String()
+}
+
+// WorkspaceError is returned for workspaces the user is not a member of,
+// anonymous requests at most have the default workspace
+type WorkspaceError struct {
+	UserName  string
+	Workspace string
+}
+
+func (e *WorkspaceError) Error() string {
+	if e.UserName == "" {
+		return fmt.Sprintf("log in to use workspace %s", e.Workspace)
+	}
+	return fmt.Sprintf("user %s is not a member of workspace %s", e.UserName, e.Workspace)
+}
+
+// CheckWorkspace checks that user, nil for anonymous requests, with
+// membership may use the tasks of workspace. Roles don't matter, admins are
+// members like everyone else. Anonymous requests only get the default
+// workspace with PublicDefaultWorkspace.
+func CheckWorkspace(user *users.User, membership users.Membership, workspace string) error {
+	if user == nil {
+		if workspace == users.DefaultWorkspace && PublicDefaultWorkspace {
+			return nil
+		}
+		return &WorkspaceError{Workspace: workspace}
+	}
+	if !membership.Has(workspace) {
+		return &WorkspaceError{UserName: user.UserName, Workspace: workspace}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestCan(t *testing.T) {
+	brewer := users.User{UserName: "brewer", UserId: uuid.New(), Role: users.RoleBrewer}
+	other := users.User{UserName: "other", UserId: uuid.New()}
+	created := &internal.Task{CreatedBy: brewer}
+	assigned := &internal.Task{CreatedBy: other, Assignee: "brewer", AssigneeId: brewer.UserId.String()}
+	// assigned to an earlier user of the name
+	reused := &internal.Task{CreatedBy: other, Assignee: "brewer", AssigneeId: uuid.NewString()}
+	foreign := &internal.Task{CreatedBy: other, Assignee: "other"}
+
+	tests := []struct {
+		name   string
+		role   users.Role
+		action Action
+		task   *internal.Task
+		want   bool
+	}{
+		{"admin deletes", users.RoleAdmin, DeleteTask, foreign, true},
+		{"admin manages roles", users.RoleAdmin, ManageRoles, nil, true},
+		{"manager edits any task", users.RoleManager, EditTask, foreign, true},
+		{"manager manages templates", users.RoleManager, ManageTemplates, nil, true},
+		{"manager manages equipment", users.RoleManager, ManageEquipment, nil, true},
+		{"manager manages inventory", users.RoleManager, ManageInventory, nil, true},
+		{"manager may not delete", users.RoleManager, DeleteTask, foreign, false},
+		{"manager may not manage roles", users.RoleManager, ManageRoles, nil, false},
+		{"manager may not manage webhooks", users.RoleManager, ManageWebhooks, nil, false},
+		{"brewer creates", users.RoleBrewer, CreateTask, nil, true},
+		{"brewer edits own task", users.RoleBrewer, EditTask, created, true},
+		{"brewer edits assigned task", users.RoleBrewer, EditTask, assigned, true},
+		{"brewer may not edit other tasks", users.RoleBrewer, EditTask, foreign, false},
+		{"brewer may not edit tasks of a reused name", users.RoleBrewer, EditTask, reused, false},
+		{"brewer may not delete own task", users.RoleBrewer, DeleteTask, created, false},
+		{"brewer may not manage templates", users.RoleBrewer, ManageTemplates, nil, false},
+		{"brewer records stock", users.RoleBrewer, RecordStock, nil, true},
+		{"brewer may not manage equipment", users.RoleBrewer, ManageEquipment, nil, false},
+		{"brewer may not manage inventory", users.RoleBrewer, ManageInventory, nil, false},
+		{"users without role are brewers", "", EditTask, created, true},
+		{"viewer may not create", users.RoleViewer, CreateTask, nil, false},
+		{"viewer may not edit own task", users.RoleViewer, EditTask, created, false},
+		{"viewer may not record stock", users.RoleViewer, RecordStock, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := brewer
+			user.Role = tt.role
+			if got := Can(user, tt.action, tt.task); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	viewer := users.User{UserName: "viewer", Role: users.RoleViewer}
+	err := Check(viewer, CreateTask, nil)
+	var forbidden *ForbiddenError
+	if !errors.As(err, &forbidden) {
+		t.Fatalf("Expected ForbiddenError, got %v", err)
+	}
+	if err.Error() != "role viewer may not create tasks" {
+		t.Errorf("Expected message about viewer, got %q", err.Error())
+	}
+	if err := Check(users.User{Role: users.RoleAdmin}, DeleteTask, nil); err != nil {
+		t.Errorf("Expected admin to pass, got %v", err)
+	}
+}
+
+func TestCheckWorkspace(t *testing.T) {
+	user := &users.User{UserName: "brewer"}
+	tests := []struct {
+		name       string
+		user       *users.User
+		membership users.Membership
+		workspace  string
+		public     bool
+		wantErr    bool
+	}{
+		{"anonymous default", nil, nil, users.DefaultWorkspace, false, true},
+		{"anonymous public default", nil, nil, users.DefaultWorkspace, true, false},
+		{"anonymous other", nil, nil, "site-b", true, true},
+		{"no workspaces means default", user, nil, users.DefaultWorkspace, false, false},
+		{"no workspaces other", user, nil, "site-b", false, true},
+		{"member", user, users.Membership{"site-b"}, "site-b", false, false},
+		{"member of other site", user, users.Membership{"site-b"}, users.DefaultWorkspace, true, true},
+		{"admins need membership", &users.User{UserName: "admin", Role: users.RoleAdmin}, users.Membership{"site-a"}, "site-b", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			PublicDefaultWorkspace = tt.public
+			t.Cleanup(func() { PublicDefaultWorkspace = false })
+			err := CheckWorkspace(tt.user, tt.membership, tt.workspace)
+			var workspaceErr *WorkspaceError
+			if tt.wantErr != errors.As(err, &workspaceErr) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
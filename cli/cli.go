@@ -22,7 +22,12 @@ const (
 	CREATE command = "create"
 	UPDATE command = "update"
 	DELETE command = "delete"
+	DONE   command = "done"
 	EXIT   command = "exit"
+
+	TEMPLATES     command = "templates"
+	FROM_TEMPLATE command = "from-template"
+	CHECK         command = "check"
 )
 
 const (
@@ -70,6 +75,7 @@ func (cli *RealCLIApp) AppStarter(newTaskHolder func(diskPath string) *in.TaskHo
 	} else {
 		taskHolder = newTaskHolder(fileName)
 	}
+	loadTemplates(taskHolder)
 	PrintCLITitle(taskHolder.Tasks)
 
 	return taskHolder, false, ExitCodeSuccess, isWeb
@@ -92,6 +98,25 @@ func PopulateTaskHolder(fileName string, savedTasks []in.Task, newTaskHolder fun
 	return taskHolder, nil
 }
 
+// templates are optional, so missing templates file is not an error
+func loadTemplates(taskHolder *in.TaskHolder) {
+	if taskHolder.DiskPath == "" {
+		return
+	}
+	templates, err := in.ReadTemplatesFromJSON(in.TemplatesPath(taskHolder.DiskPath))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Error while reading templates: %v\n", err)
+		}
+		return
+	}
+	for _, tmpl := range templates {
+		if _, err := taskHolder.AddTemplate(tmpl); err != nil {
+			fmt.Printf("Skipping invalid template %d: %v\n", tmpl.Id, err)
+		}
+	}
+}
+
 func ParseUserArg() (fileName string, savedTasks []in.Task, isHelp bool, isExit bool, exitCode int, isWebFlag bool) {
 	helpFlag := flag.Bool("h", false, "Help is here")
 	webFlag := flag.Bool("web", false, "Avoids using CLI")
@@ -135,7 +160,7 @@ func PrintHelp() {
 }
 
 func displayCommands() {
-	fmt.Println("\nAvailable Commands: read, create, update, delete, exit, search, find")
+	fmt.Println("\nAvailable Commands: read, create, update, delete, exit, search, find, templates, from-template, check, done")
 	fmt.Println("Enter Command: ")
 }
 
@@ -152,11 +177,26 @@ func parseCommand(reader *bufio.Reader) (command, int, string, error) {
 	var word string = ""
 	var err error
 
-	if len(parts) > 1 && (cmd == UPDATE || cmd == DELETE || cmd == FIND) {
+	// delete and done take several ids, e.g. done 3 5 7 or delete 10-15
+	if len(parts) > 1 && (cmd == DELETE || cmd == DONE) {
+		ids, err := parseTaskIds(parts[1:])
+		if err != nil {
+			return "", -1, "", err
+		}
+		if cmd == DONE || len(parts) > 2 || len(ids) > 1 {
+			word = strings.Join(parts[1:], " ")
+		}
+		return cmd, ids[0], word, nil
+	}
+
+	if len(parts) > 1 && (cmd == UPDATE || cmd == DELETE || cmd == FIND || cmd == FROM_TEMPLATE || cmd == CHECK) {
 		taskId, err = strconv.Atoi(parts[1])
 		if err != nil {
 			return "", -1, "", fmt.Errorf("Invalid task ID. Please enter a number.")
 		}
+		if cmd == CHECK && len(parts) > 2 {
+			word = parts[2]
+		}
 		return cmd, taskId, word, nil
 	} else if len(parts) > 1 && (cmd == SEARCH) {
 		word = parts[1]
@@ -179,7 +219,19 @@ func executeCommand(cmd command, taskId int, word string, taskHolder *in.TaskHol
 	case UPDATE:
 		err = updateTask(taskHolder, taskId, reader)
 	case DELETE:
-		err = deleteTask(taskHolder, taskId)
+		if word != "" {
+			err = bulkTasks(taskHolder, in.BulkDelete, word)
+		} else {
+			err = deleteTask(taskHolder, taskId)
+		}
+	case DONE:
+		err = bulkTasks(taskHolder, in.BulkUpdate, word)
+	case TEMPLATES:
+		err = readTemplates(taskHolder)
+	case FROM_TEMPLATE:
+		err = createTaskFromTemplate(taskHolder, taskId, reader)
+	case CHECK:
+		err = toggleChecklistItem(taskHolder, taskId, word)
 	case EXIT:
 		return exitApp(taskHolder)
 	default:
@@ -220,6 +272,11 @@ func exitApp(taskHolder *in.TaskHolder) int {
 	if err != nil {
 		panic(err)
 	}
+	// written even without templates, so deleting the last one is saved
+	err = in.WriteTemplatesToJson(in.TemplatesPath(taskHolder.DiskPath), taskHolder.ReadTemplates()...)
+	if err != nil {
+		panic(err)
+	}
 	return 0
 }
 
@@ -231,6 +288,65 @@ func deleteTask(taskHolder *in.TaskHolder, taskId int) error {
 	return err
 }
 
+// parseTaskIds reads ids and inclusive ranges like 3 5 7 or 10-15
+func parseTaskIds(args []string) ([]int, error) {
+	var ids []int
+	for _, arg := range args {
+		for _, part := range strings.Split(arg, ",") {
+			if part == "" {
+				continue
+			}
+			from, to, isRange := strings.Cut(part, "-")
+			first, err := strconv.Atoi(from)
+			last := first
+			if err == nil && isRange {
+				last, err = strconv.Atoi(to)
+			}
+			if err != nil || last < first {
+				return nil, fmt.Errorf("Invalid task ID. Please enter a number or a range like 10-15.")
+			}
+			if len(ids)+last-first+1 > in.MaxBulkOperations {
+				return nil, fmt.Errorf("Too many task IDs, at most %d.", in.MaxBulkOperations)
+			}
+			for id := first; id <= last; id++ {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("Invalid task ID. Please enter a number.")
+	}
+	return ids, nil
+}
+
+// bulkTasks deletes or marks done every task in ids, each id on its own
+func bulkTasks(taskHolder *in.TaskHolder, op in.BulkOp, ids string) error {
+	taskIds, err := parseTaskIds(strings.Fields(ids))
+	if err != nil {
+		return err
+	}
+	operations := make([]in.BulkOperation, len(taskIds))
+	for i, taskId := range taskIds {
+		operations[i] = in.BulkOperation{Op: op, Id: taskId}
+		if op == in.BulkUpdate {
+			operations[i].Task = in.TaskOptional{Done: in.BoolPtr(true)}
+		}
+	}
+	results, err := taskHolder.ApplyBulk(operations, false, nil)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("task %d: %v\n", result.Id, result.Err)
+		}
+	}
+	fmt.Printf("%s: %d tasks, %d failed\n", op, len(results)-failed, failed)
+	return nil
+}
+
 func updateTask(taskHolder *in.TaskHolder, taskId int, reader *bufio.Reader) error {
 	fmt.Println("Updating task. Press Enter to skip a field if you don't want to update it.")
 
@@ -367,3 +483,66 @@ func readTasks(taskHolder *in.TaskHolder) error {
 	in.PrintTasks(os.Stdout, all_tasks...)
 	return nil
 }
+
+func readTemplates(taskHolder *in.TaskHolder) error {
+	templates := taskHolder.ReadTemplates()
+	if len(templates) == 0 {
+		fmt.Println("No templates found.")
+		return errors.New("No templates found")
+	}
+
+	fmt.Printf("\nList of templates:\n\n")
+	for _, tmpl := range templates {
+		fmt.Printf("id:%d [%s] %s: %s (+%dh, %d checklist items)\n", tmpl.Id, tmpl.Category, tmpl.Name, tmpl.Msg, tmpl.OffsetHours, len(tmpl.Checklist))
+	}
+	return nil
+}
+
+func createTaskFromTemplate(taskHolder *in.TaskHolder, templateId int, reader *bufio.Reader) error {
+	tmpl, err := taskHolder.FindTemplateById(templateId)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]string)
+	for _, name := range tmpl.Placeholders() {
+		fmt.Printf("Enter value for {{%s}}: ", name)
+		value, _ := reader.ReadString('\n')
+		vars[name] = strings.TrimSpace(value)
+	}
+
+	fmt.Print("Enter start date (YYYY-MM-DD HH:MM) or press Enter for now: ")
+	startStr, _ := reader.ReadString('\n')
+	start := time.Now()
+	if startStr = strings.TrimSpace(startStr); startStr != "" {
+		start, err = time.Parse(in.TASK_TIME_FORMAT, startStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	task, err := taskHolder.CreateTaskFromTemplate(templateId, vars, start, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(task.String() + "\n")
+	return nil
+}
+
+// toggles done status of checklist item, usage: check <taskId> <itemId>
+func toggleChecklistItem(taskHolder *in.TaskHolder, taskId int, itemIdStr string) error {
+	itemId, err := strconv.Atoi(itemIdStr)
+	if err != nil {
+		return fmt.Errorf("Invalid checklist item ID. Usage: check <taskId> <itemId>")
+	}
+	task, err := taskHolder.FindTaskById(taskId)
+	if err != nil {
+		return err
+	}
+	for _, item := range task.Checklist {
+		if item.Id == itemId {
+			return taskHolder.SetChecklistItem(taskId, itemId, !item.Done)
+		}
+	}
+	return in.ErrNotFound
+}
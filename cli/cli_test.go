@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"flag"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -338,6 +340,7 @@ func TestParseCommand(t *testing.T) {
 		{"Invalid command", "invalid\n", "invalid", 0, false},
 		{"Update without ID", "update\n", UPDATE, 0, false},
 		{"Update with invalid ID", "update abc\n", "", -1, true},
+		{"Valid from-template command", "from-template 2\n", FROM_TEMPLATE, 2, false},
 	}
 
 	for _, tt := range tests {
@@ -361,6 +364,82 @@ func TestParseCommand(t *testing.T) {
 	}
 }
 
+func TestParseTaskIds(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedCmd command
+		expectedIds string
+		expectError bool
+	}{
+		{"done 3 5 7\n", DONE, "3 5 7", false},
+		{"done 4\n", DONE, "4", false},
+		{"delete 10-15\n", DELETE, "10-15", false},
+		{"delete 2,4-5 9\n", DELETE, "2,4-5 9", false},
+		{"delete 15-10\n", "", "", true},
+		{"done 3 x\n", "", "", true},
+		{"delete 1-100000\n", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			cmd, _, word, err := parseCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if (err != nil) != tt.expectError {
+				t.Fatalf("parseCommand() error = %v, expectError %v", err, tt.expectError)
+			}
+			if cmd != tt.expectedCmd || word != tt.expectedIds {
+				t.Errorf("parseCommand() = %v %q, want %v %q", cmd, word, tt.expectedCmd, tt.expectedIds)
+			}
+		})
+	}
+}
+
+func TestBulkTasks(t *testing.T) {
+	taskHolder := in.NewTaskHolder(filepath.Join(t.TempDir(), "tasks.json"))
+	for i := 0; i < 6; i++ {
+		taskHolder.CreateTask(in.TaskOptional{Msg: in.StringPtr(fmt.Sprintf("Task %d", i+1))})
+	}
+
+	executeCommand(DONE, 1, "1 3", taskHolder, nil)
+	executeCommand(DELETE, 4, "4-5 42", taskHolder, nil)
+
+	var done, ids []int
+	for _, task := range taskHolder.Read() {
+		ids = append(ids, task.Id)
+		if task.Done {
+			done = append(done, task.Id)
+		}
+	}
+	if fmt.Sprint(done) != "[1 3]" || fmt.Sprint(ids) != "[1 2 3 6]" {
+		t.Errorf("Expected done [1 3] and tasks [1 2 3 6], got %v and %v", done, ids)
+	}
+}
+
+func TestToggleChecklistItem(t *testing.T) {
+	taskHolder := in.NewTaskHolder(filepath.Join(t.TempDir(), "cli_disk_test.json"))
+	tmpl, _ := taskHolder.AddTemplate(in.TaskTemplate{Name: "Brew day", Msg: "Brew {{beer}}", Checklist: []string{"Mash", "Boil"}})
+	reader := bufio.NewReader(strings.NewReader("Stout\n\n"))
+	if err := createTaskFromTemplate(taskHolder, tmpl.Id, reader); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cmd, taskId, word, err := parseCommand(bufio.NewReader(strings.NewReader("check 1 2\n")))
+	if err != nil || cmd != CHECK || taskId != 1 || word != "2" {
+		t.Fatalf("parseCommand() = %v, %v, %v, %v", cmd, taskId, word, err)
+	}
+
+	if err := toggleChecklistItem(taskHolder, taskId, word); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	task, _ := taskHolder.FindTaskById(1)
+	if task.Msg != "Brew Stout" || !task.Checklist[1].Done {
+		t.Errorf("Unexpected task %v", task)
+	}
+
+	if err := toggleChecklistItem(taskHolder, taskId, "abc"); err == nil {
+		t.Errorf("Expected error for invalid item id")
+	}
+}
+
 func TestExecuteCommand(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -426,7 +505,7 @@ func TestExecuteCommand(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			taskHolder := in.NewTaskHolder("../internal/resources/cli_disk_test.json")
+			taskHolder := in.NewTaskHolder(filepath.Join(t.TempDir(), "cli_disk_test.json"))
 			tt.setup(taskHolder)
 
 			reader := bufio.NewReader(strings.NewReader(tt.input))
@@ -444,7 +523,7 @@ func TestExecuteCommand(t *testing.T) {
 }
 
 func TestRunCLI(t *testing.T) {
-	taskHolder := in.ProvideTaskHolderWithPath("../internal/resources/cli_disk_test.json")
+	taskHolder := in.ProvideTaskHolderWithPath(filepath.Join(t.TempDir(), "cli_disk_test.json"))
 
 	oldstd, read, write := in.CaptureStdout()
 	oldstdIn, inRead, inWrite := in.CaptureStdin()
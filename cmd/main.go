@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
 	"github.com/zhekagigs/golang_todo/cli"
 	"github.com/zhekagigs/golang_todo/controller"
 	"github.com/zhekagigs/golang_todo/internal"
 	"github.com/zhekagigs/golang_todo/logger"
 	mid "github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/oidc"
 	"github.com/zhekagigs/golang_todo/repository"
 	"github.com/zhekagigs/golang_todo/users"
 	"github.com/zhekagigs/golang_todo/view"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -28,15 +36,18 @@ func main() {
 	// Load initial tasks from GCS
 	taskHolder := loadTasks(repo)
 
-	// Start the application if GCP
+	// Start the application if GCP, workspaces have their own objects
 	os.Exit(RealMain(
 		func(string) *internal.TaskHolder { return taskHolder },
 		&controller.RealHTTPServer{},
 		&cli.RealCLIApp{},
+		func(workspace string) *internal.TaskHolder {
+			return loadTasks(repo.WithObject(internal.WorkspaceDiskPath(repo.ObjectName(), workspace)))
+		},
 	))
 
 	// Start app if local JSON storage
-	// os.Exit(RealMain(internal.NewTaskHolder, &controller.RealHTTPServer{}, &cli.RealCLIApp{}))
+	// os.Exit(RealMain(internal.NewTaskHolder, &controller.RealHTTPServer{}, &cli.RealCLIApp{}, nil))
 }
 
 func loadTasks(repo *repository.GCSRepository) *internal.TaskHolder {
@@ -53,7 +64,9 @@ func loadTasks(repo *repository.GCSRepository) *internal.TaskHolder {
 	return taskHolder
 }
 
-func RealMain(newTaskHolder func(diskPath string) *internal.TaskHolder, server controller.HTTPServer, cliApp cli.CLIApp) int {
+// RealMain runs the app, newWorkspaceStore loads the tasks of the workspaces in
+// WORKSPACES and defaults to newTaskHolder with a file next to the tasks file
+func RealMain(newTaskHolder func(diskPath string) *internal.TaskHolder, server controller.HTTPServer, cliApp cli.CLIApp, newWorkspaceStore func(workspace string) *internal.TaskHolder) int {
 	// Initialize with environment variables or defaults
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -65,6 +78,75 @@ func RealMain(newTaskHolder func(diskPath string) *internal.TaskHolder, server c
 		usersFile = "users.json"
 	}
 
+	batchesFile := os.Getenv("BATCHES_FILE")
+	if batchesFile == "" {
+		batchesFile = "batches.json"
+	}
+
+	inventoryFile := os.Getenv("INVENTORY_FILE")
+	if inventoryFile == "" {
+		inventoryFile = "inventory.json"
+	}
+
+	equipmentFile := os.Getenv("EQUIPMENT_FILE")
+	if equipmentFile == "" {
+		equipmentFile = "equipment.json"
+	}
+
+	qcFile := os.Getenv("QC_FILE")
+	if qcFile == "" {
+		qcFile = "qc.json"
+	}
+
+	webhooksFile := os.Getenv("WEBHOOKS_FILE")
+	if webhooksFile == "" {
+		webhooksFile = "webhooks.json"
+	}
+
+	// comma separated workspaces besides the default one, each has its own tasks
+	workspaceNames := os.Getenv("WORKSPACES")
+
+	tokensFile := os.Getenv("TOKENS_FILE")
+	if tokensFile == "" {
+		tokensFile = "tokens.json"
+	}
+
+	auditFile := os.Getenv("AUDIT_FILE")
+	if auditFile == "" {
+		auditFile = "audit.jsonl"
+	}
+
+	// the gRPC server is started only when a port is configured
+	grpcPort := os.Getenv("GRPC_PORT")
+
+	// without a secret sessions are signed with a random key and end on restart
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		mid.Sessions = mid.NewSessionManager([]byte(secret), mid.DefaultSessionTTL)
+		// logged out sessions have to stay revoked over restarts too
+		sessionsFile := os.Getenv("SESSIONS_FILE")
+		if sessionsFile == "" {
+			sessionsFile = "sessions.json"
+		}
+		if err := mid.Sessions.LoadRevocations(sessionsFile); err != nil {
+			logger.Error.Printf("error loading sessions file: %v", err)
+			return cli.ExitCodeError
+		}
+	}
+	// behind TLS cookies are marked Secure, browsers then never send them over plain HTTP
+	mid.Cookies.Secure = os.Getenv("COOKIE_SECURE") == "true"
+	// behind a proxy the audit log records the client address of X-Forwarded-For
+	mid.TrustProxy = os.Getenv("TRUST_PROXY") == "true"
+	// anonymous visitors only read the default workspace when it is public
+	authz.PublicDefaultWorkspace = os.Getenv("PUBLIC_TASKS") == "true"
+	if deprecatedAt := os.Getenv("LEGACY_DEPRECATED_AT"); deprecatedAt != "" {
+		date, err := time.Parse(time.DateOnly, deprecatedAt)
+		if err != nil {
+			logger.Error.Printf("invalid LEGACY_DEPRECATED_AT %q, expected YYYY-MM-DD: %v", deprecatedAt, err)
+			return cli.ExitCodeError
+		}
+		controller.LegacyDeprecatedAt = date
+	}
+
 	taskHolder, checkExit, exitCode, isWeb := cliApp.AppStarter(newTaskHolder)
 	if checkExit {
 		return exitCode
@@ -76,28 +158,204 @@ func RealMain(newTaskHolder func(diskPath string) *internal.TaskHolder, server c
 		return cli.ExitCodeError
 	}
 
-	taskConcurrentService := internal.NewConcurrentTaskService(taskHolder)
-	taskRenderHandler := controller.NewTaskRenderHandler(taskHolder, renderer)
 	userStore, err := users.NewUserStore(usersFile)
 	if err != nil {
 		logger.Error.Printf("error loading user store file")
 		return cli.ExitCodeError
 	}
+	// tasks keep the user id of their assignee
+	taskHolder.AssigneeLookup = userStore.UserId
+	taskHolder.ResolveAssignees()
+
+	taskConcurrentService := internal.NewConcurrentTaskService(taskHolder)
+	if newWorkspaceStore == nil {
+		newWorkspaceStore = func(workspace string) *internal.TaskHolder {
+			return newTaskHolder(internal.WorkspaceDiskPath(taskHolder.DiskPath, workspace))
+		}
+	}
+	workspaces := internal.NewWorkspaces(taskConcurrentService)
+	defer workspaces.CloseAll()
+	for _, name := range strings.Split(workspaceNames, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		store := newWorkspaceStore(name)
+		store.AssigneeLookup = userStore.UserId
+		store.ResolveAssignees()
+		if err := workspaces.Add(name, store); err != nil {
+			logger.Error.Printf("error adding workspace: %v", err)
+			return cli.ExitCodeError
+		}
+	}
+	taskRenderHandler := controller.NewTaskRenderHandler(taskHolder, renderer)
+	// ADMIN_USER makes an existing user admin, so the first admin can be set
+	if adminUser := os.Getenv("ADMIN_USER"); adminUser != "" {
+		user, ok := userStore.GetUser(adminUser)
+		if !ok {
+			logger.Error.Printf("admin user %s not found", adminUser)
+			return cli.ExitCodeError
+		}
+		if _, err := userStore.SetRole(user.UserId.String(), users.RoleAdmin); err != nil {
+			logger.Error.Printf("error making %s admin: %v", adminUser, err)
+			return cli.ExitCodeError
+		}
+		// ADMIN_PASSWORD lets an admin saved before passwords existed log in,
+		// a password already set is kept
+		if password := os.Getenv("ADMIN_PASSWORD"); password != "" && !userStore.HasPassword(adminUser) {
+			if err := userStore.SetPassword(adminUser, password); err != nil {
+				logger.Error.Printf("error setting password of %s: %v", adminUser, err)
+				return cli.ExitCodeError
+			}
+		}
+	}
+
+	batchHolder, err := internal.NewBatchHolder(batchesFile, taskHolder)
+	if err != nil {
+		logger.Error.Printf("error loading batches file")
+		return cli.ExitCodeError
+	}
+
+	inventory, err := internal.NewInventory(inventoryFile, taskHolder)
+	if err != nil {
+		logger.Error.Printf("error loading inventory file")
+		return cli.ExitCodeError
+	}
+
+	equipmentRegistry, err := internal.NewEquipmentRegistry(equipmentFile, taskHolder)
+	if err != nil {
+		logger.Error.Printf("error loading equipment file")
+		return cli.ExitCodeError
+	}
+	batchHolder.FermenterLookup = equipmentRegistry.FindFermenterByName
+
+	qualityLog, err := internal.NewQualityLog(qcFile, taskHolder)
+	if err != nil {
+		logger.Error.Printf("error loading qc file")
+		return cli.ExitCodeError
+	}
+	qualityLog.StyleLookup = batchHolder.BatchStyle
+
+	webhookRegistry, err := internal.NewWebhookRegistry(webhooksFile, taskHolder)
+	if err != nil {
+		logger.Error.Printf("error loading webhooks file")
+		return cli.ExitCodeError
+	}
+	webhookRegistry.EncodePayload = controller.EncodeWebhookPayload
+	// webhooks reach only public addresses unless receivers are on the local network
+	webhookRegistry.AllowPrivateNetworks = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+	webhookCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := make(chan struct{})
+	go func() {
+		webhookRegistry.Run(webhookCtx)
+		close(webhooksDone)
+	}()
+	// Run saves the deliveries when it stops
+	defer func() {
+		stopWebhooks()
+		<-webhooksDone
+	}()
+
+	apiTokens, err := users.NewAPITokenStore(tokensFile)
+	if err != nil {
+		logger.Error.Printf("error loading tokens file")
+		return cli.ExitCodeError
+	}
+	mid.APITokens = apiTokens
+	mid.ActiveUsers = userStore
+
+	auditLog, err := audit.Open(auditFile)
+	if err != nil {
+		logger.Error.Printf("error opening audit file: %v", err)
+		return cli.ExitCodeError
+	}
+	defer auditLog.Close()
+	mid.Audit = auditLog
 
 	api := controller.NewApiService(taskConcurrentService, userStore)
+	api.Workspaces = workspaces
+	batchApi := controller.NewBatchApiService(batchHolder, userStore)
+	inventoryApi := controller.NewInventoryApiService(inventory, userStore)
+	equipmentApi := controller.NewEquipmentApiService(equipmentRegistry, taskHolder, userStore)
+	qualityApi := controller.NewQualityApiService(qualityLog, taskHolder, userStore)
 	authHandler := controller.NewAuthHandler(userStore)
+	// single sign-on is enabled with an issuer
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.Discover(ctx, oidc.Config{
+			Issuer:        issuer,
+			ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:        strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " ")),
+			UserNameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+		}, nil)
+		cancel()
+		if err != nil {
+			logger.Error.Printf("error discovering identity provider: %v", err)
+			return cli.ExitCodeError
+		}
+		authHandler.OIDC = provider
+		renderer.SingleSignOn = true
+	}
+	tokenApi := controller.NewTokenApiService(apiTokens, userStore)
+	userApi := controller.NewUserApiService(userStore)
+	userApi.Workspaces = workspaces
+	userApi.Pages = renderer
+	auditApi := controller.NewAuditApiService(auditLog, userStore)
+	taskEvents := internal.NewTaskEventLog(internal.DefaultEventLogSize, taskHolder)
+	eventsApi := controller.NewTaskEventsService(taskEvents)
+	editLocks := internal.NewEditLocks(internal.DefaultEditLockTTL)
+	taskRenderHandler.EditLocks = editLocks
+	taskRenderHandler.UserStore = userStore
+	taskRenderHandler.Workspaces = workspaces
+	collabApi := controller.NewCollabService(editLocks, taskHolder, userStore)
+	webhookApi := controller.NewWebhookApiService(webhookRegistry, userStore)
+	graphqlApi, err := controller.NewGraphQLService(taskConcurrentService, userStore)
+	if err != nil {
+		logger.Error.Printf("error building graphql schema: %v", err)
+		return cli.ExitCodeError
+	}
+	graphqlApi.Workspaces = workspaces
 
 	// Setup shutdown channel
 	shutdownChan := make(chan struct{})
-	errChan := make(chan error, 1)
+	errChan := make(chan error, 2)
 	// Start HTTP server in goroutine
 	go func() {
-		if err := startHTTPServer(port, taskRenderHandler, server, api, authHandler); err != nil {
+		if err := startHTTPServer(port, server, services{
+			taskHandler:  taskRenderHandler,
+			api:          api,
+			batchApi:     batchApi,
+			inventoryApi: inventoryApi,
+			equipmentApi: equipmentApi,
+			qualityApi:   qualityApi,
+			eventsApi:    eventsApi,
+			collabApi:    collabApi,
+			webhookApi:   webhookApi,
+			graphqlApi:   graphqlApi,
+			tokenApi:     tokenApi,
+			userApi:      userApi,
+			auditApi:     auditApi,
+			authHandler:  authHandler,
+		}); err != nil {
 			logger.Error.Printf("Failed to start server: %v", err)
 			errChan <- err
 		}
 	}()
 
+	if grpcPort != "" {
+		grpcService := controller.NewTaskGrpcService(taskConcurrentService, userStore, taskEvents)
+		grpcService.Workspaces = workspaces
+		grpcServer := controller.NewGrpcServer(grpcService)
+		defer grpcServer.Stop()
+		go func() {
+			if err := startGrpcServer(grpcPort, grpcServer); err != nil {
+				logger.Error.Printf("Failed to start gRPC server: %v", err)
+				errChan <- err
+			}
+		}()
+	}
+
 	// Handle graceful shutdown
 	go handleShutdown(shutdownChan)
 
@@ -132,34 +390,69 @@ func RealMain(newTaskHolder func(diskPath string) *internal.TaskHolder, server c
 	}
 }
 
-func startHTTPServer(port string, taskHandler *controller.TaskRenderHandler, server controller.HTTPServer, api *controller.ApiService, authHandler *controller.AuthHandler) error {
-	router := http.NewServeMux()
-	// api routes
-	router.HandleFunc("GET /api/tasks", api.GetAllPosts)
-	router.HandleFunc("GET /api/tasks/{id}", api.GetTaskById)
-	router.HandleFunc("POST /api/tasks", mid.AuthMiddleware(api.CreateTask))
-	router.HandleFunc("PUT /api/tasks/{id}", mid.AuthMiddleware(api.UpdateTask))
-	router.HandleFunc("DELETE /api/tasks/{id}", mid.AuthMiddleware(api.DeleteTask))
-	// auth routes
-	router.HandleFunc("POST /login", authHandler.LoginHandler)
-	router.HandleFunc("POST /logout", authHandler.LogoutHandler)
-	// view routes
-	router.HandleFunc("GET /tasks/create", mid.AuthMiddleware(taskHandler.HandleTaskCreate))
-	router.HandleFunc("GET /tasks", taskHandler.HandleTaskListRead)
-	router.HandleFunc("DELETE /tasks", mid.AuthMiddleware(taskHandler.HandleTaskDelete))
-	router.HandleFunc("GET /tasks/update", mid.AuthMiddleware(taskHandler.HandleTaskUpdate))
-	router.HandleFunc("POST /tasks/update", mid.AuthMiddleware(taskHandler.HandleTaskUpdate))
-	router.HandleFunc("GET /", taskHandler.HandleTaskListRead)
-
-	// Health check
-	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	loggingHandler := mid.LoggingMiddleware{Next: router}
+func startHTTPServer(port string, server controller.HTTPServer, svc services) error {
+	router := newRouter(svc)
+	handler := mid.RequestMiddleware(mid.LoggingMiddleware{Next: router})
 
 	logger.Info.Printf("Starting server on :%s", port)
-	return server.ListenAndServe(":"+port, loggingHandler)
+	return server.ListenAndServe(":"+port, handler)
+}
+
+func startGrpcServer(port string, server *grpc.Server) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	logger.Info.Printf("Starting gRPC server on :%s", port)
+	return server.Serve(listener)
+}
+
+// services are the handlers newRouter registers
+type services struct {
+	taskHandler  *controller.TaskRenderHandler
+	api          *controller.ApiService
+	batchApi     *controller.BatchApiService
+	inventoryApi *controller.InventoryApiService
+	equipmentApi *controller.EquipmentApiService
+	qualityApi   *controller.QualityApiService
+	eventsApi    *controller.TaskEventsService
+	collabApi    *controller.CollabService
+	webhookApi   *controller.WebhookApiService
+	graphqlApi   *controller.GraphQLService
+	tokenApi     *controller.TokenApiService
+	userApi      *controller.UserApiService
+	auditApi     *controller.AuditApiService
+	authHandler  *controller.AuthHandler
+}
+
+// newRouter registers every route with its OpenAPI doc, served at /api/openapi.json
+func newRouter(svc services) *controller.Router {
+	router := controller.NewRouter()
+	svc.api.RegisterRoutes(router)
+	svc.api.RegisterRoutesV1(router)
+	// these are bound to the tasks of the default workspace
+	router.Guard(svc.api.DefaultWorkspaceOnly, func(router *controller.Router) {
+		svc.batchApi.RegisterRoutes(router)
+		svc.inventoryApi.RegisterRoutes(router)
+		svc.equipmentApi.RegisterRoutes(router)
+		svc.qualityApi.RegisterRoutes(router)
+		svc.eventsApi.RegisterRoutes(router)
+		svc.collabApi.RegisterRoutes(router)
+		svc.webhookApi.RegisterRoutes(router)
+	})
+	svc.graphqlApi.RegisterRoutes(router)
+	svc.tokenApi.RegisterRoutes(router)
+	svc.userApi.RegisterRoutes(router)
+	svc.auditApi.RegisterRoutes(router)
+	svc.authHandler.RegisterRoutes(router)
+	svc.taskHandler.RegisterRoutes(router)
+
+	router.Handle("GET /api/openapi.json", router.ServeOpenAPI, &controller.RouteDoc{
+		Summary: "OpenAPI document of this API", Tag: "meta", Response: map[string]any{}})
+	router.Handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, &controller.RouteDoc{Summary: "Health check", Tag: "meta"})
+	return router
 }
 
 // gracefult shutdown handling
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -44,7 +48,8 @@ func (cli *MockCLIApp) RunTaskManagmentCLI(taskHolder *in.TaskHolder) int {
 func TestRealMain(t *testing.T) {
 	mockServer := &MockHTTPServer{}
 	mockCli := &MockCLIApp{}
-	exitCode := RealMain(in.MockNewTaskHolder, mockServer, mockCli)
+	t.Setenv("AUDIT_FILE", filepath.Join(t.TempDir(), "audit.jsonl"))
+	exitCode := RealMain(in.MockNewTaskHolder, mockServer, mockCli, nil)
 
 	if exitCode != 0 {
 		t.Errorf("Expected exit code 0, got %d", exitCode)
@@ -59,3 +64,32 @@ func TestRealMain(t *testing.T) {
 		t.Error("Expected RunTaskManagmentCLI to be called")
 	}
 }
+
+// fails when a route is registered without an OpenAPI entry
+func TestRoutesHaveOpenAPIEntries(t *testing.T) {
+	router := newRouter(services{})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Summary string `json:"summary"`
+		} `json:"paths"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&spec); err != nil {
+		t.Fatalf("Unexpected error decoding spec: %v", err)
+	}
+
+	for _, route := range router.Routes() {
+		if route.Doc == nil {
+			if strings.HasPrefix(route.Path, "/api/") {
+				t.Errorf("Route %s %s has no OpenAPI doc", route.Method, route.Path)
+			}
+			continue
+		}
+		operation, ok := spec.Paths[route.Path][strings.ToLower(route.Method)]
+		if !ok || operation.Summary == "" {
+			t.Errorf("Route %s %s is missing in the OpenAPI spec", route.Method, route.Path)
+		}
+	}
+}
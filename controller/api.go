@@ -3,15 +3,24 @@ package controller
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
 	"github.com/zhekagigs/golang_todo/internal"
 	"github.com/zhekagigs/golang_todo/logger"
 	"github.com/zhekagigs/golang_todo/middleware"
 	"github.com/zhekagigs/golang_todo/users"
 )
 
+const maxPatchSize = 1 << 20
+
 var (
 	ErrWrongRequest = errors.New("wrong request")
 	ErrInternal     = errors.New("server error")
@@ -20,40 +29,175 @@ var (
 type ApiService struct {
 	// taskService  *internal.TaskHolder
 	taskService *internal.ConcurrentTaskService
+	// Workspaces has the task services of the X-Workspace header, by default
+	// only the default workspace with taskService
+	Workspaces  *internal.Workspaces
 	userStore   *users.UserStore
+	etagSeed    int64
+	idempotency internal.IdempotencyStore
 }
 
 func (apiHandler ApiService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger.Info.Printf("ServeHttp Received %s request for %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
 }
 
-func NewApiService(internal *internal.ConcurrentTaskService, userStore *users.UserStore) *ApiService {
+func NewApiService(taskService *internal.ConcurrentTaskService, userStore *users.UserStore) *ApiService {
 	return &ApiService{
-		taskService: internal,
+		taskService: taskService,
+		Workspaces:  internal.NewWorkspaces(taskService),
 		userStore:   userStore,
+		etagSeed:    time.Now().UnixNano(),
+		idempotency: internal.NewMemoryIdempotencyStore(internal.DefaultIdempotencyTTL),
 	}
 }
 
-func (api *ApiService) GetAllPosts(w http.ResponseWriter, r *http.Request) {
+type taskListResponse struct {
+	Tasks []internal.Task `json:"tasks"`
+	Total int             `json:"total"`
+	Next  string          `json:"next,omitempty"`
+}
+
+var taskQueryParams = []Param{
+	{Name: "category", In: "query", Description: "Category name or number"},
+	{Name: "status", In: "query", Description: "open, done or all"},
+	{Name: "assignee", In: "query", Description: "User name"},
+	{Name: "creator", In: "query", Description: "User name"},
+	{Name: "tag", In: "query"},
+	{Name: "plannedFrom", In: "query", Description: "RFC 3339 time"},
+	{Name: "plannedTo", In: "query", Description: "RFC 3339 time"},
+	{Name: "q", In: "query", Description: "Text in the task message"},
+	{Name: "sort", In: "query", Description: "Comma separated fields, - prefix sorts descending"},
+	{Name: "limit", In: "query", Type: "integer"},
+	{Name: "cursor", In: "query", Description: "Cursor from the next link"},
+}
 
-	posts := api.taskService.Read()
+func (api *ApiService) RegisterRoutes(router *Router) {
+	router.Handle("GET /api/tasks", middleware.OptionalAuthMiddleware(api.GetAllPosts), &RouteDoc{
+		Summary: "List tasks", Tag: "tasks", Params: taskQueryParams, Response: taskListResponse{}, Deprecated: true})
+	router.Handle("GET /api/tasks/{id}", middleware.OptionalAuthMiddleware(api.GetTaskById), &RouteDoc{
+		Summary: "Get task", Tag: "tasks", Response: internal.Task{}, Deprecated: true})
+	router.HandleAuth("POST /api/tasks", api.Idempotent(api.CreateTask), &RouteDoc{
+		Summary: "Create task", Tag: "tasks", Request: internal.TaskOptional{}, Status: http.StatusCreated, Response: internal.Task{}, Deprecated: true})
+	router.HandleAuth("PUT /api/tasks/{id}", api.UpdateTask, &RouteDoc{
+		Summary: "Update sent task fields", Tag: "tasks", Request: internal.TaskOptional{}, Status: http.StatusCreated, Response: internal.Task{}, Deprecated: true})
+	router.HandleAuth("PATCH /api/tasks/{id}", api.PatchTask, &RouteDoc{
+		Summary: "Patch task", Tag: "tasks", Request: map[string]any{}, RequestTypes: []string{string(internal.MergePatch), string(internal.JSONPatch)}, Response: internal.Task{}, Deprecated: true})
+	router.HandleAuth("POST /api/tasks:batch", api.Idempotent(api.BulkTasks), &RouteDoc{
+		Summary: "Create, update and delete tasks in bulk", Tag: "tasks", Request: bulkRequest{}, Response: bulkResponse{}})
+	router.HandleAuth("DELETE /api/tasks/{id}", api.DeleteTask, &RouteDoc{
+		Summary: "Delete task", Tag: "tasks", Deprecated: true})
+	router.HandleAuth("POST /api/tasks/from-template", api.Idempotent(api.CreateTaskFromTemplate), &RouteDoc{
+		Summary: "Create task from template", Tag: "templates", Request: fromTemplateRequest{}, Status: http.StatusCreated, Response: internal.Task{}})
+	router.HandleAuth("PUT /api/tasks/{id}/checklist/{item}", api.UpdateChecklistItem, &RouteDoc{
+		Summary: "Complete checklist item", Tag: "tasks", Request: checklistItemRequest{}, Response: internal.Task{}})
+	router.Handle("GET /api/templates", middleware.OptionalAuthMiddleware(api.GetAllTemplates), &RouteDoc{
+		Summary: "List templates", Tag: "templates", Response: []internal.TaskTemplate{}})
+	router.Handle("GET /api/templates/{id}", middleware.OptionalAuthMiddleware(api.GetTemplateById), &RouteDoc{
+		Summary: "Get template", Tag: "templates", Response: internal.TaskTemplate{}})
+	router.HandleAuth("POST /api/templates", api.CreateTemplate, &RouteDoc{
+		Summary: "Create template", Tag: "templates", Request: internal.TaskTemplate{}, Status: http.StatusCreated, Response: internal.TaskTemplate{}})
+	router.HandleAuth("DELETE /api/templates/{id}", api.DeleteTemplate, &RouteDoc{
+		Summary: "Delete template", Tag: "templates"})
+}
 
-	postsJson, err := json.Marshal(posts)
-	if isJsonErr(err, w) {
+// GetAllPosts returns a page of tasks matching the query parameters, the next
+// page link is also sent in the Link header and the total in X-Total-Count.
+// Pollers send the ETag back in If-None-Match and get 304 until a task changes.
+func (api *ApiService) GetAllPosts(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(postsJson)
+	query, err := parseTaskQuery(r.URL.Query())
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	// revisions of workspaces overlap, so the workspace is part of the ETag
+	etag := listETag(api.etagSeed, tasks.Revision(), requestWorkspace(r)+"?"+r.URL.RawQuery)
+	if !noneMatch(r, etag) {
+		writeNotModified(w, etag)
+		return
+	}
+	page, err := tasks.QueryTasks(query)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+
+	response := taskListResponse{Tasks: page.Tasks, Total: page.Total}
+	if page.NextCursor != "" {
+		next := r.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		response.Next = r.URL.Path + "?" + next.Encode()
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, response.Next))
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	w.Header().Set("ETag", etag)
+	writeJson(w, http.StatusOK, response)
+}
+
+func parseTaskQuery(values url.Values) (internal.TaskQuery, error) {
+	query := internal.TaskQuery{
+		Assignee: values.Get("assignee"),
+		Creator:  values.Get("creator"),
+		Tag:      values.Get("tag"),
+		Text:     values.Get("q"),
+		Cursor:   values.Get("cursor"),
+	}
+	if value := values.Get("category"); value != "" {
+		category, err := internal.ParseTaskCategory(value)
+		if err != nil {
+			return query, &internal.InvalidQueryError{Param: "category", Reason: err.Error()}
+		}
+		query.Category = &category
+	}
+	switch values.Get("status") {
+	case "", "all":
+	case "done":
+		query.Done = internal.BoolPtr(true)
+	case "open":
+		query.Done = internal.BoolPtr(false)
+	default:
+		return query, &internal.InvalidQueryError{Param: "status", Reason: "expected open, done or all"}
+	}
+	for param, target := range map[string]*time.Time{"plannedFrom": &query.PlannedFrom, "plannedTo": &query.PlannedTo} {
+		if value := values.Get(param); value != "" {
+			parsed, err := time.Parse(internal.TimeFormat, value)
+			if err != nil {
+				return query, &internal.InvalidQueryError{Param: param, Reason: "expected RFC 3339 time"}
+			}
+			*target = parsed
+		}
+	}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return query, &internal.InvalidQueryError{Param: "limit", Reason: "expected positive number"}
+		}
+		query.Limit = limit
+	}
+	sort, err := internal.ParseSort(values.Get("sort"))
+	if err != nil {
+		return query, err
+	}
+	query.Sort = sort
+	return query, nil
 }
 
 func (api *ApiService) GetTaskById(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
 	taskId, err := getTaskIdFromPath(r)
-	if handleError(w, err, http.StatusInternalServerError, "api: error processing taskId") {
+	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
+		return
+	}
+	task, err := tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
 		return
 	}
-	task, err := api.taskService.FindTaskById(taskId)
-	if handleError(w, err, http.StatusInternalServerError, "api: task not found") {
+	if !noneMatch(r, taskETag(task)) {
+		writeNotModified(w, taskETag(task))
 		return
 	}
 
@@ -61,82 +205,229 @@ func (api *ApiService) GetTaskById(w http.ResponseWriter, r *http.Request) {
 	if isJsonErr(err, w) {
 		return
 	}
+	w.Header().Set("ETag", taskETag(task))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader((http.StatusOK))
 	w.Write(taskJson)
 }
 
 func (api *ApiService) CreateTask(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	userId, ok := middleware.UserFromContext(ctx)
+	tasks, ok := api.tasks(w, r)
 	if !ok {
-		logger.Error.Println("user not found by id")
-		http.Error(w, "Task not found", http.StatusNotFound)
-
+		return
 	}
-	user, ok := api.userStore.GetUserById(userId)
+	user, ok := authorize(w, r, api.userStore, authz.CreateTask, nil)
 	if !ok {
-		logger.Error.Println("user not found by id")
-		http.Error(w, "Task not found", http.StatusNotFound)
-
+		return
 	}
 	var taskRequest *internal.TaskOptional
 	err := json.NewDecoder(r.Body).Decode(&taskRequest)
-
-	taskRequest.CreatedBy = &user
 	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
 		return
 	}
+	if taskRequest == nil {
+		taskRequest = &internal.TaskOptional{}
+	}
+	err = internal.ValidateNewTask(taskRequest)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
 
-	task := api.taskService.CreateTask(*taskRequest)
+	taskRequest.CreatedBy = user
+	task := tasks.CreateTask(*taskRequest)
+	recordTask(r.Context(), user, audit.TaskCreated, task.Id)
 	taskAsJson, err := json.Marshal(task)
-	if err != nil {
-		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+	if isJsonErr(err, w) {
 		return
 	}
 
+	w.Header().Set("ETag", taskETag(task))
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	w.Write(taskAsJson)
 }
 
+// UpdateTask is a partial update with TaskOptional, kept for older clients; see PatchTask
 func (api *ApiService) UpdateTask(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
 	var taskRequest *internal.TaskOptional
 	err := json.NewDecoder(r.Body).Decode(&taskRequest)
 	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
 		return
 	}
+	if taskRequest == nil {
+		taskRequest = &internal.TaskOptional{}
+	}
 	taskId, err := getTaskIdFromPath(r)
 	if handleError(w, err, http.StatusBadRequest, "error parsing taskId") {
 		return
 	}
-	task := api.taskService.PartialUpdateTask(taskId, taskRequest)
+	current, err := tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.EditTask, current)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(r, current)
+	if !ok {
+		writePreconditionFailed(w, current)
+		return
+	}
+	taskRequest.UpdatedBy = user
+	task, err := tasks.UpdateTaskIfVersion(taskId, version, taskRequest)
+	if handleWriteError(w, tasks, err, taskId) {
+		return
+	}
+	recordTask(r.Context(), user, audit.TaskUpdated, taskId)
 	taskAsJson, err := json.Marshal(task)
-	if err != nil {
-		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+	if isJsonErr(err, w) {
 		return
 	}
 
+	w.Header().Set("ETag", taskETag(task))
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	w.Write(taskAsJson)
 }
 
 func (api *ApiService) DeleteTask(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
 	taskId, err := getTaskIdFromPath(r)
 	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
 		return
 	}
 
-	err = api.taskService.DeleteTask(taskId)
-	if handleError(w, err, http.StatusBadRequest, "api: task not foound") {
+	current, err := tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
 		return
 	}
+	user, ok := authorize(w, r, api.userStore, authz.DeleteTask, current)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(r, current)
+	if !ok {
+		writePreconditionFailed(w, current)
+		return
+	}
+	err = tasks.DeleteTaskIfVersion(taskId, version)
+	if handleWriteError(w, tasks, err, taskId) {
+		return
+	}
+	recordTask(r.Context(), user, audit.TaskDeleted, taskId)
 	w.WriteHeader((http.StatusOK))
 }
 
+// PatchTask accepts JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) of
+// the task as returned by GetTaskById, selected by Content-Type.
+func (api *ApiService) PatchTask(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	taskId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	patchType := internal.PatchType(mediaType)
+	if patchType != internal.MergePatch && patchType != internal.JSONPatch {
+		w.Header().Set("Accept-Patch", string(internal.MergePatch)+", "+string(internal.JSONPatch))
+		writeProblem(w, NewProblem(http.StatusUnsupportedMediaType, "unsupported patch type "+mediaType))
+		return
+	}
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if handleError(w, err, http.StatusBadRequest, "error reading request body") {
+		return
+	}
+
+	current, err := tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.EditTask, current)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(r, current)
+	if !ok {
+		writePreconditionFailed(w, current)
+		return
+	}
+	task, err := tasks.PatchTask(taskId, version, patchType, patch, user)
+	if handleWriteError(w, tasks, err, taskId) {
+		return
+	}
+	recordTask(r.Context(), user, audit.TaskUpdated, taskId)
+	w.Header().Set("ETag", taskETag(task))
+	writeJson(w, http.StatusOK, task)
+}
+
+// handleWriteError answers 412 when the task changed after If-Match was checked
+func handleWriteError(w http.ResponseWriter, tasks *internal.ConcurrentTaskService, err error, taskId int) bool {
+	var versionErr *internal.VersionMismatchError
+	if errors.As(err, &versionErr) {
+		if current, err := tasks.GetTask(taskId); err == nil {
+			writePreconditionFailed(w, current)
+			return true
+		}
+	}
+	return handleError(w, err, http.StatusBadRequest, "")
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		handleError(w, err, http.StatusInternalServerError, "api: json serialization error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// tasks returns the task service of the active workspace of r
+func (api *ApiService) tasks(w http.ResponseWriter, r *http.Request) (*internal.ConcurrentTaskService, bool) {
+	return workspaceTasks(w, r, api.Workspaces, api.userStore)
+}
+
+// authorize is currentUser checking that the user may do action, task is the
+// current task for edits and deletes. It writes 401 or 403 when not allowed.
+func authorize(w http.ResponseWriter, r *http.Request, userStore *users.UserStore, action authz.Action, task *internal.Task) (*users.User, bool) {
+	user, ok := currentUser(r, userStore)
+	if !ok {
+		writeUnknownUser(w)
+		return nil, false
+	}
+	if handleError(w, checkPermission(r.Context(), *user, action, task), http.StatusForbidden, "") {
+		return nil, false
+	}
+	return user, true
+}
+
+// currentUser looks up user set by middleware.AuthMiddleware in the store
+func currentUser(r *http.Request, userStore *users.UserStore) (*users.User, bool) {
+	userId, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		return nil, false
+	}
+	user, ok := userStore.GetUserById(userId)
+	if !ok {
+		return nil, false
+	}
+	return &user, true
+}
+
 func isJsonErr(err error, w http.ResponseWriter) bool {
-	return handleError(w, err, http.StatusBadRequest, "api: json serialization error")
+	return handleError(w, err, http.StatusInternalServerError, "api: json serialization error")
 }
 
 func getTaskIdFromPath(r *http.Request) (int, error) {
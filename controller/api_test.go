@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -17,6 +18,12 @@ import (
 
 var MOCK_TOKEN string
 
+// sessionToken logs user in like POST /login does
+func sessionToken(user users.User) string {
+	token, _ := middleware.Sessions.Issue(user.UserId.String())
+	return token
+}
+
 func setupConfig(t testing.TB) (*httptest.Server, *internal.TaskHolder) {
 	// Setup
 	taskHolder := internal.NewTaskHolder("resources/concurrent_disk.json")
@@ -24,7 +31,7 @@ func setupConfig(t testing.TB) (*httptest.Server, *internal.TaskHolder) {
 	// t.Logf("loaded %d Tasks", len(taskHolder.Tasks))
 	userStore, _ := users.NewUserStore("resources/test_users.json")
 	user, _ := userStore.GetUser("AAA")
-	MOCK_TOKEN = user.UserId.String()
+	MOCK_TOKEN = sessionToken(user)
 	apiService := NewApiService(taskService, userStore)
 
 	// Create a test server
@@ -231,7 +238,7 @@ func assertTaskFields(createdTask internal.Task, testTask internal.TaskOptional,
 	if createdTask.CreatedBy.UserName != "AAA" {
 		t.Error("Expected created by AAA")
 	}
-	if createdTask.CreatedBy.UserId.String() != MOCK_TOKEN {
+	if userId, _ := middleware.Sessions.Verify(MOCK_TOKEN); createdTask.CreatedBy.UserId.String() != userId {
 		t.Error("Expected user id match mock token")
 	}
 }
@@ -282,3 +289,143 @@ func provideJsonBody(testTask internal.TaskOptional) ([]byte, error) {
 	payload, err := json.Marshal(testTask)
 	return payload, err
 }
+
+func TestGetAllPostsQuery(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewApiService(taskService, userStore)
+	t.Cleanup(taskService.CloseAll)
+	router := http.NewServeMux()
+	router.HandleFunc("GET /api/tasks", middleware.OptionalAuthMiddleware(api.GetAllPosts))
+
+	for i := 1; i <= 5; i++ {
+		taskHolder.CreateTask(internal.TaskOptional{
+			Msg:       internal.StringPtr(fmt.Sprintf("Check gravity %d", i)),
+			Category:  internal.CategoryPtr(internal.Quality),
+			PlannedAt: internal.TimePtr(internal.MockTime.Add(time.Duration(i) * time.Hour)),
+			Tags:      []string{"IPA"},
+		})
+	}
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew"), Category: internal.CategoryPtr(internal.Brewing)})
+
+	var ids []int
+	path := "/api/tasks?category=quality&tag=ipa&sort=-plannedAt&limit=2"
+	for path != "" {
+		rr := doApiRequest(router, "GET", path, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("X-Total-Count") != "5" {
+			t.Errorf("Expected X-Total-Count 5, got %q", rr.Header().Get("X-Total-Count"))
+		}
+		var page taskListResponse
+		json.NewDecoder(rr.Body).Decode(&page)
+		for _, task := range page.Tasks {
+			ids = append(ids, task.Id)
+		}
+		path = page.Next
+	}
+	if fmt.Sprint(ids) != "[5 4 3 2 1]" {
+		t.Errorf("Expected tasks [5 4 3 2 1], got %v", ids)
+	}
+
+	for _, path := range []string{"/api/tasks?status=maybe", "/api/tasks?sort=color", "/api/tasks?limit=0", "/api/tasks?cursor=%25%25"} {
+		rr := doApiRequest(router, "GET", path, nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest for %s, got %v", path, rr.Code)
+		}
+	}
+}
+
+func doConditionalRequest(router http.Handler, method, path, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", MOCK_TOKEN)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestConditionalRequests(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewApiService(taskService, userStore)
+	t.Cleanup(taskService.CloseAll)
+	router := http.NewServeMux()
+	router.HandleFunc("GET /api/tasks", middleware.OptionalAuthMiddleware(api.GetAllPosts))
+	router.HandleFunc("GET /api/tasks/{id}", middleware.OptionalAuthMiddleware(api.GetTaskById))
+	router.HandleFunc("PUT /api/tasks/{id}", middleware.AuthMiddleware(api.UpdateTask))
+	router.HandleFunc("PATCH /api/tasks/{id}", middleware.AuthMiddleware(api.PatchTask))
+	router.HandleFunc("DELETE /api/tasks/{id}", middleware.AuthMiddleware(api.DeleteTask))
+
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), Category: internal.CategoryPtr(internal.Brewing)})
+
+	rr := doConditionalRequest(router, "GET", "/api/tasks", "", "", nil)
+	listETag := rr.Header().Get("ETag")
+	rr = doConditionalRequest(router, "GET", "/api/tasks", "", "", map[string]string{"If-None-Match": listETag})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status NotModified for unchanged list, got %v", rr.Code)
+	}
+
+	rr = doConditionalRequest(router, "GET", "/api/tasks/1", "", "", nil)
+	if rr.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Expected ETag \"1\", got %q", rr.Header().Get("ETag"))
+	}
+	rr = doConditionalRequest(router, "GET", "/api/tasks/1", "", "", map[string]string{"If-None-Match": `"1"`})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status NotModified for unchanged task, got %v", rr.Code)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		ifMatch     string
+		wantStatus  int
+		wantETag    string
+	}{
+		{"merge patch", "PATCH", "application/merge-patch+json", `{"Msg":"Brew stout"}`, `"1"`, http.StatusOK, `"2"`},
+		{"stale merge patch", "PATCH", "application/merge-patch+json", `{"Msg":"Brew lager"}`, `"1"`, http.StatusPreconditionFailed, `"2"`},
+		{"json patch", "PATCH", "application/json-patch+json", `[{"op":"test","path":"/Msg","value":"Brew stout"},{"op":"replace","path":"/Done","value":true}]`, `"2"`, http.StatusOK, `"3"`},
+		{"failed test op", "PATCH", "application/json-patch+json", `[{"op":"test","path":"/Msg","value":"Brew IPA"}]`, "", http.StatusConflict, ""},
+		{"read-only field", "PATCH", "application/merge-patch+json", `{"Id":7}`, "", http.StatusBadRequest, ""},
+		{"unsupported media type", "PATCH", "application/json", `{"Msg":"Brew"}`, "", http.StatusUnsupportedMediaType, ""},
+		{"stale put", "PUT", "application/json", `{"Msg":"Brew"}`, `"2"`, http.StatusPreconditionFailed, `"3"`},
+		{"put", "PUT", "application/json", `{"Msg":"Brew porter"}`, `"3"`, http.StatusCreated, `"4"`},
+		{"stale delete", "DELETE", "", "", `"3"`, http.StatusPreconditionFailed, `"4"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.ifMatch != "" {
+				headers["If-Match"] = tt.ifMatch
+			}
+			rr := doConditionalRequest(router, tt.method, "/api/tasks/1", tt.contentType, tt.body, headers)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantETag != "" && rr.Header().Get("ETag") != tt.wantETag {
+				t.Errorf("Expected ETag %s, got %q", tt.wantETag, rr.Header().Get("ETag"))
+			}
+		})
+	}
+
+	rr = doConditionalRequest(router, "GET", "/api/tasks", "", "", map[string]string{"If-None-Match": listETag})
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK for changed list, got %v", rr.Code)
+	}
+	rr = doConditionalRequest(router, "DELETE", "/api/tasks/1", "", "", map[string]string{"If-Match": `"4"`})
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK for delete, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
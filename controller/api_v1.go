@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+)
+
+const v1Prefix = "/api/v1"
+
+// LegacyDeprecatedAt is sent in the RFC 9745 Deprecation header of the legacy
+// task routes, main sets it from LEGACY_DEPRECATED_AT
+var LegacyDeprecatedAt = time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC)
+
+func (api *ApiService) RegisterRoutesV1(router *Router) {
+	router.Handle("GET /api/v1/tasks", middleware.OptionalAuthMiddleware(api.ListTasksV1), &RouteDoc{
+		Summary: "List tasks", Tag: "v1", Params: taskQueryParams, Response: v1.TaskList{}})
+	router.Handle("GET /api/v1/tasks/{id}", middleware.OptionalAuthMiddleware(api.GetTaskV1), &RouteDoc{
+		Summary: "Get task", Tag: "v1", Response: v1.Task{}})
+	router.HandleAuth("POST /api/v1/tasks", api.Idempotent(api.CreateTaskV1), &RouteDoc{
+		Summary: "Create task", Tag: "v1", Request: v1.TaskInput{}, Status: http.StatusCreated, Response: v1.Task{}})
+	router.HandleAuth("PATCH /api/v1/tasks/{id}", api.UpdateTaskV1, &RouteDoc{
+		Summary: "Change sent task fields", Tag: "v1", Request: v1.TaskInput{}, RequestTypes: []string{"application/json", string(internal.MergePatch)}, Response: v1.Task{}})
+	router.HandleAuth("DELETE /api/v1/tasks/{id}", api.DeleteTaskV1, &RouteDoc{
+		Summary: "Delete task", Tag: "v1", Status: http.StatusNoContent})
+}
+
+// deprecated adds Deprecation and a successor link to the same path under /api/v1
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(LegacyDeprecatedAt.Unix(), 10))
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, v1Prefix, r.URL.Path[len("/api"):]))
+		next(w, r)
+	}
+}
+
+// handleErrorV1 is handleError with field names of the v1 DTOs
+func handleErrorV1(w http.ResponseWriter, err error, status int, message string) bool {
+	if err == nil {
+		return false
+	}
+	problem := problemFromError(err, status, message)
+	logger.Error.Printf("%s: %v", message, err)
+	for i := range problem.Errors {
+		problem.Errors[i].Field = v1.FieldName(problem.Errors[i].Field)
+	}
+	writeProblem(w, problem)
+	return true
+}
+
+func decodeTaskInput(r *http.Request) (v1.TaskInput, internal.TaskOptional, error) {
+	var input v1.TaskInput
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		return input, internal.TaskOptional{}, err
+	}
+	update, err := input.ToTaskOptional()
+	return input, update, err
+}
+
+func (api *ApiService) ListTasksV1(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseTaskQuery(r.URL.Query())
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	etag := listETag(api.etagSeed, tasks.Revision(), v1Prefix+requestWorkspace(r)+"?"+r.URL.RawQuery)
+	if !noneMatch(r, etag) {
+		writeNotModified(w, etag)
+		return
+	}
+	page, err := tasks.QueryTasks(query)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+
+	response := v1.TaskList{Items: v1.FromTasks(page.Tasks), Total: page.Total}
+	if page.NextCursor != "" {
+		next := r.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		response.Next = r.URL.Path + "?" + next.Encode()
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, response.Next))
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	w.Header().Set("ETag", etag)
+	writeJson(w, http.StatusOK, response)
+}
+
+func (api *ApiService) GetTaskV1(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	taskId, err := getTaskIdFromPath(r)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	task, err := tasks.GetTask(taskId)
+	if handleErrorV1(w, err, http.StatusNotFound, "task not found") {
+		return
+	}
+	if !noneMatch(r, taskETag(task)) {
+		writeNotModified(w, taskETag(task))
+		return
+	}
+	w.Header().Set("ETag", taskETag(task))
+	writeJson(w, http.StatusOK, v1.FromTask(*task))
+}
+
+func (api *ApiService) CreateTaskV1(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.CreateTask, nil)
+	if !ok {
+		return
+	}
+	_, update, err := decodeTaskInput(r)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	err = internal.ValidateNewTask(&update)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	update.CreatedBy = user
+	task := tasks.CreateTask(update)
+	recordTask(r.Context(), user, audit.TaskCreated, task.Id)
+
+	w.Header().Set("Location", fmt.Sprintf("%s/tasks/%d", v1Prefix, task.Id))
+	w.Header().Set("ETag", taskETag(task))
+	writeJson(w, http.StatusCreated, v1.FromTask(*task))
+}
+
+// UpdateTaskV1 changes the sent fields, like a merge patch of the v1 task
+func (api *ApiService) UpdateTaskV1(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != string(internal.MergePatch) {
+		w.Header().Set("Accept-Patch", "application/json, "+string(internal.MergePatch))
+		writeProblem(w, NewProblem(http.StatusUnsupportedMediaType, "unsupported content type "+mediaType))
+		return
+	}
+	taskId, err := getTaskIdFromPath(r)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	_, update, err := decodeTaskInput(r)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	current, err := tasks.GetTask(taskId)
+	if handleErrorV1(w, err, http.StatusNotFound, "task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.EditTask, current)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(r, current)
+	if !ok {
+		writePreconditionFailed(w, current)
+		return
+	}
+	update.UpdatedBy = user
+	task, err := tasks.UpdateTaskIfVersion(taskId, version, &update)
+	var versionErr *internal.VersionMismatchError
+	if errors.As(err, &versionErr) && handleWriteError(w, tasks, err, taskId) {
+		return
+	}
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	recordTask(r.Context(), user, audit.TaskUpdated, taskId)
+	w.Header().Set("ETag", taskETag(task))
+	writeJson(w, http.StatusOK, v1.FromTask(*task))
+}
+
+func (api *ApiService) DeleteTaskV1(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	taskId, err := getTaskIdFromPath(r)
+	if handleErrorV1(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	current, err := tasks.GetTask(taskId)
+	if handleErrorV1(w, err, http.StatusNotFound, "task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.DeleteTask, current)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(r, current)
+	if !ok {
+		writePreconditionFailed(w, current)
+		return
+	}
+	err = tasks.DeleteTaskIfVersion(taskId, version)
+	if handleWriteError(w, tasks, err, taskId) {
+		return
+	}
+	recordTask(r.Context(), user, audit.TaskDeleted, taskId)
+	w.WriteHeader(http.StatusNoContent)
+}
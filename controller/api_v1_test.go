@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func setupApiV1(t *testing.T) (*Router, *internal.TaskHolder) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewApiService(taskService, userStore)
+	t.Cleanup(taskService.CloseAll)
+	router := NewRouter()
+	api.RegisterRoutes(router)
+	api.RegisterRoutesV1(router)
+	return router, taskHolder
+}
+
+func TestApiV1Tasks(t *testing.T) {
+	router, _ := setupApiV1(t)
+
+	rr := doConditionalRequest(router, "POST", "/api/v1/tasks", "application/json",
+		`{"message":"Brew Hazy IPA","category":"brewing","tags":["IPA"]}`, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Location") != "/api/v1/tasks/1" || rr.Header().Get("ETag") != `"1"` {
+		t.Errorf("Unexpected headers %v", rr.Header())
+	}
+	var fields map[string]any
+	json.NewDecoder(rr.Body).Decode(&fields)
+	for _, key := range []string{"id", "message", "category", "done", "createdAt", "createdBy", "tags", "checklist", "version"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("Expected field %q in %v", key, fields)
+		}
+	}
+	if _, ok := fields["Msg"]; ok || fields["category"] != "brewing" || fields["createdBy"].(map[string]any)["name"] != "AAA" {
+		t.Errorf("Unexpected v1 task %v", fields)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		headers    map[string]string
+		wantStatus int
+		wantField  string
+	}{
+		{"get", "GET", "/api/v1/tasks/1", "", nil, http.StatusOK, ""},
+		{"list", "GET", "/api/v1/tasks?tag=ipa", "", nil, http.StatusOK, ""},
+		{"empty message", "POST", "/api/v1/tasks", `{"message":""}`, nil, http.StatusBadRequest, "message"},
+		{"unknown category", "POST", "/api/v1/tasks", `{"message":"Brew","category":"tasting"}`, nil, http.StatusBadRequest, "category"},
+		{"legacy field name", "POST", "/api/v1/tasks", `{"Msg":"Brew"}`, nil, http.StatusBadRequest, ""},
+		{"patch", "PATCH", "/api/v1/tasks/1", `{"done":true}`, map[string]string{"If-Match": `"1"`}, http.StatusOK, ""},
+		{"stale patch", "PATCH", "/api/v1/tasks/1", `{"done":false}`, map[string]string{"If-Match": `"1"`}, http.StatusPreconditionFailed, ""},
+		{"stale delete", "DELETE", "/api/v1/tasks/1", "", map[string]string{"If-Match": `"1"`}, http.StatusPreconditionFailed, ""},
+		{"delete", "DELETE", "/api/v1/tasks/1", "", map[string]string{"If-Match": `"2"`}, http.StatusNoContent, ""},
+		{"deleted", "GET", "/api/v1/tasks/1", "", nil, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doConditionalRequest(router, tt.method, tt.path, "application/json", tt.body, tt.headers)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Header().Get("Deprecation") != "" {
+				t.Errorf("Expected no Deprecation header on v1")
+			}
+			if tt.wantField != "" {
+				problem := decodeProblem(t, rr)
+				if len(problem.Errors) != 1 || problem.Errors[0].Field != tt.wantField {
+					t.Errorf("Expected error for field %s, got %+v", tt.wantField, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyTaskRoutesDeprecated(t *testing.T) {
+	router, taskHolder := setupApiV1(t)
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+
+	for _, path := range []string{"/api/tasks", "/api/tasks/1"} {
+		rr := doConditionalRequest(router, "GET", path, "", "", nil)
+		if rr.Header().Get("Deprecation") != "@"+strconv.FormatInt(LegacyDeprecatedAt.Unix(), 10) {
+			t.Errorf("Expected Deprecation header on %s, got %q", path, rr.Header().Get("Deprecation"))
+		}
+		successor := `</api/v1` + strings.TrimPrefix(path, "/api") + `>; rel="successor-version"`
+		if rr.Header().Get("Link") != successor {
+			t.Errorf("Expected Link %s, got %q", successor, rr.Header().Get("Link"))
+		}
+	}
+
+	router.Handle("GET /api/openapi.json", router.ServeOpenAPI, &RouteDoc{Summary: "OpenAPI document"})
+	rr := doConditionalRequest(router, "GET", "/api/openapi.json", "", "", nil)
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Deprecated bool `json:"deprecated"`
+		} `json:"paths"`
+	}
+	json.NewDecoder(rr.Body).Decode(&spec)
+	if !spec.Paths["/api/tasks"]["get"].Deprecated || spec.Paths["/api/v1/tasks"]["get"].Deprecated {
+		t.Errorf("Expected only legacy GET /api/tasks deprecated, got %+v", spec.Paths["/api/tasks"])
+	}
+	var task v1.Task
+	rr = doConditionalRequest(router, "GET", "/api/v1/tasks/1", "", "", nil)
+	json.NewDecoder(rr.Body).Decode(&task)
+	if task.Message != "Brew IPA" {
+		t.Errorf("Expected v1 task, got %+v", task)
+	}
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// AuditApiService lets admins search and export the audit log
+type AuditApiService struct {
+	log       *audit.Log
+	userStore *users.UserStore
+}
+
+func NewAuditApiService(log *audit.Log, userStore *users.UserStore) *AuditApiService {
+	return &AuditApiService{log: log, userStore: userStore}
+}
+
+func (api *AuditApiService) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /api/audit", api.GetEntries, &RouteDoc{
+		Summary: "Search the audit log, newest first, admins only", Tag: "audit",
+		Params:   append(auditParams, Param{Name: "limit", In: "query", Type: "integer", Description: "At most 1000, default 100"}),
+		Response: []audit.Entry{}})
+	router.HandleAuth("GET /api/audit/export", api.Export, &RouteDoc{
+		Summary: "Export the audit log as JSON lines, oldest first, admins only", Tag: "audit",
+		Params: auditParams, Response: audit.Entry{}, ResponseType: "application/x-ndjson"})
+}
+
+var auditParams = []Param{
+	{Name: "event", In: "query", Description: "Event like task.deleted, or a group like task"},
+	{Name: "user", In: "query", Description: "User name or id"},
+	{Name: "target", In: "query", Description: "Target like task 42"},
+	{Name: "requestId", In: "query", Description: "X-Request-Id of the request"},
+	{Name: "since", In: "query", Description: "RFC 3339 time, inclusive"},
+	{Name: "until", In: "query", Description: "RFC 3339 time, exclusive"},
+}
+
+// parseAuditQuery reads the filters of GET /api/audit, since and until are
+// RFC 3339 times
+func parseAuditQuery(r *http.Request) (audit.Query, error) {
+	values := r.URL.Query()
+	query := audit.Query{
+		Event:     audit.Event(values.Get("event")),
+		User:      values.Get("user"),
+		Target:    values.Get("target"),
+		RequestId: values.Get("requestId"),
+	}
+	for param, field := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := values.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, &audit.InvalidQueryError{Param: param, Reason: "not an RFC 3339 time"}
+			}
+			*field = parsed
+		}
+	}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return query, &audit.InvalidQueryError{Param: "limit", Reason: "not a number"}
+		}
+		query.Limit = limit
+	}
+	return query, query.Validate()
+}
+
+func (api *AuditApiService) GetEntries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ViewAudit, nil); !ok {
+		return
+	}
+	query, err := parseAuditQuery(r)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	entries, err := api.log.Query(query)
+	if handleError(w, err, http.StatusInternalServerError, "api: error reading audit log") {
+		return
+	}
+	writeJson(w, http.StatusOK, entries)
+}
+
+func (api *AuditApiService) Export(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ViewAudit, nil); !ok {
+		return
+	}
+	query, err := parseAuditQuery(r)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	query.Limit = 0
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	if err := api.log.Export(w, query); err != nil {
+		// the status is sent with the first line
+		logger.Error.Printf("api: error exporting audit log: %v", err)
+	}
+}
+
+// recordAudit adds event to middleware.Audit for user, or the user id of the
+// request when the handler didn't look the user up
+func recordAudit(ctx context.Context, user *users.User, event audit.Event, target, detail string) {
+	entry := audit.Entry{Event: event, Target: target, Detail: detail}
+	if user != nil {
+		entry.UserId, entry.UserName = user.UserId.String(), user.UserName
+	} else if userId, ok := middleware.UserFromContext(ctx); ok {
+		entry.UserId = userId
+	}
+	middleware.Audit.Record(ctx, entry)
+}
+
+func taskTarget(taskId int) string {
+	return fmt.Sprintf("task %d", taskId)
+}
+
+// recordTask records a change of task taskId
+func recordTask(ctx context.Context, user *users.User, event audit.Event, taskId int) {
+	recordAudit(ctx, user, event, taskTarget(taskId), "")
+}
+
+// checkPermission is authz.Check recording denials in the audit log
+func checkPermission(ctx context.Context, user users.User, action authz.Action, task *internal.Task) error {
+	err := authz.Check(user, action, task)
+	if err != nil {
+		target := ""
+		if task != nil {
+			target = taskTarget(task.Id)
+		}
+		recordAudit(ctx, &user, audit.PermissionDenied, target, err.Error())
+	}
+	return err
+}
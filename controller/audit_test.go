@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// useAuditLog records to a temporary log for the test
+func useAuditLog(t *testing.T) *audit.Log {
+	log, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := middleware.Audit
+	middleware.Audit = log
+	t.Cleanup(func() {
+		middleware.Audit = previous
+		log.Close()
+	})
+	return log
+}
+
+// setupAudit serves setupRoles with login and the audit API, recording to a
+// temporary log
+func setupAudit(t *testing.T) (http.Handler, *users.UserStore, *internal.TaskHolder) {
+	log := useAuditLog(t)
+	router, userStore, taskHolder := setupRoles(t)
+	NewAuthHandler(userStore).RegisterRoutes(router)
+	NewAuditApiService(log, userStore).RegisterRoutes(router)
+	return middleware.RequestMiddleware(router), userStore, taskHolder
+}
+
+func queryAudit(t *testing.T, handler http.Handler, token, query string) []audit.Entry {
+	t.Helper()
+	rr := doTokenRequest(handler, "GET", "/api/audit?"+query, token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var entries []audit.Entry
+	json.NewDecoder(rr.Body).Decode(&entries)
+	return entries
+}
+
+func TestAuditTaskDeletion(t *testing.T) {
+	handler, userStore, taskHolder := setupAudit(t)
+	admin, _ := userStore.GetUser("AAA")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), CreatedBy: &admin})
+	adminToken := roleToken(userStore, "AAA")
+
+	req := httptest.NewRequest("DELETE", "/api/tasks/1", nil)
+	req.Header.Set("Authorization", adminToken)
+	req.Header.Set("User-Agent", "brewctl/1.0")
+	req.Header.Set(middleware.RequestIdHeader, "delete-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if id := rr.Header().Get(middleware.RequestIdHeader); id != "delete-1" {
+		t.Errorf("Expected the request id to be sent back, got %q", id)
+	}
+
+	entries := queryAudit(t, handler, adminToken, "event=task.deleted&target=task+1")
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 deletion, got %+v", entries)
+	}
+	deleted := entries[0]
+	if deleted.UserName != "AAA" || deleted.UserId != admin.UserId.String() || deleted.IP != "192.0.2.1" ||
+		deleted.UserAgent != "brewctl/1.0" || deleted.RequestId != "delete-1" {
+		t.Errorf("Expected who deleted the task and from where, got %+v", deleted)
+	}
+
+	// an invalid request id is replaced
+	req = httptest.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set(middleware.RequestIdHeader, "not valid\n")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if id := rr.Header().Get(middleware.RequestIdHeader); id == "" || id == "not valid\n" {
+		t.Errorf("Expected a generated request id, got %q", id)
+	}
+}
+
+func TestAuditSecurityEvents(t *testing.T) {
+	handler, userStore, taskHolder := setupAudit(t)
+	admin, _ := userStore.GetUser("AAA")
+	brewer, _ := userStore.GetUser("BBB")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), CreatedBy: &admin})
+
+	doTokenRequest(handler, "DELETE", "/api/tasks/1", roleToken(userStore, "BBB"), nil)
+	doTokenRequest(handler, "DELETE", "/api/tasks/1", "forged-token", nil)
+	doTokenRequest(handler, "POST", "/login", "", loginRequest{UserName: "BBB", Password: "wrong-password"})
+
+	adminToken := roleToken(userStore, "AAA")
+	tests := []struct {
+		query      string
+		wantUserId string
+		wantTarget string
+	}{
+		{"event=permission.denied", brewer.UserId.String(), "task 1"},
+		{"event=auth.failed", "", ""},
+		{"event=login.failed", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			entries := queryAudit(t, handler, adminToken, tt.query)
+			if len(entries) != 1 || entries[0].UserId != tt.wantUserId || entries[0].Target != tt.wantTarget {
+				t.Errorf("Expected 1 entry of %s with target %q, got %+v", tt.wantUserId, tt.wantTarget, entries)
+			}
+		})
+	}
+	if entries := queryAudit(t, handler, adminToken, "event=task"); len(entries) != 0 {
+		t.Errorf("Expected no task changes, got %+v", entries)
+	}
+}
+
+func TestAuditApi(t *testing.T) {
+	handler, userStore, _ := setupAudit(t)
+	adminToken := roleToken(userStore, "AAA")
+	for _, msg := range []string{"Brew IPA", "Order malt"} {
+		doTokenRequest(handler, "POST", "/api/tasks", adminToken, internal.TaskOptional{Msg: internal.StringPtr(msg)})
+	}
+
+	tests := []struct {
+		name       string
+		user       string
+		path       string
+		wantStatus int
+	}{
+		{"admin queries", "AAA", "/api/audit?user=AAA&limit=1", http.StatusOK},
+		{"brewer may not query", "BBB", "/api/audit", http.StatusForbidden},
+		{"brewer may not export", "BBB", "/api/audit/export", http.StatusForbidden},
+		{"limit not a number", "AAA", "/api/audit?limit=ten", http.StatusBadRequest},
+		{"limit too high", "AAA", "/api/audit?limit=5000", http.StatusBadRequest},
+		{"since not a time", "AAA", "/api/audit?since=yesterday", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(handler, "GET", tt.path, roleToken(userStore, tt.user), nil)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doTokenRequest(handler, "GET", "/api/audit/export?event=task.created", adminToken, nil)
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Expected JSON lines, got %s", contentType)
+	}
+	var targets []string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var entry audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Unexpected error decoding line %q: %v", scanner.Text(), err)
+		}
+		targets = append(targets, entry.Target)
+	}
+	if len(targets) != 2 || targets[0] != "task 1" || targets[1] != "task 2" {
+		t.Errorf("Expected both created tasks oldest first, got %v", targets)
+	}
+}
+
+func TestAuditBatchAndReassign(t *testing.T) {
+	log := useAuditLog(t)
+	router, userStore, taskHolder := setupRoles(t)
+	batchHolder, _ := internal.NewBatchHolder("", taskHolder)
+	NewBatchApiService(batchHolder, userStore).RegisterRoutes(router)
+	userApi := NewUserApiService(userStore)
+	userApi.Workspaces = internal.NewWorkspaces(internal.NewConcurrentTaskService(taskHolder))
+	t.Cleanup(userApi.Workspaces.CloseAll)
+	usersRouter := NewRouter()
+	userApi.RegisterRoutes(usersRouter)
+	adminToken := roleToken(userStore, "AAA")
+
+	batch := internal.Batch{Style: "Pilsner", Profile: "lager", VolumeLiters: 1000, Fermenter: "FV1", BrewDate: time.Now().Add(7 * 24 * time.Hour)}
+	rr := doTokenRequest(router, "POST", "/api/batches", adminToken, batch)
+	var created batchResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if entries, _ := log.Query(audit.Query{Event: audit.TaskCreated}); len(created.Tasks) == 0 || len(entries) != len(created.Tasks) {
+		t.Errorf("Expected %d created tasks, got %+v", len(created.Tasks), entries)
+	}
+
+	done := true
+	taskHolder.PartialUpdateTask(created.Tasks[0].Id, &internal.TaskOptional{Done: &done})
+	brewDate := internal.CustomTime{Time: batch.BrewDate.Add(24 * time.Hour)}
+	doTokenRequest(router, "PUT", "/api/batches/1/brew-date", adminToken, rescheduleRequest{BrewDate: &brewDate})
+	entries, _ := log.Query(audit.Query{Event: audit.TaskUpdated})
+	if len(entries) != len(created.Tasks)-1 || entries[0].UserName != "AAA" {
+		t.Errorf("Expected the %d moved tasks, got %+v", len(created.Tasks)-1, entries)
+	}
+
+	brewer, _ := userStore.GetUser("BBB")
+	assignee := "BBB"
+	taskHolder.PartialUpdateTask(created.Tasks[1].Id, &internal.TaskOptional{Assignee: &assignee})
+	doTokenRequest(usersRouter, "DELETE", "/api/users/"+brewer.UserId.String(), adminToken, nil)
+	entries, _ = log.Query(audit.Query{Event: audit.TaskUpdated, Target: taskTarget(created.Tasks[1].Id)})
+	if len(entries) != 2 || entries[0].Detail != "assignee deleted in workspace default" {
+		t.Errorf("Expected the unassignment recorded, got %+v", entries)
+	}
+}
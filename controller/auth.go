@@ -3,93 +3,125 @@ package controller
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/zhekagigs/golang_todo/audit"
 	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/oidc"
 	"github.com/zhekagigs/golang_todo/users"
 )
 
 type AuthHandler struct {
 	UserStore *users.UserStore
+	// OIDC enables single sign-on at /login/oidc, users are provisioned on
+	// their first login
+	OIDC *oidc.Provider
 }
 
 func NewAuthHandler(userStore *users.UserStore) *AuthHandler {
 	return &AuthHandler{UserStore: userStore}
 }
 
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+func (ah *AuthHandler) RegisterRoutes(router *Router) {
+	router.Handle("POST /register", ah.RegisterHandler, &RouteDoc{
+		Summary: "Create an account with a password and log in", Tag: "auth", Request: loginRequest{}, Response: loginResponse{}})
+	router.Handle("POST /login", ah.LoginHandler, &RouteDoc{
+		Summary: "Log in and set session cookies", Tag: "auth", Request: loginRequest{}, Response: loginResponse{}})
+	router.Handle("POST /logout", middleware.CSRFMiddleware(ah.LogoutHandler), &RouteDoc{
+		Summary: "End the session and clear session cookies", Tag: "auth", Response: messageResponse{}})
+	router.Handle("GET /login/oidc", ah.OIDCLoginHandler, &RouteDoc{
+		Summary: "Redirect to the identity provider for single sign-on", Tag: "auth"})
+	router.Handle("GET /login/oidc/callback", ah.OIDCCallbackHandler, &RouteDoc{
+		Summary: "Finish single sign-on, set session cookies and redirect to the tasks", Tag: "auth"})
+}
+
 type loginRequest struct {
 	UserName string `json:"userName"`
+	Password string `json:"password"`
 }
 
-func (ah *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	var loginRequest *loginRequest
+// loginResponse carries the session token for api and gRPC clients, browsers
+// use the cookie and send CSRFToken with unsafe requests
+type loginResponse struct {
+	Message   string    `json:"message"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CSRFToken string    `json:"csrfToken"`
+}
 
-	err := json.NewDecoder(r.Body).Decode(&loginRequest)
+func (ah *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	var request loginRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
 	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
 		return
 	}
-	var userId string
-	var userName string
-	userOld, exist := ah.UserStore.GetUser(loginRequest.UserName)
-
-	if !exist {
-		logger.Error.Println("User doesnt exist: ", loginRequest.UserName)
-		userNew, err := ah.UserStore.AddUser(loginRequest.UserName)
-		logger.Info.Println("User was added: ", userNew.UserName)
-		if handleError(w, err, http.StatusBadRequest, "error add user") {
-			return
-		}
-		userId = userNew.UserId.String()
-		userName = userNew.UserName
-	} else {
-		userId = userOld.UserId.String()
-		userName = userOld.UserName
+	user, err := ah.UserStore.Register(request.UserName, request.Password)
+	if handleError(w, err, http.StatusInternalServerError, "error registering user") {
+		return
 	}
-	logger.Error.Println("Found user: ", loginRequest.UserName)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Authorization",
-		Value:    userId,
-		MaxAge:   600,
-		SameSite: http.SameSiteStrictMode,
+	logger.Info.Println("User was registered: ", user.UserName)
+	startSession(w, http.StatusCreated, *user, "Registration successful")
+}
 
-		// SameSite: http.SameSiteDefaultMode,
-		// Secure:   true,
-	})
+func (ah *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var request loginRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	user, err := ah.UserStore.Authenticate(request.UserName, request.Password)
+	if err != nil {
+		recordAudit(r.Context(), nil, audit.LoginFailed, "", request.UserName+": "+err.Error())
+	}
+	if handleError(w, err, http.StatusInternalServerError, "error logging in") {
+		return
+	}
+	logger.Info.Println("User logged in: ", user.UserName)
+	recordAudit(r.Context(), &user, audit.Login, "", "password")
+	startSession(w, http.StatusOK, user, "Login successful")
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "UserName",
-		Value:    userName,
-		MaxAge:   600,
-		SameSite: http.SameSiteStrictMode,
-	})
+// startSession issues a session token and answers with it
+func startSession(w http.ResponseWriter, status int, user users.User, message string) {
+	token, expiresAt := setSessionCookies(w, user)
+	writeJson(w, status, loginResponse{Message: message, Token: token, ExpiresAt: expiresAt, CSRFToken: middleware.Sessions.CSRFToken(token)})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
+// setSessionCookies issues a session token and sets it as HttpOnly cookie,
+// the UserName cookie is read by the pages to greet the user
+func setSessionCookies(w http.ResponseWriter, user users.User) (string, time.Time) {
+	token, expiresAt := middleware.Sessions.Issue(user.UserId.String())
+	ttl := middleware.Sessions.TTL()
+	http.SetCookie(w, middleware.Cookies.New(middleware.SessionCookie, token, ttl, true))
+	http.SetCookie(w, middleware.Cookies.New("UserName", user.UserName, ttl, false))
+	return token, expiresAt
 }
 
+// LogoutHandler revokes the session of the cookie or Authorization header, so
+// the token stops working even where it was copied
 func (h *AuthHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Clear UserName cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "UserName",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: false,
-		Secure:   true, // Set to true if using HTTPS
-		SameSite: http.SameSiteStrictMode,
-	})
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token, _ = middleware.ExtractSessionToken(r)
+	}
+	// API tokens are revoked with DELETE /api/tokens/{id}
+	if token != "" && !strings.HasPrefix(token, "Bearer ") {
+		if userId, err := middleware.Sessions.Verify(token); err == nil {
+			middleware.Audit.Record(r.Context(), audit.Entry{Event: audit.Logout, UserId: userId})
+		}
+		if err := middleware.Sessions.Revoke(token); err != nil {
+			logger.Error.Println("error revoking session", err)
+		}
+	}
 
-	// Clear Authorization cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Authorization",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true, // Keep this true for security
-		Secure:   true, // Set to true if using HTTPS
-		SameSite: http.SameSiteStrictMode,
-	})
+	http.SetCookie(w, middleware.Cookies.Clear("UserName", false))
+	http.SetCookie(w, middleware.Cookies.Clear(middleware.SessionCookie, true))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
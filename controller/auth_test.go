@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func setupAuth(t *testing.T) *Router {
+	userStore, err := users.NewUserStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	userStore.AddUser("legacy")
+	router := NewRouter()
+	NewAuthHandler(userStore).RegisterRoutes(router)
+	me := func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := middleware.UserFromContext(r.Context())
+		w.Write([]byte(userId))
+	}
+	router.HandleAuth("GET /me", me, nil)
+	router.HandleAuth("POST /me", me, nil)
+	router.HandleAuth("GET /api/me", me, nil)
+	return router
+}
+
+func postLogin(router http.Handler, path string, request loginRequest) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(request)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewReader(payload)))
+	return rr
+}
+
+func getMe(router http.Handler, cookie *http.Cookie) string {
+	req := httptest.NewRequest("GET", "/me", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr.Body.String()
+}
+
+func sessionCookie(rr *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == middleware.SessionCookie {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestRegisterLoginLogout(t *testing.T) {
+	router := setupAuth(t)
+
+	rr := postLogin(router, "/register", loginRequest{UserName: "brewer", Password: "hoppy-ipa"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var registered loginResponse
+	json.NewDecoder(rr.Body).Decode(&registered)
+	userId, err := middleware.Sessions.Verify(registered.Token)
+	if err != nil || getMe(router, sessionCookie(rr)) != userId {
+		t.Fatalf("Expected session of registered user, got %v", err)
+	}
+
+	rr = postLogin(router, "/login", loginRequest{UserName: "brewer", Password: "hoppy-ipa"})
+	cookie := sessionCookie(rr)
+	if rr.Code != http.StatusOK || cookie == nil || !cookie.HttpOnly || cookie.Value == userId {
+		t.Fatalf("Expected signed HttpOnly session cookie, got %v %+v", rr.Code, cookie)
+	}
+	if getMe(router, cookie) != userId {
+		t.Errorf("Expected logged in user")
+	}
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(cookie)
+	req.Header.Set(middleware.CSRFHeader, middleware.Sessions.CSRFToken(cookie.Value))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if getMe(router, cookie) == userId {
+		t.Error("Expected session revoked on logout")
+	}
+	if getMe(router, sessionCookie(postLogin(router, "/login", loginRequest{UserName: "brewer", Password: "hoppy-ipa"}))) != userId {
+		t.Error("Expected new login to work after logout")
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	router := setupAuth(t)
+	postLogin(router, "/register", loginRequest{UserName: "brewer", Password: "hoppy-ipa"})
+
+	tests := []struct {
+		name       string
+		path       string
+		request    loginRequest
+		wantStatus int
+	}{
+		{"wrong password", "/login", loginRequest{UserName: "brewer", Password: "malty-stout"}, http.StatusUnauthorized},
+		{"no implicit signup", "/login", loginRequest{UserName: "newbie", Password: "hoppy-ipa"}, http.StatusUnauthorized},
+		{"user without password", "/login", loginRequest{UserName: "legacy"}, http.StatusUnauthorized},
+		{"name taken", "/register", loginRequest{UserName: "legacy", Password: "hoppy-ipa"}, http.StatusConflict},
+		{"short password", "/register", loginRequest{UserName: "newbie", Password: "ipa"}, http.StatusBadRequest},
+		{"empty name", "/register", loginRequest{Password: "hoppy-ipa"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postLogin(router, tt.path, tt.request)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if sessionCookie(rr) != nil {
+				t.Error("Expected no session cookie")
+			}
+		})
+	}
+}
+
+func TestLoginRequired(t *testing.T) {
+	router := setupAuth(t)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/me", nil))
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("Content-Type") != problemContentType {
+		t.Errorf("Expected 401 problem for the api, got %v %s: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	if body := getMe(router, nil); !strings.Contains(body, "Please go back and login") {
+		t.Errorf("Expected login link for a page, got %s", body)
+	}
+}
+
+func TestSessionTokenRejected(t *testing.T) {
+	sessions := middleware.Sessions
+	middleware.Sessions = middleware.NewSessionManager([]byte("test secret"), time.Hour)
+	t.Cleanup(func() { middleware.Sessions = sessions })
+	router := setupAuth(t)
+	rr := postLogin(router, "/register", loginRequest{UserName: "brewer", Password: "hoppy-ipa"})
+	userId, _ := middleware.Sessions.Verify(sessionCookie(rr).Value)
+	expired, _ := middleware.NewSessionManager([]byte("test secret"), -time.Minute).Issue(userId)
+	forged, _ := middleware.NewSessionManager([]byte("other secret"), time.Hour).Issue(userId)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"raw user id", userId},
+		{"valid", sessionCookie(rr).Value},
+		{"other secret", forged},
+		{"expired", expired},
+		{"tampered", sessionCookie(rr).Value + "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getMe(router, &http.Cookie{Name: middleware.SessionCookie, Value: tt.token})
+			if (got == userId) != (tt.name == "valid") {
+				t.Errorf("Expected only the valid token accepted, %s got %q", tt.name, got)
+			}
+		})
+	}
+}
+
+func TestLogoutSurvivesRestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions.json")
+	sessions := middleware.Sessions
+	middleware.Sessions = middleware.NewSessionManager([]byte("test secret"), time.Hour)
+	t.Cleanup(func() { middleware.Sessions = sessions })
+	if err := middleware.Sessions.LoadRevocations(file); err != nil {
+		t.Fatalf("Expected no error for a missing file, got %v", err)
+	}
+	router := setupAuth(t)
+	cookie := sessionCookie(postLogin(router, "/register", loginRequest{UserName: "brewer", Password: "hoppy-ipa"}))
+	kept, _ := middleware.Sessions.Issue("other-user")
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(cookie)
+	req.Header.Set(middleware.CSRFHeader, middleware.Sessions.CSRFToken(cookie.Value))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	restarted := middleware.NewSessionManager([]byte("test secret"), time.Hour)
+	if err := restarted.LoadRevocations(file); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := restarted.Verify(cookie.Value); err != middleware.ErrRevokedSession {
+		t.Errorf("Expected %v after restart, got %v", middleware.ErrRevokedSession, err)
+	}
+	if _, err := restarted.Verify(kept); err != nil {
+		t.Errorf("Expected other sessions to stay valid, got %v", err)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	router := setupAuth(t)
+	rr := postLogin(router, "/register", loginRequest{UserName: "brewer", Password: "hoppy-ipa"})
+	var login loginResponse
+	json.NewDecoder(rr.Body).Decode(&login)
+	cookie := sessionCookie(rr)
+	other, _ := middleware.Sessions.Issue(login.Token)
+
+	tests := []struct {
+		name       string
+		header     string
+		form       string
+		cookie     bool
+		auth       string
+		wantStatus int
+	}{
+		{"no token", "", "", true, "", http.StatusForbidden},
+		{"header", login.CSRFToken, "", true, "", http.StatusOK},
+		{"form field", "", login.CSRFToken, true, "", http.StatusOK},
+		{"token of another session", middleware.Sessions.CSRFToken(other), "", true, "", http.StatusForbidden},
+		{"session id as token", cookie.Value, "", true, "", http.StatusForbidden},
+		{"authorization header", "", "", false, login.Token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set(middleware.CSRFField, tt.form)
+			}
+			req := httptest.NewRequest("POST", "/me", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set(middleware.CSRFHeader, tt.header)
+			}
+			if tt.cookie {
+				req.AddCookie(cookie)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || getMe(router, cookie) == "" {
+		t.Errorf("Expected forged logout rejected, got %v", rr.Code)
+	}
+}
+
+func TestCookiePolicy(t *testing.T) {
+	policy := middleware.Cookies
+	middleware.Cookies.Secure = true
+	t.Cleanup(func() { middleware.Cookies = policy })
+	router := setupAuth(t)
+
+	rr := postLogin(router, "/register", loginRequest{UserName: "brewer", Password: "hoppy-ipa"})
+	var login loginResponse
+	json.NewDecoder(rr.Body).Decode(&login)
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(sessionCookie(rr))
+	req.Header.Set(middleware.CSRFHeader, login.CSRFToken)
+	logout := httptest.NewRecorder()
+	router.ServeHTTP(logout, req)
+
+	for _, cookie := range append(rr.Result().Cookies(), logout.Result().Cookies()...) {
+		if !cookie.Secure || cookie.Path != "/" || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("Expected secure strict cookie for the site, got %+v", cookie)
+		}
+		if cookie.Name == middleware.SessionCookie && !cookie.HttpOnly {
+			t.Errorf("Expected HttpOnly session cookie, got %+v", cookie)
+		}
+	}
+	if len(logout.Result().Cookies()) != 2 || logout.Result().Cookies()[0].MaxAge >= 0 {
+		t.Errorf("Expected logout to clear both cookies, got %v", logout.Result().Cookies())
+	}
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type BatchApiService struct {
+	batches   *internal.BatchHolder
+	userStore *users.UserStore
+}
+
+type batchResponse struct {
+	internal.Batch
+	Tasks []internal.Task `json:"tasks"`
+}
+
+type rescheduleRequest struct {
+	BrewDate *internal.CustomTime `json:"brewDate"`
+}
+
+func NewBatchApiService(batches *internal.BatchHolder, userStore *users.UserStore) *BatchApiService {
+	return &BatchApiService{
+		batches:   batches,
+		userStore: userStore,
+	}
+}
+
+func (api *BatchApiService) RegisterRoutes(router *Router) {
+	router.Handle("GET /api/recipes", api.GetRecipeProfiles, &RouteDoc{
+		Summary: "List recipe profiles", Tag: "batches", Response: internal.RecipeProfiles})
+	router.Handle("GET /api/batches", api.GetAllBatches, &RouteDoc{
+		Summary: "List batches", Tag: "batches", Response: []internal.Batch{}})
+	router.Handle("GET /api/batches/{id}", api.GetBatchById, &RouteDoc{
+		Summary: "Get batch with its tasks", Tag: "batches", Response: batchResponse{}})
+	router.HandleAuth("POST /api/batches", api.CreateBatch, &RouteDoc{
+		Summary: "Create batch and its production schedule", Tag: "batches", Request: internal.Batch{}, Status: http.StatusCreated, Response: batchResponse{}})
+	router.HandleAuth("PUT /api/batches/{id}/brew-date", api.RescheduleBatch, &RouteDoc{
+		Summary: "Move brew date and unfinished batch tasks", Tag: "batches", Request: rescheduleRequest{}, Response: batchResponse{}})
+}
+
+func (api *BatchApiService) GetAllBatches(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, api.batches.Read())
+}
+
+func (api *BatchApiService) GetBatchById(w http.ResponseWriter, r *http.Request) {
+	batchId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing batchId") {
+		return
+	}
+	batch, err := api.batches.FindBatchById(batchId)
+	if handleError(w, err, http.StatusNotFound, "api: batch not found") {
+		return
+	}
+	tasks, err := api.batches.BatchTasks(batchId)
+	if handleError(w, err, http.StatusNotFound, "api: batch not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, batchResponse{Batch: *batch, Tasks: tasks})
+}
+
+func (api *BatchApiService) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	user, ok := authorize(w, r, api.userStore, authz.CreateTask, nil)
+	if !ok {
+		return
+	}
+
+	var batchRequest internal.Batch
+	err := json.NewDecoder(r.Body).Decode(&batchRequest)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	batch, err := api.batches.CreateBatch(batchRequest, user)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	tasks, _ := api.batches.BatchTasks(batch.Id)
+	for _, task := range tasks {
+		recordTask(r.Context(), user, audit.TaskCreated, task.Id)
+	}
+	writeJson(w, http.StatusCreated, batchResponse{Batch: *batch, Tasks: tasks})
+}
+
+func (api *BatchApiService) RescheduleBatch(w http.ResponseWriter, r *http.Request) {
+	batchId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing batchId") {
+		return
+	}
+	var request rescheduleRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	if request.BrewDate == nil {
+		writeProblem(w, NewProblem(http.StatusBadRequest, "request has invalid fields", FieldError{Field: "brewDate", Message: "brewDate is required"}))
+		return
+	}
+	user, moved, ok := api.authorizeReschedule(w, r, batchId)
+	if !ok {
+		return
+	}
+
+	batch, err := api.batches.RescheduleBatch(batchId, request.BrewDate.Time)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	for _, taskId := range moved {
+		recordTask(r.Context(), user, audit.TaskUpdated, taskId)
+	}
+	tasks, _ := api.batches.BatchTasks(batch.Id)
+	writeJson(w, http.StatusOK, batchResponse{Batch: *batch, Tasks: tasks})
+}
+
+// authorizeReschedule checks that the user may edit every unfinished task of
+// the batch, those are the tasks RescheduleBatch moves, and returns their ids
+func (api *BatchApiService) authorizeReschedule(w http.ResponseWriter, r *http.Request, batchId int) (*users.User, []int, bool) {
+	user, ok := currentUser(r, api.userStore)
+	if !ok {
+		writeUnknownUser(w)
+		return nil, nil, false
+	}
+	tasks, err := api.batches.BatchTasks(batchId)
+	if handleError(w, err, http.StatusNotFound, "api: batch not found") {
+		return nil, nil, false
+	}
+	var moved []int
+	for i := range tasks {
+		if tasks[i].Done {
+			continue
+		}
+		if handleError(w, checkPermission(r.Context(), *user, authz.EditTask, &tasks[i]), http.StatusForbidden, "") {
+			return nil, nil, false
+		}
+		moved = append(moved, tasks[i].Id)
+	}
+	return user, moved, true
+}
+
+func (api *BatchApiService) GetRecipeProfiles(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, internal.RecipeProfiles)
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func setupBatchApi(t *testing.T) *http.ServeMux {
+	taskHolder := internal.NewTaskHolder("")
+	batchHolder, _ := internal.NewBatchHolder("", taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewBatchApiService(batchHolder, userStore)
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /api/batches/{id}", api.GetBatchById)
+	router.HandleFunc("POST /api/batches", middleware.AuthMiddleware(api.CreateBatch))
+	router.HandleFunc("PUT /api/batches/{id}/brew-date", middleware.AuthMiddleware(api.RescheduleBatch))
+	return router
+}
+
+func TestBatchApi(t *testing.T) {
+	router := setupBatchApi(t)
+	brewDate := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second).UTC()
+
+	rr := doApiRequest(router, "POST", "/api/batches", internal.Batch{
+		Style:        "Pilsner",
+		Profile:      "lager",
+		VolumeLiters: 1000,
+		BrewDate:     brewDate,
+		Fermenter:    "FV1",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var created batchResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if len(created.Tasks) != len(internal.RecipeProfiles["lager"].Steps) {
+		t.Fatalf("Expected generated tasks, got %v", created.Tasks)
+	}
+	if created.Tasks[0].CreatedBy.UserName != "AAA" {
+		t.Errorf("Expected tasks created by AAA, got %s", created.Tasks[0].CreatedBy.UserName)
+	}
+
+	newBrewDate := internal.CustomTime{Time: brewDate.Add(24 * time.Hour)}
+	rr = doApiRequest(router, "PUT", "/api/batches/1/brew-date", rescheduleRequest{BrewDate: &newBrewDate})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var rescheduled batchResponse
+	json.NewDecoder(rr.Body).Decode(&rescheduled)
+	if !rescheduled.Tasks[1].PlannedAt.Equal(created.Tasks[1].PlannedAt.Add(24 * time.Hour)) {
+		t.Errorf("Expected brew task moved by a day, got %v", rescheduled.Tasks[1].PlannedAt)
+	}
+
+	rr = doApiRequest(router, "POST", "/api/batches", internal.Batch{Style: "Stout"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest, got %v", rr.Code)
+	}
+
+	rr = doApiRequest(router, "GET", "/api/batches/42", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound, got %v", rr.Code)
+	}
+}
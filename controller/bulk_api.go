@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type bulkRequest struct {
+	Atomic     bool                     `json:"atomic"`
+	Operations []internal.BulkOperation `json:"operations"`
+}
+
+type bulkItemResult struct {
+	Index   int             `json:"index"`
+	Op      internal.BulkOp `json:"op"`
+	Id      int             `json:"id,omitempty"`
+	Status  int             `json:"status"`
+	Task    *internal.Task  `json:"task,omitempty"`
+	Problem *Problem        `json:"problem,omitempty"`
+}
+
+type bulkResponse struct {
+	Atomic  bool             `json:"atomic"`
+	Applied int              `json:"applied"`
+	Failed  int              `json:"failed"`
+	Results []bulkItemResult `json:"results"`
+}
+
+var bulkEvents = map[internal.BulkOp]audit.Event{
+	internal.BulkCreate: audit.TaskCreated,
+	internal.BulkUpdate: audit.TaskUpdated,
+	internal.BulkDelete: audit.TaskDeleted,
+}
+
+// bulkAuthorizer checks the operations under the holder lock, so the tasks
+// can't change between the check and the bulk. One refusal refuses the bulk.
+func bulkAuthorizer(ctx context.Context, user users.User) internal.BulkAuthorizer {
+	return func(operation internal.BulkOperation, task *internal.Task) error {
+		switch operation.Op {
+		case internal.BulkCreate:
+			return checkPermission(ctx, user, authz.CreateTask, nil)
+		case internal.BulkUpdate:
+			return checkPermission(ctx, user, authz.EditTask, task)
+		case internal.BulkDelete:
+			return checkPermission(ctx, user, authz.DeleteTask, task)
+		}
+		return nil
+	}
+}
+
+// BulkTasks runs create, update and delete operations in one request. Each
+// result has its own status; the response status is 200 unless an atomic
+// bulk failed, then it is the status of the failed operation.
+func (api *ApiService) BulkTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	user, ok := currentUser(r, api.userStore)
+	if !ok {
+		writeUnknownUser(w)
+		return
+	}
+	var request bulkRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	for i := range request.Operations {
+		request.Operations[i].Task.CreatedBy = nil
+		request.Operations[i].Task.UpdatedBy = user
+		if request.Operations[i].Op == internal.BulkCreate {
+			request.Operations[i].Task.CreatedBy = user
+		}
+	}
+
+	results, err := tasks.ApplyBulk(request.Operations, request.Atomic, bulkAuthorizer(r.Context(), *user))
+	var operationErr *internal.BulkOperationError
+	if err != nil && !errors.As(err, &operationErr) {
+		handleError(w, err, http.StatusBadRequest, "")
+		return
+	}
+
+	response := bulkResponse{Atomic: request.Atomic, Results: make([]bulkItemResult, len(results))}
+	for i, result := range results {
+		item := bulkItemResult{Index: i, Op: result.Op, Id: result.Id, Task: result.Task, Status: http.StatusOK}
+		if result.Op == internal.BulkCreate {
+			item.Status = http.StatusCreated
+		}
+		if result.Err != nil {
+			problem := problemFromError(result.Err, http.StatusBadRequest, "")
+			item.Status = problem.Status
+			item.Problem = &problem
+			response.Failed++
+		} else {
+			response.Applied++
+			recordTask(r.Context(), user, bulkEvents[result.Op], result.Id)
+		}
+		response.Results[i] = item
+	}
+
+	status := http.StatusOK
+	if operationErr != nil {
+		status = response.Results[operationErr.Index].Status
+	}
+	writeJson(w, status, response)
+}
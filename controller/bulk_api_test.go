@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestBulkTasks(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewApiService(taskService, userStore)
+	t.Cleanup(taskService.CloseAll)
+	router := http.NewServeMux()
+	router.HandleFunc("POST /api/tasks", middleware.AuthMiddleware(api.CreateTask))
+	router.HandleFunc("POST /api/tasks:batch", middleware.AuthMiddleware(api.BulkTasks))
+
+	for _, msg := range []string{"Brew IPA", "Keg IPA", "Deliver IPA"} {
+		taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr(msg)})
+	}
+
+	tests := []struct {
+		name         string
+		request      bulkRequest
+		wantStatus   int
+		wantStatuses []int
+	}{
+		{
+			name: "independent operations",
+			request: bulkRequest{Operations: []internal.BulkOperation{
+				{Op: internal.BulkCreate, Task: internal.TaskOptional{Msg: internal.StringPtr("Clean FV3")}},
+				{Op: internal.BulkUpdate, Id: 1, Task: internal.TaskOptional{Done: internal.BoolPtr(true)}},
+				{Op: internal.BulkDelete, Id: 42},
+			}},
+			wantStatus:   http.StatusOK,
+			wantStatuses: []int{http.StatusCreated, http.StatusOK, http.StatusNotFound},
+		},
+		{
+			name: "atomic failure",
+			request: bulkRequest{Atomic: true, Operations: []internal.BulkOperation{
+				{Op: internal.BulkDelete, Id: 2},
+				{Op: internal.BulkUpdate, Id: 3, Version: internal.IntPtr(7), Task: internal.TaskOptional{Done: internal.BoolPtr(true)}},
+			}},
+			wantStatus:   http.StatusPreconditionFailed,
+			wantStatuses: []int{http.StatusFailedDependency, http.StatusPreconditionFailed},
+		},
+		{
+			name: "atomic success",
+			request: bulkRequest{Atomic: true, Operations: []internal.BulkOperation{
+				{Op: internal.BulkDelete, Id: 2},
+				{Op: internal.BulkUpdate, Id: 3, Version: internal.IntPtr(1), Task: internal.TaskOptional{Done: internal.BoolPtr(true)}},
+			}},
+			wantStatus:   http.StatusOK,
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name: "atomic with missing task",
+			request: bulkRequest{Atomic: true, Operations: []internal.BulkOperation{
+				{Op: internal.BulkUpdate, Id: 3, Task: internal.TaskOptional{Msg: internal.StringPtr("Keg stout")}},
+				{Op: internal.BulkDelete, Id: 42},
+			}},
+			wantStatus:   http.StatusNotFound,
+			wantStatuses: []int{http.StatusFailedDependency, http.StatusNotFound},
+		},
+		{
+			name:       "no operations",
+			request:    bulkRequest{},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doApiRequest(router, "POST", "/api/tasks:batch", tt.request)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			var response bulkResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			if len(response.Results) != len(tt.wantStatuses) {
+				t.Fatalf("Expected %d results, got %v", len(tt.wantStatuses), response.Results)
+			}
+			for i, result := range response.Results {
+				if result.Status != tt.wantStatuses[i] {
+					t.Errorf("Expected result %d status %v, got %v", i, tt.wantStatuses[i], result.Status)
+				}
+			}
+		})
+	}
+
+	created, _ := taskHolder.GetTask(4)
+	if created == nil || created.CreatedBy.UserName != "AAA" {
+		t.Errorf("Expected created task by AAA, got %+v", created)
+	}
+	if _, count := taskHolder.Count(); count != 3 {
+		t.Errorf("Expected 3 tasks, got %d", count)
+	}
+}
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/users"
+	"golang.org/x/net/websocket"
+)
+
+// Messages of the collaboration channel. Clients send subscribe and
+// unsubscribe to follow tasks and lock or unlock while editing one, the
+// server answers with presence of every followed task that changed.
+const (
+	collabSubscribe   = "subscribe"
+	collabUnsubscribe = "unsubscribe"
+	collabLock        = "lock"
+	collabUnlock      = "unlock"
+	collabPresence    = "presence"
+	collabError       = "error"
+
+	// messages a connection may fall behind before it is closed
+	collabSendBuffer = 32
+)
+
+type collabMessage struct {
+	Type     string   `json:"type"`
+	TaskId   int      `json:"taskId"`
+	Users    []string `json:"users,omitempty"`    // presence: users viewing the task
+	LockedBy string   `json:"lockedBy,omitempty"` // presence: user editing the task
+	Error    string   `json:"error,omitempty"`
+}
+
+type collabConn struct {
+	id       int
+	userId   string
+	userName string
+	tasks    map[int]bool
+	send     chan collabMessage
+}
+
+// CollabService is the WebSocket channel of the web UI for presence and soft
+// edit locks on the tasks of the default workspace. Locks of a connection are
+// released when it closes.
+type CollabService struct {
+	locks     *internal.EditLocks
+	tasks     *internal.TaskHolder
+	userStore *users.UserStore
+	conns     map[int]*collabConn
+	latestId  int
+	sync.Mutex
+}
+
+func NewCollabService(locks *internal.EditLocks, tasks *internal.TaskHolder, userStore *users.UserStore) *CollabService {
+	return &CollabService{locks: locks, tasks: tasks, userStore: userStore, conns: make(map[int]*collabConn)}
+}
+
+// RegisterRoutes adds the channel outside /api, browsers authenticate the
+// WebSocket handshake with the login cookie
+func (api *CollabService) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /ws/tasks", api.ServeWebSocket, nil)
+}
+
+func (api *CollabService) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r, api.userStore)
+	if !ok {
+		writeUnknownUser(w)
+		return
+	}
+	server := websocket.Server{
+		Handshake: sameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			api.serve(ws, user)
+		},
+	}
+	server.ServeHTTP(hijacker{w}, r)
+}
+
+// sameOrigin rejects handshakes from other sites, they would otherwise ride
+// on the login cookie of the user
+func sameOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return fmt.Errorf("websocket origin %q not allowed", r.Header.Get("Origin"))
+	}
+	config.Origin = origin
+	return nil
+}
+
+// hijacker lets websocket take over the connection behind middleware that
+// wraps the ResponseWriter
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+func (api *CollabService) serve(ws *websocket.Conn, user *users.User) {
+	conn := api.connect(user)
+	defer api.disconnect(conn)
+
+	go func() {
+		for message := range conn.send {
+			if err := websocket.JSON.Send(ws, message); err != nil {
+				break
+			}
+		}
+		ws.Close()
+	}()
+
+	for {
+		var message collabMessage
+		if err := websocket.JSON.Receive(ws, &message); err != nil {
+			return
+		}
+		api.handleMessage(conn, message)
+	}
+}
+
+func (api *CollabService) connect(user *users.User) *collabConn {
+	api.Lock()
+	defer api.Unlock()
+	api.latestId++
+	conn := &collabConn{
+		id:       api.latestId,
+		userId:   user.UserId.String(),
+		userName: user.UserName,
+		tasks:    make(map[int]bool),
+		send:     make(chan collabMessage, collabSendBuffer),
+	}
+	api.conns[conn.id] = conn
+	return conn
+}
+
+// disconnect releases the locks of the connection and tells the followers
+// of its tasks
+func (api *CollabService) disconnect(conn *collabConn) {
+	released := api.locks.ReleaseHolder(conn.id)
+
+	api.Lock()
+	defer api.Unlock()
+	if _, ok := api.conns[conn.id]; ok {
+		delete(api.conns, conn.id)
+		close(conn.send)
+	}
+	changed := released
+	for taskId := range conn.tasks {
+		changed = append(changed, taskId)
+	}
+	slices.Sort(changed)
+	for _, taskId := range slices.Compact(changed) {
+		api.broadcastPresence(taskId)
+	}
+}
+
+func (api *CollabService) handleMessage(conn *collabConn, message collabMessage) {
+	var err error
+	switch message.Type {
+	case collabSubscribe, collabUnsubscribe:
+	case collabLock:
+		err = api.authorizeLock(conn, message.TaskId)
+		if err == nil {
+			err = api.locks.Acquire(message.TaskId, conn.userId, conn.userName, conn.id)
+		}
+	case collabUnlock:
+		api.locks.Release(message.TaskId, conn.id)
+	default:
+		api.reply(conn, collabMessage{Type: collabError, TaskId: message.TaskId, Error: "unknown message type " + message.Type})
+		return
+	}
+
+	api.Lock()
+	defer api.Unlock()
+	if message.Type == collabUnsubscribe {
+		delete(conn.tasks, message.TaskId)
+	} else {
+		conn.tasks[message.TaskId] = true
+	}
+	if err != nil {
+		logger.Info.Printf("collab: %v", err)
+		api.send(conn, collabMessage{Type: collabError, TaskId: message.TaskId, Error: err.Error()})
+	}
+	api.broadcastPresence(message.TaskId)
+}
+
+// authorizeLock lets only users who may edit an existing task lock it, edits
+// of everyone else are refused while the lock is held
+func (api *CollabService) authorizeLock(conn *collabConn, taskId int) error {
+	user, ok := api.userStore.GetUserById(conn.userId)
+	if !ok {
+		return users.ErrUserNotFound
+	}
+	task, err := api.tasks.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	return authz.Check(user, authz.EditTask, task)
+}
+
+func (api *CollabService) reply(conn *collabConn, message collabMessage) {
+	api.Lock()
+	defer api.Unlock()
+	api.send(conn, message)
+}
+
+// broadcastPresence sends presence to every follower of the task, caller holds the mutex
+func (api *CollabService) broadcastPresence(taskId int) {
+	presence := api.presence(taskId)
+	for _, conn := range api.conns {
+		if conn.tasks[taskId] {
+			api.send(conn, presence)
+		}
+	}
+}
+
+func (api *CollabService) presence(taskId int) collabMessage {
+	message := collabMessage{Type: collabPresence, TaskId: taskId, Users: []string{}}
+	for _, conn := range api.conns {
+		if conn.tasks[taskId] && !slices.Contains(message.Users, conn.userName) {
+			message.Users = append(message.Users, conn.userName)
+		}
+	}
+	slices.Sort(message.Users)
+	message.LockedBy, _ = api.locks.LockedBy(taskId)
+	return message
+}
+
+// send drops a connection too slow to read its messages, its locks are
+// released once the read loop notices the closed socket
+func (api *CollabService) send(conn *collabConn, message collabMessage) {
+	if _, ok := api.conns[conn.id]; !ok {
+		return
+	}
+	select {
+	case conn.send <- message:
+	default:
+		logger.Error.Printf("collab: connection %d of %s is too slow, closing", conn.id, conn.userName)
+		delete(api.conns, conn.id)
+		close(conn.send)
+	}
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+	"golang.org/x/net/websocket"
+)
+
+func setupCollab(t *testing.T) (*httptest.Server, *internal.EditLocks, *users.UserStore) {
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	locks := internal.NewEditLocks(time.Minute)
+	// task 14 of brewer BBB, the tasks before it of admin AAA
+	taskHolder := internal.NewTaskHolder("")
+	admin, _ := userStore.GetUser("AAA")
+	brewer, _ := userStore.GetUser("BBB")
+	for i := 1; i <= 14; i++ {
+		createdBy := admin
+		if i == 14 {
+			createdBy = brewer
+		}
+		taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Clean FV1"), CreatedBy: &createdBy})
+	}
+	router := NewRouter()
+	NewCollabService(locks, taskHolder, userStore).RegisterRoutes(router)
+	// behind the logging middleware like in main, its writer must still hijack
+	server := httptest.NewServer(middleware.LoggingMiddleware{Next: router})
+	t.Cleanup(server.Close)
+	return server, locks, userStore
+}
+
+func dialCollab(t *testing.T, server *httptest.Server, userStore *users.UserStore, userName string) *websocket.Conn {
+	user, _ := userStore.GetUser(userName)
+	config, _ := websocket.NewConfig(strings.Replace(server.URL, "http", "ws", 1)+"/ws/tasks", server.URL)
+	config.Header.Set("Cookie", "Authorization="+sessionToken(user))
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func sendCollab(t *testing.T, ws *websocket.Conn, messageType string, taskId int) {
+	if err := websocket.JSON.Send(ws, collabMessage{Type: messageType, TaskId: taskId}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func receiveCollab(t *testing.T, ws *websocket.Conn) collabMessage {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message collabMessage
+	if err := websocket.JSON.Receive(ws, &message); err != nil {
+		t.Fatalf("Expected message, got %v", err)
+	}
+	return message
+}
+
+func TestCollabPresenceAndLocks(t *testing.T) {
+	server, locks, userStore := setupCollab(t)
+	anna := dialCollab(t, server, userStore, "AAA")
+	bob := dialCollab(t, server, userStore, "BBB")
+
+	sendCollab(t, anna, collabSubscribe, 14)
+	if message := receiveCollab(t, anna); message.Type != collabPresence || strings.Join(message.Users, ",") != "AAA" {
+		t.Errorf("Expected AAA present, got %+v", message)
+	}
+	sendCollab(t, bob, collabSubscribe, 14)
+	receiveCollab(t, bob)
+	if message := receiveCollab(t, anna); strings.Join(message.Users, ",") != "AAA,BBB" {
+		t.Errorf("Expected AAA and BBB present, got %+v", message)
+	}
+
+	sendCollab(t, anna, collabLock, 14)
+	receiveCollab(t, anna)
+	if message := receiveCollab(t, bob); message.LockedBy != "AAA" {
+		t.Errorf("Expected task locked by AAA, got %+v", message)
+	}
+
+	sendCollab(t, bob, collabLock, 14)
+	if message := receiveCollab(t, bob); message.Type != collabError || !strings.Contains(message.Error, "AAA") {
+		t.Errorf("Expected lock error, got %+v", message)
+	}
+	receiveCollab(t, bob)
+	receiveCollab(t, anna)
+
+	anna.Close()
+	if message := receiveCollab(t, bob); message.LockedBy != "" || strings.Join(message.Users, ",") != "BBB" {
+		t.Errorf("Expected lock released on disconnect, got %+v", message)
+	}
+	if _, ok := locks.LockedBy(14); ok {
+		t.Error("Expected no lock after disconnect")
+	}
+}
+
+func TestCollabLockNeedsEditPermission(t *testing.T) {
+	server, locks, userStore := setupCollab(t)
+
+	tests := []struct {
+		name   string
+		user   string
+		taskId int
+	}{
+		{"viewer", "CCC", 14},
+		{"brewer on task of others", "BBB", 1},
+		{"missing task", "AAA", 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := dialCollab(t, server, userStore, tt.user)
+			sendCollab(t, ws, collabLock, tt.taskId)
+			if message := receiveCollab(t, ws); message.Type != collabError {
+				t.Errorf("Expected lock refused, got %+v", message)
+			}
+			if holder, ok := locks.LockedBy(tt.taskId); ok {
+				t.Errorf("Expected no lock, got one of %s", holder)
+			}
+		})
+	}
+}
+
+func TestCollabUnknownMessage(t *testing.T) {
+	server, _, userStore := setupCollab(t)
+	ws := dialCollab(t, server, userStore, "AAA")
+
+	sendCollab(t, ws, "shout", 1)
+	if message := receiveCollab(t, ws); message.Type != collabError {
+		t.Errorf("Expected error message, got %+v", message)
+	}
+}
+
+func TestCollabRejectsOtherOrigin(t *testing.T) {
+	server, _, userStore := setupCollab(t)
+	user, _ := userStore.GetUser("AAA")
+	config, _ := websocket.NewConfig(strings.Replace(server.URL, "http", "ws", 1)+"/ws/tasks", "http://evil.example")
+	config.Header.Set("Cookie", "Authorization="+sessionToken(user))
+
+	if ws, err := websocket.DialConfig(config); err == nil {
+		ws.Close()
+		t.Error("Expected handshake from other origin to fail")
+	}
+}
+
+func TestCollabRequiresLogin(t *testing.T) {
+	server, _, _ := setupCollab(t)
+	resp, err := http.Get(server.URL + "/ws/tasks")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Upgrade") != "" {
+		t.Error("Expected no upgrade without login")
+	}
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhekagigs/golang_todo/internal"
+)
+
+// taskETag is strong, the version changes with every change of the task
+func taskETag(task *internal.Task) string {
+	return `"` + strconv.Itoa(task.Version) + `"`
+}
+
+// listETag is weak and covers all tasks, seed tells apart revisions of
+// different server runs as the revision is not persisted.
+func listETag(seed int64, revision int, rawQuery string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(rawQuery))
+	return fmt.Sprintf(`W/"%x-%d-%x"`, seed, revision, hash.Sum32())
+}
+
+func splitETags(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// noneMatch reports whether If-None-Match allows to send the body, weak comparison
+func noneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return true
+	}
+	for _, candidate := range splitETags(header) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return false
+		}
+	}
+	return true
+}
+
+// ifMatchVersion returns version the write is conditional on. Without If-Match
+// the write is unconditional, a stale or weak ETag never matches.
+func ifMatchVersion(r *http.Request, current *internal.Task) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return internal.AnyVersion, true
+	}
+	for _, candidate := range splitETags(header) {
+		if candidate == "*" || candidate == taskETag(current) {
+			return current.Version, true
+		}
+	}
+	return 0, false
+}
+
+func writeNotModified(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func writePreconditionFailed(w http.ResponseWriter, current *internal.Task) {
+	w.Header().Set("ETag", taskETag(current))
+	writeProblem(w, NewProblem(http.StatusPreconditionFailed, "task was changed, current ETag is "+taskETag(current)))
+}
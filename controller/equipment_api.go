@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type EquipmentApiService struct {
+	registry  *internal.EquipmentRegistry
+	tasks     *internal.TaskHolder
+	userStore *users.UserStore
+}
+
+type equipmentResponse struct {
+	internal.Equipment
+	History      []internal.MaintenanceRecord `json:"history"`
+	BlockedTasks []internal.Task              `json:"blockedTasks"`
+}
+
+type equipmentStatusRequest struct {
+	Status internal.EquipmentStatus `json:"status"`
+	Note   string                   `json:"note"`
+}
+
+func NewEquipmentApiService(registry *internal.EquipmentRegistry, tasks *internal.TaskHolder, userStore *users.UserStore) *EquipmentApiService {
+	return &EquipmentApiService{
+		registry:  registry,
+		tasks:     tasks,
+		userStore: userStore,
+	}
+}
+
+func (api *EquipmentApiService) RegisterRoutes(router *Router) {
+	router.Handle("GET /api/equipment", api.GetAllEquipment, &RouteDoc{
+		Summary: "List equipment", Tag: "equipment", Response: []internal.Equipment{}})
+	router.Handle("GET /api/equipment/{id}", api.GetEquipmentById, &RouteDoc{
+		Summary: "Get equipment with history and blocked tasks", Tag: "equipment", Response: equipmentResponse{}})
+	router.HandleAuth("POST /api/equipment", api.CreateEquipment, &RouteDoc{
+		Summary: "Register equipment", Tag: "equipment", Request: internal.Equipment{}, Status: http.StatusCreated, Response: internal.Equipment{}})
+	router.HandleAuth("PUT /api/equipment/{id}/status", api.UpdateStatus, &RouteDoc{
+		Summary: "Mark equipment available or down", Tag: "equipment", Request: equipmentStatusRequest{}, Response: internal.Equipment{}})
+	router.Handle("GET /api/equipment/{id}/maintenance", api.GetMaintenanceHistory, &RouteDoc{
+		Summary: "List maintenance history", Tag: "equipment", Response: []internal.MaintenanceRecord{}})
+	router.HandleAuth("PUT /api/equipment/{id}/tasks/{task}", api.AssignTask, &RouteDoc{
+		Summary: "Link task to equipment", Tag: "equipment", Status: http.StatusNoContent})
+}
+
+func (api *EquipmentApiService) GetAllEquipment(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, api.registry.Read())
+}
+
+func (api *EquipmentApiService) GetEquipmentById(w http.ResponseWriter, r *http.Request) {
+	equipmentId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing equipmentId") {
+		return
+	}
+	equipment, err := api.registry.FindEquipmentById(equipmentId)
+	if handleError(w, err, http.StatusNotFound, "api: equipment not found") {
+		return
+	}
+	history, err := api.registry.MaintenanceHistory(equipmentId)
+	if handleError(w, err, http.StatusNotFound, "api: equipment not found") {
+		return
+	}
+	blocked, err := api.registry.BlockedTasks(equipmentId)
+	if handleError(w, err, http.StatusNotFound, "api: equipment not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, equipmentResponse{Equipment: *equipment, History: history, BlockedTasks: blocked})
+}
+
+func (api *EquipmentApiService) CreateEquipment(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ManageEquipment, nil); !ok {
+		return
+	}
+	var equipmentRequest internal.Equipment
+	err := json.NewDecoder(r.Body).Decode(&equipmentRequest)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	equipment, err := api.registry.AddEquipment(equipmentRequest)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	writeJson(w, http.StatusCreated, equipment)
+}
+
+func (api *EquipmentApiService) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ManageEquipment, nil); !ok {
+		return
+	}
+	equipmentId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing equipmentId") {
+		return
+	}
+	var request equipmentStatusRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	equipment, err := api.registry.SetStatus(equipmentId, request.Status, request.Note)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	writeJson(w, http.StatusOK, equipment)
+}
+
+func (api *EquipmentApiService) GetMaintenanceHistory(w http.ResponseWriter, r *http.Request) {
+	equipmentId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing equipmentId") {
+		return
+	}
+	history, err := api.registry.MaintenanceHistory(equipmentId)
+	if handleError(w, err, http.StatusNotFound, "api: equipment not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, history)
+}
+
+// AssignTask links task from path value "task" to the equipment
+func (api *EquipmentApiService) AssignTask(w http.ResponseWriter, r *http.Request) {
+	equipmentId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing equipmentId") {
+		return
+	}
+	taskId, err := strconv.Atoi(r.PathValue("task"))
+	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
+		return
+	}
+	task, err := api.tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.EditTask, task)
+	if !ok {
+		return
+	}
+
+	err = api.registry.AssignTask(equipmentId, taskId)
+	if handleError(w, err, http.StatusNotFound, "") {
+		return
+	}
+	recordAudit(r.Context(), user, audit.TaskUpdated, taskTarget(taskId), fmt.Sprintf("assigned to equipment %d", equipmentId))
+	w.WriteHeader(http.StatusNoContent)
+}
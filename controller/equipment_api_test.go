@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestEquipmentApi(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	registry, _ := internal.NewEquipmentRegistry("", taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewEquipmentApiService(registry, taskHolder, userStore)
+	taskApi := NewApiService(internal.NewConcurrentTaskService(taskHolder), userStore)
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /api/equipment/{id}", api.GetEquipmentById)
+	router.HandleFunc("POST /api/equipment", middleware.AuthMiddleware(api.CreateEquipment))
+	router.HandleFunc("PUT /api/equipment/{id}/status", middleware.AuthMiddleware(api.UpdateStatus))
+	router.HandleFunc("PUT /api/equipment/{id}/tasks/{task}", middleware.AuthMiddleware(api.AssignTask))
+	router.HandleFunc("PUT /api/tasks/{id}", middleware.AuthMiddleware(taskApi.UpdateTask))
+
+	rr := doApiRequest(router, "POST", "/api/equipment", internal.Equipment{Name: "FV1", Type: internal.Fermenter, MaintenanceIntervalDays: 30})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	brew := taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), Category: internal.CategoryPtr(internal.Brewing)})
+	rr = doApiRequest(router, "PUT", "/api/equipment/1/tasks/"+strconv.Itoa(brew.Id), nil)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status NoContent, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doApiRequest(router, "PUT", "/api/equipment/1/status", equipmentStatusRequest{Status: internal.Down, Note: "Leaking valve"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doApiRequest(router, "PUT", "/api/tasks/"+strconv.Itoa(brew.Id), internal.TaskOptional{Done: internal.BoolPtr(true)})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status Conflict, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doApiRequest(router, "GET", "/api/equipment/1", nil)
+	var equipment equipmentResponse
+	json.NewDecoder(rr.Body).Decode(&equipment)
+	if equipment.Status != internal.Down || equipment.MaintenanceTaskId == 0 || len(equipment.BlockedTasks) != 1 {
+		t.Errorf("Unexpected equipment %v", equipment)
+	}
+
+	rr = doApiRequest(router, "PUT", "/api/equipment/1/status", equipmentStatusRequest{Status: "broken"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest, got %v", rr.Code)
+	}
+	rr = doApiRequest(router, "GET", "/api/equipment/99", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound, got %v", rr.Code)
+	}
+}
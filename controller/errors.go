@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/oidc"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details body, Errors lists invalid fields
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func NewProblem(status int, detail string, fieldErrors ...FieldError) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: fieldErrors,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	data, err := json.Marshal(problem)
+	if err != nil {
+		logger.Error.Printf("api: problem serialization error: %v", err)
+		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write(data)
+}
+
+// problemFromError maps known errors to a 4xx status, other errors get the
+// fallback status. Detail of 5xx errors is the message, the error is only logged.
+func problemFromError(err error, fallback int, message string) Problem {
+	var (
+		categoryErr  *internal.InvalidCategoryError
+		emptyErr     *internal.EmptyTaskValueError
+		pastErr      *internal.PastPlannedTimeError
+		batchErr     *internal.InvalidBatchValueError
+		profileErr   *internal.UnknownRecipeProfileError
+		unitErr      *internal.InvalidUnitError
+		inventoryErr *internal.InvalidInventoryValueError
+		equipmentErr *internal.InvalidEquipmentValueError
+		measureErr   *internal.InvalidMeasurementError
+		queryErr     *internal.InvalidQueryError
+		placeholder  *internal.MissingPlaceholderError
+		downErr      *internal.EquipmentDownError
+		stockErr     *internal.InsufficientStockError
+		qualityErr   *internal.NotQualityTaskError
+		versionErr   *internal.VersionMismatchError
+		patchErr     *internal.InvalidPatchError
+		patchTestErr *internal.PatchTestFailedError
+		bulkErr      *internal.InvalidBulkError
+		lockedErr    *internal.TaskLockedError
+		webhookErr   *internal.InvalidWebhookError
+		v1FieldErr   *v1.InvalidFieldError
+		tokenErr     *users.InvalidTokenValueError
+		accountErr   *users.InvalidProfileError
+		auditErr     *audit.InvalidQueryError
+		forbiddenErr *authz.ForbiddenError
+		memberErr    *authz.WorkspaceError
+		workspaceErr *internal.UnknownWorkspaceError
+		idTokenErr   *oidc.TokenError
+		syntaxErr    *json.SyntaxError
+		typeErr      *json.UnmarshalTypeError
+		numErr       *strconv.NumError
+	)
+	invalid := func(field string) Problem {
+		return NewProblem(http.StatusBadRequest, "request has invalid fields", FieldError{Field: field, Message: err.Error()})
+	}
+
+	switch {
+	case errors.Is(err, internal.ErrNotFound):
+		if message == "" {
+			message = "resource not found"
+		}
+		return NewProblem(http.StatusNotFound, message)
+	case errors.As(err, &categoryErr):
+		return invalid("category")
+	case errors.As(err, &emptyErr):
+		return invalid("msg")
+	case errors.As(err, &pastErr):
+		return invalid("plannedAt")
+	case errors.As(err, &batchErr):
+		return invalid(batchErr.Field)
+	case errors.As(err, &profileErr):
+		return invalid("profile")
+	case errors.As(err, &unitErr):
+		return invalid("unit")
+	case errors.As(err, &inventoryErr):
+		return invalid(inventoryErr.Field)
+	case errors.As(err, &equipmentErr):
+		return invalid(equipmentErr.Field)
+	case errors.As(err, &measureErr):
+		return invalid("measurements")
+	case errors.As(err, &queryErr):
+		return invalid(queryErr.Param)
+	case errors.As(err, &placeholder):
+		return invalid("vars." + placeholder.Name)
+	case errors.As(err, &patchErr):
+		return invalid(patchErr.Path)
+	case errors.As(err, &bulkErr):
+		return invalid("operations")
+	case errors.As(err, &webhookErr):
+		return invalid(webhookErr.Field)
+	case errors.As(err, &v1FieldErr):
+		return invalid(v1FieldErr.Field)
+	case errors.As(err, &tokenErr):
+		return invalid(tokenErr.Field)
+	case errors.As(err, &typeErr):
+		return invalid(typeErr.Field)
+	case errors.As(err, &syntaxErr):
+		return NewProblem(http.StatusBadRequest, "malformed JSON: "+err.Error())
+	case errors.As(err, &numErr):
+		return invalid("id")
+	case errors.As(err, &downErr), errors.As(err, &stockErr), errors.As(err, &qualityErr), errors.As(err, &patchTestErr):
+		return NewProblem(http.StatusConflict, err.Error())
+	case errors.As(err, &versionErr):
+		return NewProblem(http.StatusPreconditionFailed, err.Error())
+	case errors.As(err, &lockedErr):
+		return NewProblem(http.StatusLocked, err.Error())
+	case errors.Is(err, internal.ErrIdempotencyKeyReused):
+		return NewProblem(http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, internal.ErrIdempotencyInProgress):
+		return NewProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, internal.ErrDeliveryNotDead):
+		return NewProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, internal.ErrBulkRolledBack):
+		return NewProblem(http.StatusFailedDependency, err.Error())
+	case errors.Is(err, users.ErrEmptyUserName):
+		return invalid("userName")
+	case errors.Is(err, users.ErrWeakPassword):
+		return invalid("password")
+	case errors.Is(err, users.ErrUserExists):
+		return NewProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, users.ErrInvalidCredentials):
+		return NewProblem(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, users.ErrInvalidRole):
+		return invalid("role")
+	case errors.Is(err, users.ErrLastAdmin):
+		return NewProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, users.ErrUserNotFound):
+		return NewProblem(http.StatusNotFound, err.Error())
+	case errors.Is(err, users.ErrUserDeactivated):
+		return NewProblem(http.StatusForbidden, err.Error())
+	case errors.As(err, &accountErr):
+		return invalid("profile." + accountErr.Field)
+	case errors.As(err, &auditErr):
+		return invalid(auditErr.Param)
+	case errors.As(err, &forbiddenErr):
+		return NewProblem(http.StatusForbidden, err.Error())
+	case errors.As(err, &memberErr):
+		if memberErr.UserName == "" {
+			return NewProblem(http.StatusUnauthorized, err.Error())
+		}
+		return NewProblem(http.StatusForbidden, err.Error())
+	case errors.As(err, &workspaceErr):
+		return NewProblem(http.StatusNotFound, err.Error())
+	case errors.As(err, &idTokenErr):
+		return NewProblem(http.StatusUnauthorized, err.Error())
+	}
+
+	if fallback >= http.StatusInternalServerError {
+		return NewProblem(fallback, message)
+	}
+	if message == "" {
+		message = err.Error()
+	}
+	return NewProblem(fallback, message)
+}
+
+// writeUnknownUser is used when the authenticated user is missing in the store
+func writeUnknownUser(w http.ResponseWriter) {
+	logger.Error.Println("user not found by id")
+	writeProblem(w, NewProblem(http.StatusUnauthorized, "user not found"))
+}
+
+// handleError writes problem for err and reports whether there was one
+func handleError(w http.ResponseWriter, err error, status int, message string) bool {
+	if err == nil {
+		return false
+	}
+	problem := problemFromError(err, status, message)
+	logger.Error.Printf("%s: %v", message, err)
+	writeProblem(w, problem)
+	return true
+}
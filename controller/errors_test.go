@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestProblemFromError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		fallback   int
+		wantStatus int
+		wantField  string
+	}{
+		{"not found", internal.ErrNotFound, http.StatusInternalServerError, http.StatusNotFound, ""},
+		{"wrapped not found", fmt.Errorf("task 3: %w", internal.ErrNotFound), http.StatusBadRequest, http.StatusNotFound, ""},
+		{"invalid category", &internal.InvalidCategoryError{Category: 9}, http.StatusInternalServerError, http.StatusBadRequest, "category"},
+		{"empty msg", &internal.EmptyTaskValueError{}, http.StatusInternalServerError, http.StatusBadRequest, "msg"},
+		{"past planned time", fmt.Errorf("update: %w", &internal.PastPlannedTimeError{PlannedTime: time.Now()}), http.StatusInternalServerError, http.StatusBadRequest, "plannedAt"},
+		{"batch field", &internal.InvalidBatchValueError{Field: "volumeLiters"}, http.StatusInternalServerError, http.StatusBadRequest, "volumeLiters"},
+		{"equipment down", &internal.EquipmentDownError{Equipment: "FV1"}, http.StatusBadRequest, http.StatusConflict, ""},
+		{"invalid webhook", &internal.InvalidWebhookError{Field: "url"}, http.StatusInternalServerError, http.StatusBadRequest, "url"},
+		{"task locked", &internal.TaskLockedError{TaskId: 14, UserName: "Anna"}, http.StatusBadRequest, http.StatusLocked, ""},
+		{"unknown error", errors.New("boom"), http.StatusInternalServerError, http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := problemFromError(tt.err, tt.fallback, "")
+			if problem.Status != tt.wantStatus || problem.Title != http.StatusText(tt.wantStatus) {
+				t.Errorf("Expected status %d, got %d %q", tt.wantStatus, problem.Status, problem.Title)
+			}
+			if tt.wantField == "" && len(problem.Errors) != 0 {
+				t.Errorf("Expected no field errors, got %v", problem.Errors)
+			}
+			if tt.wantField != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tt.wantField) {
+				t.Errorf("Expected field error for %s, got %v", tt.wantField, problem.Errors)
+			}
+		})
+	}
+
+	if problem := problemFromError(errors.New("secret"), http.StatusInternalServerError, ""); problem.Detail != "" {
+		t.Errorf("Expected internal error detail to be hidden, got %q", problem.Detail)
+	}
+}
+
+func TestApiErrorsAreProblems(t *testing.T) {
+	router, _ := setupTemplateApi(t)
+	taskService := internal.NewConcurrentTaskService(internal.NewTaskHolder(""))
+	t.Cleanup(taskService.CloseAll)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	api := NewApiService(taskService, userStore)
+	router.HandleFunc("GET /api/tasks/{id}", middleware.OptionalAuthMiddleware(api.GetTaskById))
+	router.HandleFunc("POST /api/tasks", middleware.AuthMiddleware(api.CreateTask))
+	router.HandleFunc("PUT /api/tasks/{id}", middleware.AuthMiddleware(api.UpdateTask))
+	api.taskService.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+		wantField  string
+	}{
+		{"bad id", "GET", "/api/tasks/abc", nil, http.StatusBadRequest, "id"},
+		{"missing task", "GET", "/api/tasks/42", nil, http.StatusNotFound, ""},
+		{"missing template", "POST", "/api/tasks/from-template", fromTemplateRequest{TemplateId: 42}, http.StatusNotFound, ""},
+		{"empty msg", "POST", "/api/tasks", internal.TaskOptional{Category: internal.CategoryPtr(internal.Brewing)}, http.StatusBadRequest, "msg"},
+		{"invalid category", "POST", "/api/tasks", internal.TaskOptional{Msg: internal.StringPtr("x"), Category: internal.CategoryPtr(9)}, http.StatusBadRequest, "category"},
+		{"past planned time", "PUT", "/api/tasks/1", internal.TaskOptional{PlannedAt: internal.TimePtr(time.Now().Add(-time.Hour))}, http.StatusBadRequest, "plannedAt"},
+		{"update missing task", "PUT", "/api/tasks/42", internal.TaskOptional{Done: internal.BoolPtr(true)}, http.StatusNotFound, ""},
+		{"wrong field type", "POST", "/api/templates", map[string]any{"name": "x", "category": "brewing"}, http.StatusBadRequest, "category"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doApiRequest(router, tt.method, tt.path, tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			problem := decodeProblem(t, rr)
+			if problem.Status != tt.wantStatus {
+				t.Errorf("Expected problem status %d, got %d", tt.wantStatus, problem.Status)
+			}
+			if tt.wantField != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tt.wantField) {
+				t.Errorf("Expected field error for %s, got %v", tt.wantField, problem.Errors)
+			}
+		})
+	}
+}
+
+func decodeProblem(t *testing.T, rr *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	if contentType := rr.Header().Get("Content-Type"); contentType != problemContentType {
+		t.Errorf("Expected content type %s, got %s", problemContentType, contentType)
+	}
+	var problem Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("Expected problem body, got %v", err)
+	}
+	return problem
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+)
+
+const (
+	defaultHeartbeat = 15 * time.Second
+	eventRetryMillis = 3000
+	// events a connection may fall behind before it is closed, the client
+	// then reconnects and replays them from the log
+	eventListenerBuffer = 64
+)
+
+type TaskEventsService struct {
+	events    *internal.TaskEventLog
+	heartbeat time.Duration
+}
+
+func NewTaskEventsService(events *internal.TaskEventLog) *TaskEventsService {
+	return &TaskEventsService{events: events, heartbeat: defaultHeartbeat}
+}
+
+func (api *TaskEventsService) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /api/tasks/events", api.StreamTaskEvents, &RouteDoc{
+		Summary: "Stream task changes as server-sent events", Tag: "tasks",
+		Params: []Param{
+			{Name: "category", In: "query", Description: "Category name or number"},
+			{Name: "assignee", In: "query", Description: "User name"},
+			{Name: "lastEventId", In: "query", Type: "integer", Description: "Resume after this event, for clients that cannot send Last-Event-ID"},
+		},
+		Response: v1.Task{}, ResponseType: "text/event-stream"})
+}
+
+// StreamTaskEvents sends created, updated and deleted events with the v1 task
+// as data. A client reconnecting with Last-Event-ID gets the missed events
+// replayed, or a reset event when they are no longer buffered and it has to
+// reload the tasks. Comments are sent as heartbeats to keep proxies from
+// closing an idle connection.
+func (api *TaskEventsService) StreamTaskEvents(w http.ResponseWriter, r *http.Request) {
+	query, err := parseTaskQuery(r.URL.Query())
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	filter := &internal.TaskQuery{Category: query.Category, Assignee: query.Assignee}
+
+	lastId := -1
+	if value := lastEventId(r); value != "" {
+		lastId, err = strconv.Atoi(value)
+		if err != nil || lastId < 0 {
+			writeProblem(w, NewProblem(http.StatusBadRequest, "request has invalid fields",
+				FieldError{Field: "Last-Event-ID", Message: "expected event id"}))
+			return
+		}
+	}
+
+	response := http.NewResponseController(w)
+	replay, ok, events, cancel := api.events.Listen(lastId, eventListenerBuffer)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis)
+	if !ok {
+		// no id, the client reloads the tasks and reconnects from the latest event
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for i := range replay {
+		writeTaskEvent(w, &replay[i], filter)
+	}
+	if err := response.Flush(); err != nil {
+		logger.Error.Printf("api: streaming not supported: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(api.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			writeTaskEvent(w, &event, filter)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := response.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// lastEventId is sent by EventSource on reconnect, the query parameter is
+// for the first connection
+func lastEventId(r *http.Request) string {
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		return value
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// writeTaskEvent skips events not matching the filter, the next event still
+// carries a higher id, so resuming never repeats them
+func writeTaskEvent(w http.ResponseWriter, event *internal.LoggedTaskEvent, filter *internal.TaskQuery) {
+	if !event.Matches(filter) {
+		return
+	}
+	data, err := json.Marshal(v1.FromTask(event.Task))
+	if err != nil {
+		logger.Error.Printf("api: error encoding task event %d: %v", event.Id, err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type sseEvent struct {
+	Id    string
+	Event string
+	Data  string
+}
+
+func setupTaskEvents(t *testing.T, heartbeat time.Duration) (*httptest.Server, *internal.TaskHolder) {
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	taskHolder := internal.NewTaskHolder("")
+	api := NewTaskEventsService(internal.NewTaskEventLog(3, taskHolder))
+	api.heartbeat = heartbeat
+	router := NewRouter()
+	api.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, taskHolder
+}
+
+func openEventStream(t *testing.T, url string, headers map[string]string) *bufio.Reader {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", MOCK_TOKEN)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %v %v", resp.StatusCode, resp.Header)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent returns the next block of lines, comments are returned as Data
+func readEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected event, got %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.Id = value
+		case "event":
+			event.Event = value
+		case "data", "":
+			event.Data = value
+		}
+	}
+}
+
+func TestStreamTaskEvents(t *testing.T) {
+	server, taskHolder := setupTaskEvents(t, time.Hour)
+	stream := openEventStream(t, server.URL+"/api/tasks/events?category=brewing", nil)
+	if event := readEvent(t, stream); event.Event != "" || event.Data != "" {
+		t.Fatalf("Expected retry block first, got %+v", event)
+	}
+
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Post on social"), Category: internal.CategoryPtr(internal.Marketing)})
+	task := taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), Category: internal.CategoryPtr(internal.Brewing)})
+	taskHolder.PartialUpdateTask(task.Id, &internal.TaskOptional{Done: internal.BoolPtr(true)})
+	taskHolder.DeleteTask(task.Id)
+
+	tests := []struct {
+		id    string
+		event string
+		done  bool
+	}{
+		{"2", "created", false},
+		{"3", "updated", true},
+		{"4", "deleted", true},
+	}
+	for _, tt := range tests {
+		event := readEvent(t, stream)
+		if event.Id != tt.id || event.Event != tt.event {
+			t.Fatalf("Expected event %s %s, got %+v", tt.id, tt.event, event)
+		}
+		var data v1.Task
+		if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+			t.Fatalf("Expected v1 task data, got %v", err)
+		}
+		if data.Id != task.Id || data.Message != "Brew IPA" || data.Done != tt.done {
+			t.Errorf("Unexpected task %+v", data)
+		}
+	}
+}
+
+func TestStreamTaskEventsResume(t *testing.T) {
+	server, taskHolder := setupTaskEvents(t, time.Hour)
+	for i := 0; i < 5; i++ {
+		taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("task")})
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		want    []string
+	}{
+		{"header", "/api/tasks/events", map[string]string{"Last-Event-ID": "3"}, []string{"4", "5"}},
+		{"query", "/api/tasks/events?lastEventId=4", nil, []string{"5"}},
+		{"dropped from buffer", "/api/tasks/events", map[string]string{"Last-Event-ID": "1"}, []string{"reset"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := openEventStream(t, server.URL+tt.url, tt.headers)
+			readEvent(t, stream)
+			for _, want := range tt.want {
+				event := readEvent(t, stream)
+				if event.Id != want && event.Event != want {
+					t.Errorf("Expected event %s, got %+v", want, event)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamTaskEventsHeartbeat(t *testing.T) {
+	server, _ := setupTaskEvents(t, 10*time.Millisecond)
+	stream := openEventStream(t, server.URL+"/api/tasks/events", nil)
+	readEvent(t, stream)
+	if event := readEvent(t, stream); event.Data != "heartbeat" {
+		t.Errorf("Expected heartbeat comment, got %+v", event)
+	}
+}
+
+func TestStreamTaskEventsInvalidParams(t *testing.T) {
+	server, _ := setupTaskEvents(t, time.Hour)
+
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+	}{
+		{"category", "/api/tasks/events?category=cooking", nil},
+		{"last event id", "/api/tasks/events", map[string]string{"Last-Event-ID": "abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+tt.url, nil)
+			req.Header.Set("Authorization", MOCK_TOKEN)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status Bad Request, got %v", resp.StatusCode)
+			}
+		})
+	}
+}
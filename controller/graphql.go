@@ -0,0 +1,376 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/zhekagigs/golang_todo/authz"
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+const (
+	maxGraphQLDepth      = 8
+	maxGraphQLComplexity = 5000
+	maxGraphQLQuerySize  = 64 << 10
+)
+
+var (
+	errGraphQLLogin = &graphQLProblem{NewProblem(http.StatusUnauthorized, "login required")}
+	errGraphQLScope = &graphQLProblem{NewProblem(http.StatusForbidden, "api token lacks the write scope")}
+)
+
+// GraphQLService serves tasks and users with their relationships in one
+// round-trip. Mutations use the TaskServiceInterface methods of the task service.
+type GraphQLService struct {
+	taskService *internal.ConcurrentTaskService
+	// Workspaces has the task services of the X-Workspace header, by default
+	// only the default workspace with taskService
+	Workspaces *internal.Workspaces
+	userStore  *users.UserStore
+	schema     graphql.Schema
+	maxDepth   int
+	maxCost    int
+}
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+func NewGraphQLService(taskService *internal.ConcurrentTaskService, userStore *users.UserStore) (*GraphQLService, error) {
+	api := &GraphQLService{
+		taskService: taskService,
+		Workspaces:  internal.NewWorkspaces(taskService),
+		userStore:   userStore,
+		maxDepth:    maxGraphQLDepth,
+		maxCost:     maxGraphQLComplexity,
+	}
+	schema, err := api.newGraphQLSchema()
+	if err != nil {
+		return nil, err
+	}
+	api.schema = schema
+	return api, nil
+}
+
+func (api *GraphQLService) RegisterRoutes(router *Router) {
+	// queries are public like GET /api/tasks, mutations check the user
+	router.Handle("POST /graphql", middleware.OptionalAuthMiddleware(middleware.CSRFMiddleware(api.ServeGraphQL)), &RouteDoc{
+		Summary: "Query tasks and users, mutations need the Authorization header or cookie", Tag: "graphql",
+		Request: graphQLRequest{}, Response: graphql.Result{}})
+}
+
+// ServeGraphQL answers with status 200 and the errors in the body once the
+// request is decoded, like other GraphQL servers. Only JSON bodies are
+// accepted, so forms of other sites cannot post mutations with the cookie.
+func (api *GraphQLService) ServeGraphQL(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeProblem(w, NewProblem(http.StatusUnsupportedMediaType, "expected application/json"))
+		return
+	}
+	var request graphQLRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLQuerySize)).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	tasks, ok := workspaceTasks(w, r, api.Workspaces, api.userStore)
+	if !ok {
+		return
+	}
+	ctx := context.WithValue(r.Context(), workspaceTasksKey{}, tasks)
+	writeJson(w, http.StatusOK, api.execute(ctx, newUserLoader(api.userStore), request))
+}
+
+type workspaceTasksKey struct{}
+
+// tasks returns the task service of the workspace ServeGraphQL resolved
+func (api *GraphQLService) tasks(ctx context.Context) *internal.ConcurrentTaskService {
+	if tasks, ok := ctx.Value(workspaceTasksKey{}).(*internal.ConcurrentTaskService); ok {
+		return tasks
+	}
+	return api.taskService
+}
+
+// execute validates the query and checks its depth and complexity before
+// running any resolver, loader is used for this request only
+func (api *GraphQLService) execute(ctx context.Context, loader *userLoader, request graphQLRequest) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	validation := graphql.ValidateDocument(&api.schema, document, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	if err := api.checkLimits(document, request.Variables); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        api.schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       context.WithValue(ctx, loaderKey{}, loader),
+	})
+}
+
+// graphQLProblem carries the status and invalid fields of a problem into
+// the error extensions
+type graphQLProblem struct {
+	Problem
+}
+
+func (e *graphQLProblem) Error() string {
+	return e.Detail
+}
+
+func (e *graphQLProblem) Extensions() map[string]any {
+	extensions := map[string]any{"status": e.Status}
+	if len(e.Errors) > 0 {
+		extensions["fields"] = e.Errors
+	}
+	return extensions
+}
+
+// graphQLError maps err like handleError, field names are the v1 ones used
+// by the schema
+func graphQLError(err error) error {
+	problem := problemFromError(err, http.StatusInternalServerError, "")
+	if problem.Status >= http.StatusInternalServerError {
+		logger.Error.Printf("graphql: %v", err)
+		problem.Detail = ErrInternal.Error()
+	}
+	for i := range problem.Errors {
+		problem.Errors[i].Field = v1.FieldName(problem.Errors[i].Field)
+	}
+	return &graphQLProblem{problem}
+}
+
+// mutationUser is currentUser for mutation resolvers, API tokens also need
+// the write scope
+func mutationUser(p graphql.ResolveParams, userStore *users.UserStore) (*users.User, error) {
+	userId, ok := middleware.UserFromContext(p.Context)
+	if !ok {
+		return nil, errGraphQLLogin
+	}
+	user, ok := userStore.GetUserById(userId)
+	if !ok {
+		return nil, errGraphQLLogin
+	}
+	if !middleware.HasScope(p.Context, users.ScopeWrite) {
+		return nil, errGraphQLScope
+	}
+	return &user, nil
+}
+
+// authorizeTask is mutationUser checking that the user may do action with
+// task taskId
+func (api *GraphQLService) authorizeTask(p graphql.ResolveParams, action authz.Action, taskId int) (*users.User, error) {
+	user, err := mutationUser(p, api.userStore)
+	if err != nil {
+		return nil, err
+	}
+	task, err := api.tasks(p.Context).GetTask(taskId)
+	if err != nil {
+		return nil, graphQLError(err)
+	}
+	if err := checkPermission(p.Context, *user, action, task); err != nil {
+		return nil, graphQLError(err)
+	}
+	return user, nil
+}
+
+// checkLimits rejects operations nested deeper than maxDepth or costing more
+// than maxCost. Every field costs 1 plus its selection; a field with a first
+// argument costs its selection once per requested item. Introspection fields
+// are free, so tools can load the schema.
+func (api *GraphQLService) checkLimits(document *ast.Document, variables map[string]any) error {
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	walker := &costWalker{schema: &api.schema, fragments: fragments, variables: variables}
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		root := api.schema.QueryType()
+		if operation.Operation == ast.OperationTypeMutation {
+			root = api.schema.MutationType()
+		}
+		depth, cost := walker.selection(operation.SelectionSet, root, 1)
+		if depth > api.maxDepth {
+			return fmt.Errorf("query depth %d exceeds the limit of %d", depth, api.maxDepth)
+		}
+		if cost > api.maxCost {
+			return fmt.Errorf("query complexity %d exceeds the limit of %d", cost, api.maxCost)
+		}
+	}
+	return nil
+}
+
+type costWalker struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+}
+
+// selection returns the depth and cost of set, fields of set are at depth.
+// Fragment cycles were rejected by validation.
+func (c *costWalker) selection(set *ast.SelectionSet, parent graphql.Type, depth int) (int, int) {
+	if set == nil {
+		return depth - 1, 0
+	}
+	maxDepth, cost := depth, 0
+	for _, selection := range set.Selections {
+		var childDepth, childCost int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			childDepth, childCost = c.field(selection, parent, depth)
+		case *ast.InlineFragment:
+			childDepth, childCost = c.selection(selection.SelectionSet, c.typeCondition(selection.TypeCondition, parent), depth)
+		case *ast.FragmentSpread:
+			fragment := c.fragments[selection.Name.Value]
+			if fragment == nil {
+				continue
+			}
+			childDepth, childCost = c.selection(fragment.SelectionSet, c.typeCondition(fragment.TypeCondition, parent), depth)
+		}
+		maxDepth = max(maxDepth, childDepth)
+		cost += childCost
+	}
+	return maxDepth, cost
+}
+
+func (c *costWalker) field(selection *ast.Field, parent graphql.Type, depth int) (int, int) {
+	object, ok := parent.(*graphql.Object)
+	if !ok {
+		return depth, 0
+	}
+	definition, ok := object.Fields()[selection.Name.Value]
+	if !ok {
+		// introspection like __schema and __typename
+		return depth, 0
+	}
+	childDepth, childCost := c.selection(selection.SelectionSet, namedType(definition.Type), depth+1)
+	for _, arg := range definition.Args {
+		if arg.Name() == "first" {
+			childCost *= c.pageSize(selection)
+		}
+	}
+	return childDepth, 1 + childCost
+}
+
+// pageSize is the first argument as the task query will apply it
+func (c *costWalker) pageSize(selection *ast.Field) int {
+	size := internal.DefaultQueryLimit
+	for _, arg := range selection.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			fmt.Sscan(value.Value, &size)
+		case *ast.Variable:
+			if number, ok := c.variables[value.Name.Value].(float64); ok {
+				size = int(number)
+			}
+		}
+	}
+	return min(max(size, 1), internal.MaxQueryLimit)
+}
+
+func (c *costWalker) typeCondition(condition *ast.Named, parent graphql.Type) graphql.Type {
+	if condition == nil {
+		return parent
+	}
+	return c.schema.Type(condition.Name.Value)
+}
+
+func namedType(fieldType graphql.Type) graphql.Type {
+	for {
+		switch wrapped := fieldType.(type) {
+		case *graphql.NonNull:
+			fieldType = wrapped.OfType
+		case *graphql.List:
+			fieldType = wrapped.OfType
+		default:
+			return fieldType
+		}
+	}
+}
+
+type loaderKey struct{}
+
+// userLoader batches the user lookups of one request like a dataloader. Load
+// queues the name and returns a thunk; graphql-go resolves thunks after the
+// fields around them, and the first one fetches all queued names in one call
+// to UserStore. Users found are cached for the rest of the request.
+type userLoader struct {
+	store   *users.UserStore
+	pending map[string]struct{}
+	loaded  map[string]*users.User // nil for names without account
+	batches int
+	sync.Mutex
+}
+
+func newUserLoader(store *users.UserStore) *userLoader {
+	return &userLoader{store: store, pending: map[string]struct{}{}, loaded: map[string]*users.User{}}
+}
+
+// loaderFromContext returns the loader execute sets for every request
+func loaderFromContext(ctx context.Context) *userLoader {
+	return ctx.Value(loaderKey{}).(*userLoader)
+}
+
+// Load returns nil from the thunk when the name has no account
+func (l *userLoader) Load(name string) func() *users.User {
+	l.Lock()
+	if _, ok := l.loaded[name]; !ok {
+		l.pending[name] = struct{}{}
+	}
+	l.Unlock()
+
+	return func() *users.User {
+		l.Lock()
+		defer l.Unlock()
+		if len(l.pending) > 0 {
+			names := make([]string, 0, len(l.pending))
+			for pending := range l.pending {
+				names = append(names, pending)
+			}
+			found := l.store.GetUsers(names)
+			for _, pending := range names {
+				if user, ok := found[pending]; ok {
+					l.loaded[pending] = &user
+				} else {
+					l.loaded[pending] = nil
+				}
+			}
+			l.pending = map[string]struct{}{}
+			l.batches++
+		}
+		return l.loaded[name]
+	}
+}
@@ -0,0 +1,351 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// newGraphQLSchema resolves tasks as internal.Task and users as users.User.
+// Creator and assignee go through the request's userLoader.
+func (api *GraphQLService) newGraphQLSchema() (graphql.Schema, error) {
+	category := graphql.NewEnum(graphql.EnumConfig{
+		Name: "Category",
+		Values: graphql.EnumValueConfigMap{
+			"BREWING":   &graphql.EnumValueConfig{Value: internal.Brewing},
+			"MARKETING": &graphql.EnumValueConfig{Value: internal.Marketing},
+			"LOGISTICS": &graphql.EnumValueConfig{Value: internal.Logistics},
+			"QUALITY":   &graphql.EnumValueConfig{Value: internal.Quality},
+		},
+	})
+	checklistItem := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ChecklistItem",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: field(func(item internal.ChecklistItem) any { return item.Id })},
+			"text": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: field(func(item internal.ChecklistItem) any { return item.Text })},
+			"done": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: field(func(item internal.ChecklistItem) any { return item.Done })},
+		},
+	})
+
+	var task, user, taskConnection *graphql.Object
+	pageArgs := graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{Type: graphql.Int, Description: "Page size, default 50, at most 200"},
+		"after": &graphql.ArgumentConfig{Type: graphql.String, Description: "endCursor of the previous page"},
+	}
+	user = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "User",
+		Description: "Assignees are user names, an assignee without account has no id",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id": &graphql.Field{Type: graphql.ID, Resolve: field(func(u users.User) any {
+					if u.UserId == uuid.Nil {
+						return nil
+					}
+					return u.UserId.String()
+				})},
+				"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: field(func(u users.User) any { return u.UserName })},
+				"assignedTasks": &graphql.Field{Type: graphql.NewNonNull(taskConnection), Args: pageArgs,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return api.queryTasks(p, internal.TaskQuery{Assignee: p.Source.(users.User).UserName})
+					}},
+				"createdTasks": &graphql.Field{Type: graphql.NewNonNull(taskConnection), Args: pageArgs,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return api.queryTasks(p, internal.TaskQuery{Creator: p.Source.(users.User).UserName})
+					}},
+			}
+		}),
+	})
+	task = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Task",
+		Fields: graphql.Fields{
+			"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: field(func(t internal.Task) any { return t.Id })},
+			"message":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: field(func(t internal.Task) any { return t.Msg })},
+			"category": &graphql.Field{Type: graphql.NewNonNull(category), Resolve: field(func(t internal.Task) any { return t.Category })},
+			"done":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: field(func(t internal.Task) any { return t.Done })},
+			"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: field(func(t internal.Task) any {
+				return t.CreatedAt
+			})},
+			"plannedAt": &graphql.Field{Type: graphql.DateTime, Resolve: field(func(t internal.Task) any {
+				if t.PlannedAt.IsZero() {
+					return nil
+				}
+				return t.PlannedAt
+			})},
+			"tags":        &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))), Resolve: field(func(t internal.Task) any { return append([]string{}, t.Tags...) })},
+			"checklist":   &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(checklistItem))), Resolve: field(func(t internal.Task) any { return append([]internal.ChecklistItem{}, t.Checklist...) })},
+			"batchId":     &graphql.Field{Type: graphql.Int, Resolve: field(func(t internal.Task) any { return optionalId(t.BatchId) })},
+			"equipmentId": &graphql.Field{Type: graphql.Int, Resolve: field(func(t internal.Task) any { return optionalId(t.EquipmentId) })},
+			"version":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: field(func(t internal.Task) any { return t.Version })},
+			"creator": &graphql.Field{Type: graphql.NewNonNull(user), Resolve: func(p graphql.ResolveParams) (any, error) {
+				createdBy := p.Source.(internal.Task).CreatedBy
+				load := loaderFromContext(p.Context).Load(createdBy.UserName)
+				// tasks created by the cli or templates have a creator without account
+				return func() (any, error) {
+					if found := load(); found != nil {
+						return *found, nil
+					}
+					return createdBy, nil
+				}, nil
+			}},
+			"assignee": &graphql.Field{Type: user, Resolve: func(p graphql.ResolveParams) (any, error) {
+				name := p.Source.(internal.Task).Assignee
+				if name == "" {
+					return nil, nil
+				}
+				load := loaderFromContext(p.Context).Load(name)
+				return func() (any, error) {
+					if found := load(); found != nil {
+						return *found, nil
+					}
+					return users.User{UserName: name}, nil
+				}, nil
+			}},
+		},
+	})
+	taskConnection = graphql.NewObject(graphql.ObjectConfig{
+		Name: "TaskConnection",
+		Fields: graphql.Fields{
+			"nodes":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(task))), Resolve: field(func(page internal.TaskPage) any { return page.Tasks })},
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: field(func(page internal.TaskPage) any { return page.Total })},
+			"endCursor": &graphql.Field{Type: graphql.String, Description: "Set when there is a next page", Resolve: field(func(page internal.TaskPage) any {
+				if page.NextCursor == "" {
+					return nil
+				}
+				return page.NextCursor
+			})},
+		},
+	})
+
+	taskArgs := graphql.FieldConfigArgument{
+		"category": &graphql.ArgumentConfig{Type: category},
+		"done":     &graphql.ArgumentConfig{Type: graphql.Boolean},
+		"assignee": &graphql.ArgumentConfig{Type: graphql.String},
+		"creator":  &graphql.ArgumentConfig{Type: graphql.String},
+		"tag":      &graphql.ArgumentConfig{Type: graphql.String},
+		"q":        &graphql.ArgumentConfig{Type: graphql.String, Description: "Text in the task message"},
+		"sort":     &graphql.ArgumentConfig{Type: graphql.String, Description: "Comma separated fields, - prefix sorts descending"},
+	}
+	for name, arg := range pageArgs {
+		taskArgs[name] = arg
+	}
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"tasks": &graphql.Field{Type: graphql.NewNonNull(taskConnection), Args: taskArgs, Resolve: api.resolveTasks},
+			"task": &graphql.Field{Type: task,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					found, err := api.tasks(p.Context).GetTask(p.Args["id"].(int))
+					if errors.Is(err, internal.ErrNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, graphQLError(err)
+					}
+					return *found, nil
+				}},
+			"users": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(user))),
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int, Description: "Number of users by name, default 50, at most 200"},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					limit := internal.DefaultQueryLimit
+					if first, ok := p.Args["first"].(int); ok {
+						if first <= 0 {
+							return nil, graphQLError(&internal.InvalidQueryError{Param: "first", Reason: "expected positive number"})
+						}
+						limit = min(first, internal.MaxQueryLimit)
+					}
+					list := api.userStore.ListUsers()
+					return list[:min(limit, len(list))], nil
+				}},
+			"user": &graphql.Field{Type: user,
+				Args: graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					load := loaderFromContext(p.Context).Load(p.Args["name"].(string))
+					return func() (any, error) {
+						if found := load(); found != nil {
+							return *found, nil
+						}
+						return nil, nil
+					}, nil
+				}},
+		},
+	})
+
+	taskInputFields := graphql.InputObjectConfigFieldMap{
+		"category":  &graphql.InputObjectFieldConfig{Type: category},
+		"plannedAt": &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+		"assignee":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"tags":      &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+	}
+	createFields := graphql.InputObjectConfigFieldMap{"message": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)}}
+	updateFields := graphql.InputObjectConfigFieldMap{
+		"message": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"done":    &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+	}
+	for name, inputField := range taskInputFields {
+		createFields[name] = inputField
+		updateFields[name] = inputField
+	}
+	createInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "CreateTaskInput", Fields: createFields})
+	updateInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "UpdateTaskInput", Description: "Only sent fields change", Fields: updateFields})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createTask": &graphql.Field{Type: graphql.NewNonNull(task),
+				Args:    graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(createInput)}},
+				Resolve: api.resolveCreateTask},
+			"updateTask": &graphql.Field{Type: graphql.NewNonNull(task),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(updateInput)},
+				},
+				Resolve: api.resolveUpdateTask},
+			"deleteTask": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean),
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: api.resolveDeleteTask},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// field adapts a getter of the source type to a resolver
+func field[T any](get func(T) any) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		return get(p.Source.(T)), nil
+	}
+}
+
+func optionalId(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (api *GraphQLService) resolveTasks(p graphql.ResolveParams) (any, error) {
+	query := internal.TaskQuery{}
+	if value, ok := p.Args["category"].(internal.TaskCategory); ok {
+		query.Category = &value
+	}
+	if value, ok := p.Args["done"].(bool); ok {
+		query.Done = &value
+	}
+	query.Assignee, _ = p.Args["assignee"].(string)
+	query.Creator, _ = p.Args["creator"].(string)
+	query.Tag, _ = p.Args["tag"].(string)
+	query.Text, _ = p.Args["q"].(string)
+	sort, err := internal.ParseSort(stringArg(p.Args, "sort"))
+	if err != nil {
+		return nil, graphQLError(err)
+	}
+	query.Sort = sort
+	return api.queryTasks(p, query)
+}
+
+// queryTasks applies the first and after arguments to query
+func (api *GraphQLService) queryTasks(p graphql.ResolveParams, query internal.TaskQuery) (any, error) {
+	if first, ok := p.Args["first"].(int); ok {
+		if first <= 0 {
+			return nil, graphQLError(&internal.InvalidQueryError{Param: "first", Reason: "expected positive number"})
+		}
+		query.Limit = first
+	}
+	query.Cursor = stringArg(p.Args, "after")
+	page, err := api.tasks(p.Context).QueryTasks(query)
+	if err != nil {
+		return nil, graphQLError(err)
+	}
+	return page, nil
+}
+
+func stringArg(args map[string]any, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+// taskInput translates sent input fields, absent fields stay nil
+func taskInput(input map[string]any) internal.TaskOptional {
+	update := internal.TaskOptional{}
+	if value, ok := input["message"].(string); ok {
+		update.Msg = &value
+	}
+	if value, ok := input["done"].(bool); ok {
+		update.Done = &value
+	}
+	if value, ok := input["category"].(internal.TaskCategory); ok {
+		update.Category = &value
+	}
+	if value, ok := input["plannedAt"].(time.Time); ok {
+		update.PlannedAt = &internal.CustomTime{Time: value}
+	}
+	if value, ok := input["assignee"].(string); ok {
+		update.Assignee = &value
+	}
+	if values, ok := input["tags"].([]any); ok {
+		update.Tags = []string{}
+		for _, value := range values {
+			update.Tags = append(update.Tags, value.(string))
+		}
+	}
+	return update
+}
+
+func (api *GraphQLService) resolveCreateTask(p graphql.ResolveParams) (any, error) {
+	user, err := mutationUser(p, api.userStore)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermission(p.Context, *user, authz.CreateTask, nil); err != nil {
+		return nil, graphQLError(err)
+	}
+	taskRequest := taskInput(p.Args["input"].(map[string]any))
+	if err := internal.ValidateNewTask(&taskRequest); err != nil {
+		return nil, graphQLError(err)
+	}
+	taskRequest.CreatedBy = user
+	task := api.tasks(p.Context).CreateTask(taskRequest)
+	recordTask(p.Context, user, audit.TaskCreated, task.Id)
+	return *task, nil
+}
+
+func (api *GraphQLService) resolveUpdateTask(p graphql.ResolveParams) (any, error) {
+	taskId := p.Args["id"].(int)
+	user, err := api.authorizeTask(p, authz.EditTask, taskId)
+	if err != nil {
+		return nil, err
+	}
+	update := taskInput(p.Args["input"].(map[string]any))
+	update.UpdatedBy = user
+	if err := api.tasks(p.Context).PartialUpdateTask(taskId, &update); err != nil {
+		return nil, graphQLError(err)
+	}
+	recordTask(p.Context, user, audit.TaskUpdated, taskId)
+	updated, err := api.tasks(p.Context).GetTask(taskId)
+	if err != nil {
+		return nil, graphQLError(err)
+	}
+	return *updated, nil
+}
+
+func (api *GraphQLService) resolveDeleteTask(p graphql.ResolveParams) (any, error) {
+	taskId := p.Args["id"].(int)
+	user, err := api.authorizeTask(p, authz.DeleteTask, taskId)
+	if err != nil {
+		return nil, err
+	}
+	if err := api.tasks(p.Context).DeleteTask(taskId); err != nil {
+		return nil, graphQLError(err)
+	}
+	recordTask(p.Context, user, audit.TaskDeleted, taskId)
+	return true, nil
+}
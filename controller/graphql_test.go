@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type graphQLResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+func setupGraphQL(t *testing.T) (*Router, *GraphQLService, *internal.TaskHolder) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	// queries are made anonymously, like on a public task board
+	authz.PublicDefaultWorkspace = true
+	t.Cleanup(func() { authz.PublicDefaultWorkspace = false })
+
+	api, err := NewGraphQLService(taskService, userStore)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	router := NewRouter()
+	api.RegisterRoutes(router)
+	return router, api, taskHolder
+}
+
+func doGraphQL(t *testing.T, router *Router, token string, query string, variables map[string]any) graphQLResponse {
+	body, _ := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	req := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var response graphQLResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return response
+}
+
+func TestGraphQLTasksWithUsers(t *testing.T) {
+	router, _, taskHolder := setupGraphQL(t)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	creator, _ := userStore.GetUser("AAA")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), Category: internal.CategoryPtr(internal.Brewing), CreatedBy: &creator, Assignee: internal.StringPtr("BBB")})
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew Stout"), Category: internal.CategoryPtr(internal.Brewing), Assignee: internal.StringPtr("Temp")})
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Post photo"), Category: internal.CategoryPtr(internal.Marketing)})
+
+	response := doGraphQL(t, router, "", `query($first: Int) {
+		tasks(category: BREWING, sort: "id", first: $first) {
+			totalCount
+			endCursor
+			nodes { id message category creator { id name } assignee { id name assignedTasks { totalCount } } }
+		}
+	}`, map[string]any{"first": 1})
+	if len(response.Errors) > 0 {
+		t.Fatalf("Expected no errors, got %+v", response.Errors)
+	}
+	data, _ := json.Marshal(response.Data)
+	for _, want := range []string{
+		`"totalCount":2`,
+		`"message":"Brew IPA"`,
+		`"category":"BREWING"`,
+		`"creator":{"id":"` + creator.UserId.String() + `","name":"AAA"}`,
+		`"name":"BBB"`,
+		`"assignedTasks":{"totalCount":1}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in %s", want, data)
+		}
+	}
+	if strings.Contains(string(data), `"endCursor":null`) {
+		t.Errorf("Expected endCursor of next page, got %s", data)
+	}
+
+	response = doGraphQL(t, router, "", `{ tasks(category: BREWING, sort: "id", first: 1, after: "`+response.Data["tasks"].(map[string]any)["endCursor"].(string)+`") {
+		nodes { message creator { id name } assignee { id name } } } }`, nil)
+	data, _ = json.Marshal(response.Data)
+	if !strings.Contains(string(data), `"creator":{"id":null,"name":"Team"}`) || !strings.Contains(string(data), `"assignee":{"id":null,"name":"Temp"}`) {
+		t.Errorf("Expected users without account, got %s", data)
+	}
+
+	response = doGraphQL(t, router, "", `{ users(first: 1) { name } }`, nil)
+	if list, _ := response.Data["users"].([]any); len(list) != 1 {
+		t.Errorf("Expected one user, got %+v", response)
+	}
+}
+
+func TestGraphQLBatchesUserLookups(t *testing.T) {
+	_, api, taskHolder := setupGraphQL(t)
+	for _, assignee := range []string{"AAA", "BBB", "CCC", "BBB"} {
+		taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), Assignee: internal.StringPtr(assignee)})
+	}
+
+	loader := newUserLoader(api.userStore)
+	loads := []func() *users.User{loader.Load("AAA"), loader.Load("BBB"), loader.Load("Nobody")}
+	if loads[0]().UserName != "AAA" || loads[1]().UserName != "BBB" || loads[2]() != nil {
+		t.Error("Expected users loaded by name")
+	}
+	loader.Load("AAA")()
+	if loader.batches != 1 {
+		t.Errorf("Expected 1 batch, got %d", loader.batches)
+	}
+
+	loader = newUserLoader(api.userStore)
+	result := api.execute(context.Background(), loader, graphQLRequest{Query: `{ tasks { nodes { assignee { name } creator { name } } } }`})
+	if result.HasErrors() {
+		t.Fatalf("Expected no errors, got %v", result.Errors)
+	}
+	if loader.batches != 1 || len(loader.loaded) != 4 {
+		t.Errorf("Expected assignees and creators loaded in 1 batch, got %d batches of %v", loader.batches, loader.loaded)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	router, _, _ := setupGraphQL(t)
+
+	response := doGraphQL(t, router, MOCK_TOKEN, `mutation {
+		createTask(input: {message: "Brew IPA", category: BREWING, tags: ["IPA"]}) { id creator { name } tags }
+	}`, nil)
+	data, _ := json.Marshal(response.Data)
+	if len(response.Errors) > 0 || !strings.Contains(string(data), `"createTask":{"creator":{"name":"AAA"},"id":1,"tags":["ipa"]}`) {
+		t.Fatalf("Expected created task, got %s %+v", data, response.Errors)
+	}
+
+	response = doGraphQL(t, router, MOCK_TOKEN, `mutation { updateTask(id: 1, input: {done: true}) { done message version } }`, nil)
+	data, _ = json.Marshal(response.Data)
+	if !strings.Contains(string(data), `"done":true,"message":"Brew IPA"`) {
+		t.Errorf("Expected updated task, got %s %+v", data, response.Errors)
+	}
+
+	response = doGraphQL(t, router, MOCK_TOKEN, `mutation { deleteTask(id: 1) }`, nil)
+	if response.Data["deleteTask"] != true {
+		t.Errorf("Expected task deleted, got %+v", response)
+	}
+	response = doGraphQL(t, router, "", `{ task(id: 1) { id } }`, nil)
+	if response.Data["task"] != nil || len(response.Errors) > 0 {
+		t.Errorf("Expected null task, got %+v", response)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	router, _, _ := setupGraphQL(t)
+
+	tests := []struct {
+		name       string
+		token      string
+		query      string
+		wantError  string
+		wantStatus float64
+		wantField  string
+	}{
+		{"anonymous mutation", "", `mutation { deleteTask(id: 1) }`, "login required", http.StatusUnauthorized, ""},
+		{"unknown user", "nobody", `mutation { deleteTask(id: 1) }`, "login required", http.StatusUnauthorized, ""},
+		{"missing task", MOCK_TOKEN, `mutation { deleteTask(id: 42) }`, "resource not found", http.StatusNotFound, ""},
+		{"empty message", MOCK_TOKEN, `mutation { createTask(input: {message: " "}) { id } }`, "request has invalid fields", http.StatusBadRequest, "message"},
+		{"invalid sort", "", `{ tasks(sort: "color") { totalCount } }`, "request has invalid fields", http.StatusBadRequest, "sort"},
+		{"unknown field", "", `{ tasks { nodes { color } } }`, `Cannot query field "color"`, 0, ""},
+		{"too deep", "", `{ users { assignedTasks { nodes { assignee { assignedTasks { nodes { creator { createdTasks { nodes { id } } } } } } } } } }`, "query depth 10 exceeds", 0, ""},
+		{"too complex", "", `{ tasks(first: 200) { nodes { assignee { assignedTasks(first: 200) { nodes { id } } } } } }`, "query complexity", 0, ""},
+		{"users counted", "", `{ users(first: 200) { assignedTasks(first: 200) { nodes { id } } } }`, "query complexity", 0, ""},
+		{"invalid users page", "", `{ users(first: 0) { name } }`, "request has invalid fields", http.StatusBadRequest, "first"},
+		{"fragment counted", "", `{ tasks(first: 200) { nodes { ...deep } } } fragment deep on Task { assignee { assignedTasks(first: 200) { nodes { id } } } }`, "query complexity", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := doGraphQL(t, router, tt.token, tt.query, nil)
+			if len(response.Errors) == 0 || !strings.Contains(response.Errors[0].Message, tt.wantError) {
+				t.Fatalf("Expected error %q, got %+v", tt.wantError, response.Errors)
+			}
+			if tt.wantStatus != 0 && response.Errors[0].Extensions["status"] != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v", tt.wantStatus, response.Errors[0].Extensions)
+			}
+			if tt.wantField != "" {
+				fields, _ := json.Marshal(response.Errors[0].Extensions["fields"])
+				if !strings.Contains(string(fields), `"field":"`+tt.wantField+`"`) {
+					t.Errorf("Expected invalid %s, got %s", tt.wantField, fields)
+				}
+			}
+		})
+	}
+}
+
+func TestGraphQLIntrospectionAllowed(t *testing.T) {
+	router, _, _ := setupGraphQL(t)
+	response := doGraphQL(t, router, "", `{ __schema { types { name fields { name type { name ofType { name ofType { name ofType { name } } } } } } } }`, nil)
+	if len(response.Errors) > 0 {
+		t.Errorf("Expected introspection allowed, got %+v", response.Errors)
+	}
+}
+
+func TestGraphQLRequiresJson(t *testing.T) {
+	router, _, _ := setupGraphQL(t)
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader("query={tasks{totalCount}}"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,387 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	tasksv1 "github.com/zhekagigs/golang_todo/proto/tasks/v1"
+	"github.com/zhekagigs/golang_todo/users"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TaskGrpcService serves tasks.v1.TaskService from proto/tasks/v1 with the
+// same task service and event log as ApiService and TaskEventsService
+type TaskGrpcService struct {
+	tasksv1.UnimplementedTaskServiceServer
+	taskService *internal.ConcurrentTaskService
+	// Workspaces has the task services of the x-workspace metadata, by
+	// default only the default workspace with taskService
+	Workspaces *internal.Workspaces
+	userStore  *users.UserStore
+	events     *internal.TaskEventLog
+}
+
+func NewTaskGrpcService(taskService *internal.ConcurrentTaskService, userStore *users.UserStore, events *internal.TaskEventLog) *TaskGrpcService {
+	return &TaskGrpcService{taskService: taskService, Workspaces: internal.NewWorkspaces(taskService), userStore: userStore, events: events}
+}
+
+// NewGrpcServer registers the service behind the auth interceptors of middleware
+func NewGrpcServer(service *TaskGrpcService) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(logUnary, middleware.UnaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(logStream, middleware.StreamAuthInterceptor),
+	)
+	tasksv1.RegisterTaskServiceServer(server, service)
+	return server
+}
+
+func logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.Info.Printf("Method: %s, Code: %s, Duration: %v", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+func logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logger.Info.Printf("Method: %s, Code: %s, Duration: %v", info.FullMethod, status.Code(err), time.Since(start))
+	return err
+}
+
+func (s *TaskGrpcService) GetTask(ctx context.Context, req *tasksv1.GetTaskRequest) (*tasksv1.Task, error) {
+	tasks, err := s.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	task, err := tasks.GetTask(int(req.Id))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoTask(task), nil
+}
+
+func (s *TaskGrpcService) ListTasks(ctx context.Context, req *tasksv1.ListTasksRequest) (*tasksv1.ListTasksResponse, error) {
+	tasks, err := s.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.PageSize < 0 {
+		return nil, grpcError(&internal.InvalidQueryError{Param: "page_size", Reason: "expected positive number"})
+	}
+	query := internal.TaskQuery{
+		Category: fromProtoCategory(req.Category),
+		Done:     req.Done,
+		Assignee: req.Assignee,
+		Creator:  req.Creator,
+		Tag:      req.Tag,
+		Text:     req.Query,
+		Cursor:   req.PageToken,
+		Limit:    int(req.PageSize),
+	}
+	if req.PlannedFrom != nil {
+		query.PlannedFrom = req.PlannedFrom.AsTime()
+	}
+	if req.PlannedTo != nil {
+		query.PlannedTo = req.PlannedTo.AsTime()
+	}
+	sort, err := internal.ParseSort(req.Sort)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	query.Sort = sort
+
+	page, err := tasks.QueryTasks(query)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &tasksv1.ListTasksResponse{Total: int32(page.Total), NextPageToken: page.NextCursor}
+	for i := range page.Tasks {
+		response.Tasks = append(response.Tasks, toProtoTask(&page.Tasks[i]))
+	}
+	return response, nil
+}
+
+func (s *TaskGrpcService) CreateTask(ctx context.Context, req *tasksv1.CreateTaskRequest) (*tasksv1.Task, error) {
+	tasks, err := s.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := grpcUser(ctx, s.userStore)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "user not found")
+	}
+	if err := checkPermission(ctx, *user, authz.CreateTask, nil); err != nil {
+		return nil, grpcError(err)
+	}
+	taskRequest := &internal.TaskOptional{
+		Msg:       &req.Message,
+		Category:  fromProtoCategory(req.Category),
+		PlannedAt: fromProtoTime(req.PlannedAt),
+		Tags:      req.Tags,
+		CreatedBy: user,
+	}
+	if req.Assignee != "" {
+		taskRequest.Assignee = &req.Assignee
+	}
+	if err := internal.ValidateNewTask(taskRequest); err != nil {
+		return nil, grpcError(err)
+	}
+	task := tasks.CreateTask(*taskRequest)
+	recordTask(ctx, user, audit.TaskCreated, task.Id)
+	return toProtoTask(task), nil
+}
+
+func (s *TaskGrpcService) UpdateTask(ctx context.Context, req *tasksv1.UpdateTaskRequest) (*tasksv1.Task, error) {
+	tasks, err := s.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.authorizeTask(ctx, tasks, authz.EditTask, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	update := &internal.TaskOptional{
+		Msg:       req.Message,
+		Category:  fromProtoCategory(req.Category),
+		Done:      req.Done,
+		PlannedAt: fromProtoTime(req.PlannedAt),
+		Assignee:  req.Assignee,
+		UpdatedBy: user,
+	}
+	if req.UpdateTags {
+		update.Tags = append([]string{}, req.Tags...)
+	}
+	task, err := tasks.UpdateTaskIfVersion(int(req.Id), expectedVersion(req.ExpectedVersion), update)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	recordTask(ctx, user, audit.TaskUpdated, task.Id)
+	return toProtoTask(task), nil
+}
+
+func (s *TaskGrpcService) DeleteTask(ctx context.Context, req *tasksv1.DeleteTaskRequest) (*tasksv1.DeleteTaskResponse, error) {
+	tasks, err := s.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.authorizeTask(ctx, tasks, authz.DeleteTask, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	err = tasks.DeleteTaskIfVersion(int(req.Id), expectedVersion(req.ExpectedVersion))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	recordTask(ctx, user, audit.TaskDeleted, int(req.Id))
+	return &tasksv1.DeleteTaskResponse{}, nil
+}
+
+// WatchTasks is StreamTaskEvents for gRPC. Missed events are replayed after
+// after_event_id, a client which fell too far behind gets UNAVAILABLE and
+// resumes with the id of the last event it received. Only the default
+// workspace has events.
+func (s *TaskGrpcService) WatchTasks(req *tasksv1.WatchTasksRequest, stream grpc.ServerStreamingServer[tasksv1.TaskEvent]) error {
+	ctx := stream.Context()
+	if name := metadataWorkspace(ctx); name != "" && name != internal.DefaultWorkspace {
+		return status.Error(codes.NotFound, "only the default workspace has task events")
+	}
+	userId, _ := middleware.UserFromContext(ctx)
+	if _, err := resolveWorkspace(ctx, s.Workspaces, s.userStore, userId, internal.DefaultWorkspace); err != nil {
+		return grpcError(err)
+	}
+	filter := &internal.TaskQuery{Category: fromProtoCategory(req.Category), Assignee: req.Assignee}
+	lastId := -1
+	if req.AfterEventId != nil {
+		if *req.AfterEventId < 0 {
+			return grpcError(&internal.InvalidQueryError{Param: "after_event_id", Reason: "expected event id"})
+		}
+		lastId = int(*req.AfterEventId)
+	}
+
+	replay, ok, events, cancel := s.events.Listen(lastId, eventListenerBuffer)
+	defer cancel()
+	if !ok {
+		return status.Error(codes.FailedPrecondition, "events are no longer buffered, list the tasks and watch from the latest event")
+	}
+	for i := range replay {
+		if err := sendTaskEvent(stream, &replay[i], filter); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, open := <-events:
+			if !open {
+				return status.Error(codes.Unavailable, "watch fell behind, resume after the last event")
+			}
+			if err := sendTaskEvent(stream, &event, filter); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var protoEventTypes = map[internal.TaskEventType]tasksv1.TaskEvent_Type{
+	internal.TaskCreated: tasksv1.TaskEvent_TYPE_CREATED,
+	internal.TaskUpdated: tasksv1.TaskEvent_TYPE_UPDATED,
+	internal.TaskDeleted: tasksv1.TaskEvent_TYPE_DELETED,
+}
+
+func sendTaskEvent(stream grpc.ServerStreamingServer[tasksv1.TaskEvent], event *internal.LoggedTaskEvent, filter *internal.TaskQuery) error {
+	if !event.Matches(filter) {
+		return nil
+	}
+	return stream.Send(&tasksv1.TaskEvent{
+		Id:   int64(event.Id),
+		Type: protoEventTypes[event.Type],
+		Task: toProtoTask(&event.Task),
+	})
+}
+
+// grpcUser is currentUser for gRPC calls authenticated by middleware.UnaryAuthInterceptor
+func grpcUser(ctx context.Context, userStore *users.UserStore) (*users.User, bool) {
+	userId, ok := middleware.UserFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	user, ok := userStore.GetUserById(userId)
+	if !ok {
+		return nil, false
+	}
+	return &user, true
+}
+
+// workspaceMetadata names the active workspace of calls like WorkspaceHeader
+const workspaceMetadata = "x-workspace"
+
+func metadataWorkspace(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(workspaceMetadata); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// tasks returns the task service of the workspace of the call
+func (s *TaskGrpcService) tasks(ctx context.Context) (*internal.ConcurrentTaskService, error) {
+	userId, _ := middleware.UserFromContext(ctx)
+	tasks, err := resolveWorkspace(ctx, s.Workspaces, s.userStore, userId, metadataWorkspace(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return tasks, nil
+}
+
+// authorizeTask checks that the user of ctx may do action with task taskId
+// and returns the user
+func (s *TaskGrpcService) authorizeTask(ctx context.Context, tasks *internal.ConcurrentTaskService, action authz.Action, taskId int) (*users.User, error) {
+	user, ok := grpcUser(ctx, s.userStore)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "user not found")
+	}
+	task, err := tasks.GetTask(taskId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := checkPermission(ctx, *user, action, task); err != nil {
+		return nil, grpcError(err)
+	}
+	return user, nil
+}
+
+// grpcError maps the status problemFromError gives err to a gRPC code, so both
+// APIs reject the same requests. Invalid fields are sent as BadRequest details.
+func grpcError(err error) error {
+	problem := problemFromError(err, http.StatusInternalServerError, "")
+	code := codes.Internal
+	switch problem.Status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusPreconditionFailed:
+		code = codes.Aborted
+	case http.StatusConflict, http.StatusLocked, http.StatusFailedDependency:
+		code = codes.FailedPrecondition
+	}
+	if code == codes.Internal {
+		logger.Error.Printf("grpc: %v", err)
+		problem.Detail = ErrInternal.Error()
+	}
+
+	st := status.New(code, problem.Detail)
+	if len(problem.Errors) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, fieldErr := range problem.Errors {
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: fieldErr.Field, Description: fieldErr.Message})
+		}
+		if detailed, err := st.WithDetails(badRequest); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+func expectedVersion(version *int64) int {
+	if version == nil {
+		return internal.AnyVersion
+	}
+	return int(*version)
+}
+
+// fromProtoCategory returns nil for CATEGORY_UNSPECIFIED, unknown values
+// are rejected by the task holder as invalid category
+func fromProtoCategory(category tasksv1.Category) *internal.TaskCategory {
+	if category == tasksv1.Category_CATEGORY_UNSPECIFIED {
+		return nil
+	}
+	return internal.CategoryPtr(internal.TaskCategory(category - 1))
+}
+
+func fromProtoTime(ts *timestamppb.Timestamp) *internal.CustomTime {
+	if ts == nil {
+		return nil
+	}
+	return &internal.CustomTime{Time: ts.AsTime()}
+}
+
+func toProtoTask(task *internal.Task) *tasksv1.Task {
+	protoTask := &tasksv1.Task{
+		Id:          int64(task.Id),
+		Message:     task.Msg,
+		Category:    tasksv1.Category(task.Category + 1),
+		Done:        task.Done,
+		CreatedAt:   timestamppb.New(task.CreatedAt),
+		PlannedAt:   timestamppb.New(task.PlannedAt),
+		CreatedBy:   &tasksv1.User{Id: task.CreatedBy.UserId.String(), Name: task.CreatedBy.UserName},
+		Assignee:    task.Assignee,
+		Tags:        task.Tags,
+		BatchId:     int64(task.BatchId),
+		EquipmentId: int64(task.EquipmentId),
+		Version:     int64(task.Version),
+	}
+	for _, item := range task.Checklist {
+		protoTask.Checklist = append(protoTask.Checklist, &tasksv1.ChecklistItem{Id: int64(item.Id), Text: item.Text, Done: item.Done})
+	}
+	return protoTask
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	tasksv1 "github.com/zhekagigs/golang_todo/proto/tasks/v1"
+	"github.com/zhekagigs/golang_todo/users"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupGrpc(t *testing.T) (tasksv1.TaskServiceClient, *internal.TaskHolder, context.Context) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	events := internal.NewTaskEventLog(internal.DefaultEventLogSize, taskHolder)
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewGrpcServer(NewTaskGrpcService(taskService, userStore, events))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	user, _ := userStore.GetUser("AAA")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", sessionToken(user))
+	return tasksv1.NewTaskServiceClient(conn), taskHolder, ctx
+}
+
+func TestGrpcTaskCrud(t *testing.T) {
+	client, _, ctx := setupGrpc(t)
+
+	created, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{
+		Message: "Brew IPA", Category: tasksv1.Category_CATEGORY_BREWING, Tags: []string{"IPA"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if created.Id != 1 || created.CreatedBy.GetName() != "AAA" || created.Category != tasksv1.Category_CATEGORY_BREWING {
+		t.Errorf("Unexpected created task %v", created)
+	}
+	client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Message: "Post photo", Category: tasksv1.Category_CATEGORY_MARKETING})
+
+	list, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{Category: tasksv1.Category_CATEGORY_BREWING})
+	if err != nil || list.Total != 1 || list.Tasks[0].Message != "Brew IPA" {
+		t.Errorf("Expected brewing task listed, got %v %v", list, err)
+	}
+	list, _ = client.ListTasks(ctx, &tasksv1.ListTasksRequest{PageSize: 1, Sort: "-id"})
+	if list.Total != 2 || len(list.Tasks) != 1 || list.Tasks[0].Id != 2 || list.NextPageToken == "" {
+		t.Errorf("Expected first page with next token, got %v", list)
+	}
+	list, _ = client.ListTasks(ctx, &tasksv1.ListTasksRequest{PageSize: 1, Sort: "-id", PageToken: list.NextPageToken})
+	if len(list.Tasks) != 1 || list.Tasks[0].Id != 1 {
+		t.Errorf("Expected second page, got %v", list)
+	}
+
+	done := true
+	updated, err := client.UpdateTask(ctx, &tasksv1.UpdateTaskRequest{Id: created.Id, Done: &done, ExpectedVersion: &created.Version})
+	if err != nil || !updated.Done || updated.Message != "Brew IPA" || updated.Version == created.Version {
+		t.Errorf("Expected task done, got %v %v", updated, err)
+	}
+
+	_, err = client.DeleteTask(ctx, &tasksv1.DeleteTaskRequest{Id: created.Id, ExpectedVersion: &updated.Version})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = client.GetTask(ctx, &tasksv1.GetTaskRequest{Id: created.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+}
+
+func TestGrpcErrors(t *testing.T) {
+	client, taskHolder, ctx := setupGrpc(t)
+	task := taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+	staleVersion := int64(task.Version + 1)
+
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context) error
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{"no authorization", func(ctx context.Context) error {
+			_, err := client.GetTask(ctx, &tasksv1.GetTaskRequest{Id: 1})
+			return err
+		}, context.Background(), codes.Unauthenticated},
+		{"unknown user", func(ctx context.Context) error {
+			_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Message: "Brew IPA"})
+			return err
+		}, metadata.AppendToOutgoingContext(context.Background(), "authorization", "nobody"), codes.Unauthenticated},
+		{"missing task", func(ctx context.Context) error {
+			_, err := client.UpdateTask(ctx, &tasksv1.UpdateTaskRequest{Id: 42})
+			return err
+		}, ctx, codes.NotFound},
+		{"stale version", func(ctx context.Context) error {
+			_, err := client.DeleteTask(ctx, &tasksv1.DeleteTaskRequest{Id: int64(task.Id), ExpectedVersion: &staleVersion})
+			return err
+		}, ctx, codes.Aborted},
+		{"invalid sort", func(ctx context.Context) error {
+			_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{Sort: "color"})
+			return err
+		}, ctx, codes.InvalidArgument},
+		{"invalid category", func(ctx context.Context) error {
+			_, err := client.UpdateTask(ctx, &tasksv1.UpdateTaskRequest{Id: int64(task.Id), Category: tasksv1.Category(9)})
+			return err
+		}, ctx, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(tt.ctx)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Expected %v, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestGrpcCreateInvalidTaskDetails(t *testing.T) {
+	client, _, ctx := setupGrpc(t)
+
+	_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Message: " "})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("Expected InvalidArgument with details, got %v", err)
+	}
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok || badRequest.FieldViolations[0].Field != "msg" {
+		t.Errorf("Expected msg field violation, got %v", st.Details())
+	}
+}
+
+func TestGrpcWatchTasks(t *testing.T) {
+	client, taskHolder, ctx := setupGrpc(t)
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), Category: internal.CategoryPtr(internal.Brewing)})
+
+	stream, err := client.WatchTasks(ctx, &tasksv1.WatchTasksRequest{Category: tasksv1.Category_CATEGORY_BREWING, AfterEventId: new(int64)})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	event, err := stream.Recv()
+	if err != nil || event.Id != 1 || event.Type != tasksv1.TaskEvent_TYPE_CREATED || event.Task.Message != "Brew IPA" {
+		t.Fatalf("Expected replayed created event, got %v %v", event, err)
+	}
+
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Post photo"), Category: internal.CategoryPtr(internal.Marketing)})
+	taskHolder.PartialUpdateTask(1, &internal.TaskOptional{Done: internal.BoolPtr(true)})
+	event, err = stream.Recv()
+	if err != nil || event.Id != 3 || event.Type != tasksv1.TaskEvent_TYPE_UPDATED || !event.Task.Done {
+		t.Errorf("Expected marketing task skipped and update received, got %v %v", event, err)
+	}
+}
+
+func TestGrpcWatchTasksExpired(t *testing.T) {
+	client, taskHolder, ctx := setupGrpc(t)
+	for i := 0; i < internal.DefaultEventLogSize+1; i++ {
+		taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+	}
+
+	stream, _ := client.WatchTasks(ctx, &tasksv1.WatchTasksRequest{AfterEventId: new(int64)})
+	if _, err := stream.Recv(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestGrpcApiTokenScopes(t *testing.T) {
+	client, _, _ := setupGrpc(t)
+	tokens, _ := users.NewAPITokenStore("")
+	apiTokens := middleware.APITokens
+	middleware.APITokens = tokens
+	t.Cleanup(func() { middleware.APITokens = apiTokens })
+	_, secret, _ := tokens.Create("25c5e443-d076-4210-ab0e-89b3fbdfead8", "sensor", []string{users.ScopeRead}, 0)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+secret)
+
+	if _, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{}); err != nil {
+		t.Errorf("Expected read token to list, got %v", err)
+	}
+	if _, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Message: "Brew IPA"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+)
+
+// recordingWriter keeps a copy of the response for the idempotency store
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Idempotent replays the saved response when a request with the same
+// Idempotency-Key is retried. Keys are scoped to the user, workspace, method
+// and path; requests without the header run as usual. Use after AuthMiddleware.
+func (api *ApiService) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeProblem(w, NewProblem(http.StatusBadRequest, "request has invalid fields",
+				FieldError{Field: idempotencyKeyHeader, Message: "key is longer than 255 characters"}))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+		if handleError(w, err, http.StatusBadRequest, "error reading request body") {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userId, _ := middleware.UserFromContext(r.Context())
+		storeKey := userId + " " + requestWorkspace(r) + " " + r.Method + " " + r.URL.Path + " " + key
+		hash := sha256.Sum256(append([]byte(r.URL.RawQuery+"\n"), body...))
+
+		saved, err := api.idempotency.Begin(storeKey, hex.EncodeToString(hash[:]))
+		if err != nil {
+			if err == internal.ErrIdempotencyInProgress {
+				w.Header().Set("Retry-After", "1")
+			}
+			handleError(w, err, http.StatusConflict, "")
+			return
+		}
+		if saved != nil {
+			for name, values := range saved.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(saved.Status)
+			w.Write(saved.Body)
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			api.idempotency.Abort(storeKey)
+			return
+		}
+		err = api.idempotency.Complete(storeKey, internal.IdempotentResponse{
+			Status: recorder.status,
+			Header: recorder.Header().Clone(),
+			Body:   recorder.body.Bytes(),
+		})
+		if err != nil {
+			logger.Error.Printf("error saving idempotent response: %v", err)
+		}
+	}
+}
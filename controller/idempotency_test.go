@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestIdempotentCreateTask(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewApiService(taskService, userStore)
+	t.Cleanup(taskService.CloseAll)
+	router := http.NewServeMux()
+	router.HandleFunc("POST /api/tasks", middleware.AuthMiddleware(api.Idempotent(api.CreateTask)))
+
+	post := func(key string, msg string, token string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(internal.TaskOptional{Msg: internal.StringPtr(msg)})
+		req := httptest.NewRequest("POST", "/api/tasks", bytes.NewReader(payload))
+		req.Header.Set("Authorization", token)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post("scan-1", "Receive malt", MOCK_TOKEN)
+	tests := []struct {
+		name         string
+		key          string
+		msg          string
+		token        string
+		wantStatus   int
+		wantReplayed bool
+	}{
+		{"retry replays response", "scan-1", "Receive malt", MOCK_TOKEN, http.StatusCreated, true},
+		{"reused key with other body", "scan-1", "Receive hops", MOCK_TOKEN, http.StatusUnprocessableEntity, false},
+		{"new key creates task", "scan-2", "Receive malt", MOCK_TOKEN, http.StatusCreated, false},
+		{"no key creates task", "", "Receive malt", MOCK_TOKEN, http.StatusCreated, false},
+		{"key of other user", "scan-1", "Receive malt", sessionToken(users.User{UserName: "Nobody"}), http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := post(tt.key, tt.msg, tt.token)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if replayed := rr.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Errorf("Expected replayed %v, got %v", tt.wantReplayed, replayed)
+			}
+			if tt.wantReplayed && (rr.Body.String() != first.Body.String() || rr.Header().Get("ETag") != first.Header().Get("ETag")) {
+				t.Errorf("Expected replay of %s, got %s", first.Body.String(), rr.Body.String())
+			}
+		})
+	}
+
+	if _, count := taskHolder.Count(); count != 3 {
+		t.Errorf("Expected 3 tasks, got %d", count)
+	}
+}
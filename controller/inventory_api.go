@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type InventoryApiService struct {
+	inventory *internal.Inventory
+	userStore *users.UserStore
+}
+
+type inventoryItemResponse struct {
+	internal.InventoryItem
+	Movements []internal.StockMovement `json:"movements"`
+}
+
+type movementRequest struct {
+	Quantity float64                 `json:"quantity"`
+	Unit     internal.Unit           `json:"unit"`
+	Reason   internal.MovementReason `json:"reason"`
+	Note     string                  `json:"note"`
+}
+
+func NewInventoryApiService(inventory *internal.Inventory, userStore *users.UserStore) *InventoryApiService {
+	return &InventoryApiService{
+		inventory: inventory,
+		userStore: userStore,
+	}
+}
+
+func (api *InventoryApiService) RegisterRoutes(router *Router) {
+	router.Handle("GET /api/inventory", api.GetAllItems, &RouteDoc{
+		Summary: "List inventory items", Tag: "inventory", Response: []internal.InventoryItem{}})
+	router.Handle("GET /api/inventory/{id}", api.GetItemById, &RouteDoc{
+		Summary: "Get inventory item with movements", Tag: "inventory", Response: inventoryItemResponse{}})
+	router.HandleAuth("POST /api/inventory", api.CreateItem, &RouteDoc{
+		Summary: "Create inventory item", Tag: "inventory", Request: internal.InventoryItem{}, Status: http.StatusCreated, Response: internal.InventoryItem{}})
+	router.Handle("GET /api/inventory/{id}/movements", api.GetMovements, &RouteDoc{
+		Summary: "List stock movements", Tag: "inventory", Response: []internal.StockMovement{}})
+	router.HandleAuth("POST /api/inventory/{id}/movements", api.RecordMovement, &RouteDoc{
+		Summary: "Record stock movement", Tag: "inventory", Request: movementRequest{}, Status: http.StatusCreated, Response: internal.StockMovement{}})
+}
+
+func (api *InventoryApiService) GetAllItems(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, api.inventory.ReadItems())
+}
+
+func (api *InventoryApiService) GetItemById(w http.ResponseWriter, r *http.Request) {
+	itemId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing itemId") {
+		return
+	}
+	item, err := api.inventory.FindItemById(itemId)
+	if handleError(w, err, http.StatusNotFound, "api: item not found") {
+		return
+	}
+	movements, err := api.inventory.ItemMovements(itemId)
+	if handleError(w, err, http.StatusNotFound, "api: item not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, inventoryItemResponse{InventoryItem: *item, Movements: movements})
+}
+
+func (api *InventoryApiService) CreateItem(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ManageInventory, nil); !ok {
+		return
+	}
+	var itemRequest internal.InventoryItem
+	err := json.NewDecoder(r.Body).Decode(&itemRequest)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	item, err := api.inventory.AddItem(itemRequest)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	writeJson(w, http.StatusCreated, item)
+}
+
+func (api *InventoryApiService) GetMovements(w http.ResponseWriter, r *http.Request) {
+	itemId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing itemId") {
+		return
+	}
+	movements, err := api.inventory.ItemMovements(itemId)
+	if handleError(w, err, http.StatusNotFound, "api: item not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, movements)
+}
+
+// RecordMovement books receipts and consumption, correcting the stock with an
+// adjustment needs authz.ManageInventory
+func (api *InventoryApiService) RecordMovement(w http.ResponseWriter, r *http.Request) {
+	itemId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing itemId") {
+		return
+	}
+	var request movementRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	action := authz.RecordStock
+	if request.Reason == internal.Adjustment {
+		action = authz.ManageInventory
+	}
+	user, ok := authorize(w, r, api.userStore, action, nil)
+	if !ok {
+		return
+	}
+
+	movement, err := api.inventory.RecordMovement(itemId, request.Quantity, request.Unit, request.Reason, request.Note, user)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	writeJson(w, http.StatusCreated, movement)
+}
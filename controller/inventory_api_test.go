@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestInventoryApi(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	inventory, _ := internal.NewInventory("", taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewInventoryApiService(inventory, userStore)
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /api/inventory/{id}", api.GetItemById)
+	router.HandleFunc("POST /api/inventory", middleware.AuthMiddleware(api.CreateItem))
+	router.HandleFunc("POST /api/inventory/{id}/movements", middleware.AuthMiddleware(api.RecordMovement))
+
+	rr := doApiRequest(router, "POST", "/api/inventory", internal.InventoryItem{
+		Name: "Hops", Unit: internal.Kilogram, Stock: 20, ReorderThreshold: 10, ReorderQuantity: 120,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doApiRequest(router, "POST", "/api/inventory/1/movements", movementRequest{Quantity: 15, Reason: internal.Consumption})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var movement internal.StockMovement
+	json.NewDecoder(rr.Body).Decode(&movement)
+	if movement.CreatedBy.UserName != "AAA" || movement.Quantity != -15 {
+		t.Errorf("Unexpected movement %v", movement)
+	}
+
+	rr = doApiRequest(router, "GET", "/api/inventory/1", nil)
+	var item inventoryItemResponse
+	json.NewDecoder(rr.Body).Decode(&item)
+	if item.Stock != 5 || len(item.Movements) != 1 {
+		t.Errorf("Unexpected item %v", item)
+	}
+	if item.ReorderTaskId == 0 {
+		t.Errorf("Expected reorder task to be created")
+	}
+
+	rr = doApiRequest(router, "POST", "/api/inventory/1/movements", movementRequest{Quantity: 1, Unit: "oz", Reason: internal.Receipt})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest, got %v", rr.Code)
+	}
+}
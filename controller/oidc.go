@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/oidc"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// oidcLoginCookie keeps the oidc.LoginState while the browser is at the
+// provider. It is Lax, strict cookies are not sent on the way back.
+const oidcLoginCookie = "OIDCLogin"
+
+const oidcLoginPath = "/login/oidc"
+
+// OIDCLoginHandler sends the browser to the provider with a new login state
+func (ah *AuthHandler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if ah.OIDC == nil {
+		writeProblem(w, NewProblem(http.StatusNotFound, "single sign-on is not configured"))
+		return
+	}
+	login := oidc.NewLoginState()
+	value, _ := json.Marshal(login)
+	http.SetCookie(w, loginStateCookie(base64.RawURLEncoding.EncodeToString(value), 10*time.Minute))
+	http.Redirect(w, r, ah.OIDC.AuthCodeURL(login), http.StatusFound)
+}
+
+// OIDCCallbackHandler trades the code for the ID token, provisions the user
+// and starts a session like /login
+func (ah *AuthHandler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if ah.OIDC == nil {
+		writeProblem(w, NewProblem(http.StatusNotFound, "single sign-on is not configured"))
+		return
+	}
+	login, ok := readLoginState(r)
+	// the state is used once
+	http.SetCookie(w, loginStateCookie("", -time.Second))
+	query := r.URL.Query()
+	if !ok || query.Get("state") != login.State {
+		writeProblem(w, NewProblem(http.StatusBadRequest, "login state does not match, start the login again"))
+		return
+	}
+	if reason := query.Get("error"); reason != "" {
+		logger.Error.Printf("identity provider refused login: %s %s", reason, query.Get("error_description"))
+		recordAudit(r.Context(), nil, audit.LoginFailed, "", "single sign-on: "+reason)
+		writeProblem(w, NewProblem(http.StatusUnauthorized, "identity provider refused login: "+reason))
+		return
+	}
+	claims, err := ah.OIDC.Exchange(r.Context(), query.Get("code"), login)
+	if err != nil {
+		recordAudit(r.Context(), nil, audit.LoginFailed, "", "single sign-on: "+err.Error())
+	}
+	if handleError(w, err, http.StatusBadGateway, "error logging in with the identity provider") {
+		return
+	}
+	identity := users.ExternalIdentity{Issuer: claims.Issuer, Subject: claims.Subject}
+	user, err := ah.UserStore.ProvisionUser(identity, ah.OIDC.UserName(claims))
+	if handleError(w, err, http.StatusInternalServerError, "error provisioning user") {
+		return
+	}
+	logger.Info.Println("User logged in with single sign-on: ", user.UserName)
+	recordAudit(r.Context(), &user, audit.Login, "", "single sign-on")
+	setSessionCookies(w, user)
+	http.Redirect(w, r, "/tasks", http.StatusSeeOther)
+}
+
+// loginStateCookie follows middleware.Cookies, but Lax and only for the
+// login paths
+func loginStateCookie(value string, maxAge time.Duration) *http.Cookie {
+	cookie := middleware.Cookies.New(oidcLoginCookie, value, maxAge, true)
+	cookie.Path = oidcLoginPath
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie
+}
+
+func readLoginState(r *http.Request) (oidc.LoginState, bool) {
+	var login oidc.LoginState
+	cookie, err := r.Cookie(oidcLoginCookie)
+	if err != nil {
+		return login, false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || json.Unmarshal(value, &login) != nil || login.State == "" {
+		return login, false
+	}
+	return login, true
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/oidc"
+	"github.com/zhekagigs/golang_todo/oidc/oidctest"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func setupOIDC(t *testing.T) (*Router, *oidctest.Provider, *users.UserStore) {
+	mock := oidctest.NewProvider("todo", "secret")
+	t.Cleanup(mock.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     "todo",
+		ClientSecret: "secret",
+		RedirectURL:  "http://todo.test/login/oidc/callback",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected discovery to work, got %v", err)
+	}
+	userStore, _ := users.NewUserStore(filepath.Join(t.TempDir(), "users.json"))
+	userStore.Register("local", "hoppy-ipa")
+	authHandler := NewAuthHandler(userStore)
+	authHandler.OIDC = provider
+	router := NewRouter()
+	authHandler.RegisterRoutes(router)
+	return router, mock, userStore
+}
+
+// startOIDCLogin follows the redirects to the provider and back, it returns
+// the callback request and the login state cookie
+func startOIDCLogin(t *testing.T, router http.Handler) (*http.Request, *http.Cookie) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/login/oidc", nil))
+	if rr.Code != http.StatusFound || len(rr.Result().Cookies()) != 1 {
+		t.Fatalf("Expected redirect with login state, got %v", rr.Code)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected the provider to redirect back, got %v", err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	return httptest.NewRequest("GET", callback.RequestURI(), nil), rr.Result().Cookies()[0]
+}
+
+func TestOIDCLogin(t *testing.T) {
+	router, mock, userStore := setupOIDC(t)
+	mock.SetClaims(map[string]any{"sub": "248289761001", "preferred_username": "jane"})
+
+	req, loginCookie := startOIDCLogin(t, router)
+	req.AddCookie(loginCookie)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/tasks" {
+		t.Fatalf("Expected redirect to the tasks, got %v: %s", rr.Code, rr.Body.String())
+	}
+	jane, ok := userStore.GetUser("jane")
+	if !ok || jane.Role != users.DefaultRole {
+		t.Fatalf("Expected jane provisioned as %s, got %v", users.DefaultRole, jane)
+	}
+	cookie := sessionCookie(rr)
+	if cookie == nil {
+		t.Fatal("Expected a session cookie")
+	}
+	if userId, err := middleware.Sessions.Verify(cookie.Value); err != nil || userId != jane.UserId.String() {
+		t.Errorf("Expected a session of jane, got %s %v", userId, err)
+	}
+
+	// a renamed user at the provider keeps the account of the subject
+	mock.SetClaims(map[string]any{"sub": "248289761001", "preferred_username": "jane.doe"})
+	req, loginCookie = startOIDCLogin(t, router)
+	req.AddCookie(loginCookie)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if userId, _ := middleware.Sessions.Verify(sessionCookie(rr).Value); userId != jane.UserId.String() {
+		t.Errorf("Expected the second login as jane, got %s", userId)
+	}
+	if len(userStore.ListUsers()) != 2 {
+		t.Errorf("Expected no new user, got %v", userStore.ListUsers())
+	}
+}
+
+func TestOIDCLoginErrors(t *testing.T) {
+	router, mock, _ := setupOIDC(t)
+
+	tests := []struct {
+		name       string
+		claims     map[string]any
+		modify     func(req *http.Request, cookie *http.Cookie) *http.Request
+		wantStatus int
+	}{
+		{
+			name:       "missing login state",
+			modify:     func(req *http.Request, cookie *http.Cookie) *http.Request { return req },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "state of another login",
+			modify: func(req *http.Request, cookie *http.Cookie) *http.Request {
+				_, other := startOIDCLogin(t, router)
+				req.AddCookie(other)
+				return req
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "refused by the provider",
+			modify: func(req *http.Request, cookie *http.Cookie) *http.Request {
+				query := req.URL.Query()
+				query.Set("error", "access_denied")
+				query.Del("code")
+				refused := httptest.NewRequest("GET", "/login/oidc/callback?"+query.Encode(), nil)
+				refused.AddCookie(cookie)
+				return refused
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong code",
+			modify: func(req *http.Request, cookie *http.Cookie) *http.Request {
+				query := req.URL.Query()
+				query.Set("code", "forged")
+				forged := httptest.NewRequest("GET", "/login/oidc/callback?"+query.Encode(), nil)
+				forged.AddCookie(cookie)
+				return forged
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:   "name of a local user",
+			claims: map[string]any{"sub": "2", "preferred_username": "local"},
+			modify: func(req *http.Request, cookie *http.Cookie) *http.Request {
+				req.AddCookie(cookie)
+				return req
+			},
+			wantStatus: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.SetClaims(map[string]any{"sub": "1", "preferred_username": "jane"})
+			if tt.claims != nil {
+				mock.SetClaims(tt.claims)
+			}
+			req, cookie := startOIDCLogin(t, router)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, tt.modify(req, cookie))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if sessionCookie(rr) != nil {
+				t.Error("Expected no session")
+			}
+		})
+	}
+}
+
+func TestOIDCNotConfigured(t *testing.T) {
+	router := setupAuth(t)
+	for _, path := range []string{"/login/oidc", "/login/oidc/callback?code=c&state=s"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v for %s, got %v", http.StatusNotFound, path, rr.Code)
+		}
+	}
+}
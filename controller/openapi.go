@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	apiVersion     = "1.0.0"
+	problemType    = "application/problem+json"
+)
+
+var (
+	pathParamPattern = regexp.MustCompile(`\{(\w+)(\.\.\.)?\}`)
+	timeType         = reflect.TypeOf(time.Time{})
+	customTimeType   = reflect.TypeOf(internal.CustomTime{})
+	marshalerType    = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// ServeOpenAPI writes the OpenAPI 3 document of all documented routes
+func (rt *Router) ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, rt.OpenAPI())
+}
+
+// OpenAPI builds the document from the registered routes and their Go types
+func (rt *Router) OpenAPI() map[string]any {
+	schemas := newSchemaBuilder()
+	problem := schemas.schema(reflect.TypeOf(Problem{}))
+	paths := map[string]any{}
+
+	for _, route := range rt.routes {
+		if route.Doc == nil {
+			continue
+		}
+		item, ok := paths[route.Path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation(route, schemas, problem)
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "Microbrewery Tasks API",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]any{
+				"session":  map[string]any{"type": "apiKey", "in": "header", "name": "Authorization"},
+				"apiToken": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func operation(route Route, schemas *schemaBuilder, problem map[string]any) map[string]any {
+	doc := route.Doc
+	op := map[string]any{"summary": doc.Summary}
+	if doc.Tag != "" {
+		op["tags"] = []string{doc.Tag}
+	}
+	if params := parameters(route); len(params) > 0 {
+		op["parameters"] = params
+	}
+	if doc.Request != nil {
+		requestTypes := doc.RequestTypes
+		if len(requestTypes) == 0 {
+			requestTypes = []string{"application/json"}
+		}
+		content := map[string]any{}
+		for _, mediaType := range requestTypes {
+			content[mediaType] = map[string]any{"schema": schemas.schema(reflect.TypeOf(doc.Request))}
+		}
+		op["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if doc.Response != nil {
+		responseType := doc.ResponseType
+		if responseType == "" {
+			responseType = "application/json"
+		}
+		schema := map[string]any{"type": "string"}
+		if responseType == "application/json" {
+			schema = schemas.schema(reflect.TypeOf(doc.Response))
+		}
+		success["content"] = map[string]any{responseType: map[string]any{"schema": schema}}
+	}
+	op["responses"] = map[string]any{
+		strconv.Itoa(status): success,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{problemType: map[string]any{"schema": problem}},
+		},
+	}
+	if doc.Deprecated {
+		op["deprecated"] = true
+	}
+	if route.Auth {
+		op["security"] = []map[string][]string{{"session": {}}, {"apiToken": {}}}
+	}
+	return op
+}
+
+func parameters(route Route) []map[string]any {
+	documented := map[string]Param{}
+	for _, param := range route.Doc.Params {
+		documented[param.In+" "+param.Name] = param
+	}
+
+	var params []map[string]any
+	for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+		param, ok := documented["path "+match[1]]
+		if !ok {
+			param = Param{Name: match[1], In: "path", Type: "integer"}
+		}
+		params = append(params, parameter(param, true))
+	}
+	for _, param := range route.Doc.Params {
+		if param.In == "query" {
+			params = append(params, parameter(param, false))
+		}
+	}
+	return params
+}
+
+func parameter(param Param, required bool) map[string]any {
+	paramType := param.Type
+	if paramType == "" {
+		paramType = "string"
+	}
+	result := map[string]any{
+		"name":     param.Name,
+		"in":       param.In,
+		"required": required,
+		"schema":   map[string]any{"type": paramType},
+	}
+	if param.Description != "" {
+		result["description"] = param.Description
+	}
+	return result
+}
+
+// schemaBuilder turns Go types into JSON schemas, named structs become
+// components referenced by $ref.
+type schemaBuilder struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType || t == customTimeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(textMarshalType) || reflect.PointerTo(t).Implements(textMarshalType):
+		return map[string]any{"type": "string"}
+	case t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		return b.ref(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+func (b *schemaBuilder) ref(t reflect.Type) map[string]any {
+	name, ok := b.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := b.schemas[name]; taken {
+			name = strings.ReplaceAll(t.String(), ".", "_")
+		}
+		b.names[t] = name
+		b.schemas[name] = map[string]any{} // placeholder for recursive types
+		b.schemas[name] = b.object(t)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	b.addFields(t, properties)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// addFields follows encoding/json naming, embedded structs are flattened
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+	}
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+)
+
+func TestOpenAPI(t *testing.T) {
+	router := NewRouter()
+	var api *ApiService
+	var batchApi *BatchApiService
+	api.RegisterRoutes(router)
+	batchApi.RegisterRoutes(router)
+	router.Handle("GET /api/openapi.json", router.ServeOpenAPI, &RouteDoc{Summary: "OpenAPI document", Response: map[string]any{}})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", rr.Code)
+	}
+	var spec struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&spec); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if spec.OpenAPI != openAPIVersion {
+		t.Errorf("Expected openapi %s, got %s", openAPIVersion, spec.OpenAPI)
+	}
+
+	tests := []struct {
+		name   string
+		schema string
+		field  string
+		want   map[string]any
+	}{
+		{"time", "Task", "PlannedAt", map[string]any{"type": "string", "format": "date-time"}},
+		{"nested struct", "Task", "CreatedBy", map[string]any{"$ref": "#/components/schemas/User"}},
+		{"slice", "Task", "Tags", map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+		{"uuid", "User", "userId", map[string]any{"type": "string"}},
+		{"custom time", "TaskOptional", "plannedAt", map[string]any{"type": "string", "format": "date-time"}},
+		{"embedded struct", "batchResponse", "brewDate", map[string]any{"type": "string", "format": "date-time"}},
+		{"embedded struct own field", "batchResponse", "tasks", map[string]any{"type": "array", "items": map[string]any{"$ref": "#/components/schemas/Task"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(spec.Components.Schemas[tt.schema].Properties[tt.field])
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("Expected %s.%s schema %s, got %s", tt.schema, tt.field, want, got)
+			}
+		})
+	}
+
+	patch := spec.Paths["/api/tasks/{id}"]["patch"]
+	content := patch["requestBody"].(map[string]any)["content"].(map[string]any)
+	if _, ok := content[string(internal.MergePatch)]; !ok || patch["security"] == nil {
+		t.Errorf("Expected merge patch body and security on PATCH, got %v", patch)
+	}
+	params, _ := json.Marshal(spec.Paths["/api/tasks/{id}/checklist/{item}"]["put"]["parameters"])
+	if string(params) != `[{"in":"path","name":"id","required":true,"schema":{"type":"integer"}},{"in":"path","name":"item","required":true,"schema":{"type":"integer"}}]` {
+		t.Errorf("Unexpected path parameters %s", params)
+	}
+	if _, ok := spec.Paths["/api/tasks"]["get"]["security"]; ok {
+		t.Errorf("Expected no security on public GET /api/tasks")
+	}
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type QualityApiService struct {
+	qualityLog *internal.QualityLog
+	tasks      *internal.TaskHolder
+	userStore  *users.UserStore
+}
+
+type completeTaskRequest struct {
+	Style        string                      `json:"style"`
+	Measurements []internal.MeasurementInput `json:"measurements"`
+}
+
+func NewQualityApiService(qualityLog *internal.QualityLog, tasks *internal.TaskHolder, userStore *users.UserStore) *QualityApiService {
+	return &QualityApiService{
+		qualityLog: qualityLog,
+		tasks:      tasks,
+		userStore:  userStore,
+	}
+}
+
+func (api *QualityApiService) RegisterRoutes(router *Router) {
+	router.HandleAuth("POST /api/tasks/{id}/complete", api.CompleteTask, &RouteDoc{
+		Summary: "Complete quality task with measurements", Tag: "quality", Request: completeTaskRequest{}, Status: http.StatusCreated, Response: []internal.Measurement{}})
+	router.Handle("GET /api/tasks/{id}/measurements", api.GetTaskMeasurements, &RouteDoc{
+		Summary: "List task measurements", Tag: "quality", Response: []internal.Measurement{}})
+	router.Handle("GET /api/qc/specs", api.GetSpecs, &RouteDoc{
+		Summary: "List style specs", Tag: "quality", Response: internal.StyleSpecs})
+	router.Handle("GET /api/qc/measurements", api.GetAllMeasurements, &RouteDoc{
+		Summary: "List measurements", Tag: "quality", Response: []internal.Measurement{}})
+	router.Handle("GET /api/qc/measurements.csv", api.ExportMeasurements, &RouteDoc{
+		Summary: "Export measurements as CSV", Tag: "quality", Response: "", ResponseType: "text/csv"})
+}
+
+func (api *QualityApiService) GetSpecs(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, internal.StyleSpecs)
+}
+
+func (api *QualityApiService) GetAllMeasurements(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, api.qualityLog.Read())
+}
+
+func (api *QualityApiService) ExportMeasurements(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="qc_measurements.csv"`)
+	if err := api.qualityLog.WriteCSV(w); err != nil {
+		logger.Error.Printf("error writing measurements csv: %v", err)
+	}
+}
+
+func (api *QualityApiService) GetTaskMeasurements(w http.ResponseWriter, r *http.Request) {
+	taskId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
+		return
+	}
+	writeJson(w, http.StatusOK, api.qualityLog.TaskMeasurements(taskId))
+}
+
+// CompleteTask finishes a Quality task with its measurements
+func (api *QualityApiService) CompleteTask(w http.ResponseWriter, r *http.Request) {
+	taskId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
+		return
+	}
+	task, err := api.tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.EditTask, task)
+	if !ok {
+		return
+	}
+	var request completeTaskRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	measurements, err := api.qualityLog.CompleteTask(taskId, request.Style, request.Measurements, user)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	recordAudit(r.Context(), user, audit.TaskUpdated, taskTarget(taskId), "quality check completed")
+	writeJson(w, http.StatusCreated, measurements)
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func TestQualityApi(t *testing.T) {
+	taskHolder := internal.NewTaskHolder("")
+	qualityLog, _ := internal.NewQualityLog("", taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewQualityApiService(qualityLog, taskHolder, userStore)
+
+	router := http.NewServeMux()
+	router.HandleFunc("POST /api/tasks/{id}/complete", middleware.AuthMiddleware(api.CompleteTask))
+	router.HandleFunc("GET /api/tasks/{id}/measurements", api.GetTaskMeasurements)
+	router.HandleFunc("GET /api/qc/measurements.csv", api.ExportMeasurements)
+
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Check pH"), Category: internal.CategoryPtr(internal.Quality)})
+	rr := doApiRequest(router, "POST", "/api/tasks/1/complete", completeTaskRequest{
+		Style:        "ipa",
+		Measurements: []internal.MeasurementInput{{Kind: internal.PH, Value: 5.1}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var measurements []internal.Measurement
+	json.NewDecoder(rr.Body).Decode(&measurements)
+	if len(measurements) != 1 || measurements[0].InSpec || measurements[0].RecordedBy.UserName != "AAA" {
+		t.Errorf("Unexpected measurements %v", measurements)
+	}
+
+	rr = doApiRequest(router, "POST", "/api/tasks/1/complete", completeTaskRequest{
+		Measurements: []internal.MeasurementInput{{Kind: internal.PH, Value: 4.2}},
+	})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status Conflict for done task, got %v", rr.Code)
+	}
+
+	rr = doApiRequest(router, "GET", "/api/tasks/1/measurements", nil)
+	json.NewDecoder(rr.Body).Decode(&measurements)
+	if len(measurements) != 1 {
+		t.Errorf("Expected one measurement, got %v", measurements)
+	}
+
+	rr = doApiRequest(router, "GET", "/api/qc/measurements.csv", nil)
+	if rr.Header().Get("Content-Type") != "text/csv" || !strings.HasPrefix(rr.Body.String(), "id,task_id,") {
+		t.Errorf("Unexpected csv export %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/zhekagigs/golang_todo/middleware"
+)
+
+// Param documents a path or query parameter, path parameters missing from
+// RouteDoc.Params are documented as integer ids.
+type Param struct {
+	Name        string
+	In          string // path or query
+	Type        string // OpenAPI type, default string for query and integer for path
+	Description string
+}
+
+// RouteDoc describes a route for the OpenAPI document. Request and Response
+// are zero values of the Go types sent and returned; their schemas are
+// generated from the types, so they stay in sync with the handlers.
+type RouteDoc struct {
+	Summary      string
+	Tag          string
+	Params       []Param
+	Request      any
+	RequestTypes []string // default application/json
+	Status       int      // success status, default 200
+	Response     any
+	ResponseType string // default application/json
+	Deprecated   bool   // responses get Deprecation header and link to the /api/v1 successor
+}
+
+type Route struct {
+	Method string
+	Path   string
+	Auth   bool
+	Doc    *RouteDoc // nil for routes left out of the spec, e.g. html views
+}
+
+// Router is http.ServeMux that remembers routes with their docs
+type Router struct {
+	mux    *http.ServeMux
+	routes []Route
+	guard  func(http.HandlerFunc) http.HandlerFunc
+}
+
+func NewRouter() *Router {
+	return &Router{mux: http.NewServeMux()}
+}
+
+// Handle registers handler for pattern "METHOD /path"
+func (rt *Router) Handle(pattern string, handler http.HandlerFunc, doc *RouteDoc) {
+	rt.handle(pattern, rt.guarded(handler), false, doc)
+}
+
+// HandleAuth registers handler behind middleware.AuthMiddleware, requests
+// made with the session cookie need the CSRF token of the session
+func (rt *Router) HandleAuth(pattern string, handler http.HandlerFunc, doc *RouteDoc) {
+	rt.handle(pattern, middleware.AuthMiddleware(middleware.CSRFMiddleware(rt.guarded(handler))), true, doc)
+}
+
+// Guard wraps the handlers of the routes register adds with guard, inside
+// the auth middleware so guard sees the user. A nil guard wraps nothing.
+func (rt *Router) Guard(guard func(http.HandlerFunc) http.HandlerFunc, register func(*Router)) {
+	rt.guard = guard
+	defer func() { rt.guard = nil }()
+	register(rt)
+}
+
+func (rt *Router) guarded(handler http.HandlerFunc) http.HandlerFunc {
+	if rt.guard == nil {
+		return handler
+	}
+	return rt.guard(handler)
+}
+
+func (rt *Router) handle(pattern string, handler http.HandlerFunc, auth bool, doc *RouteDoc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	if doc != nil && doc.Deprecated {
+		handler = deprecated(handler)
+	}
+	rt.mux.HandleFunc(pattern, handler)
+	rt.routes = append(rt.routes, Route{Method: method, Path: path, Auth: auth, Doc: doc})
+}
+
+func (rt *Router) Routes() []Route {
+	return append([]Route(nil), rt.routes...)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
@@ -6,32 +6,26 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
 	"github.com/zhekagigs/golang_todo/internal"
 	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
 	"github.com/zhekagigs/golang_todo/view"
 )
 
 type TaskRenderHandler struct {
 	service  internal.TaskServiceInterface
 	renderer view.Renderer
-}
-
-func handleError(w http.ResponseWriter, err error, status int, message string) bool {
-	if err != nil {
-		if err == internal.ErrNotFound {
-			logger.Error.Printf("Task not found: %s", message)
-			http.Error(w, "Task not found", http.StatusNotFound)
-		} else {
-			logger.Error.Printf("%s: %v", message, err)
-			if message == "" {
-				message = err.Error()
-			}
-			http.Error(w, message, status)
-		}
-
-		return true
-	}
-	return false
+	// EditLocks, when set, rejects updates of tasks another user is editing
+	EditLocks *internal.EditLocks
+	// UserStore, when set, checks the role of the user before updates and
+	// deletes like the API does
+	UserStore *users.UserStore
+	// Workspaces, when set with UserStore, shows the tasks of the workspace
+	// of the Workspace cookie instead of service
+	Workspaces *internal.Workspaces
 }
 
 func getTaskIdFromQuery(r *http.Request) (int, error) {
@@ -42,26 +36,79 @@ func getTaskIdFromQuery(r *http.Request) (int, error) {
 	return strconv.Atoi(taskIDStr)
 }
 
+// tasks returns the task service of the active workspace of r
+func (h *TaskRenderHandler) tasks(w http.ResponseWriter, r *http.Request) (internal.TaskServiceInterface, bool) {
+	if h.Workspaces == nil || h.UserStore == nil {
+		return h.service, true
+	}
+	return workspaceTasks(w, r, h.Workspaces, h.UserStore)
+}
+
+// isDefaultWorkspace reports whether tasks are the default workspace, edit
+// locks are only kept there like the other collab features
+func (h *TaskRenderHandler) isDefaultWorkspace(tasks internal.TaskServiceInterface) bool {
+	if h.Workspaces == nil {
+		return true
+	}
+	defaultTasks, err := h.Workspaces.Get(internal.DefaultWorkspace)
+	return err == nil && tasks == internal.TaskServiceInterface(defaultTasks)
+}
+
+// authorize is like the API authorize for the pages, it allows everything
+// when no UserStore is set and then returns no user
+func (h *TaskRenderHandler) authorize(w http.ResponseWriter, r *http.Request, tasks internal.TaskServiceInterface, action authz.Action, taskID int) (*users.User, bool) {
+	if h.UserStore == nil {
+		return nil, true
+	}
+	task, err := tasks.FindTaskById(taskID)
+	if handleError(w, err, http.StatusNotFound, fmt.Sprintf("task %d not found", taskID)) {
+		return nil, false
+	}
+	return authorize(w, r, h.UserStore, action, task)
+}
+
 func NewTaskRenderHandler(service internal.TaskServiceInterface, renderer view.Renderer) *TaskRenderHandler {
 	return &TaskRenderHandler{service: service, renderer: renderer}
 }
 
+// RegisterRoutes adds the html views, they are not part of the API spec
+func (h *TaskRenderHandler) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /tasks/create", h.HandleTaskCreate, nil)
+	router.Handle("GET /tasks", middleware.OptionalAuthMiddleware(h.HandleTaskListRead), nil)
+	router.HandleAuth("DELETE /tasks", h.HandleTaskDelete, nil)
+	router.HandleAuth("GET /tasks/update", h.HandleTaskUpdate, nil)
+	router.HandleAuth("POST /tasks/update", h.HandleTaskUpdate, nil)
+	router.Handle("GET /", middleware.OptionalAuthMiddleware(h.HandleTaskListRead), nil)
+}
+
 func (h *TaskRenderHandler) HandleTaskListRead(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeProblem(w, NewProblem(http.StatusMethodNotAllowed, ""))
 		return
 	}
 
-	tasks := h.service.Read()
+	// anonymous visitors of private tasks get the page to log in without tasks
+	var tasks []internal.Task
+	liveUpdates := false
+	_, loggedIn := middleware.UserFromContext(r.Context())
+	if loggedIn || h.UserStore == nil || authz.PublicDefaultWorkspace {
+		service, ok := h.tasks(w, r)
+		if !ok {
+			return
+		}
+		tasks = service.Read()
+		// task events need a login and only follow the default workspace
+		liveUpdates = loggedIn && h.isDefaultWorkspace(service)
+	}
 
-	err := h.renderer.RenderTaskList(w, tasks)
+	err := h.renderer.RenderTaskList(w, tasks, middleware.CSRFToken(r), liveUpdates)
 	if handleError(w, err, http.StatusInternalServerError, "") {
 		return
 	}
 }
 
 func (h *TaskRenderHandler) HandleTaskCreate(w http.ResponseWriter, r *http.Request) {
-	err := h.renderer.RenderCreateForm(w)
+	err := h.renderer.RenderCreateForm(w, middleware.CSRFToken(r))
 	handleError(w, err, http.StatusInternalServerError, "")
 	return
 }
@@ -73,37 +120,54 @@ func (h *TaskRenderHandler) HandleTaskUpdate(w http.ResponseWriter, r *http.Requ
 	if handleError(w, err, http.StatusBadRequest, "Invalid task ID") {
 		return
 	}
+	tasks, ok := h.tasks(w, r)
+	if !ok {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
-		h.handleGetTaskUpdate(w, taskID)
+		h.handleGetTaskUpdate(w, r, tasks, taskID)
 	case http.MethodPost:
-		h.handlePostTaskUpdate(w, r, taskID)
+		h.handlePostTaskUpdate(w, r, tasks, taskID)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeProblem(w, NewProblem(http.StatusMethodNotAllowed, ""))
 	}
 }
 
-func (h *TaskRenderHandler) handleGetTaskUpdate(w http.ResponseWriter, taskID int) {
-	task, err := h.service.FindTaskById(taskID)
+func (h *TaskRenderHandler) handleGetTaskUpdate(w http.ResponseWriter, r *http.Request, tasks internal.TaskServiceInterface, taskID int) {
+	task, err := tasks.FindTaskById(taskID)
 	if handleError(w, err, http.StatusNotFound, "Task not found") {
 		return
 	}
 
-	err = h.renderer.RenderTaskUpdate(w, task)
+	err = h.renderer.RenderTaskUpdate(w, task, middleware.CSRFToken(r))
 	handleError(w, err, http.StatusInternalServerError, "Error rendering update form")
 }
 
-func (h *TaskRenderHandler) handlePostTaskUpdate(w http.ResponseWriter, r *http.Request, taskID int) {
+func (h *TaskRenderHandler) handlePostTaskUpdate(w http.ResponseWriter, r *http.Request, tasks internal.TaskServiceInterface, taskID int) {
+	user, ok := h.authorize(w, r, tasks, authz.EditTask, taskID)
+	if !ok {
+		return
+	}
+	if h.EditLocks != nil && h.isDefaultWorkspace(tasks) {
+		userId, _ := middleware.UserFromContext(r.Context())
+		err := h.EditLocks.CheckEdit(taskID, userId)
+		if handleError(w, err, http.StatusLocked, "Task is being edited") {
+			return
+		}
+	}
 	update, err := ExtractFormValues(r)
 	if handleError(w, err, http.StatusBadRequest, "Invalid form data") {
 		return
 	}
 
+	update.UpdatedBy = user
 	// logger.Info.Printf("Updating task with ID: %d", taskID)
-	err = h.service.PartialUpdateTask(taskID, update)
-	if handleError(w, err, http.StatusInternalServerError, "Failed to update task") {
+	err = tasks.PartialUpdateTask(taskID, update)
+	if handleError(w, err, http.StatusBadRequest, "Failed to update task") {
 		return
 	}
+	recordTask(r.Context(), user, audit.TaskUpdated, taskID)
 
 	// logger.Info.Printf("Successfully updated task with ID: %d", taskID)
 	http.Redirect(w, r, "/tasks", http.StatusSeeOther)
@@ -113,14 +177,26 @@ func (h *TaskRenderHandler) HandleTaskDelete(w http.ResponseWriter, r *http.Requ
 	// logger.Info.Printf("Handling %s request for task deletion from %s", r.Method, r.RemoteAddr)
 	if r.Method != http.MethodDelete {
 		logger.Error.Printf("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeProblem(w, NewProblem(http.StatusMethodNotAllowed, ""))
 		return
 	}
 	taskID, err := getTaskIdFromQuery(r)
-	err = h.service.DeleteTask(taskID)
-	if handleError(w, err, http.StatusInternalServerError, fmt.Sprint(taskID)) {
+	if handleError(w, err, http.StatusBadRequest, "Invalid task ID") {
+		return
+	}
+	tasks, ok := h.tasks(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.authorize(w, r, tasks, authz.DeleteTask, taskID)
+	if !ok {
+		return
+	}
+	err = tasks.DeleteTask(taskID)
+	if handleError(w, err, http.StatusNotFound, fmt.Sprintf("task %d not found", taskID)) {
 		return
 	}
+	recordTask(r.Context(), user, audit.TaskDeleted, taskID)
 
 	// logger.Info.Printf("Successfully deleted task with ID: %d", taskID)
 	w.WriteHeader(http.StatusOK)
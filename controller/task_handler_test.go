@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
 )
 
 // Mock TaskHolder
@@ -76,19 +77,23 @@ type mockRenderer struct {
 	renderTaskListCalled   bool
 	renderCreateFormCalled bool
 	renderTaskUpdateCalled bool
+	renderedTasks          []internal.Task
+	liveUpdates            bool
 }
 
-func (m *mockRenderer) RenderTaskList(w http.ResponseWriter, tasks []internal.Task) error {
+func (m *mockRenderer) RenderTaskList(w http.ResponseWriter, tasks []internal.Task, csrfToken string, liveUpdates bool) error {
 	m.renderTaskListCalled = true
+	m.renderedTasks = tasks
+	m.liveUpdates = liveUpdates
 	return nil
 }
 
-func (m *mockRenderer) RenderCreateForm(w http.ResponseWriter) error {
+func (m *mockRenderer) RenderCreateForm(w http.ResponseWriter, csrfToken string) error {
 	m.renderCreateFormCalled = true
 	return nil
 }
 
-func (m *mockRenderer) RenderTaskUpdate(w http.ResponseWriter, task *internal.Task) error {
+func (m *mockRenderer) RenderTaskUpdate(w http.ResponseWriter, task *internal.Task, csrfToken string) error {
 	m.renderTaskUpdateCalled = true
 	return nil
 }
@@ -120,6 +125,42 @@ func TestHandleTaskListRead(t *testing.T) {
 	}
 }
 
+func TestHandleTaskListReadAnonymous(t *testing.T) {
+	_, userStore, taskHolder := setupRoles(t)
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	mockRenderer := &mockRenderer{}
+	handler := NewTaskRenderHandler(taskService, mockRenderer)
+	handler.UserStore = userStore
+	handler.Workspaces = internal.NewWorkspaces(taskService)
+	router := NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/tasks", nil))
+	if rr.Code != http.StatusOK || !mockRenderer.renderTaskListCalled || len(mockRenderer.renderedTasks) != 0 || mockRenderer.liveUpdates {
+		t.Errorf("Expected the login page without tasks and events, got %v with %v", rr.Code, mockRenderer.renderedTasks)
+	}
+
+	req := httptest.NewRequest("GET", "/tasks", nil)
+	req.Header.Set("Authorization", roleToken(userStore, "CCC"))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if len(mockRenderer.renderedTasks) != 1 || !mockRenderer.liveUpdates {
+		t.Errorf("Expected the task of the member with live updates, got %v", mockRenderer.renderedTasks)
+	}
+
+	// task events only follow the default workspace
+	handler.Workspaces.Add("site-b", internal.NewTaskHolder(""))
+	req.Header.Set(WorkspaceHeader, "site-b")
+	viewer, _ := userStore.GetUser("CCC")
+	userStore.SetWorkspaces(viewer.UserId.String(), []string{"site-b"})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if mockRenderer.liveUpdates {
+		t.Error("Expected no live updates in site-b")
+	}
+}
+
 func TestHandleTaskCreate(t *testing.T) {
 	mockService := &mockTaskHolder{}
 	mockRenderer := &mockRenderer{}
@@ -198,6 +239,43 @@ func TestHandleTaskUpdate(t *testing.T) {
 	})
 }
 
+func TestHandleTaskUpdateLocked(t *testing.T) {
+	mockService := &mockTaskHolder{tasks: []internal.Task{{Id: 1, Msg: "Task 1"}}}
+	handler := NewTaskRenderHandler(mockService, &mockRenderer{})
+	handler.EditLocks = internal.NewEditLocks(time.Minute)
+	handler.EditLocks.Acquire(1, "anna-id", "Anna", 1)
+
+	tests := []struct {
+		name       string
+		userId     string
+		wantStatus int
+	}{
+		{"locked by another user", "bob-id", http.StatusLocked},
+		{"lock owner", "anna-id", http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"msg": {"Updated by " + tt.userId}, "category": {"0"}}
+			req, _ := http.NewRequest("POST", "/tasks/update?id=1", strings.NewReader(form.Encode()))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req = req.WithContext(middleware.ContextWithUser(req.Context(), tt.userId))
+
+			rr := httptest.NewRecorder()
+			handler.HandleTaskUpdate(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v", tt.wantStatus, rr.Code)
+			}
+		})
+	}
+
+	task, _ := mockService.FindTaskById(1)
+	if task.Msg != "Updated by anna-id" {
+		t.Errorf("Expected only the lock owner's update, got %q", task.Msg)
+	}
+}
+
 func TestHandleTaskDelete(t *testing.T) {
 	mockService := &mockTaskHolder{
 		tasks: []internal.Task{
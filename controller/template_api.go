@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+)
+
+type fromTemplateRequest struct {
+	TemplateId int                  `json:"templateId"`
+	Vars       map[string]string    `json:"vars"`
+	StartAt    *internal.CustomTime `json:"startAt"`
+}
+
+type checklistItemRequest struct {
+	Done bool `json:"done"`
+}
+
+func (api *ApiService) GetAllTemplates(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	templates := tasks.ReadTemplates()
+	writeJson(w, http.StatusOK, templates)
+}
+
+func (api *ApiService) GetTemplateById(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	templateId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing templateId") {
+		return
+	}
+	tmpl, err := tasks.FindTemplateById(templateId)
+	if handleError(w, err, http.StatusNotFound, "api: template not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, tmpl)
+}
+
+func (api *ApiService) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := authorize(w, r, api.userStore, authz.ManageTemplates, nil); !ok {
+		return
+	}
+	var tmplRequest internal.TaskTemplate
+	err := json.NewDecoder(r.Body).Decode(&tmplRequest)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	tmplRequest.Id = 0
+
+	tmpl, err := tasks.AddTemplate(tmplRequest)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	writeJson(w, http.StatusCreated, tmpl)
+}
+
+func (api *ApiService) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := authorize(w, r, api.userStore, authz.ManageTemplates, nil); !ok {
+		return
+	}
+	templateId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing templateId") {
+		return
+	}
+	err = tasks.DeleteTemplate(templateId)
+	if handleError(w, err, http.StatusNotFound, "api: template not found") {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (api *ApiService) CreateTaskFromTemplate(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.CreateTask, nil)
+	if !ok {
+		return
+	}
+
+	var request fromTemplateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	start := time.Now()
+	if request.StartAt != nil {
+		start = request.StartAt.Time
+	}
+
+	task, err := tasks.CreateTaskFromTemplate(request.TemplateId, request.Vars, start, user)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	recordTask(r.Context(), user, audit.TaskCreated, task.Id)
+	writeJson(w, http.StatusCreated, task)
+}
+
+func (api *ApiService) UpdateChecklistItem(w http.ResponseWriter, r *http.Request) {
+	tasks, ok := api.tasks(w, r)
+	if !ok {
+		return
+	}
+	taskId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing taskId") {
+		return
+	}
+	itemId, err := strconv.Atoi(r.PathValue("item"))
+	if handleError(w, err, http.StatusBadRequest, "api: error processing checklist item id") {
+		return
+	}
+	var request checklistItemRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	current, err := tasks.GetTask(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
+		return
+	}
+	user, ok := authorize(w, r, api.userStore, authz.EditTask, current)
+	if !ok {
+		return
+	}
+	err = tasks.SetChecklistItem(taskId, itemId, request.Done)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	recordAudit(r.Context(), user, audit.TaskUpdated, taskTarget(taskId), fmt.Sprintf("checklist item %d done %v", itemId, request.Done))
+	task, err := tasks.FindTaskById(taskId)
+	if handleError(w, err, http.StatusNotFound, "api: task not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, task)
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func setupTemplateApi(t *testing.T) (*http.ServeMux, *internal.TaskHolder) {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	api := NewApiService(taskService, userStore)
+	t.Cleanup(taskService.CloseAll)
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /api/templates", middleware.OptionalAuthMiddleware(api.GetAllTemplates))
+	router.HandleFunc("POST /api/templates", middleware.AuthMiddleware(api.CreateTemplate))
+	router.HandleFunc("POST /api/tasks/from-template", middleware.AuthMiddleware(api.CreateTaskFromTemplate))
+	router.HandleFunc("PUT /api/tasks/{id}/checklist/{item}", middleware.AuthMiddleware(api.UpdateChecklistItem))
+	return router, taskHolder
+}
+
+func doApiRequest(router http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", MOCK_TOKEN)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTemplateApi(t *testing.T) {
+	router, taskHolder := setupTemplateApi(t)
+
+	rr := doApiRequest(router, "POST", "/api/templates", internal.TaskTemplate{
+		Name:        "Brew day",
+		Msg:         "Brew {{beer}}",
+		Category:    internal.Brewing,
+		OffsetHours: 24,
+		Checklist:   []string{"Mash", "Boil"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var tmpl internal.TaskTemplate
+	json.NewDecoder(rr.Body).Decode(&tmpl)
+
+	t.Run("List templates", func(t *testing.T) {
+		rr := doApiRequest(router, "GET", "/api/templates", nil)
+		var templates []internal.TaskTemplate
+		json.NewDecoder(rr.Body).Decode(&templates)
+		if len(templates) != 1 || templates[0].Name != "Brew day" {
+			t.Errorf("Unexpected templates %v", templates)
+		}
+	})
+
+	t.Run("Invalid template", func(t *testing.T) {
+		rr := doApiRequest(router, "POST", "/api/templates", internal.TaskTemplate{Name: "Broken"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest, got %v", rr.Code)
+		}
+	})
+
+	t.Run("Create task from template", func(t *testing.T) {
+		start := internal.CustomTime{Time: time.Now().Add(time.Hour).Truncate(time.Second)}
+		rr := doApiRequest(router, "POST", "/api/tasks/from-template", fromTemplateRequest{
+			TemplateId: tmpl.Id,
+			Vars:       map[string]string{"beer": "Porter"},
+			StartAt:    &start,
+		})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+		}
+		var task internal.Task
+		json.NewDecoder(rr.Body).Decode(&task)
+		if task.Msg != "Brew Porter" {
+			t.Errorf("Expected msg %q, got %q", "Brew Porter", task.Msg)
+		}
+		if task.CreatedBy.UserName != "AAA" {
+			t.Errorf("Expected created by AAA, got %s", task.CreatedBy.UserName)
+		}
+		if !task.PlannedAt.Equal(start.Time.Add(24 * time.Hour)) {
+			t.Errorf("Expected plannedAt %v, got %v", start.Time.Add(24*time.Hour), task.PlannedAt)
+		}
+
+		rr = doApiRequest(router, "PUT", "/api/tasks/1/checklist/2", checklistItemRequest{Done: true})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v: %s", rr.Code, rr.Body.String())
+		}
+		updated, _ := taskHolder.FindTaskById(task.Id)
+		if !updated.Checklist[1].Done {
+			t.Errorf("Expected checklist item 2 to be done")
+		}
+	})
+
+	t.Run("Missing placeholder", func(t *testing.T) {
+		rr := doApiRequest(router, "POST", "/api/tasks/from-template", fromTemplateRequest{TemplateId: tmpl.Id})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest, got %v", rr.Code)
+		}
+	})
+
+	t.Run("Unknown template", func(t *testing.T) {
+		rr := doApiRequest(router, "POST", "/api/tasks/from-template", fromTemplateRequest{TemplateId: 42})
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status NotFound, got %v", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// TokenApiService lets users manage their personal API tokens. Tokens are
+// managed with a login session only, so a leaked token can't mint others.
+type TokenApiService struct {
+	tokens    *users.APITokenStore
+	userStore *users.UserStore
+}
+
+type createTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays,omitempty"`
+}
+
+// createdTokenResponse has the only copy of the token secret
+type createdTokenResponse struct {
+	users.APIToken
+	Token string `json:"token"`
+}
+
+func NewTokenApiService(tokens *users.APITokenStore, userStore *users.UserStore) *TokenApiService {
+	return &TokenApiService{tokens: tokens, userStore: userStore}
+}
+
+func (api *TokenApiService) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /api/tokens", api.GetTokens, &RouteDoc{
+		Summary: "List API tokens of the user", Tag: "tokens", Response: []users.APIToken{}})
+	router.HandleAuth("POST /api/tokens", api.CreateToken, &RouteDoc{
+		Summary: "Create API token with read or write scopes, the token is only returned here", Tag: "tokens", Request: createTokenRequest{}, Status: http.StatusCreated, Response: createdTokenResponse{}})
+	router.HandleAuth("DELETE /api/tokens/{id}", api.RevokeToken, &RouteDoc{
+		Summary: "Revoke API token", Tag: "tokens", Status: http.StatusNoContent})
+}
+
+// sessionUser is currentUser for token management, API tokens are refused
+func (api *TokenApiService) sessionUser(w http.ResponseWriter, r *http.Request) (*users.User, bool) {
+	if _, isToken := middleware.ScopesFromContext(r.Context()); isToken {
+		writeProblem(w, NewProblem(http.StatusForbidden, "api tokens can't manage tokens, log in instead"))
+		return nil, false
+	}
+	user, ok := currentUser(r, api.userStore)
+	if !ok {
+		writeUnknownUser(w)
+		return nil, false
+	}
+	return user, true
+}
+
+func (api *TokenApiService) GetTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, api.tokens.List(user.UserId.String()))
+}
+
+func (api *TokenApiService) CreateToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	var tokenRequest createTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&tokenRequest)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	token, secret, err := api.tokens.Create(user.UserId.String(), tokenRequest.Name, tokenRequest.Scopes, tokenRequest.ExpiresInDays)
+	if handleError(w, err, http.StatusInternalServerError, "api: error creating token") {
+		return
+	}
+	w.Header().Set("Location", "/api/tokens/"+strconv.Itoa(token.Id))
+	writeJson(w, http.StatusCreated, createdTokenResponse{APIToken: *token, Token: secret})
+}
+
+func (api *TokenApiService) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	tokenId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing tokenId") {
+		return
+	}
+	err = api.tokens.Revoke(user.UserId.String(), tokenId)
+	if handleError(w, err, http.StatusNotFound, "api: token not found") {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func setupTokenApi(t *testing.T) *Router {
+	taskHolder := internal.NewTaskHolder("")
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+
+	tokens, _ := users.NewAPITokenStore("")
+	apiTokens := middleware.APITokens
+	middleware.APITokens = tokens
+	t.Cleanup(func() { middleware.APITokens = apiTokens })
+
+	router := NewRouter()
+	NewApiService(taskService, userStore).RegisterRoutes(router)
+	NewTokenApiService(tokens, userStore).RegisterRoutes(router)
+	router.HandleAuth("GET /api/me", func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := middleware.UserFromContext(r.Context())
+		w.Write([]byte(userId))
+	}, nil)
+	return router
+}
+
+func doTokenRequest(router http.Handler, method, path, authorization string, body any) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func createToken(t *testing.T, router http.Handler, scopes ...string) createdTokenResponse {
+	rr := doApiRequest(router, "POST", "/api/tokens", createTokenRequest{Name: "sensor", Scopes: scopes})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var created createdTokenResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	return created
+}
+
+func TestApiTokenScopes(t *testing.T) {
+	router := setupTokenApi(t)
+	readToken := createToken(t, router, users.ScopeRead)
+	writeToken := createToken(t, router, users.ScopeRead, users.ScopeWrite)
+	task := internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		auth       string
+		wantStatus int
+	}{
+		{"read token reads", "GET", "/api/me", "Bearer " + readToken.Token, http.StatusOK},
+		{"read token can't create", "POST", "/api/tasks", "Bearer " + readToken.Token, http.StatusForbidden},
+		{"write token creates", "POST", "/api/tasks", "Bearer " + writeToken.Token, http.StatusCreated},
+		// the secret is not a session token
+		{"token without bearer", "GET", "/api/me", writeToken.Token, http.StatusUnauthorized},
+		{"token can't list tokens", "GET", "/api/tokens", "Bearer " + writeToken.Token, http.StatusForbidden},
+		{"token can't create tokens", "POST", "/api/tokens", "Bearer " + writeToken.Token, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(router, tt.method, tt.path, tt.auth, task)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestApiTokenListAndRevoke(t *testing.T) {
+	router := setupTokenApi(t)
+	created := createToken(t, router, users.ScopeRead)
+	if rr := doTokenRequest(router, "GET", "/api/me", "Bearer "+created.Token, nil); rr.Body.String() != created.UserId {
+		t.Fatalf("Expected token of user, got %s", rr.Body.String())
+	}
+
+	rr := doApiRequest(router, "GET", "/api/tokens", nil)
+	var list []map[string]any
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list) != 1 || list[0]["lastUsedAt"] == nil || list[0]["token"] != nil || list[0]["hash"] != nil {
+		t.Fatalf("Expected token with last use and no secret, got %v", list)
+	}
+
+	if rr := doApiRequest(router, "DELETE", "/api/tokens/42", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %v", rr.Code)
+	}
+	if rr := doApiRequest(router, "DELETE", "/api/tokens/1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doTokenRequest(router, "GET", "/api/me", "Bearer "+created.Token, nil); rr.Body.String() == created.UserId {
+		t.Error("Expected revoked token rejected")
+	}
+}
+
+func TestCreateApiTokenInvalid(t *testing.T) {
+	router := setupTokenApi(t)
+	rr := doApiRequest(router, "POST", "/api/tokens", createTokenRequest{Name: "sensor", Scopes: []string{"admin"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
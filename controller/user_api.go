@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+	"github.com/zhekagigs/golang_todo/view"
+)
+
+// UserApiService lets admins manage users, their roles and workspaces
+type UserApiService struct {
+	userStore *users.UserStore
+	// Workspaces users may be added to, without it only the default workspace
+	Workspaces *internal.Workspaces
+	// Pages renders the admin pages, without it /admin/users is not served
+	Pages view.UserRenderer
+}
+
+type createUserRequest struct {
+	UserName string        `json:"userName"`
+	Password string        `json:"password,omitempty"`
+	Role     users.Role    `json:"role,omitempty"`
+	Profile  users.Profile `json:"profile"`
+}
+
+// updateUserRequest changes the fields which are set, an empty password
+// removes the password
+type updateUserRequest struct {
+	UserName *string        `json:"userName,omitempty"`
+	Profile  *users.Profile `json:"profile,omitempty"`
+	Password *string        `json:"password,omitempty"`
+}
+
+type activeRequest struct {
+	Active bool `json:"active"`
+}
+
+type roleRequest struct {
+	Role users.Role `json:"role"`
+}
+
+type workspacesRequest struct {
+	Workspaces []string `json:"workspaces"`
+}
+
+// userResponse is an account with its effective role and workspaces
+type userResponse struct {
+	users.Account
+	Workspaces []string `json:"workspaces"`
+}
+
+func newUserResponse(account users.Account) userResponse {
+	account.Role = account.EffectiveRole()
+	return userResponse{Account: account, Workspaces: effectiveWorkspaces(account.Workspaces)}
+}
+
+func NewUserApiService(userStore *users.UserStore) *UserApiService {
+	return &UserApiService{userStore: userStore}
+}
+
+func (api *UserApiService) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /api/users", api.GetUsers, &RouteDoc{
+		Summary: "List users with their roles, profiles and workspaces, admins only", Tag: "users", Response: []userResponse{}})
+	router.HandleAuth("POST /api/users", api.CreateUser, &RouteDoc{
+		Summary: "Create a user, admins only", Tag: "users", Request: createUserRequest{}, Response: userResponse{}})
+	router.HandleAuth("GET /api/users/{id}", api.GetUser, &RouteDoc{
+		Summary: "Get a user, admins only", Tag: "users", Response: userResponse{}})
+	router.HandleAuth("PUT /api/users/{id}", api.UpdateUser, &RouteDoc{
+		Summary: "Rename a user or change its profile or password, admins only", Tag: "users", Request: updateUserRequest{}, Response: userResponse{}})
+	router.HandleAuth("DELETE /api/users/{id}", api.DeleteUser, &RouteDoc{
+		Summary: "Delete a user, tasks keep it as creator, admins only", Tag: "users"})
+	router.HandleAuth("PUT /api/users/{id}/active", api.SetActive, &RouteDoc{
+		Summary: "Deactivate or reactivate a user, admins only", Tag: "users", Request: activeRequest{}, Response: userResponse{}})
+	router.HandleAuth("PUT /api/users/{id}/role", api.SetRole, &RouteDoc{
+		Summary: "Change the role of a user, admins only", Tag: "users", Request: roleRequest{}, Response: users.User{}})
+	router.HandleAuth("PUT /api/users/{id}/workspaces", api.SetWorkspaces, &RouteDoc{
+		Summary: "Replace the workspaces of a user, admins only", Tag: "users", Request: workspacesRequest{}, Response: workspacesRequest{}})
+	router.HandleAuth("GET /api/workspaces", api.GetWorkspaces, &RouteDoc{
+		Summary: "List the workspaces of the user, the X-Workspace header selects one", Tag: "users", Response: workspacesRequest{}})
+	router.HandleAuth("GET /admin/users", api.HandleUserList, nil)
+	router.HandleAuth("GET /admin/users/{id}", api.HandleUserEdit, nil)
+}
+
+// effectiveWorkspaces lists membership with the default workspace for users
+// without workspaces
+func effectiveWorkspaces(membership users.Membership) []string {
+	if len(membership) == 0 {
+		return []string{users.DefaultWorkspace}
+	}
+	return membership
+}
+
+func (api *UserApiService) GetUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil); !ok {
+		return
+	}
+	accounts := api.userStore.ListAccounts()
+	response := make([]userResponse, len(accounts))
+	for i, account := range accounts {
+		response[i] = newUserResponse(account)
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func (api *UserApiService) CreateUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil)
+	if !ok {
+		return
+	}
+	var request createUserRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	account, err := api.userStore.CreateUser(request.UserName, request.Password, request.Role, request.Profile)
+	if handleError(w, err, http.StatusInternalServerError, "api: error creating user") {
+		return
+	}
+	logger.Info.Printf("User %s created user %s as %s", admin.UserName, account.UserName, account.Role)
+	writeJson(w, http.StatusCreated, newUserResponse(account))
+}
+
+func (api *UserApiService) GetUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil); !ok {
+		return
+	}
+	account, found := api.userStore.Account(r.PathValue("id"))
+	if !found {
+		handleError(w, users.ErrUserNotFound, http.StatusNotFound, "")
+		return
+	}
+	writeJson(w, http.StatusOK, newUserResponse(account))
+}
+
+func (api *UserApiService) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil)
+	if !ok {
+		return
+	}
+	var request updateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	before, found := api.userStore.Account(r.PathValue("id"))
+	if !found {
+		handleError(w, users.ErrUserNotFound, http.StatusNotFound, "")
+		return
+	}
+	account, err := api.userStore.UpdateUser(r.PathValue("id"), users.UserUpdate{
+		UserName: request.UserName,
+		Profile:  request.Profile,
+		Password: request.Password,
+	})
+	if handleError(w, err, http.StatusInternalServerError, "api: error updating user") {
+		return
+	}
+	if account.UserName != before.UserName {
+		logger.Info.Printf("User %s renamed user %s to %s", admin.UserName, before.UserName, account.UserName)
+		api.reassign(r.Context(), admin, account.UserId.String(), account.UserName)
+	} else {
+		logger.Info.Printf("User %s updated user %s", admin.UserName, account.UserName)
+	}
+	writeJson(w, http.StatusOK, newUserResponse(account))
+}
+
+// reassign sets the assignee of the tasks assigned to user userId in every
+// workspace, the new name after a rename and none after a deletion. Tasks keep
+// the name they were created with in CreatedBy. Each change is audited for admin.
+func (api *UserApiService) reassign(ctx context.Context, admin *users.User, userId, assignee string) {
+	workspaces := api.Workspaces
+	if workspaces == nil {
+		return
+	}
+	for _, name := range workspaces.Names() {
+		tasks, err := workspaces.Get(name)
+		if err != nil {
+			continue
+		}
+		for _, task := range tasks.Read() {
+			if task.AssigneeId != userId {
+				continue
+			}
+			err := tasks.PartialUpdateTask(task.Id, &internal.TaskOptional{Assignee: &assignee, UpdatedBy: admin})
+			if err != nil {
+				logger.Error.Printf("api: error reassigning task %d in %s to %q: %v", task.Id, name, assignee, err)
+				continue
+			}
+			detail := fmt.Sprintf("assignee %s in workspace %s", assignee, name)
+			if assignee == "" {
+				detail = "assignee deleted in workspace " + name
+			}
+			recordAudit(ctx, admin, audit.TaskUpdated, taskTarget(task.Id), detail)
+		}
+	}
+}
+
+func (api *UserApiService) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil)
+	if !ok {
+		return
+	}
+	account, found := api.userStore.Account(r.PathValue("id"))
+	if !found {
+		handleError(w, users.ErrUserNotFound, http.StatusNotFound, "")
+		return
+	}
+	err := api.userStore.DeleteUser(r.PathValue("id"))
+	if handleError(w, err, http.StatusInternalServerError, "api: error deleting user") {
+		return
+	}
+	logger.Info.Printf("User %s deleted user %s", admin.UserName, account.UserName)
+	// a later user of the name must not get the tasks
+	api.reassign(r.Context(), admin, account.UserId.String(), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *UserApiService) SetActive(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil)
+	if !ok {
+		return
+	}
+	var request activeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	account, err := api.userStore.SetActive(r.PathValue("id"), request.Active)
+	if handleError(w, err, http.StatusInternalServerError, "api: error changing user") {
+		return
+	}
+	logger.Info.Printf("User %s set %s active to %v", admin.UserName, account.UserName, account.Active)
+	writeJson(w, http.StatusOK, newUserResponse(account))
+}
+
+// HandleUserList renders the users page for admins
+func (api *UserApiService) HandleUserList(w http.ResponseWriter, r *http.Request) {
+	if api.Pages == nil {
+		writeProblem(w, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+	if _, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil); !ok {
+		return
+	}
+	err := api.Pages.RenderUserList(w, api.userStore.ListAccounts(), middleware.CSRFToken(r))
+	handleError(w, err, http.StatusInternalServerError, "")
+}
+
+// HandleUserEdit renders the page of one user for admins
+func (api *UserApiService) HandleUserEdit(w http.ResponseWriter, r *http.Request) {
+	if api.Pages == nil {
+		writeProblem(w, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+	if _, ok := authorize(w, r, api.userStore, authz.ManageUsers, nil); !ok {
+		return
+	}
+	account, found := api.userStore.Account(r.PathValue("id"))
+	if !found {
+		handleError(w, users.ErrUserNotFound, http.StatusNotFound, "")
+		return
+	}
+	account.Role = account.EffectiveRole()
+	err := api.Pages.RenderUserEdit(w, account, middleware.CSRFToken(r))
+	handleError(w, err, http.StatusInternalServerError, "")
+}
+
+func (api *UserApiService) SetRole(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r, api.userStore, authz.ManageRoles, nil)
+	if !ok {
+		return
+	}
+	var request roleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	user, err := api.userStore.SetRole(r.PathValue("id"), request.Role)
+	if handleError(w, err, http.StatusInternalServerError, "api: error changing role") {
+		return
+	}
+	logger.Info.Printf("User %s changed role of %s to %s", admin.UserName, user.UserName, user.Role)
+	writeJson(w, http.StatusOK, user)
+}
+
+func (api *UserApiService) SetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authorize(w, r, api.userStore, authz.ManageWorkspaces, nil)
+	if !ok {
+		return
+	}
+	var request workspacesRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+	workspaces := api.Workspaces
+	if workspaces == nil {
+		workspaces = internal.NewWorkspaces(nil)
+	}
+	for _, name := range request.Workspaces {
+		if _, err := workspaces.Get(name); err != nil {
+			writeProblem(w, NewProblem(http.StatusBadRequest, "request has invalid fields", FieldError{Field: "workspaces", Message: err.Error()}))
+			return
+		}
+	}
+	membership, err := api.userStore.SetWorkspaces(r.PathValue("id"), request.Workspaces)
+	if handleError(w, err, http.StatusInternalServerError, "api: error changing workspaces") {
+		return
+	}
+	logger.Info.Printf("User %s changed workspaces of %s to %v", admin.UserName, r.PathValue("id"), membership)
+	writeJson(w, http.StatusOK, workspacesRequest{Workspaces: effectiveWorkspaces(membership)})
+}
+
+func (api *UserApiService) GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r, api.userStore)
+	if !ok {
+		writeUnknownUser(w)
+		return
+	}
+	writeJson(w, http.StatusOK, workspacesRequest{Workspaces: effectiveWorkspaces(api.userStore.Membership(user.UserName))})
+}
@@ -0,0 +1,402 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+	"github.com/zhekagigs/golang_todo/view"
+)
+
+// setupRoles serves the task and user APIs with a copy of the test users:
+// AAA is admin, BBB brewer, CCC viewer and DDD manager
+func setupRoles(t *testing.T) (*Router, *users.UserStore, *internal.TaskHolder) {
+	data, _ := os.ReadFile("resources/test_users.json")
+	usersFile := filepath.Join(t.TempDir(), "users.json")
+	os.WriteFile(usersFile, data, 0644)
+	userStore, err := users.NewUserStore(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taskHolder := internal.NewTaskHolder("")
+	taskHolder.AssigneeLookup = userStore.UserId
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+
+	router := NewRouter()
+	NewApiService(taskService, userStore).RegisterRoutes(router)
+	NewUserApiService(userStore).RegisterRoutes(router)
+	return router, userStore, taskHolder
+}
+
+func roleToken(userStore *users.UserStore, name string) string {
+	user, _ := userStore.GetUser(name)
+	return sessionToken(user)
+}
+
+func TestTaskPermissions(t *testing.T) {
+	router, userStore, taskHolder := setupRoles(t)
+	admin, _ := userStore.GetUser("AAA")
+	brewer, _ := userStore.GetUser("BBB")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), CreatedBy: &brewer})
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Order malt"), CreatedBy: &admin})
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Clean FV1"), CreatedBy: &admin, Assignee: internal.StringPtr("BBB")})
+
+	edit := internal.TaskOptional{Msg: internal.StringPtr("Brew stout")}
+	tests := []struct {
+		name       string
+		user       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+	}{
+		{"viewer may not create", "CCC", "POST", "/api/tasks", edit, http.StatusForbidden},
+		{"brewer creates", "BBB", "POST", "/api/tasks", edit, http.StatusCreated},
+		{"brewer edits own task", "BBB", "PUT", "/api/tasks/1", edit, http.StatusCreated},
+		{"brewer edits assigned task", "BBB", "PUT", "/api/tasks/3", edit, http.StatusCreated},
+		{"brewer may not edit other tasks", "BBB", "PUT", "/api/tasks/2", edit, http.StatusForbidden},
+		{"brewer may not delete", "BBB", "DELETE", "/api/tasks/1", nil, http.StatusForbidden},
+		{"brewer may not delete in bulk", "BBB", "POST", "/api/tasks:batch", bulkRequest{Operations: []internal.BulkOperation{
+			{Op: internal.BulkUpdate, Id: 1, Task: internal.TaskOptional{Msg: internal.StringPtr("Brew lager")}},
+			{Op: internal.BulkDelete, Id: 1},
+		}}, http.StatusForbidden},
+		{"brewer may not create templates", "BBB", "POST", "/api/templates", internal.TaskTemplate{Name: "brew"}, http.StatusForbidden},
+		{"manager edits any task", "DDD", "PUT", "/api/tasks/2", edit, http.StatusCreated},
+		{"manager may not delete", "DDD", "DELETE", "/api/tasks/2", nil, http.StatusForbidden},
+		{"admin deletes", "AAA", "DELETE", "/api/tasks/2", nil, http.StatusOK},
+		{"missing task", "BBB", "PUT", "/api/tasks/42", edit, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(router, tt.method, tt.path, roleToken(userStore, tt.user), tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusForbidden {
+				var problem Problem
+				json.NewDecoder(rr.Body).Decode(&problem)
+				if problem.Status != http.StatusForbidden || problem.Detail == "" {
+					t.Errorf("Expected forbidden problem, got %+v", problem)
+				}
+			}
+		})
+	}
+
+	task, _ := taskHolder.GetTask(1)
+	if task.Msg != "Brew stout" {
+		t.Errorf("Expected forbidden bulk to change nothing, got %q", task.Msg)
+	}
+}
+
+func TestBreweryPermissions(t *testing.T) {
+	router, userStore, taskHolder := setupRoles(t)
+	admin, _ := userStore.GetUser("AAA")
+	batchHolder, _ := internal.NewBatchHolder("", taskHolder)
+	registry, _ := internal.NewEquipmentRegistry("", taskHolder)
+	inventory, _ := internal.NewInventory("", taskHolder)
+	qualityLog, _ := internal.NewQualityLog("", taskHolder)
+	NewBatchApiService(batchHolder, userStore).RegisterRoutes(router)
+	NewEquipmentApiService(registry, taskHolder, userStore).RegisterRoutes(router)
+	NewInventoryApiService(inventory, userStore).RegisterRoutes(router)
+	NewQualityApiService(qualityLog, taskHolder, userStore).RegisterRoutes(router)
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Check pH"), Category: internal.CategoryPtr(internal.Quality), CreatedBy: &admin})
+
+	batch := internal.Batch{Style: "Pilsner", Profile: "lager", VolumeLiters: 1000, Fermenter: "FV1", BrewDate: time.Now().Add(7 * 24 * time.Hour)}
+	brewDate := internal.CustomTime{Time: batch.BrewDate.Add(24 * time.Hour)}
+	equipment := internal.Equipment{Name: "FV1", Type: internal.Fermenter}
+	item := internal.InventoryItem{Name: "Pilsner malt", Unit: internal.Kilogram}
+	tests := []struct {
+		name       string
+		user       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+	}{
+		{"viewer may not create batches", "CCC", "POST", "/api/batches", batch, http.StatusForbidden},
+		{"admin creates batch", "AAA", "POST", "/api/batches", batch, http.StatusCreated},
+		{"brewer may not reschedule other tasks", "BBB", "PUT", "/api/batches/1/brew-date", rescheduleRequest{BrewDate: &brewDate}, http.StatusForbidden},
+		{"manager reschedules", "DDD", "PUT", "/api/batches/1/brew-date", rescheduleRequest{BrewDate: &brewDate}, http.StatusOK},
+		{"brewer may not register equipment", "BBB", "POST", "/api/equipment", equipment, http.StatusForbidden},
+		{"manager registers equipment", "DDD", "POST", "/api/equipment", equipment, http.StatusCreated},
+		{"viewer may not mark equipment down", "CCC", "PUT", "/api/equipment/1/status", equipmentStatusRequest{Status: internal.Down}, http.StatusForbidden},
+		{"brewer may not link other tasks", "BBB", "PUT", "/api/equipment/1/tasks/1", nil, http.StatusForbidden},
+		{"viewer may not complete tasks", "CCC", "POST", "/api/tasks/1/complete", completeTaskRequest{}, http.StatusForbidden},
+		{"brewer may not complete other tasks", "BBB", "POST", "/api/tasks/1/complete", completeTaskRequest{}, http.StatusForbidden},
+		{"brewer may not create inventory items", "BBB", "POST", "/api/inventory", item, http.StatusForbidden},
+		{"manager creates inventory item", "DDD", "POST", "/api/inventory", item, http.StatusCreated},
+		{"viewer may not record stock", "CCC", "POST", "/api/inventory/1/movements", movementRequest{Quantity: 25, Reason: internal.Receipt}, http.StatusForbidden},
+		{"brewer records receipt", "BBB", "POST", "/api/inventory/1/movements", movementRequest{Quantity: 25, Reason: internal.Receipt}, http.StatusCreated},
+		{"brewer may not adjust stock", "BBB", "POST", "/api/inventory/1/movements", movementRequest{Quantity: -5, Reason: internal.Adjustment}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(router, tt.method, tt.path, roleToken(userStore, tt.user), tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if task, _ := taskHolder.GetTask(1); task.Done || task.EquipmentId != 0 {
+		t.Errorf("Expected forbidden requests to leave the task alone, got %+v", task)
+	}
+}
+
+func TestRoleManagement(t *testing.T) {
+	router, userStore, _ := setupRoles(t)
+	admin, _ := userStore.GetUser("AAA")
+	viewer, _ := userStore.GetUser("CCC")
+
+	rr := doTokenRequest(router, "GET", "/api/users", roleToken(userStore, "AAA"), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var list []users.User
+	json.NewDecoder(rr.Body).Decode(&list)
+	for _, user := range list {
+		if user.Role == "" {
+			t.Errorf("Expected effective role of %q, got none", user.UserName)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		user       string
+		userId     string
+		role       users.Role
+		wantStatus int
+	}{
+		{"brewer may not change roles", "BBB", viewer.UserId.String(), users.RoleAdmin, http.StatusForbidden},
+		{"admin promotes viewer", "AAA", viewer.UserId.String(), users.RoleManager, http.StatusOK},
+		{"invalid role", "AAA", viewer.UserId.String(), "owner", http.StatusBadRequest},
+		{"unknown user", "AAA", uuid.NewString(), users.RoleViewer, http.StatusNotFound},
+		{"last admin", "AAA", admin.UserId.String(), users.RoleViewer, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(router, "PUT", "/api/users/"+tt.userId+"/role", roleToken(userStore, tt.user), roleRequest{Role: tt.role})
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if rr := doTokenRequest(router, "GET", "/api/users", roleToken(userStore, "BBB"), nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for brewer, got %v", rr.Code)
+	}
+	if user, _ := userStore.GetUser("CCC"); user.Role != users.RoleManager {
+		t.Errorf("Expected CCC to be manager, got %v", user.Role)
+	}
+}
+
+func TestUserCRUD(t *testing.T) {
+	router, userStore, _ := setupRoles(t)
+	admin := roleToken(userStore, "AAA")
+	brewer, _ := userStore.GetUser("BBB")
+
+	rr := doTokenRequest(router, "POST", "/api/users", admin, createUserRequest{
+		UserName: "jane",
+		Password: "hoppy-ipa",
+		Profile:  users.Profile{DisplayName: "Jane Doe", Email: "jane@brewery.test", Timezone: "Europe/Berlin"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var jane userResponse
+	json.NewDecoder(rr.Body).Decode(&jane)
+	if jane.Role != users.DefaultRole || !jane.Active || jane.Profile.DisplayName != "Jane Doe" || len(jane.Workspaces) != 1 {
+		t.Errorf("Expected active brewer with profile, got %+v", jane)
+	}
+	janeUrl := "/api/users/" + jane.UserId.String()
+
+	tests := []struct {
+		name       string
+		user       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+	}{
+		{"brewer may not list", "BBB", "GET", "/api/users", nil, http.StatusForbidden},
+		{"brewer may not create", "BBB", "POST", "/api/users", createUserRequest{UserName: "joe"}, http.StatusForbidden},
+		{"manager may not delete", "DDD", "DELETE", janeUrl, nil, http.StatusForbidden},
+		{"taken name", "AAA", "POST", "/api/users", createUserRequest{UserName: "BBB"}, http.StatusConflict},
+		{"missing name", "AAA", "POST", "/api/users", createUserRequest{}, http.StatusBadRequest},
+		{"invalid email", "AAA", "POST", "/api/users", createUserRequest{UserName: "joe", Profile: users.Profile{Email: "joe"}}, http.StatusBadRequest},
+		{"get", "AAA", "GET", janeUrl, nil, http.StatusOK},
+		{"unknown user", "AAA", "GET", "/api/users/" + uuid.NewString(), nil, http.StatusNotFound},
+		{"rename to taken name", "AAA", "PUT", janeUrl, updateUserRequest{UserName: internal.StringPtr("BBB")}, http.StatusConflict},
+		{"unknown timezone", "AAA", "PUT", janeUrl, updateUserRequest{Profile: &users.Profile{Timezone: "Mars/Olympus"}}, http.StatusBadRequest},
+		{"update profile", "AAA", "PUT", janeUrl, updateUserRequest{Profile: &users.Profile{DisplayName: "Jane"}}, http.StatusOK},
+		{"deactivate", "AAA", "PUT", janeUrl + "/active", activeRequest{Active: false}, http.StatusOK},
+		{"delete", "AAA", "DELETE", "/api/users/" + brewer.UserId.String(), nil, http.StatusNoContent},
+		{"delete twice", "AAA", "DELETE", "/api/users/" + brewer.UserId.String(), nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(router, tt.method, tt.path, roleToken(userStore, tt.user), tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	account, _ := userStore.Account(jane.UserId.String())
+	if account.Active || account.Profile != (users.Profile{DisplayName: "Jane"}) {
+		t.Errorf("Expected deactivated jane with new profile, got %+v", account)
+	}
+	if _, err := userStore.Authenticate("jane", "hoppy-ipa"); err != users.ErrUserDeactivated {
+		t.Errorf("Expected deactivated jane to be refused, got %v", err)
+	}
+	if _, found := userStore.GetUser("BBB"); found {
+		t.Error("Expected BBB to be deleted")
+	}
+}
+
+func TestDeactivatedUserSession(t *testing.T) {
+	router, userStore, taskHolder := setupRoles(t)
+	middleware.ActiveUsers = userStore
+	t.Cleanup(func() { middleware.ActiveUsers = nil })
+	brewer, _ := userStore.GetUser("BBB")
+	token := roleToken(userStore, "BBB")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), CreatedBy: &brewer})
+
+	if rr := doTokenRequest(router, "GET", "/api/workspaces", token, nil); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 before deactivation, got %v", rr.Code)
+	}
+	rr := doTokenRequest(router, "PUT", "/api/users/"+brewer.UserId.String()+"/active", roleToken(userStore, "AAA"), activeRequest{Active: false})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = doTokenRequest(router, "GET", "/api/workspaces", token, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a deactivated user, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if task, _ := taskHolder.GetTask(1); task.CreatedBy != brewer {
+		t.Errorf("Expected the task to keep its creator, got %v", task.CreatedBy)
+	}
+}
+
+func TestRenameReassignsTasks(t *testing.T) {
+	router, userStore, taskHolder := setupRoles(t)
+	admin, _ := userStore.GetUser("AAA")
+	brewer, _ := userStore.GetUser("BBB")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Clean FV1"), CreatedBy: &admin, Assignee: internal.StringPtr("BBB")})
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), CreatedBy: &brewer})
+
+	userApi := NewUserApiService(userStore)
+	userApi.Workspaces = internal.NewWorkspaces(internal.NewConcurrentTaskService(taskHolder))
+	routerWithWorkspaces := NewRouter()
+	userApi.RegisterRoutes(routerWithWorkspaces)
+
+	rr := doTokenRequest(routerWithWorkspaces, "PUT", "/api/users/"+brewer.UserId.String(), roleToken(userStore, "AAA"), updateUserRequest{UserName: internal.StringPtr("bob")})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if task, _ := taskHolder.GetTask(1); task.Assignee != "bob" {
+		t.Errorf("Expected the task assigned to bob, got %q", task.Assignee)
+	}
+	if task, _ := taskHolder.GetTask(2); task.CreatedBy.UserName != "BBB" || task.CreatedBy.UserId != brewer.UserId {
+		t.Errorf("Expected the creator to keep the old name, got %v", task.CreatedBy)
+	}
+	// the renamed user keeps its session and its tasks
+	if rr := doTokenRequest(router, "PUT", "/api/tasks/1", roleToken(userStore, "bob"), internal.TaskOptional{Msg: internal.StringPtr("Clean FV2")}); rr.Code != http.StatusCreated {
+		t.Errorf("Expected bob to edit the assigned task, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeleteUserUnassignsTasks(t *testing.T) {
+	router, userStore, taskHolder := setupRoles(t)
+	admin, _ := userStore.GetUser("AAA")
+	brewer, _ := userStore.GetUser("BBB")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Clean FV1"), CreatedBy: &admin, Assignee: internal.StringPtr("BBB")})
+	siteB := internal.NewTaskHolder("")
+	siteB.AssigneeLookup = userStore.UserId
+	siteB.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Clean FV2"), CreatedBy: &admin, Assignee: internal.StringPtr("BBB")})
+
+	userApi := NewUserApiService(userStore)
+	userApi.Workspaces = internal.NewWorkspaces(internal.NewConcurrentTaskService(taskHolder))
+	userApi.Workspaces.Add("site-b", siteB)
+	t.Cleanup(userApi.Workspaces.CloseAll)
+	routerWithWorkspaces := NewRouter()
+	userApi.RegisterRoutes(routerWithWorkspaces)
+
+	adminToken := roleToken(userStore, "AAA")
+	if rr := doTokenRequest(routerWithWorkspaces, "DELETE", "/api/users/"+brewer.UserId.String(), adminToken, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %v: %s", rr.Code, rr.Body.String())
+	}
+	for _, holder := range []*internal.TaskHolder{taskHolder, siteB} {
+		if task, _ := holder.GetTask(1); task.Assignee != "" || task.AssigneeId != "" {
+			t.Errorf("Expected the task unassigned, got %q %q", task.Assignee, task.AssigneeId)
+		}
+	}
+
+	// a new user of the name doesn't get the tasks
+	rr := doTokenRequest(routerWithWorkspaces, "POST", "/api/users", adminToken, createUserRequest{UserName: "BBB", Password: "hoppy-ipa"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doTokenRequest(router, "PUT", "/api/tasks/1", roleToken(userStore, "BBB"), internal.TaskOptional{Msg: internal.StringPtr("Clean FV3")}); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for the new BBB, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestUserPages(t *testing.T) {
+	_, userStore, _ := setupRoles(t)
+	brewer, _ := userStore.GetUser("BBB")
+	renderer, err := view.NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userApi := NewUserApiService(userStore)
+	router := NewRouter()
+	userApi.RegisterRoutes(router)
+
+	doPageRequest := func(path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.AddCookie(&http.Cookie{Name: middleware.SessionCookie, Value: roleToken(userStore, user)})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := doPageRequest("/admin/users", "AAA"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without pages, got %v", rr.Code)
+	}
+
+	userApi.Pages = renderer
+	tests := []struct {
+		name       string
+		user       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"list", "AAA", "/admin/users", http.StatusOK, "DDD"},
+		{"edit", "AAA", "/admin/users/" + brewer.UserId.String(), http.StatusOK, "User BBB"},
+		{"unknown user", "AAA", "/admin/users/" + uuid.NewString(), http.StatusNotFound, ""},
+		{"brewer", "BBB", "/admin/users", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doPageRequest(tt.path, tt.user)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("Expected page with %q, got %s", tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package v1 is the wire format of /api/v1. Types here are part of the public
+// contract: fields may be added, never renamed or removed. Translation to and
+// from internal types keeps internal refactors away from clients.
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhekagigs/golang_todo/internal"
+)
+
+type UserRef struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type ChecklistItem struct {
+	Id   int    `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+type Task struct {
+	Id          int             `json:"id"`
+	Message     string          `json:"message"`
+	Category    string          `json:"category"`
+	Done        bool            `json:"done"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	PlannedAt   *time.Time      `json:"plannedAt,omitempty"`
+	CreatedBy   UserRef         `json:"createdBy"`
+	Assignee    string          `json:"assignee,omitempty"`
+	Tags        []string        `json:"tags"`
+	Checklist   []ChecklistItem `json:"checklist"`
+	BatchId     int             `json:"batchId,omitempty"`
+	EquipmentId int             `json:"equipmentId,omitempty"`
+	Version     int             `json:"version"`
+}
+
+type TaskList struct {
+	Items []Task `json:"items"`
+	Total int    `json:"total"`
+	Next  string `json:"next,omitempty"`
+}
+
+// TaskInput creates a task or changes the sent fields of one
+type TaskInput struct {
+	Message   *string    `json:"message"`
+	Category  *string    `json:"category"`
+	Done      *bool      `json:"done"`
+	PlannedAt *time.Time `json:"plannedAt"`
+	Assignee  *string    `json:"assignee"`
+	Tags      []string   `json:"tags"`
+}
+
+type InvalidFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *InvalidFieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func FromTask(task internal.Task) Task {
+	result := Task{
+		Id:          task.Id,
+		Message:     task.Msg,
+		Category:    categoryName(task.Category),
+		Done:        task.Done,
+		CreatedAt:   task.CreatedAt,
+		CreatedBy:   UserRef{Name: task.CreatedBy.UserName},
+		Assignee:    task.Assignee,
+		Tags:        append([]string{}, task.Tags...),
+		Checklist:   []ChecklistItem{},
+		BatchId:     task.BatchId,
+		EquipmentId: task.EquipmentId,
+		Version:     task.Version,
+	}
+	if task.CreatedBy.UserId != uuid.Nil {
+		result.CreatedBy.Id = task.CreatedBy.UserId.String()
+	}
+	if !task.PlannedAt.IsZero() {
+		plannedAt := task.PlannedAt
+		result.PlannedAt = &plannedAt
+	}
+	for _, item := range task.Checklist {
+		result.Checklist = append(result.Checklist, ChecklistItem{Id: item.Id, Text: item.Text, Done: item.Done})
+	}
+	return result
+}
+
+func FromTasks(tasks []internal.Task) []Task {
+	result := make([]Task, len(tasks))
+	for i, task := range tasks {
+		result[i] = FromTask(task)
+	}
+	return result
+}
+
+// ToTaskOptional translates sent fields, absent fields stay nil
+func (input *TaskInput) ToTaskOptional() (internal.TaskOptional, error) {
+	update := internal.TaskOptional{
+		Msg:      input.Message,
+		Done:     input.Done,
+		Assignee: input.Assignee,
+		Tags:     input.Tags,
+	}
+	if input.Category != nil {
+		category, err := internal.ParseTaskCategory(*input.Category)
+		if err != nil {
+			return update, &InvalidFieldError{Field: "category", Reason: fmt.Sprintf("unknown category %q", *input.Category)}
+		}
+		update.Category = &category
+	}
+	if input.PlannedAt != nil {
+		update.PlannedAt = &internal.CustomTime{Time: *input.PlannedAt}
+	}
+	return update, nil
+}
+
+// FieldName maps field names of internal errors to their v1 names
+func FieldName(field string) string {
+	if field == "msg" {
+		return "message"
+	}
+	return field
+}
+
+func categoryName(category internal.TaskCategory) string {
+	return strings.ToLower(category.String())
+}
@@ -0,0 +1,86 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// The v1 wire format is a contract with clients, changing these strings
+// breaks them. Add fields instead.
+func TestTaskContract(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		task internal.Task
+		want string
+	}{
+		{
+			name: "full task",
+			task: internal.Task{
+				Id:          7,
+				Msg:         "Brew Hazy IPA",
+				Category:    internal.Brewing,
+				Done:        true,
+				CreatedAt:   createdAt,
+				PlannedAt:   createdAt.Add(24 * time.Hour),
+				CreatedBy:   users.User{UserName: "AAA", UserId: uuid.MustParse("208c0b87-b79e-41fb-a1b3-cd797ef584df")},
+				Checklist:   []internal.ChecklistItem{{Id: 1, Text: "Mash in", Done: true}},
+				BatchId:     3,
+				EquipmentId: 2,
+				Assignee:    "BBB",
+				Tags:        []string{"ipa"},
+				Version:     4,
+			},
+			want: `{"id":7,"message":"Brew Hazy IPA","category":"brewing","done":true,"createdAt":"2026-01-02T08:00:00Z","plannedAt":"2026-01-03T08:00:00Z","createdBy":{"id":"208c0b87-b79e-41fb-a1b3-cd797ef584df","name":"AAA"},"assignee":"BBB","tags":["ipa"],"checklist":[{"id":1,"text":"Mash in","done":true}],"batchId":3,"equipmentId":2,"version":4}`,
+		},
+		{
+			name: "minimal task",
+			task: internal.Task{Id: 1, Msg: "Call pub", Category: internal.Marketing, CreatedAt: createdAt, CreatedBy: users.User{UserName: "Team"}, Version: 1},
+			want: `{"id":1,"message":"Call pub","category":"marketing","done":false,"createdAt":"2026-01-02T08:00:00Z","createdBy":{"name":"Team"},"tags":[],"checklist":[],"version":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(FromTask(tt.task))
+			if string(got) != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	list, _ := json.Marshal(TaskList{Items: []Task{}, Total: 0})
+	if string(list) != `{"items":[],"total":0}` {
+		t.Errorf("Unexpected task list %s", list)
+	}
+}
+
+func TestTaskInputContract(t *testing.T) {
+	var input TaskInput
+	err := json.Unmarshal([]byte(`{"message":"Keg IPA","category":"Logistics","done":false,"plannedAt":"2026-01-05T10:00:00Z","assignee":"AAA","tags":["ipa"]}`), &input)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	update, err := input.ToTaskOptional()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if *update.Msg != "Keg IPA" || *update.Category != internal.Logistics || *update.Done || *update.Assignee != "AAA" ||
+		!update.PlannedAt.Time.Equal(time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)) || len(update.Tags) != 1 {
+		t.Errorf("Unexpected update %+v", update)
+	}
+
+	if update, _ := (&TaskInput{Done: internal.BoolPtr(true)}).ToTaskOptional(); update.Msg != nil || update.Category != nil || update.Tags != nil {
+		t.Errorf("Expected absent fields to stay nil, got %+v", update)
+	}
+
+	var fieldErr *InvalidFieldError
+	if _, err := (&TaskInput{Category: internal.StringPtr("tasting")}).ToTaskOptional(); !errors.As(err, &fieldErr) || fieldErr.Field != "category" {
+		t.Errorf("Expected InvalidFieldError for category, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/authz"
+	v1 "github.com/zhekagigs/golang_todo/controller/v1"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// WebhookApiService manages webhooks, admins only: webhooks receive every
+// task and their deliveries show the payloads
+type WebhookApiService struct {
+	registry  *internal.WebhookRegistry
+	userStore *users.UserStore
+}
+
+// webhookResponse hides the secret, it is only returned on creation
+type webhookResponse struct {
+	internal.Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+// webhookPayload is the body posted to webhooks, tasks in the v1 format
+type webhookPayload struct {
+	Event      internal.TaskEventType `json:"event"`
+	OccurredAt time.Time              `json:"occurredAt"`
+	Task       v1.Task                `json:"task"`
+	Previous   *v1.Task               `json:"previous,omitempty"`
+}
+
+func NewWebhookApiService(registry *internal.WebhookRegistry, userStore *users.UserStore) *WebhookApiService {
+	return &WebhookApiService{
+		registry:  registry,
+		userStore: userStore,
+	}
+}
+
+// EncodeWebhookPayload is set as WebhookRegistry.EncodePayload
+func EncodeWebhookPayload(event internal.TaskEvent) ([]byte, error) {
+	payload := webhookPayload{
+		Event:      event.Type,
+		OccurredAt: time.Now().UTC(),
+		Task:       v1.FromTask(event.Task),
+	}
+	if event.Previous != nil {
+		previous := v1.FromTask(*event.Previous)
+		payload.Previous = &previous
+	}
+	return json.Marshal(payload)
+}
+
+func (api *WebhookApiService) RegisterRoutes(router *Router) {
+	router.HandleAuth("GET /api/webhooks", api.admin(api.GetAllWebhooks), &RouteDoc{
+		Summary: "List webhooks", Tag: "webhooks", Response: []webhookResponse{}})
+	router.HandleAuth("POST /api/webhooks", api.admin(api.CreateWebhook), &RouteDoc{
+		Summary: "Subscribe URL to task events, the secret is generated when empty", Tag: "webhooks", Request: internal.Webhook{}, Status: http.StatusCreated, Response: webhookResponse{}})
+	router.HandleAuth("GET /api/webhooks/{id}", api.admin(api.GetWebhookById), &RouteDoc{
+		Summary: "Get webhook", Tag: "webhooks", Response: webhookResponse{}})
+	router.HandleAuth("DELETE /api/webhooks/{id}", api.admin(api.DeleteWebhook), &RouteDoc{
+		Summary: "Delete webhook and its pending deliveries", Tag: "webhooks", Status: http.StatusNoContent})
+	router.HandleAuth("GET /api/webhooks/{id}/deliveries", api.admin(api.GetDeliveries), &RouteDoc{
+		Summary: "List deliveries of webhook, newest first", Tag: "webhooks", Response: []internal.WebhookDelivery{}})
+	router.HandleAuth("GET /api/webhooks/dead-letters", api.admin(api.GetDeadLetters), &RouteDoc{
+		Summary: "List deliveries that ran out of attempts", Tag: "webhooks", Response: []internal.WebhookDelivery{}})
+	router.HandleAuth("POST /api/webhooks/deliveries/{id}/retry", api.admin(api.RetryDelivery), &RouteDoc{
+		Summary: "Queue dead letter again", Tag: "webhooks", Response: internal.WebhookDelivery{}})
+}
+
+// admin lets only users with authz.ManageWebhooks through to next
+func (api *WebhookApiService) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorize(w, r, api.userStore, authz.ManageWebhooks, nil); !ok {
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (api *WebhookApiService) GetAllWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks := []webhookResponse{}
+	for _, webhook := range api.registry.Read() {
+		webhooks = append(webhooks, webhookResponse{Webhook: webhook})
+	}
+	writeJson(w, http.StatusOK, webhooks)
+}
+
+func (api *WebhookApiService) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhookRequest internal.Webhook
+	err := json.NewDecoder(r.Body).Decode(&webhookRequest)
+	if handleError(w, err, http.StatusBadRequest, "error decoding request body") {
+		return
+	}
+
+	webhook, err := api.registry.AddWebhook(webhookRequest)
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return
+	}
+	w.Header().Set("Location", "/api/webhooks/"+strconv.Itoa(webhook.Id))
+	writeJson(w, http.StatusCreated, webhookResponse{Webhook: *webhook, Secret: webhook.Secret})
+}
+
+func (api *WebhookApiService) GetWebhookById(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing webhookId") {
+		return
+	}
+	webhook, err := api.registry.FindWebhookById(webhookId)
+	if handleError(w, err, http.StatusNotFound, "api: webhook not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, webhookResponse{Webhook: *webhook})
+}
+
+func (api *WebhookApiService) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing webhookId") {
+		return
+	}
+	err = api.registry.DeleteWebhook(webhookId)
+	if handleError(w, err, http.StatusNotFound, "api: webhook not found") {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *WebhookApiService) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing webhookId") {
+		return
+	}
+	deliveries, err := api.registry.WebhookDeliveries(webhookId)
+	if handleError(w, err, http.StatusNotFound, "api: webhook not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, deliveries)
+}
+
+func (api *WebhookApiService) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, api.registry.DeadLetters())
+}
+
+func (api *WebhookApiService) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryId, err := getTaskIdFromPath(r)
+	if handleError(w, err, http.StatusBadRequest, "api: error processing deliveryId") {
+		return
+	}
+	delivery, err := api.registry.RetryDelivery(deliveryId)
+	if handleError(w, err, http.StatusNotFound, "api: delivery not found") {
+		return
+	}
+	writeJson(w, http.StatusOK, delivery)
+}
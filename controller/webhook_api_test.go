@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func setupWebhookApi(t *testing.T, receiverStatus int) (*Router, *internal.TaskHolder, string, chan receivedWebhook) {
+	received := make(chan receivedWebhook, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header, body: body}
+		w.WriteHeader(receiverStatus)
+	}))
+	t.Cleanup(receiver.Close)
+
+	taskHolder := internal.NewTaskHolder("")
+	registry, _ := internal.NewWebhookRegistry("", taskHolder)
+	registry.EncodePayload = EncodeWebhookPayload
+	registry.RetryDelay = time.Millisecond
+	registry.AllowPrivateNetworks = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	user, _ := userStore.GetUser("AAA")
+	MOCK_TOKEN = sessionToken(user)
+	router := NewRouter()
+	NewWebhookApiService(registry, userStore).RegisterRoutes(router)
+	return router, taskHolder, receiver.URL, received
+}
+
+func receiveWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	select {
+	case webhook := <-received:
+		return webhook
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+		return receivedWebhook{}
+	}
+}
+
+func TestWebhookApi(t *testing.T) {
+	router, taskHolder, receiverURL, received := setupWebhookApi(t, http.StatusOK)
+
+	rr := doApiRequest(router, "POST", "/api/webhooks", internal.Webhook{URL: receiverURL, Events: []internal.TaskEventType{internal.TaskCreated}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var created webhookResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.Secret == "" || rr.Header().Get("Location") != "/api/webhooks/"+strconv.Itoa(created.Id) {
+		t.Fatalf("Expected generated secret and location, got %+v %v", created, rr.Header())
+	}
+
+	task := taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+	webhook := receiveWebhook(t, received)
+	timestamp, _ := strconv.ParseInt(webhook.header.Get(internal.WebhookTimestampHeader), 10, 64)
+	if webhook.header.Get(internal.WebhookSignatureHeader) != internal.SignWebhook(created.Secret, timestamp, webhook.body) {
+		t.Errorf("Expected valid signature")
+	}
+	var payload map[string]any
+	json.Unmarshal(webhook.body, &payload)
+	taskData, _ := payload["task"].(map[string]any)
+	if payload["event"] != "created" || taskData["message"] != "Brew IPA" || taskData["id"] != float64(task.Id) {
+		t.Errorf("Expected v1 task payload, got %s", webhook.body)
+	}
+
+	rr = doApiRequest(router, "GET", "/api/webhooks", nil)
+	var webhooks []map[string]any
+	json.NewDecoder(rr.Body).Decode(&webhooks)
+	if len(webhooks) != 1 || webhooks[0]["secret"] != nil {
+		t.Errorf("Expected one webhook without secret, got %v", webhooks)
+	}
+
+	var deliveries []internal.WebhookDelivery
+	deadline := time.Now().Add(5 * time.Second)
+	for len(deliveries) == 0 || deliveries[0].Status != internal.DeliveryDelivered {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected delivered delivery, got %+v", deliveries)
+		}
+		rr = doApiRequest(router, "GET", "/api/webhooks/1/deliveries", nil)
+		json.NewDecoder(rr.Body).Decode(&deliveries)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+	}{
+		{"invalid url", "POST", "/api/webhooks", internal.Webhook{URL: "erp"}, http.StatusBadRequest},
+		{"missing webhook deliveries", "GET", "/api/webhooks/42/deliveries", nil, http.StatusNotFound},
+		{"retry delivered", "POST", "/api/webhooks/deliveries/1/retry", nil, http.StatusConflict},
+		{"retry missing", "POST", "/api/webhooks/deliveries/42/retry", nil, http.StatusNotFound},
+		{"delete", "DELETE", "/api/webhooks/1", nil, http.StatusNoContent},
+		{"get deleted", "GET", "/api/webhooks/1", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doApiRequest(router, tt.method, tt.path, tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebhookApiDeadLetters(t *testing.T) {
+	router, taskHolder, receiverURL, received := setupWebhookApi(t, http.StatusInternalServerError)
+	doApiRequest(router, "POST", "/api/webhooks", internal.Webhook{URL: receiverURL})
+
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA")})
+	for i := 0; i < internal.MaxWebhookAttempts; i++ {
+		receiveWebhook(t, received)
+	}
+
+	var dead []internal.WebhookDelivery
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dead) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected dead letter")
+		}
+		rr := doApiRequest(router, "GET", "/api/webhooks/dead-letters", nil)
+		json.NewDecoder(rr.Body).Decode(&dead)
+	}
+
+	rr := doApiRequest(router, "POST", "/api/webhooks/deliveries/"+strconv.Itoa(dead[0].Id)+"/retry", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", rr.Code, rr.Body.String())
+	}
+	receiveWebhook(t, received)
+}
+
+func TestWebhookApiAdminOnly(t *testing.T) {
+	router, _, receiverURL, _ := setupWebhookApi(t, http.StatusOK)
+	userStore, _ := users.NewUserStore("resources/test_users.json")
+	brewer, _ := userStore.GetUser("BBB")
+
+	tests := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{"GET", "/api/webhooks", nil},
+		{"POST", "/api/webhooks", internal.Webhook{URL: receiverURL}},
+		{"GET", "/api/webhooks/dead-letters", nil},
+		{"POST", "/api/webhooks/deliveries/1/retry", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := doTokenRequest(router, tt.method, tt.path, sessionToken(brewer), tt.body)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %v: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/audit"
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/middleware"
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+const (
+	// WorkspaceHeader names the active workspace of API requests
+	WorkspaceHeader = "X-Workspace"
+	// WorkspaceCookie names the active workspace of the pages
+	WorkspaceCookie = "Workspace"
+)
+
+// requestWorkspace returns the workspace named by r, "" when there is none
+func requestWorkspace(r *http.Request) string {
+	if name := r.Header.Get(WorkspaceHeader); name != "" {
+		return name
+	}
+	if cookie, err := r.Cookie(WorkspaceCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// resolveWorkspace returns the task service of workspace name for the user
+// with userId, "" for anonymous requests. Without a name members use their
+// home workspace and anonymous requests the default one.
+func resolveWorkspace(ctx context.Context, workspaces *internal.Workspaces, userStore *users.UserStore, userId, name string) (*internal.ConcurrentTaskService, error) {
+	var user *users.User
+	var membership users.Membership
+	if userId != "" {
+		if found, ok := userStore.GetUserById(userId); ok {
+			user = &found
+			membership = userStore.Membership(found.UserName)
+		}
+	}
+	if name == "" {
+		name = membership.Home()
+	}
+	if err := authz.CheckWorkspace(user, membership, name); err != nil {
+		recordAudit(ctx, user, audit.PermissionDenied, "workspace "+name, err.Error())
+		return nil, err
+	}
+	return workspaces.Get(name)
+}
+
+// workspaceTasks is resolveWorkspace for r, it writes the problem when the
+// workspace can't be used
+func workspaceTasks(w http.ResponseWriter, r *http.Request, workspaces *internal.Workspaces, userStore *users.UserStore) (*internal.ConcurrentTaskService, bool) {
+	userId, _ := middleware.UserFromContext(r.Context())
+	tasks, err := resolveWorkspace(r.Context(), workspaces, userStore, userId, requestWorkspace(r))
+	if handleError(w, err, http.StatusBadRequest, "") {
+		return nil, false
+	}
+	return tasks, true
+}
+
+// DefaultWorkspaceOnly guards the services bound to the tasks of the default
+// workspace, like batches and task events. Requests naming another workspace
+// get 404 and users who aren't members of the default workspace 403.
+func (api *ApiService) DefaultWorkspaceOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := requestWorkspace(r)
+		if name != "" && name != internal.DefaultWorkspace {
+			writeProblem(w, NewProblem(http.StatusNotFound, "only the default workspace has "+r.URL.Path))
+			return
+		}
+		userId, _ := middleware.UserFromContext(r.Context())
+		_, err := resolveWorkspace(r.Context(), api.Workspaces, api.userStore, userId, internal.DefaultWorkspace)
+		if handleError(w, err, http.StatusBadRequest, "") {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/authz"
+	"github.com/zhekagigs/golang_todo/internal"
+)
+
+func doWorkspaceRequest(router http.Handler, method, path, token, workspace string, body any) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	if workspace != "" {
+		req.Header.Set(WorkspaceHeader, workspace)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWorkspaceIsolation(t *testing.T) {
+	_, userStore, taskHolder := setupRoles(t)
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	workspaces := internal.NewWorkspaces(taskService)
+	t.Cleanup(workspaces.CloseAll)
+	workspaces.Add("site-b", internal.NewTaskHolder(""))
+
+	api := NewApiService(taskService, userStore)
+	api.Workspaces = workspaces
+	userApi := NewUserApiService(userStore)
+	userApi.Workspaces = workspaces
+	router := NewRouter()
+	api.RegisterRoutes(router)
+	userApi.RegisterRoutes(router)
+	router.Guard(api.DefaultWorkspaceOnly, func(router *Router) {
+		router.HandleAuth("GET /api/batches", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, nil)
+	})
+
+	// AAA is admin in the default workspace, BBB only in site-b, DDD in both
+	brewer, _ := userStore.GetUser("BBB")
+	manager, _ := userStore.GetUser("DDD")
+	userStore.SetWorkspaces(brewer.UserId.String(), []string{"site-b"})
+	userStore.SetWorkspaces(manager.UserId.String(), []string{internal.DefaultWorkspace, "site-b"})
+	admin, brewerToken, managerToken := roleToken(userStore, "AAA"), roleToken(userStore, "BBB"), roleToken(userStore, "DDD")
+
+	rr := doWorkspaceRequest(router, "POST", "/api/tasks", brewerToken, "", provideTask("Brew IPA"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected task created in the home workspace, got %v: %s", rr.Code, rr.Body.String())
+	}
+	siteB, _ := workspaces.Get("site-b")
+	if len(siteB.Read()) != 1 || len(taskService.Read()) != 0 {
+		t.Fatalf("Expected the task in site-b only, got %d and %d", len(siteB.Read()), len(taskService.Read()))
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		workspace  string
+		wantStatus int
+	}{
+		{"anonymous reads default", "GET", "/api/tasks", "", "", http.StatusUnauthorized},
+		{"member of site-b only reads default", "GET", "/api/tasks", brewerToken, internal.DefaultWorkspace, http.StatusForbidden},
+		{"anonymous reads site-b", "GET", "/api/tasks/1", "", "site-b", http.StatusUnauthorized},
+		{"non member reads", "GET", "/api/tasks/1", admin, "site-b", http.StatusForbidden},
+		{"non member deletes", "DELETE", "/api/tasks/1", admin, "site-b", http.StatusForbidden},
+		{"member reads", "GET", "/api/tasks/1", managerToken, "site-b", http.StatusOK},
+		{"home workspace of member", "GET", "/api/tasks/1", managerToken, "", http.StatusNotFound},
+		{"unknown workspace", "GET", "/api/tasks/1", managerToken, "site-c", http.StatusForbidden},
+		{"default only route for other workspace", "GET", "/api/batches", managerToken, "site-b", http.StatusNotFound},
+		{"default only route for non member", "GET", "/api/batches", brewerToken, "", http.StatusForbidden},
+		{"default only route", "GET", "/api/batches", managerToken, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doWorkspaceRequest(router, tt.method, tt.path, tt.token, tt.workspace, nil)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	var list taskListResponse
+	rr = doWorkspaceRequest(router, "GET", "/api/tasks", admin, "", nil)
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.Total != 0 {
+		t.Errorf("Expected no tasks in the default workspace, got %d", list.Total)
+	}
+}
+
+func TestDefaultWorkspaceNeedsLogin(t *testing.T) {
+	_, userStore, taskHolder := setupRoles(t)
+	admin, _ := userStore.GetUser("AAA")
+	taskHolder.CreateTask(internal.TaskOptional{Msg: internal.StringPtr("Brew IPA"), CreatedBy: &admin})
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	workspaces := internal.NewWorkspaces(taskService)
+	t.Cleanup(workspaces.CloseAll)
+	workspaces.Add("site-b", internal.NewTaskHolder(""))
+	brewer, _ := userStore.GetUser("BBB")
+	userStore.SetWorkspaces(brewer.UserId.String(), []string{"site-b"})
+
+	api := NewApiService(taskService, userStore)
+	api.Workspaces = workspaces
+	graphqlApi, _ := NewGraphQLService(taskService, userStore)
+	graphqlApi.Workspaces = workspaces
+	router := NewRouter()
+	api.RegisterRoutes(router)
+	api.RegisterRoutesV1(router)
+	graphqlApi.RegisterRoutes(router)
+	graphql := graphQLRequest{Query: "{ tasks { totalCount } }"}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+	}{
+		{"tasks", "GET", "/api/tasks", nil},
+		{"task", "GET", "/api/tasks/1", nil},
+		{"v1 tasks", "GET", "/api/v1/tasks", nil},
+		{"templates", "GET", "/api/templates", nil},
+		{"graphql", "POST", "/graphql", graphql},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doWorkspaceRequest(router, tt.method, tt.path, "", "", tt.body); rr.Code != http.StatusUnauthorized {
+				t.Errorf("Expected anonymous request refused, got %v: %s", rr.Code, rr.Body.String())
+			}
+			rr := doWorkspaceRequest(router, tt.method, tt.path, roleToken(userStore, "BBB"), internal.DefaultWorkspace, tt.body)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("Expected member of site-b refused, got %v: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	authz.PublicDefaultWorkspace = true
+	t.Cleanup(func() { authz.PublicDefaultWorkspace = false })
+	if rr := doWorkspaceRequest(router, "GET", "/api/tasks/1", "", "", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected anonymous read of public tasks, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSetWorkspaces(t *testing.T) {
+	_, userStore, taskHolder := setupRoles(t)
+	taskService := internal.NewConcurrentTaskService(taskHolder)
+	t.Cleanup(taskService.CloseAll)
+	workspaces := internal.NewWorkspaces(taskService)
+	t.Cleanup(workspaces.CloseAll)
+	workspaces.Add("site-b", internal.NewTaskHolder(""))
+	userApi := NewUserApiService(userStore)
+	userApi.Workspaces = workspaces
+	router := NewRouter()
+	userApi.RegisterRoutes(router)
+	brewer, _ := userStore.GetUser("BBB")
+	path := "/api/users/" + brewer.UserId.String() + "/workspaces"
+
+	tests := []struct {
+		name       string
+		user       string
+		workspaces []string
+		wantStatus int
+		want       []string
+	}{
+		{"brewer may not", "BBB", []string{"site-b"}, http.StatusForbidden, nil},
+		{"unknown workspace", "AAA", []string{"site-c"}, http.StatusBadRequest, nil},
+		{"admin adds site-b", "AAA", []string{"site-b", "default"}, http.StatusOK, []string{"default", "site-b"}},
+		{"admin clears", "AAA", []string{}, http.StatusOK, []string{"default"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doTokenRequest(router, "PUT", path, roleToken(userStore, tt.user), workspacesRequest{Workspaces: tt.workspaces})
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+			var response workspacesRequest
+			json.NewDecoder(rr.Body).Decode(&response)
+			rr = doTokenRequest(router, "GET", "/api/workspaces", roleToken(userStore, "BBB"), nil)
+			var own workspacesRequest
+			json.NewDecoder(rr.Body).Decode(&own)
+			if len(response.Workspaces) != len(tt.want) || len(own.Workspaces) != len(tt.want) || own.Workspaces[0] != tt.want[0] {
+				t.Errorf("Expected workspaces %v, got %v and %v", tt.want, response.Workspaces, own.Workspaces)
+			}
+		})
+	}
+}
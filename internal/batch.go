@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type UnknownRecipeProfileError struct {
+	Profile string
+}
+
+func (e *UnknownRecipeProfileError) Error() string {
+	return fmt.Sprintf("unknown recipe profile: %q", e.Profile)
+}
+
+type InvalidBatchValueError struct {
+	Field string
+}
+
+func (e *InvalidBatchValueError) Error() string {
+	return fmt.Sprintf("invalid batch value: %s", e.Field)
+}
+
+// RecipeProfile is a production schedule of a beer style. Every step is a
+// template planned relative to the brew date, placeholders {{style}},
+// {{volume}} and {{fermenter}} are filled from the batch.
+type RecipeProfile struct {
+	Name  string         `json:"name"`
+	Steps []TaskTemplate `json:"steps"`
+}
+
+var RecipeProfiles = map[string]RecipeProfile{
+	"ale": {
+		Name: "ale",
+		Steps: []TaskTemplate{
+			{Name: "Order", Msg: "Order ingredients for {{style}}", Category: Logistics, OffsetHours: -7 * 24},
+			{Name: "Brew", Msg: "Brew {{volume}} L of {{style}} in {{fermenter}}", Category: Brewing, OffsetHours: 0,
+				Checklist: []string{"Mill grain", "Mash in", "Sparge", "Boil", "Chill", "Pitch yeast"}},
+			{Name: "Gravity", Msg: "Check gravity of {{style}} in {{fermenter}}", Category: Quality, OffsetHours: 3 * 24},
+			{Name: "Dry hop", Msg: "Dry hop {{style}} in {{fermenter}}", Category: Brewing, OffsetHours: 5 * 24},
+			{Name: "Crash", Msg: "Cold crash {{style}} in {{fermenter}}", Category: Brewing, OffsetHours: 10 * 24},
+			{Name: "Micro", Msg: "Perform microbiological testing of {{style}}", Category: Quality, OffsetHours: 12 * 24},
+			{Name: "Package", Msg: "Package {{volume}} L of {{style}}", Category: Logistics, OffsetHours: 14 * 24},
+		},
+	},
+	"lager": {
+		Name: "lager",
+		Steps: []TaskTemplate{
+			{Name: "Order", Msg: "Order ingredients for {{style}}", Category: Logistics, OffsetHours: -7 * 24},
+			{Name: "Brew", Msg: "Brew {{volume}} L of {{style}} in {{fermenter}}", Category: Brewing, OffsetHours: 0,
+				Checklist: []string{"Mill grain", "Mash in", "Sparge", "Boil", "Chill", "Pitch yeast"}},
+			{Name: "Gravity", Msg: "Check gravity of {{style}} in {{fermenter}}", Category: Quality, OffsetHours: 7 * 24},
+			{Name: "Diacetyl", Msg: "Diacetyl rest {{style}} in {{fermenter}}", Category: Brewing, OffsetHours: 10 * 24},
+			{Name: "Lager", Msg: "Start lagering {{style}} in {{fermenter}}", Category: Brewing, OffsetHours: 14 * 24},
+			{Name: "Micro", Msg: "Perform microbiological testing of {{style}}", Category: Quality, OffsetHours: 40 * 24},
+			{Name: "Package", Msg: "Package {{volume}} L of {{style}}", Category: Logistics, OffsetHours: 42 * 24},
+		},
+	},
+}
+
+type Batch struct {
+	Id           int       `json:"id"`
+	Style        string    `json:"style"`
+	Profile      string    `json:"profile"`
+	VolumeLiters float64   `json:"volumeLiters"`
+	BrewDate     time.Time `json:"brewDate"`
+	Fermenter    string    `json:"fermenter"`
+	TaskIds      []int     `json:"taskIds"`
+}
+
+func (b *Batch) Validate() error {
+	if strings.TrimSpace(b.Style) == "" {
+		return &InvalidBatchValueError{Field: "style"}
+	}
+	if b.VolumeLiters <= 0 {
+		return &InvalidBatchValueError{Field: "volumeLiters"}
+	}
+	if b.BrewDate.IsZero() {
+		return &InvalidBatchValueError{Field: "brewDate"}
+	}
+	if strings.TrimSpace(b.Fermenter) == "" {
+		return &InvalidBatchValueError{Field: "fermenter"}
+	}
+	return nil
+}
+
+func (b *Batch) vars() map[string]string {
+	return map[string]string{
+		"style":     b.Style,
+		"volume":    fmt.Sprintf("%g", b.VolumeLiters),
+		"fermenter": b.Fermenter,
+	}
+}
+
+// BatchHolder keeps batches and creates their tasks in the TaskHolder.
+// Like users.UserStore it saves itself on every change when DiskPath is set.
+// FermenterLookup, when set, links brewing tasks to the fermenter equipment.
+type BatchHolder struct {
+	latestId        int
+	Batches         []Batch
+	DiskPath        string
+	FermenterLookup func(name string) (int, bool)
+	taskHolder      *TaskHolder
+	sync.Mutex
+}
+
+func NewBatchHolder(diskPath string, taskHolder *TaskHolder) (*BatchHolder, error) {
+	holder := &BatchHolder{DiskPath: diskPath, taskHolder: taskHolder}
+	if diskPath == "" {
+		return holder, nil
+	}
+	err := loadJsonFile(diskPath, &holder.Batches)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, batch := range holder.Batches {
+		if batch.Id > holder.latestId {
+			holder.latestId = batch.Id
+		}
+	}
+	return holder, nil
+}
+
+func (b *BatchHolder) save() error {
+	if b.DiskPath == "" {
+		return nil
+	}
+	return saveJsonFile(b.DiskPath, b.Batches)
+}
+
+func (b *BatchHolder) Read() []Batch {
+	b.Lock()
+	defer b.Unlock()
+	return append([]Batch(nil), b.Batches...)
+}
+
+func (b *BatchHolder) FindBatchById(batchId int) (*Batch, error) {
+	b.Lock()
+	defer b.Unlock()
+	batch, err := b.findBatchById(batchId)
+	if err != nil {
+		return nil, err
+	}
+	found := *batch
+	return &found, nil
+}
+
+// BatchStyle is used by the quality log to pick spec ranges of batch tasks
+func (b *BatchHolder) BatchStyle(batchId int) (string, bool) {
+	batch, err := b.FindBatchById(batchId)
+	if err != nil {
+		return "", false
+	}
+	return batch.Style, true
+}
+
+func (b *BatchHolder) findBatchById(batchId int) (*Batch, error) {
+	for i := range b.Batches {
+		if b.Batches[i].Id == batchId {
+			return &b.Batches[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// CreateBatch generates the production schedule from the recipe profile.
+// Profile defaults to the lowercased style and falls back to ale.
+func (b *BatchHolder) CreateBatch(batch Batch, user *users.User) (*Batch, error) {
+	if err := batch.Validate(); err != nil {
+		return nil, err
+	}
+	profile, err := resolveRecipeProfile(batch.Profile, batch.Style)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.latestId++
+	batch.Id = b.latestId
+	batch.Profile = profile.Name
+	batch.BrewDate = batch.BrewDate.Round(0)
+
+	tasks, err := b.taskHolder.CreateBatchTasks(batch.Id, profile.Steps, batch.vars(), batch.BrewDate, user)
+	if err != nil {
+		return nil, err
+	}
+	if b.FermenterLookup != nil {
+		if equipmentId, ok := b.FermenterLookup(batch.Fermenter); ok {
+			for _, task := range tasks {
+				if task.Category == Brewing {
+					b.taskHolder.SetTaskEquipment(task.Id, equipmentId)
+				}
+			}
+		}
+	}
+	batch.TaskIds = make([]int, len(tasks))
+	for i, task := range tasks {
+		batch.TaskIds[i] = task.Id
+	}
+
+	b.Batches = append(b.Batches, batch)
+	if err := b.save(); err != nil {
+		// a batch lost on restart would leave its tasks behind
+		b.Batches = b.Batches[:len(b.Batches)-1]
+		b.latestId--
+		for _, taskId := range batch.TaskIds {
+			b.taskHolder.DeleteTask(taskId)
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// RescheduleBatch moves the brew date and shifts all unfinished batch tasks by the same amount
+func (b *BatchHolder) RescheduleBatch(batchId int, brewDate time.Time) (*Batch, error) {
+	if brewDate.IsZero() {
+		return nil, &InvalidBatchValueError{Field: "brewDate"}
+	}
+	b.Lock()
+	defer b.Unlock()
+	batch, err := b.findBatchById(batchId)
+	if err != nil {
+		return nil, err
+	}
+
+	brewDate = brewDate.Round(0)
+	previous, delta := batch.BrewDate, brewDate.Sub(batch.BrewDate)
+	shifted := b.taskHolder.ShiftTasks(batch.TaskIds, delta)
+	batch.BrewDate = brewDate
+	if err := b.save(); err != nil {
+		// the saved brew date would not match the tasks after a restart
+		batch.BrewDate = previous
+		b.taskHolder.ShiftTasks(shifted, -delta)
+		return nil, err
+	}
+
+	rescheduled := *batch
+	return &rescheduled, nil
+}
+
+// BatchTasks returns tasks that belong to the batch, deleted tasks are skipped
+func (b *BatchHolder) BatchTasks(batchId int) ([]Task, error) {
+	batch, err := b.FindBatchById(batchId)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []Task
+	for _, task := range b.taskHolder.Read() {
+		if task.BatchId == batch.Id {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+func resolveRecipeProfile(name string, style string) (RecipeProfile, error) {
+	if name != "" {
+		profile, ok := RecipeProfiles[strings.ToLower(name)]
+		if !ok {
+			return RecipeProfile{}, &UnknownRecipeProfileError{Profile: name}
+		}
+		return profile, nil
+	}
+	if profile, ok := RecipeProfiles[strings.ToLower(style)]; ok {
+		return profile, nil
+	}
+	return RecipeProfiles["ale"], nil
+}
+
+func (t *TaskHolder) CreateBatchTasks(batchId int, steps []TaskTemplate, vars map[string]string, brewDate time.Time, user *users.User) ([]Task, error) {
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+	tasks := make([]Task, 0, len(steps))
+	for i := range steps {
+		task, err := t.newTaskFromTemplate(&steps[i], vars, brewDate, user)
+		if err != nil {
+			return nil, err
+		}
+		task.BatchId = batchId
+		tasks = append(tasks, task)
+	}
+	for i := range tasks {
+		t.latestId++
+		tasks[i].Id = t.latestId
+		t.Tasks = append(t.Tasks, tasks[i])
+		t.queueEvent(TaskCreated, &tasks[i], nil, nil)
+	}
+	return tasks, nil
+}
+
+// ShiftTasks moves planned time of unfinished tasks, finished tasks keep their
+// dates. It returns the ids of the moved tasks.
+func (t *TaskHolder) ShiftTasks(taskIds []int, delta time.Duration) []int {
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+	var shifted []int
+	for _, taskId := range taskIds {
+		task, err := t.FindTaskById(taskId)
+		if err != nil || task.Done {
+			continue
+		}
+		previous := task.clone()
+		task.PlannedAt = task.PlannedAt.Add(delta)
+		t.queueEvent(TaskUpdated, task, &previous, nil)
+		shifted = append(shifted, taskId)
+	}
+	return shifted
+}
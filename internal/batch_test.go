@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func provideBatch() Batch {
+	return Batch{
+		Style:        "Hazy IPA",
+		VolumeLiters: 500,
+		BrewDate:     time.Date(2030, 3, 10, 8, 0, 0, 0, time.UTC),
+		Fermenter:    "FV3",
+	}
+}
+
+func TestCreateBatch(t *testing.T) {
+	th := NewTaskHolder("")
+	bh, _ := NewBatchHolder("", th)
+
+	batch, err := bh.CreateBatch(provideBatch(), ProvideMockUser())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if batch.Id != 1 || batch.Profile != "ale" {
+		t.Errorf("Expected batch 1 with ale profile, got %d %s", batch.Id, batch.Profile)
+	}
+
+	steps := RecipeProfiles["ale"].Steps
+	if len(batch.TaskIds) != len(steps) {
+		t.Fatalf("Expected %d tasks, got %d", len(steps), len(batch.TaskIds))
+	}
+
+	tasks, _ := bh.BatchTasks(batch.Id)
+	for i, task := range tasks {
+		want := batch.BrewDate.Add(time.Duration(steps[i].OffsetHours) * time.Hour)
+		if !task.PlannedAt.Equal(want) {
+			t.Errorf("Task %q expected plannedAt %v, got %v", task.Msg, want, task.PlannedAt)
+		}
+		if task.BatchId != batch.Id {
+			t.Errorf("Expected task linked to batch %d, got %d", batch.Id, task.BatchId)
+		}
+	}
+	if tasks[1].Msg != "Brew 500 L of Hazy IPA in FV3" {
+		t.Errorf("Unexpected brew task msg %q", tasks[1].Msg)
+	}
+	if len(tasks[1].Checklist) == 0 {
+		t.Errorf("Expected brew task to have a checklist")
+	}
+}
+
+func TestCreateBatchValidation(t *testing.T) {
+	bh, _ := NewBatchHolder("", NewTaskHolder(""))
+
+	invalid := provideBatch()
+	invalid.VolumeLiters = 0
+	if _, err := bh.CreateBatch(invalid, nil); err == nil {
+		t.Errorf("Expected InvalidBatchValueError, got nil")
+	} else if e, ok := err.(*InvalidBatchValueError); !ok || e.Field != "volumeLiters" {
+		t.Errorf("Expected InvalidBatchValueError for volumeLiters, got %v", err)
+	}
+
+	unknown := provideBatch()
+	unknown.Profile = "kombucha"
+	if _, err := bh.CreateBatch(unknown, nil); err == nil {
+		t.Errorf("Expected UnknownRecipeProfileError, got nil")
+	} else if _, ok := err.(*UnknownRecipeProfileError); !ok {
+		t.Errorf("Expected UnknownRecipeProfileError, got %v", err)
+	}
+
+	lager := provideBatch()
+	lager.Style = "Lager"
+	batch, err := bh.CreateBatch(lager, nil)
+	if err != nil || batch.Profile != "lager" {
+		t.Errorf("Expected lager profile picked by style, got %v %v", batch, err)
+	}
+}
+
+func TestRescheduleBatch(t *testing.T) {
+	th := NewTaskHolder("")
+	bh, _ := NewBatchHolder("", th)
+	batch, _ := bh.CreateBatch(provideBatch(), nil)
+	before, _ := bh.BatchTasks(batch.Id)
+
+	// finished task keeps its date
+	th.PartialUpdateTask(batch.TaskIds[0], &TaskOptional{Done: BoolPtr(true)})
+
+	newBrewDate := batch.BrewDate.Add(72 * time.Hour)
+	rescheduled, err := bh.RescheduleBatch(batch.Id, newBrewDate)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !rescheduled.BrewDate.Equal(newBrewDate) {
+		t.Errorf("Expected brew date %v, got %v", newBrewDate, rescheduled.BrewDate)
+	}
+
+	after, _ := bh.BatchTasks(batch.Id)
+	if !after[0].PlannedAt.Equal(before[0].PlannedAt) {
+		t.Errorf("Expected done task to keep planned date %v, got %v", before[0].PlannedAt, after[0].PlannedAt)
+	}
+	for i := 1; i < len(after); i++ {
+		if !after[i].PlannedAt.Equal(before[i].PlannedAt.Add(72 * time.Hour)) {
+			t.Errorf("Task %q expected to move by 72h, got %v", after[i].Msg, after[i].PlannedAt)
+		}
+	}
+
+	if _, err := bh.RescheduleBatch(999, newBrewDate); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRescheduleBatchRollback(t *testing.T) {
+	th := NewTaskHolder("")
+	bh, _ := NewBatchHolder("", th)
+	batch, _ := bh.CreateBatch(provideBatch(), nil)
+	before, _ := bh.BatchTasks(batch.Id)
+
+	bh.DiskPath = filepath.Join(t.TempDir(), "missing", "batches.json")
+	if _, err := bh.RescheduleBatch(batch.Id, batch.BrewDate.Add(72*time.Hour)); err == nil {
+		t.Fatal("Expected save error, got nil")
+	}
+	if kept, _ := bh.FindBatchById(batch.Id); !kept.BrewDate.Equal(batch.BrewDate) {
+		t.Errorf("Expected brew date %v kept, got %v", batch.BrewDate, kept.BrewDate)
+	}
+	after, _ := bh.BatchTasks(batch.Id)
+	for i := range after {
+		if !after[i].PlannedAt.Equal(before[i].PlannedAt) {
+			t.Errorf("Task %q expected to keep %v, got %v", after[i].Msg, before[i].PlannedAt, after[i].PlannedAt)
+		}
+	}
+}
+
+func TestBatchHolderPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batches.json")
+	bh, err := NewBatchHolder(path, NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error for missing file, got %v", err)
+	}
+	bh.CreateBatch(provideBatch(), nil)
+
+	reloaded, err := NewBatchHolder(path, NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	batches := reloaded.Read()
+	if len(batches) != 1 || batches[0].Style != "Hazy IPA" {
+		t.Errorf("Unexpected batches after reload %v", batches)
+	}
+	next, _ := reloaded.CreateBatch(provideBatch(), nil)
+	if next.Id != 2 {
+		t.Errorf("Expected next batch id 2, got %d", next.Id)
+	}
+}
+
+func TestCreateBatchRollback(t *testing.T) {
+	th := NewTaskHolder("")
+	bh, _ := NewBatchHolder(filepath.Join(t.TempDir(), "missing", "batches.json"), th)
+
+	if _, err := bh.CreateBatch(provideBatch(), nil); err == nil {
+		t.Fatal("Expected save error, got nil")
+	}
+	if len(bh.Read()) != 0 || len(th.Read()) != 0 {
+		t.Errorf("Expected no batch and no tasks left, got %v %v", bh.Read(), th.Read())
+	}
+
+	bh.DiskPath = ""
+	if batch, _ := bh.CreateBatch(provideBatch(), nil); batch == nil || batch.Id != 1 {
+		t.Errorf("Expected batch id 1 reused, got %+v", batch)
+	}
+}
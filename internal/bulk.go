@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+type BulkOp string
+
+const (
+	BulkCreate BulkOp = "create"
+	BulkUpdate BulkOp = "update"
+	BulkDelete BulkOp = "delete"
+)
+
+const MaxBulkOperations = 500
+
+// ErrBulkRolledBack is the result of operations undone because another
+// operation of an atomic bulk failed.
+var ErrBulkRolledBack = errors.New("not applied, bulk was rolled back")
+
+type InvalidBulkError struct {
+	Reason string
+}
+
+func (e *InvalidBulkError) Error() string {
+	return "invalid bulk: " + e.Reason
+}
+
+// BulkOperationError is returned when an atomic bulk fails at operation Index
+type BulkOperationError struct {
+	Index int
+	Err   error
+}
+
+func (e *BulkOperationError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BulkOperationError) Unwrap() error {
+	return e.Err
+}
+
+// BulkOperation creates a task, or updates or deletes the task Id. Version
+// works like If-Match, nil applies to any version.
+type BulkOperation struct {
+	Op      BulkOp       `json:"op"`
+	Id      int          `json:"id,omitempty"`
+	Version *int         `json:"version,omitempty"`
+	Task    TaskOptional `json:"task"`
+}
+
+// BulkResult has the created or updated task, or Err when the operation failed
+type BulkResult struct {
+	Op   BulkOp
+	Id   int
+	Task *Task
+	Err  error
+}
+
+// BulkAuthorizer may refuse an operation on task, nil for creates. It is
+// called with the holder lock held and must not call back into the holder.
+type BulkAuthorizer func(operation BulkOperation, task *Task) error
+
+// ApplyBulk runs operations in order under one lock. Every operation is first
+// checked with authorize, when set, and any refusal is returned before a change
+// is made. Operations on missing tasks fail with ErrNotFound. Without atomic
+// every operation succeeds or fails on its own; with atomic the first failure
+// undoes all changes and is returned as BulkOperationError. Listeners get
+// events only for applied changes.
+func (t *TaskHolder) ApplyBulk(operations []BulkOperation, atomic bool, authorize BulkAuthorizer) ([]BulkResult, error) {
+	if len(operations) == 0 {
+		return nil, &InvalidBulkError{Reason: "no operations"}
+	}
+	if len(operations) > MaxBulkOperations {
+		return nil, &InvalidBulkError{Reason: fmt.Sprintf("more than %d operations", MaxBulkOperations)}
+	}
+
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+
+	results := make([]BulkResult, len(operations))
+	if err := t.authorizeBulk(operations, results, authorize); err != nil {
+		return nil, err
+	}
+	var rollback func()
+	if atomic {
+		rollback = t.snapshot()
+	}
+	for i, operation := range operations {
+		if results[i].Err == nil {
+			results[i] = t.applyBulkOperation(operation)
+		}
+		if atomic && results[i].Err != nil {
+			rollback()
+			for j := range results {
+				if j != i {
+					results[j] = BulkResult{Op: operations[j].Op, Id: operations[j].Id, Err: ErrBulkRolledBack}
+				}
+			}
+			return results, &BulkOperationError{Index: i, Err: results[i].Err}
+		}
+	}
+	return results, nil
+}
+
+// authorizeBulk checks every operation before any is applied and sets
+// ErrNotFound as the result of operations on missing tasks. Caller holds the lock.
+func (t *TaskHolder) authorizeBulk(operations []BulkOperation, results []BulkResult, authorize BulkAuthorizer) error {
+	for i, operation := range operations {
+		var task *Task
+		if operation.Op == BulkUpdate || operation.Op == BulkDelete {
+			found, err := t.FindTaskById(operation.Id)
+			if err != nil {
+				results[i] = BulkResult{Op: operation.Op, Id: operation.Id, Err: err}
+				continue
+			}
+			cloned := found.clone()
+			task = &cloned
+		}
+		if authorize == nil {
+			continue
+		}
+		if err := authorize(operation, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// caller holds the lock
+func (t *TaskHolder) applyBulkOperation(operation BulkOperation) BulkResult {
+	result := BulkResult{Op: operation.Op, Id: operation.Id}
+	expectedVersion := AnyVersion
+	if operation.Version != nil {
+		expectedVersion = *operation.Version
+	}
+
+	switch operation.Op {
+	case BulkCreate:
+		if result.Err = ValidateNewTask(&operation.Task); result.Err == nil {
+			task := t.createTask(operation.Task)
+			result.Id = task.Id
+			result.Task = task
+		}
+	case BulkUpdate:
+		var task *Task
+		if task, result.Err = t.findTaskWithVersion(operation.Id, expectedVersion); result.Err == nil {
+			if result.Err = t.applyUpdate(task, &operation.Task); result.Err == nil {
+				updated := task.clone()
+				result.Task = &updated
+			}
+		}
+	case BulkDelete:
+		result.Err = t.deleteTask(operation.Id, expectedVersion)
+	default:
+		result.Err = &InvalidBulkError{Reason: fmt.Sprintf("unknown op %q", operation.Op)}
+	}
+	return result
+}
+
+// snapshot returns func restoring tasks, ids, revision and queued events to
+// their current state. Caller holds the lock.
+func (t *TaskHolder) snapshot() func() {
+	tasks := make([]Task, len(t.Tasks))
+	for i := range t.Tasks {
+		tasks[i] = t.Tasks[i].clone()
+	}
+	latestId, revision, pending := t.latestId, t.revision, len(t.pendingEvents)
+	return func() {
+		t.Tasks = tasks
+		t.latestId = latestId
+		t.revision = revision
+		t.pendingEvents = t.pendingEvents[:pending]
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyBulk(t *testing.T) {
+	th := NewTaskHolder("")
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA"), Category: CategoryPtr(Brewing)})
+	th.CreateTask(TaskOptional{Msg: StringPtr("Keg IPA"), Category: CategoryPtr(Marketing)})
+	var events []TaskEvent
+	th.Subscribe(func(event TaskEvent) { events = append(events, event) })
+
+	results, err := th.ApplyBulk([]BulkOperation{
+		{Op: BulkCreate, Task: TaskOptional{Msg: StringPtr("Clean FV3")}},
+		{Op: BulkUpdate, Id: 1, Task: TaskOptional{Done: BoolPtr(true)}},
+		{Op: BulkDelete, Id: 2},
+		{Op: BulkDelete, Id: 42},
+		{Op: BulkCreate, Task: TaskOptional{Msg: StringPtr("")}},
+	}, false, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if results[0].Id != 3 || !results[1].Task.Done || results[2].Err != nil {
+		t.Errorf("Unexpected results %+v", results)
+	}
+	if !errors.Is(results[3].Err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", results[3].Err)
+	}
+	var emptyErr *EmptyTaskValueError
+	if !errors.As(results[4].Err, &emptyErr) {
+		t.Errorf("Expected EmptyTaskValueError, got %v", results[4].Err)
+	}
+	if len(events) != 3 || len(th.Tasks) != 2 {
+		t.Errorf("Expected 3 events and 2 tasks, got %d and %d", len(events), len(th.Tasks))
+	}
+}
+
+func TestApplyBulkAtomic(t *testing.T) {
+	th := NewTaskHolder("")
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA"), Category: CategoryPtr(Brewing)})
+	var events []TaskEvent
+	th.Subscribe(func(event TaskEvent) { events = append(events, event) })
+	revision := th.Revision()
+
+	results, err := th.ApplyBulk([]BulkOperation{
+		{Op: BulkUpdate, Id: 1, Task: TaskOptional{Done: BoolPtr(true)}},
+		{Op: BulkCreate, Task: TaskOptional{Msg: StringPtr("Clean FV3")}},
+		{Op: BulkDelete, Id: 1, Version: IntPtr(1)},
+		{Op: BulkDelete, Id: 1},
+	}, true, nil)
+
+	var operationErr *BulkOperationError
+	var versionErr *VersionMismatchError
+	if !errors.As(err, &operationErr) || operationErr.Index != 2 || !errors.As(err, &versionErr) {
+		t.Fatalf("Expected version mismatch at operation 2, got %v", err)
+	}
+	for i, result := range results {
+		if i != 2 && !errors.Is(result.Err, ErrBulkRolledBack) {
+			t.Errorf("Expected operation %d rolled back, got %v", i, result.Err)
+		}
+	}
+	task, _ := th.GetTask(1)
+	latestId, count := th.Count()
+	if task.Done || task.Version != 1 || latestId != 1 || count != 1 || th.Revision() != revision || len(events) != 0 {
+		t.Errorf("Expected no changes after rollback, got task %+v, latestId %d, count %d, events %d", task, latestId, count, len(events))
+	}
+
+	if _, err := th.ApplyBulk([]BulkOperation{{Op: BulkDelete, Id: 1}, {Op: BulkCreate, Task: TaskOptional{Msg: StringPtr("Clean FV3")}}}, true, nil); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(events) != 2 || len(th.Tasks) != 1 || th.Tasks[0].Id != 2 {
+		t.Errorf("Expected delete and create applied, got %v", th.Tasks)
+	}
+}
+
+func TestApplyBulkAuthorize(t *testing.T) {
+	th := NewTaskHolder("")
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+	var checked []string
+	authorize := func(operation BulkOperation, task *Task) error {
+		if task != nil {
+			checked = append(checked, task.Msg)
+		}
+		if operation.Op == BulkDelete {
+			return errors.New("may not delete")
+		}
+		return nil
+	}
+
+	_, err := th.ApplyBulk([]BulkOperation{
+		{Op: BulkUpdate, Id: 1, Task: TaskOptional{Done: BoolPtr(true)}},
+		{Op: BulkDelete, Id: 1},
+	}, false, authorize)
+	if err == nil || len(checked) != 2 {
+		t.Fatalf("Expected the refusal after checking both tasks, got %v, checked %v", err, checked)
+	}
+	if task, _ := th.GetTask(1); task.Done {
+		t.Errorf("Expected a refused bulk to change nothing, got %+v", task)
+	}
+
+	results, err := th.ApplyBulk([]BulkOperation{
+		{Op: BulkUpdate, Id: 1, Task: TaskOptional{Done: BoolPtr(true)}},
+		{Op: BulkUpdate, Id: 42, Task: TaskOptional{Done: BoolPtr(true)}},
+	}, true, authorize)
+	var operationErr *BulkOperationError
+	if !errors.As(err, &operationErr) || operationErr.Index != 1 || !errors.Is(results[1].Err, ErrNotFound) {
+		t.Fatalf("Expected missing task reported, got %v, %+v", err, results)
+	}
+	if task, _ := th.GetTask(1); task.Done {
+		t.Errorf("Expected atomic bulk with a missing task to change nothing, got %+v", task)
+	}
+}
+
+func TestApplyBulkInvalid(t *testing.T) {
+	th := NewTaskHolder("")
+	var bulkErr *InvalidBulkError
+	for _, operations := range [][]BulkOperation{nil, make([]BulkOperation, MaxBulkOperations+1)} {
+		if _, err := th.ApplyBulk(operations, false, nil); !errors.As(err, &bulkErr) {
+			t.Errorf("Expected InvalidBulkError for %d operations, got %v", len(operations), err)
+		}
+	}
+	results, _ := th.ApplyBulk([]BulkOperation{{Op: "archive", Id: 1}}, false, nil)
+	if !errors.As(results[0].Err, &bulkErr) {
+		t.Errorf("Expected InvalidBulkError for unknown op, got %v", results[0].Err)
+	}
+}
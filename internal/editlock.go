@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultEditLockTTL bounds locks of clients that vanished without closing
+// their connection, editors refresh the lock well within it
+const DefaultEditLockTTL = 2 * time.Minute
+
+// TaskLockedError is returned for a task another user is editing
+type TaskLockedError struct {
+	TaskId   int
+	UserName string
+}
+
+func (e *TaskLockedError) Error() string {
+	return fmt.Sprintf("task %d is being edited by %s", e.TaskId, e.UserName)
+}
+
+type editLock struct {
+	userId    string
+	userName  string
+	holder    int
+	expiresAt time.Time
+}
+
+// EditLocks are soft locks of the web update form. They only keep other
+// users of the form from overwriting each other, the API ignores them.
+// Locks are kept in memory and released when their holder disconnects.
+type EditLocks struct {
+	ttl   time.Duration
+	now   func() time.Time
+	locks map[int]*editLock
+	sync.Mutex
+}
+
+func NewEditLocks(ttl time.Duration) *EditLocks {
+	return &EditLocks{
+		ttl:   ttl,
+		now:   time.Now,
+		locks: make(map[int]*editLock),
+	}
+}
+
+// Acquire locks the task for the user or refreshes the user's lock, holder
+// identifies the connection so its locks can be released together
+func (l *EditLocks) Acquire(taskId int, userId string, userName string, holder int) error {
+	l.Lock()
+	defer l.Unlock()
+	if lock := l.active(taskId); lock != nil && lock.userId != userId {
+		return &TaskLockedError{TaskId: taskId, UserName: lock.userName}
+	}
+	l.locks[taskId] = &editLock{userId: userId, userName: userName, holder: holder, expiresAt: l.now().Add(l.ttl)}
+	return nil
+}
+
+// Release unlocks the task if holder has it locked
+func (l *EditLocks) Release(taskId int, holder int) bool {
+	l.Lock()
+	defer l.Unlock()
+	if lock, ok := l.locks[taskId]; ok && lock.holder == holder {
+		delete(l.locks, taskId)
+		return true
+	}
+	return false
+}
+
+// ReleaseHolder unlocks all tasks of holder and returns their ids
+func (l *EditLocks) ReleaseHolder(holder int) []int {
+	l.Lock()
+	defer l.Unlock()
+	var released []int
+	for taskId, lock := range l.locks {
+		if lock.holder == holder {
+			delete(l.locks, taskId)
+			released = append(released, taskId)
+		}
+	}
+	return released
+}
+
+// LockedBy returns name of the user editing the task
+func (l *EditLocks) LockedBy(taskId int) (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if lock := l.active(taskId); lock != nil {
+		return lock.userName, true
+	}
+	return "", false
+}
+
+// CheckEdit returns TaskLockedError when another user holds the lock
+func (l *EditLocks) CheckEdit(taskId int, userId string) error {
+	l.Lock()
+	defer l.Unlock()
+	if lock := l.active(taskId); lock != nil && lock.userId != userId {
+		return &TaskLockedError{TaskId: taskId, UserName: lock.userName}
+	}
+	return nil
+}
+
+// active returns the unexpired lock of the task, caller holds the mutex
+func (l *EditLocks) active(taskId int) *editLock {
+	lock, ok := l.locks[taskId]
+	if !ok {
+		return nil
+	}
+	if !l.now().Before(lock.expiresAt) {
+		delete(l.locks, taskId)
+		return nil
+	}
+	return lock
+}
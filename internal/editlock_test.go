@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEditLocks(t *testing.T) {
+	locks := NewEditLocks(time.Minute)
+
+	if err := locks.Acquire(14, "anna-id", "Anna", 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := locks.Acquire(14, "anna-id", "Anna", 2); err != nil {
+		t.Errorf("Expected same user to take over the lock, got %v", err)
+	}
+
+	var lockedErr *TaskLockedError
+	err := locks.Acquire(14, "bob-id", "Bob", 3)
+	if !errors.As(err, &lockedErr) || lockedErr.UserName != "Anna" || lockedErr.TaskId != 14 {
+		t.Errorf("Expected task locked by Anna, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userId  string
+		wantErr bool
+	}{
+		{"lock owner", "anna-id", false},
+		{"other user", "bob-id", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := locks.CheckEdit(14, tt.userId); (err != nil) != tt.wantErr {
+				t.Errorf("CheckEdit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if locks.Release(14, 1) {
+		t.Error("Expected release by previous holder to be ignored")
+	}
+	if name, ok := locks.LockedBy(14); !ok || name != "Anna" {
+		t.Errorf("Expected task locked by Anna, got %q %v", name, ok)
+	}
+	if !locks.Release(14, 2) {
+		t.Error("Expected release by holder")
+	}
+	if err := locks.CheckEdit(14, "bob-id"); err != nil {
+		t.Errorf("Expected released task editable, got %v", err)
+	}
+}
+
+func TestEditLocksReleaseHolder(t *testing.T) {
+	locks := NewEditLocks(time.Minute)
+	locks.Acquire(1, "anna-id", "Anna", 7)
+	locks.Acquire(2, "anna-id", "Anna", 7)
+	locks.Acquire(3, "bob-id", "Bob", 8)
+
+	released := locks.ReleaseHolder(7)
+	if len(released) != 2 {
+		t.Errorf("Expected 2 released locks, got %v", released)
+	}
+	for taskId, want := range map[int]bool{1: false, 2: false, 3: true} {
+		if _, ok := locks.LockedBy(taskId); ok != want {
+			t.Errorf("Expected task %d locked %v, got %v", taskId, want, ok)
+		}
+	}
+}
+
+func TestEditLocksExpire(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	locks := NewEditLocks(time.Minute)
+	locks.now = func() time.Time { return now }
+
+	locks.Acquire(14, "anna-id", "Anna", 1)
+	now = now.Add(30 * time.Second)
+	locks.Acquire(14, "anna-id", "Anna", 1)
+	now = now.Add(45 * time.Second)
+	if _, ok := locks.LockedBy(14); !ok {
+		t.Error("Expected refreshed lock to be active")
+	}
+
+	now = now.Add(time.Minute)
+	if err := locks.Acquire(14, "bob-id", "Bob", 2); err != nil {
+		t.Errorf("Expected expired lock to be taken over, got %v", err)
+	}
+}
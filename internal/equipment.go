@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type EquipmentType string
+
+const (
+	Fermenter EquipmentType = "fermenter"
+	Keg       EquipmentType = "keg"
+	Vehicle   EquipmentType = "vehicle"
+)
+
+type EquipmentStatus string
+
+const (
+	Available EquipmentStatus = "available"
+	Down      EquipmentStatus = "down"
+)
+
+// maintenance task is being created, id is not known yet
+const maintenancePending = -1
+
+type InvalidEquipmentValueError struct {
+	Field string
+}
+
+func (e *InvalidEquipmentValueError) Error() string {
+	return fmt.Sprintf("invalid equipment value: %s", e.Field)
+}
+
+type EquipmentDownError struct {
+	Equipment string
+}
+
+func (e *EquipmentDownError) Error() string {
+	return fmt.Sprintf("equipment %s is down", e.Equipment)
+}
+
+// Equipment with MaintenanceIntervalDays gets a maintenance task planned
+// interval days after the last maintenance. MaintenanceTaskId is the open one.
+type Equipment struct {
+	Id                      int             `json:"id"`
+	Name                    string          `json:"name"`
+	Type                    EquipmentType   `json:"type"`
+	Status                  EquipmentStatus `json:"status"`
+	StatusNote              string          `json:"statusNote,omitempty"`
+	MaintenanceIntervalDays int             `json:"maintenanceIntervalDays"`
+	LastMaintainedAt        time.Time       `json:"lastMaintainedAt"`
+	MaintenanceTaskId       int             `json:"maintenanceTaskId,omitempty"`
+}
+
+func (e *Equipment) Validate() error {
+	if strings.TrimSpace(e.Name) == "" {
+		return &InvalidEquipmentValueError{Field: "name"}
+	}
+	switch e.Type {
+	case Fermenter, Keg, Vehicle:
+	default:
+		return &InvalidEquipmentValueError{Field: "type"}
+	}
+	if e.MaintenanceIntervalDays < 0 {
+		return &InvalidEquipmentValueError{Field: "maintenanceIntervalDays"}
+	}
+	return nil
+}
+
+func (e *Equipment) maintenanceCategory() TaskCategory {
+	if e.Type == Fermenter {
+		return Brewing
+	}
+	return Logistics
+}
+
+type MaintenanceRecord struct {
+	Id          int        `json:"id"`
+	EquipmentId int        `json:"equipmentId"`
+	TaskId      int        `json:"taskId"`
+	Msg         string     `json:"msg"`
+	PerformedAt time.Time  `json:"performedAt"`
+	PerformedBy users.User `json:"performedBy"`
+}
+
+// EquipmentRegistry never calls the TaskHolder while holding its own lock,
+// the holder calls back into the registry to check for equipment downtime.
+type EquipmentRegistry struct {
+	latestId       int
+	latestRecordId int
+	Equipment      []Equipment         `json:"equipment"`
+	History        []MaintenanceRecord `json:"history"`
+	DiskPath       string              `json:"-"`
+	taskHolder     *TaskHolder
+	sync.Mutex
+}
+
+func NewEquipmentRegistry(diskPath string, taskHolder *TaskHolder) (*EquipmentRegistry, error) {
+	registry := &EquipmentRegistry{DiskPath: diskPath, taskHolder: taskHolder}
+	if diskPath != "" {
+		err := loadJsonFile(diskPath, registry)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	for _, equipment := range registry.Equipment {
+		registry.latestId = max(registry.latestId, equipment.Id)
+	}
+	for _, record := range registry.History {
+		registry.latestRecordId = max(registry.latestRecordId, record.Id)
+	}
+	taskHolder.Subscribe(registry.handleTaskEvent)
+	taskHolder.AddValidator(registry.checkTaskEquipment)
+	return registry, nil
+}
+
+func (reg *EquipmentRegistry) save() error {
+	if reg.DiskPath == "" {
+		return nil
+	}
+	return saveJsonFile(reg.DiskPath, reg)
+}
+
+func (reg *EquipmentRegistry) Read() []Equipment {
+	reg.Lock()
+	defer reg.Unlock()
+	return append([]Equipment(nil), reg.Equipment...)
+}
+
+func (reg *EquipmentRegistry) FindEquipmentById(equipmentId int) (*Equipment, error) {
+	reg.Lock()
+	defer reg.Unlock()
+	equipment, err := reg.findEquipmentById(equipmentId)
+	if err != nil {
+		return nil, err
+	}
+	found := *equipment
+	return &found, nil
+}
+
+func (reg *EquipmentRegistry) findEquipmentById(equipmentId int) (*Equipment, error) {
+	for i := range reg.Equipment {
+		if reg.Equipment[i].Id == equipmentId {
+			return &reg.Equipment[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// FindFermenterByName is used by batches to link brewing tasks to the fermenter
+func (reg *EquipmentRegistry) FindFermenterByName(name string) (int, bool) {
+	reg.Lock()
+	defer reg.Unlock()
+	for _, equipment := range reg.Equipment {
+		if equipment.Type == Fermenter && strings.EqualFold(equipment.Name, strings.TrimSpace(name)) {
+			return equipment.Id, true
+		}
+	}
+	return 0, false
+}
+
+func (reg *EquipmentRegistry) AddEquipment(equipment Equipment) (*Equipment, error) {
+	if err := equipment.Validate(); err != nil {
+		return nil, err
+	}
+	reg.Lock()
+	reg.latestId++
+	equipment.Id = reg.latestId
+	equipment.Status = Available
+	equipment.MaintenanceTaskId = 0
+	if equipment.LastMaintainedAt.IsZero() {
+		equipment.LastMaintainedAt = timeNow().Round(0)
+	}
+	reg.Equipment = append(reg.Equipment, equipment)
+	err := reg.save()
+	reg.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	reg.scheduleMaintenance(equipment.Id)
+	return reg.FindEquipmentById(equipment.Id)
+}
+
+func (reg *EquipmentRegistry) SetStatus(equipmentId int, status EquipmentStatus, note string) (*Equipment, error) {
+	if status != Available && status != Down {
+		return nil, &InvalidEquipmentValueError{Field: "status"}
+	}
+	reg.Lock()
+	defer reg.Unlock()
+	equipment, err := reg.findEquipmentById(equipmentId)
+	if err != nil {
+		return nil, err
+	}
+	equipment.Status = status
+	equipment.StatusNote = note
+	updated := *equipment
+	return &updated, reg.save()
+}
+
+// AssignTask makes the task depend on the equipment
+func (reg *EquipmentRegistry) AssignTask(equipmentId int, taskId int) error {
+	if _, err := reg.FindEquipmentById(equipmentId); err != nil {
+		return err
+	}
+	return reg.taskHolder.SetTaskEquipment(taskId, equipmentId)
+}
+
+// BlockedTasks returns unfinished brewing tasks waiting for the equipment
+func (reg *EquipmentRegistry) BlockedTasks(equipmentId int) ([]Task, error) {
+	equipment, err := reg.FindEquipmentById(equipmentId)
+	if err != nil {
+		return nil, err
+	}
+	var blocked []Task
+	if equipment.Status != Down {
+		return blocked, nil
+	}
+	for _, task := range reg.taskHolder.Read() {
+		if task.EquipmentId == equipmentId && task.Category == Brewing && !task.Done {
+			blocked = append(blocked, task)
+		}
+	}
+	return blocked, nil
+}
+
+// MaintenanceHistory returns records of the equipment, oldest first
+func (reg *EquipmentRegistry) MaintenanceHistory(equipmentId int) ([]MaintenanceRecord, error) {
+	reg.Lock()
+	defer reg.Unlock()
+	if _, err := reg.findEquipmentById(equipmentId); err != nil {
+		return nil, err
+	}
+	var history []MaintenanceRecord
+	for _, record := range reg.History {
+		if record.EquipmentId == equipmentId {
+			history = append(history, record)
+		}
+	}
+	return history, nil
+}
+
+// checkTaskEquipment refuses to finish a brewing task while its equipment is down
+func (reg *EquipmentRegistry) checkTaskEquipment(task Task, update *TaskOptional) error {
+	if task.EquipmentId == 0 || task.Category != Brewing || update.Done == nil || !*update.Done {
+		return nil
+	}
+	reg.Lock()
+	defer reg.Unlock()
+	equipment, err := reg.findEquipmentById(task.EquipmentId)
+	if err != nil || equipment.Status != Down {
+		return nil
+	}
+	return &EquipmentDownError{Equipment: equipment.Name}
+}
+
+func (reg *EquipmentRegistry) scheduleMaintenance(equipmentId int) {
+	reg.Lock()
+	equipment, err := reg.findEquipmentById(equipmentId)
+	if err != nil || equipment.MaintenanceIntervalDays == 0 || equipment.MaintenanceTaskId != 0 {
+		reg.Unlock()
+		return
+	}
+	equipment.MaintenanceTaskId = maintenancePending
+	msg := fmt.Sprintf("Maintain %s %s", equipment.Type, equipment.Name)
+	category := equipment.maintenanceCategory()
+	plannedAt := equipment.LastMaintainedAt.AddDate(0, 0, equipment.MaintenanceIntervalDays)
+	reg.Unlock()
+
+	task := reg.taskHolder.CreateTask(TaskOptional{
+		Msg:       &msg,
+		Category:  &category,
+		PlannedAt: TimePtr(plannedAt),
+	})
+
+	reg.Lock()
+	defer reg.Unlock()
+	if equipment, err := reg.findEquipmentById(equipmentId); err == nil {
+		equipment.MaintenanceTaskId = task.Id
+		reg.save()
+	}
+}
+
+func (reg *EquipmentRegistry) handleTaskEvent(event TaskEvent) {
+	switch event.Type {
+	case TaskUpdated:
+		if event.Task.Done && event.Previous != nil && !event.Previous.Done {
+			if equipmentId, ok := reg.recordMaintenance(event.Task, event.By); ok {
+				reg.scheduleMaintenance(equipmentId)
+			}
+		}
+	case TaskDeleted:
+		reg.Lock()
+		defer reg.Unlock()
+		for i := range reg.Equipment {
+			if reg.Equipment[i].MaintenanceTaskId == event.Task.Id {
+				reg.Equipment[i].MaintenanceTaskId = 0
+				reg.save()
+			}
+		}
+	}
+}
+
+// recordMaintenance adds the finished maintenance task to the history of its
+// equipment, performed by the user who finished it
+func (reg *EquipmentRegistry) recordMaintenance(task Task, user *users.User) (int, bool) {
+	if user == nil {
+		user = &users.User{UserName: "Team"}
+	}
+	reg.Lock()
+	defer reg.Unlock()
+	for i := range reg.Equipment {
+		equipment := &reg.Equipment[i]
+		if equipment.MaintenanceTaskId != task.Id {
+			continue
+		}
+		now := timeNow().Round(0)
+		reg.latestRecordId++
+		reg.History = append(reg.History, MaintenanceRecord{
+			Id:          reg.latestRecordId,
+			EquipmentId: equipment.Id,
+			TaskId:      task.Id,
+			Msg:         task.Msg,
+			PerformedAt: now,
+			PerformedBy: *user,
+		})
+		equipment.LastMaintainedAt = now
+		equipment.MaintenanceTaskId = 0
+		reg.save()
+		return equipment.Id, true
+	}
+	return 0, false
+}
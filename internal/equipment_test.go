@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func provideEquipmentRegistry(t *testing.T) (*EquipmentRegistry, *TaskHolder) {
+	th := NewTaskHolder("")
+	registry, err := NewEquipmentRegistry("", th)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return registry, th
+}
+
+func TestEquipmentValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		equipment Equipment
+		wantErr   bool
+	}{
+		{"valid fermenter", Equipment{Name: "FV1", Type: Fermenter}, false},
+		{"valid vehicle", Equipment{Name: "Van", Type: Vehicle, MaintenanceIntervalDays: 90}, false},
+		{"empty name", Equipment{Name: " ", Type: Keg}, true},
+		{"unknown type", Equipment{Name: "Mill", Type: "mill"}, true},
+		{"negative interval", Equipment{Name: "FV1", Type: Fermenter, MaintenanceIntervalDays: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.equipment.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaintenanceSchedule(t *testing.T) {
+	registry, th := provideEquipmentRegistry(t)
+	lastMaintained := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	van, err := registry.AddEquipment(Equipment{Name: "Van", Type: Vehicle, MaintenanceIntervalDays: 90, LastMaintainedAt: lastMaintained})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if van.MaintenanceTaskId == 0 {
+		t.Fatalf("Expected maintenance task to be created")
+	}
+
+	task, _ := th.FindTaskById(van.MaintenanceTaskId)
+	if task.Msg != "Maintain vehicle Van" || task.Category != Logistics {
+		t.Errorf("Unexpected maintenance task %v", task)
+	}
+	if !task.PlannedAt.Equal(lastMaintained.AddDate(0, 0, 90)) {
+		t.Errorf("Expected task planned at %v, got %v", lastMaintained.AddDate(0, 0, 90), task.PlannedAt)
+	}
+
+	mechanic := &users.User{UserName: "mechanic"}
+	if err := th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true), UpdatedBy: mechanic}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	history, _ := registry.MaintenanceHistory(van.Id)
+	if len(history) != 1 || history[0].TaskId != task.Id {
+		t.Fatalf("Expected one maintenance record for task %d, got %v", task.Id, history)
+	}
+	if history[0].PerformedBy.UserName != "mechanic" {
+		t.Errorf("Expected maintenance performed by mechanic, got %v", history[0].PerformedBy.UserName)
+	}
+
+	van, _ = registry.FindEquipmentById(van.Id)
+	if van.MaintenanceTaskId == 0 || van.MaintenanceTaskId == task.Id {
+		t.Errorf("Expected next maintenance task, got %d", van.MaintenanceTaskId)
+	}
+	if !van.LastMaintainedAt.Equal(history[0].PerformedAt) {
+		t.Errorf("Expected last maintenance %v, got %v", history[0].PerformedAt, van.LastMaintainedAt)
+	}
+}
+
+func TestMaintenanceTaskDeleted(t *testing.T) {
+	registry, th := provideEquipmentRegistry(t)
+	keg, _ := registry.AddEquipment(Equipment{Name: "Keg 7", Type: Keg, MaintenanceIntervalDays: 30})
+
+	th.DeleteTask(keg.MaintenanceTaskId)
+	keg, _ = registry.FindEquipmentById(keg.Id)
+	if keg.MaintenanceTaskId != 0 {
+		t.Errorf("Expected maintenance task to be unlinked, got %d", keg.MaintenanceTaskId)
+	}
+}
+
+func TestDownEquipmentBlocksTasks(t *testing.T) {
+	registry, th := provideEquipmentRegistry(t)
+	fermenter, _ := registry.AddEquipment(Equipment{Name: "FV1", Type: Fermenter})
+	brew := th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA in FV1"), Category: CategoryPtr(Brewing)})
+	check := th.CreateTask(TaskOptional{Msg: StringPtr("Check gravity in FV1"), Category: CategoryPtr(Quality)})
+	for _, taskId := range []int{brew.Id, check.Id} {
+		if err := registry.AssignTask(fermenter.Id, taskId); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if _, err := registry.SetStatus(fermenter.Id, Down, "Leaking valve"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	blocked, _ := registry.BlockedTasks(fermenter.Id)
+	if len(blocked) != 1 || blocked[0].Id != brew.Id {
+		t.Errorf("Expected brewing task to be blocked, got %v", blocked)
+	}
+
+	var downErr *EquipmentDownError
+	err := th.PartialUpdateTask(brew.Id, &TaskOptional{Done: BoolPtr(true)})
+	if !errors.As(err, &downErr) {
+		t.Errorf("Expected EquipmentDownError, got %v", err)
+	}
+	if err := th.PartialUpdateTask(check.Id, &TaskOptional{Done: BoolPtr(true)}); err != nil {
+		t.Errorf("Expected quality task to be done, got %v", err)
+	}
+
+	registry.SetStatus(fermenter.Id, Available, "")
+	if err := th.PartialUpdateTask(brew.Id, &TaskOptional{Done: BoolPtr(true)}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestBatchTasksLinkedToFermenter(t *testing.T) {
+	registry, th := provideEquipmentRegistry(t)
+	fermenter, _ := registry.AddEquipment(Equipment{Name: "FV2", Type: Fermenter})
+	bh, _ := NewBatchHolder("", th)
+	bh.FermenterLookup = registry.FindFermenterByName
+
+	batch, err := bh.CreateBatch(Batch{Style: "Ale", VolumeLiters: 500, BrewDate: timeNow().Add(48 * time.Hour), Fermenter: "fv2"}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tasks, _ := bh.BatchTasks(batch.Id)
+	for _, task := range tasks {
+		linked := task.EquipmentId == fermenter.Id
+		if linked != (task.Category == Brewing) {
+			t.Errorf("Unexpected equipment link %d for task %q", task.EquipmentId, task.Msg)
+		}
+	}
+}
+
+func TestEquipmentRegistryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "equipment.json")
+	registry, err := NewEquipmentRegistry(path, NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	registry.AddEquipment(Equipment{Name: "FV1", Type: Fermenter})
+	registry.SetStatus(1, Down, "CIP")
+
+	reloaded, err := NewEquipmentRegistry(path, NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	equipment, err := reloaded.FindEquipmentById(1)
+	if err != nil || equipment.Status != Down || equipment.StatusNote != "CIP" {
+		t.Errorf("Unexpected reloaded equipment %v, err %v", equipment, err)
+	}
+	added, _ := reloaded.AddEquipment(Equipment{Name: "FV2", Type: Fermenter})
+	if added.Id != 2 {
+		t.Errorf("Expected id 2, got %d", added.Id)
+	}
+}
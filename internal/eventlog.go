@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"sync"
+)
+
+const DefaultEventLogSize = 256
+
+// LoggedTaskEvent is a task event numbered in the order the log received it
+type LoggedTaskEvent struct {
+	Id int
+	TaskEvent
+}
+
+// Matches reports whether the event concerns tasks selected by the query. An
+// update matches when the task matched before or after it, so a stream
+// filtered by category still learns that a task moved out of the category.
+func (e *LoggedTaskEvent) Matches(query *TaskQuery) bool {
+	if query.matches(&e.Task) {
+		return true
+	}
+	return e.Previous != nil && query.matches(e.Previous)
+}
+
+// TaskEventLog keeps the latest task events in a bounded buffer and fans them
+// out to listeners, so streaming clients can resume after a reconnect. Ids
+// are not persisted and start from 1 with every server run.
+type TaskEventLog struct {
+	events    []LoggedTaskEvent // ring buffer, oldest at start
+	start     int
+	size      int
+	latestId  int
+	listeners map[chan LoggedTaskEvent]struct{}
+	sync.Mutex
+}
+
+// NewTaskEventLog subscribes to the holder and keeps up to size events
+func NewTaskEventLog(size int, taskHolder *TaskHolder) *TaskEventLog {
+	if size <= 0 {
+		size = DefaultEventLogSize
+	}
+	log := &TaskEventLog{
+		events:    make([]LoggedTaskEvent, size),
+		listeners: map[chan LoggedTaskEvent]struct{}{},
+	}
+	taskHolder.Subscribe(log.handleTaskEvent)
+	return log
+}
+
+func (l *TaskEventLog) handleTaskEvent(event TaskEvent) {
+	l.Lock()
+	defer l.Unlock()
+	l.latestId++
+	logged := LoggedTaskEvent{Id: l.latestId, TaskEvent: event}
+	if l.size < len(l.events) {
+		l.events[(l.start+l.size)%len(l.events)] = logged
+		l.size++
+	} else {
+		l.events[l.start] = logged
+		l.start = (l.start + 1) % len(l.events)
+	}
+
+	for listener := range l.listeners {
+		select {
+		case listener <- logged:
+		default:
+			// a listener too slow to keep up is dropped, its client
+			// reconnects and replays the missed events from the buffer
+			delete(l.listeners, listener)
+			close(listener)
+		}
+	}
+}
+
+// Since returns buffered events after lastId. It returns false when events
+// after lastId were already dropped from the buffer or lastId is unknown,
+// e.g. from before a restart, then the client has to reload all tasks.
+func (l *TaskEventLog) Since(lastId int) ([]LoggedTaskEvent, bool) {
+	l.Lock()
+	defer l.Unlock()
+	return l.since(lastId)
+}
+
+func (l *TaskEventLog) since(lastId int) ([]LoggedTaskEvent, bool) {
+	if lastId > l.latestId || lastId < l.latestId-l.size {
+		return nil, false
+	}
+	missed := l.latestId - lastId
+	events := make([]LoggedTaskEvent, 0, missed)
+	for i := l.size - missed; i < l.size; i++ {
+		events = append(events, l.events[(l.start+i)%len(l.events)])
+	}
+	return events, true
+}
+
+// Listen returns events after lastId like Since and a channel receiving every
+// later event, no event is lost or repeated in between. Negative lastId
+// listens from the latest event without replay. The channel is closed by
+// cancel or when the listener falls more than buffer events behind.
+func (l *TaskEventLog) Listen(lastId int, buffer int) ([]LoggedTaskEvent, bool, <-chan LoggedTaskEvent, func()) {
+	l.Lock()
+	defer l.Unlock()
+	if lastId < 0 {
+		lastId = l.latestId
+	}
+	replay, ok := l.since(lastId)
+	listener := make(chan LoggedTaskEvent, buffer)
+	l.listeners[listener] = struct{}{}
+
+	cancel := func() {
+		l.Lock()
+		defer l.Unlock()
+		if _, ok := l.listeners[listener]; ok {
+			delete(l.listeners, listener)
+			close(listener)
+		}
+	}
+	return replay, ok, listener, cancel
+}
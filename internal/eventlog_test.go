@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"testing"
+)
+
+func eventIds(events []LoggedTaskEvent) []int {
+	var ids []int
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	return ids
+}
+
+func equalIds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTaskEventLogSince(t *testing.T) {
+	th := NewTaskHolder("")
+	log := NewTaskEventLog(3, th)
+	for i := 0; i < 5; i++ {
+		th.CreateTask(TaskOptional{Msg: StringPtr("task")})
+	}
+
+	tests := []struct {
+		name    string
+		lastId  int
+		wantIds []int
+		wantOk  bool
+	}{
+		{"all buffered", 2, []int{3, 4, 5}, true},
+		{"some missed", 4, []int{5}, true},
+		{"up to date", 5, nil, true},
+		{"dropped from buffer", 1, nil, false},
+		{"unknown id", 9, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, ok := log.Since(tt.lastId)
+			if ok != tt.wantOk {
+				t.Errorf("Expected ok %v, got %v", tt.wantOk, ok)
+			}
+			if !equalIds(eventIds(events), tt.wantIds) {
+				t.Errorf("Expected ids %v, got %v", tt.wantIds, eventIds(events))
+			}
+		})
+	}
+}
+
+func TestTaskEventLogListen(t *testing.T) {
+	th := NewTaskHolder("")
+	log := NewTaskEventLog(10, th)
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("task")})
+
+	replay, ok, events, cancel := log.Listen(0, 10)
+	if !ok || !equalIds(eventIds(replay), []int{1}) {
+		t.Fatalf("Expected replay of event 1, got %v %v", eventIds(replay), ok)
+	}
+
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true)})
+	th.DeleteTask(task.Id)
+
+	for _, want := range []TaskEventType{TaskUpdated, TaskDeleted} {
+		event := <-events
+		if event.Type != want {
+			t.Errorf("Expected %s event, got %s", want, event.Type)
+		}
+	}
+
+	cancel()
+	if _, open := <-events; open {
+		t.Error("Expected channel closed after cancel")
+	}
+	cancel()
+}
+
+func TestTaskEventLogListenFromLatest(t *testing.T) {
+	th := NewTaskHolder("")
+	log := NewTaskEventLog(10, th)
+	th.CreateTask(TaskOptional{Msg: StringPtr("task")})
+
+	replay, ok, _, cancel := log.Listen(-1, 1)
+	defer cancel()
+	if !ok || len(replay) != 0 {
+		t.Errorf("Expected no replay, got %v %v", eventIds(replay), ok)
+	}
+}
+
+func TestTaskEventLogDropsSlowListener(t *testing.T) {
+	th := NewTaskHolder("")
+	log := NewTaskEventLog(10, th)
+	_, _, events, cancel := log.Listen(-1, 1)
+	defer cancel()
+
+	th.CreateTask(TaskOptional{Msg: StringPtr("first")})
+	th.CreateTask(TaskOptional{Msg: StringPtr("second")})
+
+	if event := <-events; event.Id != 1 {
+		t.Errorf("Expected event 1, got %d", event.Id)
+	}
+	if _, open := <-events; open {
+		t.Error("Expected slow listener dropped")
+	}
+}
+
+func TestLoggedTaskEventMatches(t *testing.T) {
+	brewing := Brewing
+	query := &TaskQuery{Category: &brewing, Assignee: "bob"}
+	previous := Task{Category: Brewing, Assignee: "Bob"}
+
+	tests := []struct {
+		name  string
+		event LoggedTaskEvent
+		want  bool
+	}{
+		{"matches", LoggedTaskEvent{TaskEvent: TaskEvent{Type: TaskCreated, Task: Task{Category: Brewing, Assignee: "bob"}}}, true},
+		{"other category", LoggedTaskEvent{TaskEvent: TaskEvent{Type: TaskCreated, Task: Task{Category: Marketing, Assignee: "bob"}}}, false},
+		{"other assignee", LoggedTaskEvent{TaskEvent: TaskEvent{Type: TaskDeleted, Task: Task{Category: Brewing, Assignee: "amy"}}}, false},
+		{"moved out", LoggedTaskEvent{TaskEvent: TaskEvent{Type: TaskUpdated, Task: Task{Category: Marketing}, Previous: &previous}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.Matches(query); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import "github.com/zhekagigs/golang_todo/users"
+
+type TaskEventType string
+
+const (
+	TaskCreated TaskEventType = "created"
+	TaskUpdated TaskEventType = "updated"
+	TaskDeleted TaskEventType = "deleted"
+)
+
+// TaskEvent is published by TaskHolder after every change of a task.
+// Previous is set for updates only. By is the user who made the change,
+// nil when the change is not made by a known user.
+type TaskEvent struct {
+	Type     TaskEventType
+	Task     Task
+	Previous *Task
+	By       *users.User
+}
+
+type TaskListener func(TaskEvent)
+
+// TaskValidator may reject a partial update before it is applied. It is
+// called with the holder lock held and must not call back into the holder.
+type TaskValidator func(task Task, update *TaskOptional) error
+
+// Subscribe registers listener called for every task change. Listeners are
+// called after the holder lock is released, so they may call back into the
+// holder, and get events one at a time in the order of the changes.
+func (t *TaskHolder) Subscribe(listener TaskListener) {
+	t.Lock()
+	defer t.Unlock()
+	t.listeners = append(t.listeners, listener)
+}
+
+func (t *TaskHolder) AddValidator(validator TaskValidator) {
+	t.Lock()
+	defer t.Unlock()
+	t.validators = append(t.validators, validator)
+}
+
+// Revision changes with every task change, it is not persisted
+func (t *TaskHolder) Revision() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.revision
+}
+
+// queueEvent is called for every change, so it also bumps the version of an
+// updated task and the holder revision. Caller holds the lock.
+func (t *TaskHolder) queueEvent(eventType TaskEventType, task *Task, previous *Task, by *users.User) {
+	t.revision++
+	if eventType == TaskUpdated {
+		task.Version++
+	}
+	if len(t.listeners) == 0 {
+		return
+	}
+	t.pendingEvents = append(t.pendingEvents, TaskEvent{Type: eventType, Task: task.clone(), Previous: previous, By: by})
+}
+
+// flushEvents is deferred before taking the lock so it runs after unlock.
+// Only one caller dispatches at a time, it also sends events queued meanwhile
+// by other goroutines or by the listeners, so events keep the revision order.
+func (t *TaskHolder) flushEvents() {
+	t.Lock()
+	if t.dispatching {
+		t.Unlock()
+		return
+	}
+	t.dispatching = true
+	finished := false
+	defer func() {
+		// a listener panicked, the next change dispatches the rest
+		if !finished {
+			t.Lock()
+			t.dispatching = false
+			t.Unlock()
+		}
+	}()
+
+	for len(t.pendingEvents) > 0 {
+		events := t.pendingEvents
+		t.pendingEvents = nil
+		listeners := append([]TaskListener(nil), t.listeners...)
+		t.Unlock()
+		for _, event := range events {
+			for _, listener := range listeners {
+				listener(event)
+			}
+		}
+		t.Lock()
+	}
+	t.dispatching = false
+	finished = true
+	t.Unlock()
+}
+
+func (task *Task) clone() Task {
+	cloned := *task
+	cloned.Checklist = append([]ChecklistItem(nil), task.Checklist...)
+	cloned.Tags = append([]string(nil), task.Tags...)
+	return cloned
+}
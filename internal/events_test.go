@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTaskEventsInOrder(t *testing.T) {
+	th := NewTaskHolder("")
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+	var versions []int
+	th.Subscribe(func(event TaskEvent) {
+		if event.Type == TaskUpdated {
+			versions = append(versions, event.Task.Version)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.PartialUpdateTask(task.Id, &TaskOptional{Tags: []string{"hoppy"}})
+		}()
+	}
+	wg.Wait()
+
+	if len(versions) != 50 {
+		t.Fatalf("Expected 50 events, got %d", len(versions))
+	}
+	for i, version := range versions {
+		if version != i+2 {
+			t.Fatalf("Expected events in version order, got %v", versions)
+		}
+	}
+}
+
+func TestTaskEventsOfListenerAfterCurrent(t *testing.T) {
+	th := NewTaskHolder("")
+	var types []TaskEventType
+	th.Subscribe(func(event TaskEvent) {
+		types = append(types, event.Type)
+		if event.Type == TaskUpdated {
+			th.CreateTask(TaskOptional{Msg: StringPtr("Keg IPA")})
+		}
+	})
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+	th.ApplyBulk([]BulkOperation{
+		{Op: BulkUpdate, Id: task.Id, Task: TaskOptional{Done: BoolPtr(true)}},
+		{Op: BulkDelete, Id: task.Id},
+	}, false, nil)
+
+	want := []TaskEventType{TaskCreated, TaskUpdated, TaskDeleted, TaskCreated}
+	if len(types) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("Expected events %v, got %v", want, types)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+var (
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is still in progress")
+)
+
+// IdempotentResponse is replayed to retries of the original request
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore remembers requests by idempotency key until the TTL passes.
+type IdempotencyStore interface {
+	// Begin reserves key for the request with requestHash. A completed request
+	// with the same hash returns its response, a different hash returns
+	// ErrIdempotencyKeyReused and an unfinished one ErrIdempotencyInProgress.
+	Begin(key string, requestHash string) (*IdempotentResponse, error)
+	// Complete saves the response for retries
+	Complete(key string, response IdempotentResponse) error
+	// Abort releases the key so the request can be retried, e.g. after a server error
+	Abort(key string) error
+}
+
+type idempotencyEntry struct {
+	requestHash string
+	response    *IdempotentResponse
+	expiresAt   time.Time
+}
+
+// MemoryIdempotencyStore keeps keys in memory, they are lost on restart
+type MemoryIdempotencyStore struct {
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]*idempotencyEntry
+	sync.Mutex
+}
+
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Begin(key string, requestHash string) (*IdempotentResponse, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.removeExpired()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		s.entries[key] = &idempotencyEntry{requestHash: requestHash, expiresAt: s.now().Add(s.ttl)}
+		return nil, nil
+	}
+	if entry.requestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if entry.response == nil {
+		return nil, ErrIdempotencyInProgress
+	}
+	return entry.response, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, response IdempotentResponse) error {
+	s.Lock()
+	defer s.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return ErrNotFound
+	}
+	entry.response = &response
+	entry.expiresAt = s.now().Add(s.ttl)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Abort(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// caller holds the lock
+func (s *MemoryIdempotencyStore) removeExpired() {
+	now := s.now()
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Hour)
+	now := MockTime
+	store.now = func() time.Time { return now }
+
+	if saved, err := store.Begin("key", "hash"); saved != nil || err != nil {
+		t.Fatalf("Expected new key, got %v, %v", saved, err)
+	}
+	if _, err := store.Begin("key", "hash"); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Errorf("Expected ErrIdempotencyInProgress, got %v", err)
+	}
+	store.Complete("key", IdempotentResponse{Status: 201, Body: []byte(`{"Id":1}`)})
+
+	tests := []struct {
+		name    string
+		key     string
+		hash    string
+		after   time.Duration
+		want    *IdempotentResponse
+		wantErr error
+	}{
+		{"replay", "key", "hash", 0, &IdempotentResponse{Status: 201}, nil},
+		{"reused key", "key", "other", 0, nil, ErrIdempotencyKeyReused},
+		{"other key", "key2", "hash", 0, nil, nil},
+		{"expired", "key", "other", time.Hour, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+			saved, err := store.Begin(tt.key, tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if (saved == nil) != (tt.want == nil) || (saved != nil && saved.Status != tt.want.Status) {
+				t.Errorf("Expected response %v, got %v", tt.want, saved)
+			}
+		})
+	}
+
+	store.Abort("key")
+	if saved, err := store.Begin("key", "third"); saved != nil || err != nil {
+		t.Errorf("Expected aborted key to be free, got %v, %v", saved, err)
+	}
+}
@@ -0,0 +1,396 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type Unit string
+
+const (
+	Kilogram Unit = "kg"
+	Pound    Unit = "lbs"
+)
+
+const poundsPerKilogram = 2.20462262
+
+type MovementReason string
+
+const (
+	Receipt     MovementReason = "receipt"
+	Consumption MovementReason = "consumption"
+	Adjustment  MovementReason = "adjustment"
+)
+
+// reorder task is being created, id is not known yet
+const reorderPending = -1
+
+var ErrAlreadyReceived = errors.New("order task was already received")
+
+// matches generated tasks like "Order 120 kg of Hops"
+var orderTaskPattern = regexp.MustCompile(`^Order (\d+(?:\.\d+)?) (kg|lbs) of (.+)$`)
+
+type InvalidUnitError struct {
+	Unit Unit
+}
+
+func (e *InvalidUnitError) Error() string {
+	return fmt.Sprintf("invalid unit: %q, expected kg or lbs", e.Unit)
+}
+
+type InvalidInventoryValueError struct {
+	Field string
+}
+
+func (e *InvalidInventoryValueError) Error() string {
+	return fmt.Sprintf("invalid inventory value: %s", e.Field)
+}
+
+type InsufficientStockError struct {
+	Item      string
+	Available float64
+	Requested float64
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock of %s: available %g, requested %g", e.Item, e.Available, e.Requested)
+}
+
+func ConvertQuantity(quantity float64, from Unit, to Unit) (float64, error) {
+	if !isValidUnit(from) {
+		return 0, &InvalidUnitError{Unit: from}
+	}
+	if !isValidUnit(to) {
+		return 0, &InvalidUnitError{Unit: to}
+	}
+	switch {
+	case from == to:
+		return quantity, nil
+	case from == Kilogram:
+		return quantity * poundsPerKilogram, nil
+	default:
+		return quantity / poundsPerKilogram, nil
+	}
+}
+
+func isValidUnit(unit Unit) bool {
+	return unit == Kilogram || unit == Pound
+}
+
+// InventoryItem stock and reorder settings are kept in the item unit.
+// When stock drops below ReorderThreshold a Logistics task to order
+// ReorderQuantity is created, ReorderTaskId points to the open task.
+type InventoryItem struct {
+	Id               int     `json:"id"`
+	Name             string  `json:"name"`
+	Unit             Unit    `json:"unit"`
+	Stock            float64 `json:"stock"`
+	ReorderThreshold float64 `json:"reorderThreshold"`
+	ReorderQuantity  float64 `json:"reorderQuantity"`
+	ReorderTaskId    int     `json:"reorderTaskId,omitempty"`
+}
+
+func (item *InventoryItem) Validate() error {
+	if strings.TrimSpace(item.Name) == "" {
+		return &InvalidInventoryValueError{Field: "name"}
+	}
+	if !isValidUnit(item.Unit) {
+		return &InvalidUnitError{Unit: item.Unit}
+	}
+	if item.Stock < 0 {
+		return &InvalidInventoryValueError{Field: "stock"}
+	}
+	if item.ReorderThreshold < 0 || item.ReorderQuantity < 0 {
+		return &InvalidInventoryValueError{Field: "reorder"}
+	}
+	return nil
+}
+
+func (item *InventoryItem) needsReorder() bool {
+	return item.ReorderQuantity > 0 && item.Stock < item.ReorderThreshold && item.ReorderTaskId == 0
+}
+
+// StockMovement is a ledger line, Quantity is signed and in the item unit
+type StockMovement struct {
+	Id        int            `json:"id"`
+	ItemId    int            `json:"itemId"`
+	Quantity  float64        `json:"quantity"`
+	Reason    MovementReason `json:"reason"`
+	TaskId    int            `json:"taskId,omitempty"`
+	Note      string         `json:"note,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	CreatedBy users.User     `json:"createdBy"`
+}
+
+// Inventory is an append only ledger of stock movements per item.
+// It saves itself on every change when DiskPath is set.
+type Inventory struct {
+	latestItemId     int
+	latestMovementId int
+	Items            []InventoryItem `json:"items"`
+	Movements        []StockMovement `json:"movements"`
+	DiskPath         string          `json:"-"`
+	taskHolder       *TaskHolder
+	sync.Mutex
+}
+
+// NewInventory loads the ledger and subscribes to task changes, so that
+// finishing an "Order" task posts a receipt.
+func NewInventory(diskPath string, taskHolder *TaskHolder) (*Inventory, error) {
+	inventory := &Inventory{DiskPath: diskPath, taskHolder: taskHolder}
+	if diskPath != "" {
+		err := loadJsonFile(diskPath, inventory)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	for _, item := range inventory.Items {
+		inventory.latestItemId = max(inventory.latestItemId, item.Id)
+	}
+	for _, movement := range inventory.Movements {
+		inventory.latestMovementId = max(inventory.latestMovementId, movement.Id)
+	}
+	taskHolder.Subscribe(inventory.handleTaskEvent)
+	return inventory, nil
+}
+
+func (inv *Inventory) save() error {
+	if inv.DiskPath == "" {
+		return nil
+	}
+	return saveJsonFile(inv.DiskPath, inv)
+}
+
+func (inv *Inventory) ReadItems() []InventoryItem {
+	inv.Lock()
+	defer inv.Unlock()
+	return append([]InventoryItem(nil), inv.Items...)
+}
+
+func (inv *Inventory) FindItemById(itemId int) (*InventoryItem, error) {
+	inv.Lock()
+	defer inv.Unlock()
+	item, err := inv.findItemById(itemId)
+	if err != nil {
+		return nil, err
+	}
+	found := *item
+	return &found, nil
+}
+
+func (inv *Inventory) findItemById(itemId int) (*InventoryItem, error) {
+	for i := range inv.Items {
+		if inv.Items[i].Id == itemId {
+			return &inv.Items[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (inv *Inventory) findItemByName(name string) (*InventoryItem, error) {
+	for i := range inv.Items {
+		if strings.EqualFold(inv.Items[i].Name, strings.TrimSpace(name)) {
+			return &inv.Items[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (inv *Inventory) AddItem(item InventoryItem) (*InventoryItem, error) {
+	if err := item.Validate(); err != nil {
+		return nil, err
+	}
+	inv.Lock()
+	if _, err := inv.findItemByName(item.Name); err == nil {
+		inv.Unlock()
+		return nil, &InvalidInventoryValueError{Field: "name already exists"}
+	}
+	inv.latestItemId++
+	item.Id = inv.latestItemId
+	item.ReorderTaskId = 0
+	inv.Items = append(inv.Items, item)
+	err := inv.save()
+	inv.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	inv.reorderIfNeeded(item.Id)
+	return inv.FindItemById(item.Id)
+}
+
+// ItemMovements returns ledger lines of an item, oldest first
+func (inv *Inventory) ItemMovements(itemId int) ([]StockMovement, error) {
+	inv.Lock()
+	defer inv.Unlock()
+	if _, err := inv.findItemById(itemId); err != nil {
+		return nil, err
+	}
+	var movements []StockMovement
+	for _, movement := range inv.Movements {
+		if movement.ItemId == itemId {
+			movements = append(movements, movement)
+		}
+	}
+	return movements, nil
+}
+
+// RecordMovement posts quantity given in any supported unit to the ledger.
+// Consumption is posted as a negative quantity regardless of the given sign.
+func (inv *Inventory) RecordMovement(itemId int, quantity float64, unit Unit, reason MovementReason, note string, user *users.User) (*StockMovement, error) {
+	movement, err := inv.recordMovement(itemId, quantity, unit, reason, note, 0, user)
+	if err != nil {
+		return nil, err
+	}
+	inv.reorderIfNeeded(itemId)
+	return movement, nil
+}
+
+func (inv *Inventory) recordMovement(itemId int, quantity float64, unit Unit, reason MovementReason, note string, taskId int, user *users.User) (*StockMovement, error) {
+	if user == nil {
+		user = &users.User{UserName: "Team"}
+	}
+	inv.Lock()
+	defer inv.Unlock()
+	item, err := inv.findItemById(itemId)
+	if err != nil {
+		return nil, err
+	}
+	if unit == "" {
+		unit = item.Unit
+	}
+	converted, err := ConvertQuantity(quantity, unit, item.Unit)
+	if err != nil {
+		return nil, err
+	}
+	converted = math.Round(converted*1000) / 1000
+	if reason == Receipt && taskId != 0 && inv.hasReceipt(taskId) {
+		return nil, ErrAlreadyReceived
+	}
+
+	switch reason {
+	case Receipt:
+		converted = math.Abs(converted)
+	case Consumption:
+		converted = -math.Abs(converted)
+	case Adjustment:
+	default:
+		return nil, &InvalidInventoryValueError{Field: "reason"}
+	}
+	if converted == 0 {
+		return nil, &InvalidInventoryValueError{Field: "quantity"}
+	}
+	if item.Stock+converted < 0 {
+		return nil, &InsufficientStockError{Item: item.Name, Available: item.Stock, Requested: -converted}
+	}
+
+	inv.latestMovementId++
+	movement := StockMovement{
+		Id:        inv.latestMovementId,
+		ItemId:    item.Id,
+		Quantity:  converted,
+		Reason:    reason,
+		TaskId:    taskId,
+		Note:      note,
+		CreatedAt: timeNow().Round(0),
+		CreatedBy: *user,
+	}
+	inv.Movements = append(inv.Movements, movement)
+	item.Stock = math.Round((item.Stock+converted)*1000) / 1000
+	if reason == Receipt && taskId != 0 && item.ReorderTaskId == taskId {
+		item.ReorderTaskId = 0
+	}
+	return &movement, inv.save()
+}
+
+// hasReceipt tells if the order task was received, caller holds the lock
+func (inv *Inventory) hasReceipt(taskId int) bool {
+	for _, movement := range inv.Movements {
+		if movement.Reason == Receipt && movement.TaskId == taskId {
+			return true
+		}
+	}
+	return false
+}
+
+// reorderIfNeeded creates the Logistics order task outside of the inventory lock,
+// the item is marked pending first so concurrent movements don't order twice.
+func (inv *Inventory) reorderIfNeeded(itemId int) {
+	inv.Lock()
+	item, err := inv.findItemById(itemId)
+	if err != nil || !item.needsReorder() {
+		inv.Unlock()
+		return
+	}
+	item.ReorderTaskId = reorderPending
+	msg := fmt.Sprintf("Order %s %s of %s", strconv.FormatFloat(item.ReorderQuantity, 'f', -1, 64), item.Unit, item.Name)
+	inv.Unlock()
+
+	task := inv.taskHolder.CreateTask(TaskOptional{
+		Msg:       &msg,
+		Category:  CategoryPtr(Logistics),
+		PlannedAt: TimePtr(timeNow().Add(24 * time.Hour)),
+	})
+
+	inv.Lock()
+	defer inv.Unlock()
+	if item, err := inv.findItemById(itemId); err == nil {
+		item.ReorderTaskId = task.Id
+		inv.save()
+	}
+}
+
+func (inv *Inventory) handleTaskEvent(event TaskEvent) {
+	switch event.Type {
+	case TaskUpdated:
+		if event.Task.Done && event.Previous != nil && !event.Previous.Done {
+			inv.receiveOrder(event.Task, event.By)
+		}
+	case TaskDeleted:
+		inv.Lock()
+		defer inv.Unlock()
+		for i := range inv.Items {
+			if inv.Items[i].ReorderTaskId == event.Task.Id {
+				inv.Items[i].ReorderTaskId = 0
+				inv.save()
+			}
+		}
+	}
+}
+
+// receiveOrder posts receipt for finished task like "Order 120 kg of Hops"
+// by the user who finished it. Tasks for unknown items are ignored, and a
+// task reopened and finished again is received only once.
+func (inv *Inventory) receiveOrder(task Task, user *users.User) {
+	match := orderTaskPattern.FindStringSubmatch(strings.TrimSpace(task.Msg))
+	if match == nil {
+		return
+	}
+	quantity, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return
+	}
+
+	inv.Lock()
+	item, err := inv.findItemByName(match[3])
+	inv.Unlock()
+	if err != nil {
+		return
+	}
+
+	note := fmt.Sprintf("task %d: %s", task.Id, task.Msg)
+	_, err = inv.recordMovement(item.Id, quantity, Unit(match[2]), Receipt, note, task.Id, user)
+	if err != nil {
+		return
+	}
+	inv.reorderIfNeeded(item.Id)
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+func provideInventory(t *testing.T) (*Inventory, *TaskHolder) {
+	th := NewTaskHolder("")
+	inventory, err := NewInventory("", th)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return inventory, th
+}
+
+func TestConvertQuantity(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity float64
+		from     Unit
+		to       Unit
+		want     float64
+		wantErr  bool
+	}{
+		{"kg to kg", 10, Kilogram, Kilogram, 10, false},
+		{"kg to lbs", 1, Kilogram, Pound, 2.20462262, false},
+		{"lbs to kg", 220.462262, Pound, Kilogram, 100, false},
+		{"invalid unit", 1, Unit("oz"), Kilogram, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConvertQuantity(tt.quantity, tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConvertQuantity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ConvertQuantity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordMovement(t *testing.T) {
+	inventory, _ := provideInventory(t)
+	item, err := inventory.AddItem(InventoryItem{Name: "Hops", Unit: Kilogram, Stock: 50})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := inventory.RecordMovement(item.Id, 22.0462262, Pound, Receipt, "", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := inventory.RecordMovement(item.Id, 15, Kilogram, Consumption, "brew day", ProvideMockUser()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	updated, _ := inventory.FindItemById(item.Id)
+	if updated.Stock != 45 {
+		t.Errorf("Expected stock 45 kg, got %v", updated.Stock)
+	}
+
+	movements, _ := inventory.ItemMovements(item.Id)
+	if len(movements) != 2 || movements[0].Quantity != 10 || movements[1].Quantity != -15 {
+		t.Errorf("Unexpected ledger %v", movements)
+	}
+
+	_, err = inventory.RecordMovement(item.Id, 100, Kilogram, Consumption, "", nil)
+	if _, ok := err.(*InsufficientStockError); !ok {
+		t.Errorf("Expected InsufficientStockError, got %v", err)
+	}
+	_, err = inventory.RecordMovement(item.Id, 1, Kilogram, MovementReason("theft"), "", nil)
+	if _, ok := err.(*InvalidInventoryValueError); !ok {
+		t.Errorf("Expected InvalidInventoryValueError, got %v", err)
+	}
+}
+
+func TestReorderTaskCreatedAndReceived(t *testing.T) {
+	inventory, th := provideInventory(t)
+	item, _ := inventory.AddItem(InventoryItem{Name: "Malt", Unit: Kilogram, Stock: 100, ReorderThreshold: 50, ReorderQuantity: 200})
+
+	inventory.RecordMovement(item.Id, 60, Kilogram, Consumption, "", nil)
+
+	updated, _ := inventory.FindItemById(item.Id)
+	if updated.ReorderTaskId == 0 {
+		t.Fatalf("Expected reorder task to be created")
+	}
+	task, err := th.FindTaskById(updated.ReorderTaskId)
+	if err != nil {
+		t.Fatalf("Expected reorder task in holder, got %v", err)
+	}
+	if task.Msg != "Order 200 kg of Malt" || task.Category != Logistics {
+		t.Errorf("Unexpected reorder task %q [%s]", task.Msg, task.Category)
+	}
+
+	// stock still low, but the task is open, so no second order
+	inventory.RecordMovement(item.Id, 10, Kilogram, Consumption, "", nil)
+	if _, total := th.Count(); total != 1 {
+		t.Errorf("Expected a single reorder task, got %d tasks", total)
+	}
+
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true)})
+
+	received, _ := inventory.FindItemById(item.Id)
+	if received.Stock != 230 {
+		t.Errorf("Expected stock 230 after receipt, got %v", received.Stock)
+	}
+	if received.ReorderTaskId != 0 {
+		t.Errorf("Expected reorder task to be cleared, got %d", received.ReorderTaskId)
+	}
+	movements, _ := inventory.ItemMovements(item.Id)
+	last := movements[len(movements)-1]
+	if last.Reason != Receipt || last.TaskId != task.Id {
+		t.Errorf("Expected receipt linked to task %d, got %v", task.Id, last)
+	}
+}
+
+func TestGeneratedOrderTaskPostsReceipt(t *testing.T) {
+	inventory, th := provideInventory(t)
+	item, _ := inventory.AddItem(InventoryItem{Name: "Hops", Unit: Kilogram})
+
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Order 110.231131 lbs of hops"), Category: CategoryPtr(Brewing)})
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true)})
+	// finishing twice doesn't post twice
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true)})
+
+	updated, _ := inventory.FindItemById(item.Id)
+	if updated.Stock != 50 {
+		t.Errorf("Expected stock 50 kg, got %v", updated.Stock)
+	}
+
+	other := th.CreateTask(TaskOptional{Msg: StringPtr("Order 5 kg of Unicorn dust"), Category: CategoryPtr(Brewing)})
+	th.PartialUpdateTask(other.Id, &TaskOptional{Done: BoolPtr(true)})
+	if movements, _ := inventory.ItemMovements(item.Id); len(movements) != 1 {
+		t.Errorf("Expected unknown item order to be ignored, got %v", movements)
+	}
+}
+
+func TestReopenedOrderTaskReceivedOnce(t *testing.T) {
+	inventory, th := provideInventory(t)
+	item, _ := inventory.AddItem(InventoryItem{Name: "Yeast", Unit: Kilogram})
+	creator := users.User{UserName: "planner"}
+	brewer := &users.User{UserName: "brewer"}
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Order 5 kg of Yeast"), Category: CategoryPtr(Logistics), CreatedBy: &creator})
+
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true), UpdatedBy: brewer})
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(false)})
+	th.PartialUpdateTask(task.Id, &TaskOptional{Done: BoolPtr(true)})
+
+	movements, _ := inventory.ItemMovements(item.Id)
+	if len(movements) != 1 || movements[0].CreatedBy.UserName != "brewer" {
+		t.Fatalf("Expected one receipt by the user who finished the task, got %+v", movements)
+	}
+	if updated, _ := inventory.FindItemById(item.Id); updated.Stock != 5 {
+		t.Errorf("Expected stock 5 kg, got %v", updated.Stock)
+	}
+}
+
+func TestInventoryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	inventory, _ := NewInventory(path, NewTaskHolder(""))
+	item, _ := inventory.AddItem(InventoryItem{Name: "Yeast", Unit: Pound, Stock: 3})
+	inventory.RecordMovement(item.Id, 1, Pound, Receipt, "", nil)
+
+	reloaded, err := NewInventory(path, NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	items := reloaded.ReadItems()
+	if len(items) != 1 || items[0].Stock != 4 {
+		t.Errorf("Unexpected items after reload %v", items)
+	}
+	movement, _ := reloaded.RecordMovement(item.Id, 1, Pound, Receipt, "", nil)
+	if movement.Id != 2 {
+		t.Errorf("Expected next movement id 2, got %d", movement.Id)
+	}
+}
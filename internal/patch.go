@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type PatchType string
+
+const (
+	MergePatch PatchType = "application/merge-patch+json"
+	JSONPatch  PatchType = "application/json-patch+json"
+)
+
+type InvalidPatchError struct {
+	Path   string
+	Reason string
+}
+
+func (e *InvalidPatchError) Error() string {
+	return fmt.Sprintf("invalid patch at %q: %s", e.Path, e.Reason)
+}
+
+type PatchTestFailedError struct {
+	Path string
+}
+
+func (e *PatchTestFailedError) Error() string {
+	return fmt.Sprintf("patch test failed at %q", e.Path)
+}
+
+// ApplyMergePatch applies RFC 7396 merge patch to the JSON document
+func ApplyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, patchValue any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, &InvalidPatchError{Path: "", Reason: err.Error()}
+	}
+	return json.Marshal(mergePatch(target, patchValue))
+}
+
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// ApplyJSONPatch applies RFC 6902 operations in order, all or nothing
+func ApplyJSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	var operations []patchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, &InvalidPatchError{Path: "", Reason: err.Error()}
+	}
+
+	for _, op := range operations {
+		var value any
+		if op.Value != nil {
+			if err := json.Unmarshal(*op.Value, &value); err != nil {
+				return nil, &InvalidPatchError{Path: op.Path, Reason: err.Error()}
+			}
+		}
+		var err error
+		switch op.Op {
+		case "add":
+			if op.Value == nil {
+				return nil, &InvalidPatchError{Path: op.Path, Reason: "missing value"}
+			}
+			target, err = pointerAdd(target, op.Path, value)
+		case "remove":
+			target, _, err = pointerRemove(target, op.Path)
+		case "replace":
+			if op.Value == nil {
+				return nil, &InvalidPatchError{Path: op.Path, Reason: "missing value"}
+			}
+			if target, _, err = pointerRemove(target, op.Path); err == nil {
+				target, err = pointerAdd(target, op.Path, value)
+			}
+		case "move":
+			var moved any
+			if target, moved, err = pointerRemove(target, op.From); err == nil {
+				target, err = pointerAdd(target, op.Path, moved)
+			}
+		case "copy":
+			var copied any
+			if copied, err = pointerGet(target, op.From); err == nil {
+				target, err = pointerAdd(target, op.Path, deepCopy(copied))
+			}
+		case "test":
+			var current any
+			if current, err = pointerGet(target, op.Path); err == nil && !reflect.DeepEqual(current, value) {
+				err = &PatchTestFailedError{Path: op.Path}
+			}
+		default:
+			err = &InvalidPatchError{Path: op.Path, Reason: fmt.Sprintf("unknown op %q", op.Op)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(target)
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, &InvalidPatchError{Path: pointer, Reason: "pointer must start with /"}
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(array []any, token string, pointer string, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return len(array), nil
+	}
+	index, err := strconv.Atoi(token)
+	limit := len(array)
+	if allowEnd {
+		limit++
+	}
+	if err != nil || index < 0 || index >= limit || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, &InvalidPatchError{Path: pointer, Reason: "invalid array index"}
+	}
+	return index, nil
+}
+
+func pointerGet(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, &InvalidPatchError{Path: pointer, Reason: "path not found"}
+			}
+			current = value
+		case []any:
+			index, err := arrayIndex(node, token, pointer, false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, &InvalidPatchError{Path: pointer, Reason: "path not found"}
+		}
+	}
+	return current, nil
+}
+
+// pointerAdd returns the document with value added, the root may be replaced
+func pointerAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		index, err := arrayIndex(node, last, pointer, true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node[:index], append([]any{value}, node[index:]...)...)
+		return pointerReplaceParent(doc, parentPointer, node)
+	default:
+		return nil, &InvalidPatchError{Path: pointer, Reason: "parent is not a container"}
+	}
+}
+
+// pointerRemove returns the document without the value and the removed value
+func pointerRemove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, &InvalidPatchError{Path: pointer, Reason: "path not found"}
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []any:
+		index, err := arrayIndex(node, last, pointer, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[index]
+		node = append(node[:index:index], node[index+1:]...)
+		doc, err = pointerReplaceParent(doc, parentPointer, node)
+		return doc, value, err
+	default:
+		return nil, nil, &InvalidPatchError{Path: pointer, Reason: "path not found"}
+	}
+}
+
+// slices can't be changed in place, the grown or shrunk array is set back into its parent
+func pointerReplaceParent(doc any, pointer string, value any) (any, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	grandParent, err := pointerGet(doc, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, err
+	}
+	tokens, _ := parsePointer(pointer)
+	last := tokens[len(tokens)-1]
+	switch node := grandParent.(type) {
+	case map[string]any:
+		node[last] = value
+	case []any:
+		index, err := arrayIndex(node, last, pointer, false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+	}
+	return doc, nil
+}
+
+func deepCopy(value any) any {
+	data, _ := json.Marshal(value)
+	var copied any
+	json.Unmarshal(data, &copied)
+	return copied
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace field", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add field", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"remove field", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"replace array", `{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{"nested object", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"non object patch", `{"a":"b"}`, `["c"]`, `["c"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyMergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			assertJsonEqual(t, tt.want, got)
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := `{"a":"b","list":[1,2,3],"obj":{"x":1}}`
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{"add field", `[{"op":"add","path":"/c","value":"d"}]`, `{"a":"b","c":"d","list":[1,2,3],"obj":{"x":1}}`, nil},
+		{"append to array", `[{"op":"add","path":"/list/-","value":4}]`, `{"a":"b","list":[1,2,3,4],"obj":{"x":1}}`, nil},
+		{"insert into array", `[{"op":"add","path":"/list/0","value":0}]`, `{"a":"b","list":[0,1,2,3],"obj":{"x":1}}`, nil},
+		{"remove from array", `[{"op":"remove","path":"/list/1"}]`, `{"a":"b","list":[1,3],"obj":{"x":1}}`, nil},
+		{"replace nested", `[{"op":"replace","path":"/obj/x","value":2}]`, `{"a":"b","list":[1,2,3],"obj":{"x":2}}`, nil},
+		{"move", `[{"op":"move","from":"/a","path":"/obj/a"}]`, `{"list":[1,2,3],"obj":{"a":"b","x":1}}`, nil},
+		{"copy", `[{"op":"copy","from":"/obj","path":"/copy"}]`, `{"a":"b","copy":{"x":1},"list":[1,2,3],"obj":{"x":1}}`, nil},
+		{"test passes", `[{"op":"test","path":"/list","value":[1,2,3]}]`, doc, nil},
+		{"test fails", `[{"op":"test","path":"/a","value":"c"}]`, "", &PatchTestFailedError{}},
+		{"missing path", `[{"op":"remove","path":"/missing"}]`, "", &InvalidPatchError{}},
+		{"index out of range", `[{"op":"replace","path":"/list/3","value":1}]`, "", &InvalidPatchError{}},
+		{"unknown op", `[{"op":"merge","path":"/a"}]`, "", &InvalidPatchError{}},
+		{"missing value", `[{"op":"add","path":"/a"}]`, "", &InvalidPatchError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyJSONPatch([]byte(doc), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !sameErrorType(err, tt.wantErr) {
+					t.Errorf("Expected error %T, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			assertJsonEqual(t, tt.want, got)
+		})
+	}
+}
+
+func TestPatchTask(t *testing.T) {
+	th := NewTaskHolder("")
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA"), Category: CategoryPtr(Brewing)})
+
+	task, err := th.PatchTask(1, 1, MergePatch, []byte(`{"Msg":"Brew stout","Tags":["dark"]}`), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if task.Msg != "Brew stout" || !task.HasTag("dark") || task.Version != 2 {
+		t.Errorf("Unexpected patched task %+v", task)
+	}
+
+	var versionErr *VersionMismatchError
+	if _, err := th.PatchTask(1, 1, MergePatch, []byte(`{"Msg":"Brew lager"}`), nil); !errors.As(err, &versionErr) || versionErr.Current != 2 {
+		t.Errorf("Expected VersionMismatchError, got %v", err)
+	}
+	var patchErr *InvalidPatchError
+	if _, err := th.PatchTask(1, AnyVersion, JSONPatch, []byte(`[{"op":"replace","path":"/CreatedBy","value":null}]`), nil); !errors.As(err, &patchErr) || patchErr.Path != "/CreatedBy" {
+		t.Errorf("Expected InvalidPatchError for read-only field, got %v", err)
+	}
+	if _, err := th.PatchTask(1, AnyVersion, MergePatch, []byte(`{"Category":"Tasting"}`), nil); err == nil {
+		t.Errorf("Expected error for invalid category")
+	}
+	if task, _ := th.GetTask(1); task.Version != 2 || task.Msg != "Brew stout" {
+		t.Errorf("Expected rejected patches to leave task unchanged, got %+v", task)
+	}
+}
+
+func sameErrorType(err error, target error) bool {
+	switch target.(type) {
+	case *PatchTestFailedError:
+		var e *PatchTestFailedError
+		return errors.As(err, &e)
+	case *InvalidPatchError:
+		var e *InvalidPatchError
+		return errors.As(err, &e)
+	}
+	return false
+}
+
+func assertJsonEqual(t *testing.T, want string, got []byte) {
+	t.Helper()
+	var wantValue, gotValue any
+	json.Unmarshal([]byte(want), &wantValue)
+	json.Unmarshal(got, &gotValue)
+	wantJson, _ := json.Marshal(wantValue)
+	gotJson, _ := json.Marshal(gotValue)
+	if string(wantJson) != string(gotJson) {
+		t.Errorf("Expected %s, got %s", wantJson, gotJson)
+	}
+}
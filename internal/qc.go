@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+type MeasurementKind string
+
+const (
+	Gravity MeasurementKind = "gravity"
+	PH      MeasurementKind = "ph"
+	ABV     MeasurementKind = "abv"
+	IBU     MeasurementKind = "ibu"
+	Micro   MeasurementKind = "micro"
+)
+
+type InvalidMeasurementError struct {
+	Field string
+}
+
+func (e *InvalidMeasurementError) Error() string {
+	return fmt.Sprintf("invalid measurement: %s", e.Field)
+}
+
+type NotQualityTaskError struct {
+	TaskId int
+}
+
+func (e *NotQualityTaskError) Error() string {
+	return fmt.Sprintf("task %d is not an open Quality task", e.TaskId)
+}
+
+type SpecRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (s SpecRange) Contains(value float64) bool {
+	return s.Min <= value && value <= s.Max
+}
+
+// StyleSpecs are acceptance ranges of finished beer per style, micro is the
+// count of colony forming units and must be zero. Unknown styles use ale.
+var StyleSpecs = map[string]map[MeasurementKind]SpecRange{
+	"ale": {
+		Gravity: {Min: 1.008, Max: 1.016},
+		PH:      {Min: 4.0, Max: 4.6},
+		ABV:     {Min: 4.0, Max: 6.5},
+		IBU:     {Min: 20, Max: 45},
+		Micro:   {Min: 0, Max: 0},
+	},
+	"ipa": {
+		Gravity: {Min: 1.008, Max: 1.014},
+		PH:      {Min: 4.1, Max: 4.6},
+		ABV:     {Min: 5.5, Max: 7.5},
+		IBU:     {Min: 40, Max: 70},
+		Micro:   {Min: 0, Max: 0},
+	},
+	"lager": {
+		Gravity: {Min: 1.006, Max: 1.012},
+		PH:      {Min: 4.1, Max: 4.5},
+		ABV:     {Min: 4.2, Max: 5.5},
+		IBU:     {Min: 15, Max: 30},
+		Micro:   {Min: 0, Max: 0},
+	},
+	"stout": {
+		Gravity: {Min: 1.010, Max: 1.020},
+		PH:      {Min: 4.0, Max: 4.5},
+		ABV:     {Min: 4.0, Max: 6.5},
+		IBU:     {Min: 30, Max: 50},
+		Micro:   {Min: 0, Max: 0},
+	},
+}
+
+func ResolveStyleSpecs(style string) map[MeasurementKind]SpecRange {
+	if specs, ok := StyleSpecs[strings.ToLower(strings.TrimSpace(style))]; ok {
+		return specs
+	}
+	return StyleSpecs["ale"]
+}
+
+// MeasurementInput is a single reading entered when completing a task
+type MeasurementInput struct {
+	Kind  MeasurementKind `json:"kind"`
+	Value float64         `json:"value"`
+	Note  string          `json:"note"`
+}
+
+// Measurement keeps the spec range used at the time of recording, so later
+// changes of the specs don't rewrite history.
+type Measurement struct {
+	Id             int             `json:"id"`
+	TaskId         int             `json:"taskId"`
+	BatchId        int             `json:"batchId,omitempty"`
+	Style          string          `json:"style"`
+	Kind           MeasurementKind `json:"kind"`
+	Value          float64         `json:"value"`
+	Spec           SpecRange       `json:"spec"`
+	InSpec         bool            `json:"inSpec"`
+	Note           string          `json:"note,omitempty"`
+	FollowUpTaskId int             `json:"followUpTaskId,omitempty"`
+	RecordedAt     time.Time       `json:"recordedAt"`
+	RecordedBy     users.User      `json:"recordedBy"`
+}
+
+// QualityLog stores QC measurements and saves itself on every change.
+// StyleLookup, when set, resolves style of batch tasks.
+type QualityLog struct {
+	latestId     int
+	Measurements []Measurement
+	DiskPath     string
+	StyleLookup  func(batchId int) (string, bool)
+	taskHolder   *TaskHolder
+	sync.Mutex
+}
+
+func NewQualityLog(diskPath string, taskHolder *TaskHolder) (*QualityLog, error) {
+	qualityLog := &QualityLog{DiskPath: diskPath, taskHolder: taskHolder}
+	if diskPath == "" {
+		return qualityLog, nil
+	}
+	err := loadJsonFile(diskPath, &qualityLog.Measurements)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, measurement := range qualityLog.Measurements {
+		qualityLog.latestId = max(qualityLog.latestId, measurement.Id)
+	}
+	return qualityLog, nil
+}
+
+func (q *QualityLog) save() error {
+	if q.DiskPath == "" {
+		return nil
+	}
+	return saveJsonFile(q.DiskPath, q.Measurements)
+}
+
+func (q *QualityLog) Read() []Measurement {
+	q.Lock()
+	defer q.Unlock()
+	return append([]Measurement(nil), q.Measurements...)
+}
+
+func (q *QualityLog) TaskMeasurements(taskId int) []Measurement {
+	q.Lock()
+	defer q.Unlock()
+	var measurements []Measurement
+	for _, measurement := range q.Measurements {
+		if measurement.TaskId == taskId {
+			measurements = append(measurements, measurement)
+		}
+	}
+	return measurements
+}
+
+// CompleteTask marks an open Quality task done and records its measurements.
+// Every out of spec reading raises a Quality follow-up task for the next day.
+func (q *QualityLog) CompleteTask(taskId int, style string, inputs []MeasurementInput, user *users.User) ([]Measurement, error) {
+	if user == nil {
+		user = &users.User{UserName: "Team"}
+	}
+	if len(inputs) == 0 {
+		return nil, &InvalidMeasurementError{Field: "no measurements"}
+	}
+	for _, input := range inputs {
+		if _, ok := StyleSpecs["ale"][input.Kind]; !ok {
+			return nil, &InvalidMeasurementError{Field: fmt.Sprintf("kind %q", input.Kind)}
+		}
+	}
+
+	q.taskHolder.Lock()
+	found, err := q.taskHolder.FindTaskById(taskId)
+	var task Task
+	if err == nil {
+		task = found.clone()
+	}
+	q.taskHolder.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if task.Category != Quality || task.Done {
+		return nil, &NotQualityTaskError{TaskId: taskId}
+	}
+	if style == "" && task.BatchId != 0 && q.StyleLookup != nil {
+		style, _ = q.StyleLookup(task.BatchId)
+	}
+
+	if err := q.taskHolder.PartialUpdateTask(taskId, &TaskOptional{Done: BoolPtr(true), UpdatedBy: user}); err != nil {
+		return nil, err
+	}
+
+	specs := ResolveStyleSpecs(style)
+	now := timeNow().Round(0)
+	measurements := make([]Measurement, len(inputs))
+	for i, input := range inputs {
+		spec := specs[input.Kind]
+		measurements[i] = Measurement{
+			TaskId:     task.Id,
+			BatchId:    task.BatchId,
+			Style:      style,
+			Kind:       input.Kind,
+			Value:      input.Value,
+			Spec:       spec,
+			InSpec:     spec.Contains(input.Value),
+			Note:       input.Note,
+			RecordedAt: now,
+			RecordedBy: *user,
+		}
+		if !measurements[i].InSpec {
+			followUp := q.taskHolder.CreateTask(TaskOptional{
+				Msg:       StringPtr(followUpMsg(task, measurements[i])),
+				Category:  CategoryPtr(Quality),
+				PlannedAt: TimePtr(now.Add(24 * time.Hour)),
+				CreatedBy: user,
+			})
+			measurements[i].FollowUpTaskId = followUp.Id
+		}
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	for i := range measurements {
+		q.latestId++
+		measurements[i].Id = q.latestId
+	}
+	q.Measurements = append(q.Measurements, measurements...)
+	return measurements, q.save()
+}
+
+func followUpMsg(task Task, m Measurement) string {
+	return fmt.Sprintf("Investigate %s %s out of spec (%s-%s) from task %d: %s",
+		m.Kind, formatFloat(m.Value), formatFloat(m.Spec.Min), formatFloat(m.Spec.Max), task.Id, task.Msg)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var measurementCsvHeader = []string{"id", "task_id", "batch_id", "style", "kind", "value", "spec_min", "spec_max", "in_spec", "follow_up_task_id", "note", "recorded_at", "recorded_by"}
+
+// WriteCSV exports all measurements, oldest first
+func (q *QualityLog) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(measurementCsvHeader); err != nil {
+		return err
+	}
+	for _, m := range q.Read() {
+		err := writer.Write([]string{
+			strconv.Itoa(m.Id),
+			strconv.Itoa(m.TaskId),
+			strconv.Itoa(m.BatchId),
+			m.Style,
+			string(m.Kind),
+			formatFloat(m.Value),
+			formatFloat(m.Spec.Min),
+			formatFloat(m.Spec.Max),
+			strconv.FormatBool(m.InSpec),
+			strconv.Itoa(m.FollowUpTaskId),
+			m.Note,
+			m.RecordedAt.Format(TimeFormat),
+			m.RecordedBy.UserName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
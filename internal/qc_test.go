@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpecRangeContains(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  SpecRange
+		value float64
+		want  bool
+	}{
+		{"inside", SpecRange{Min: 4.0, Max: 4.6}, 4.3, true},
+		{"lower bound", SpecRange{Min: 4.0, Max: 4.6}, 4.0, true},
+		{"upper bound", SpecRange{Min: 4.0, Max: 4.6}, 4.6, true},
+		{"below", SpecRange{Min: 4.0, Max: 4.6}, 3.9, false},
+		{"micro growth", SpecRange{Min: 0, Max: 0}, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.Contains(tt.value); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveStyleSpecs(t *testing.T) {
+	if got := ResolveStyleSpecs(" IPA "); got[IBU] != StyleSpecs["ipa"][IBU] {
+		t.Errorf("Expected ipa specs, got %v", got)
+	}
+	if got := ResolveStyleSpecs("Gose"); got[IBU] != StyleSpecs["ale"][IBU] {
+		t.Errorf("Expected ale specs for unknown style, got %v", got)
+	}
+}
+
+func TestCompleteQualityTask(t *testing.T) {
+	th := NewTaskHolder("")
+	qualityLog, _ := NewQualityLog("", th)
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Check gravity of IPA"), Category: CategoryPtr(Quality)})
+
+	measurements, err := qualityLog.CompleteTask(task.Id, "IPA", []MeasurementInput{
+		{Kind: Gravity, Value: 1.012},
+		{Kind: PH, Value: 4.9, Note: "retest"},
+	}, ProvideMockUser())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(measurements) != 2 || !measurements[0].InSpec || measurements[1].InSpec {
+		t.Fatalf("Unexpected measurements %v", measurements)
+	}
+	if measurements[0].FollowUpTaskId != 0 || measurements[1].FollowUpTaskId == 0 {
+		t.Errorf("Expected follow-up only for out of spec reading, got %v", measurements)
+	}
+
+	done, _ := th.FindTaskById(task.Id)
+	if !done.Done {
+		t.Errorf("Expected task to be done")
+	}
+	followUp, _ := th.FindTaskById(measurements[1].FollowUpTaskId)
+	want := "Investigate ph 4.9 out of spec (4.1-4.6) from task 1: Check gravity of IPA"
+	if followUp.Msg != want || followUp.Category != Quality {
+		t.Errorf("Expected follow-up %q, got %v", want, followUp)
+	}
+
+	var notQuality *NotQualityTaskError
+	_, err = qualityLog.CompleteTask(task.Id, "IPA", []MeasurementInput{{Kind: Gravity, Value: 1.012}}, nil)
+	if !errors.As(err, &notQuality) {
+		t.Errorf("Expected NotQualityTaskError for done task, got %v", err)
+	}
+}
+
+func TestCompleteTaskErrors(t *testing.T) {
+	th := NewTaskHolder("")
+	qualityLog, _ := NewQualityLog("", th)
+	brew := th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA"), Category: CategoryPtr(Brewing)})
+	check := th.CreateTask(TaskOptional{Msg: StringPtr("Check IPA"), Category: CategoryPtr(Quality)})
+
+	tests := []struct {
+		name   string
+		taskId int
+		inputs []MeasurementInput
+	}{
+		{"no measurements", check.Id, nil},
+		{"unknown kind", check.Id, []MeasurementInput{{Kind: "color", Value: 8}}},
+		{"not quality task", brew.Id, []MeasurementInput{{Kind: PH, Value: 4.2}}},
+		{"missing task", 99, []MeasurementInput{{Kind: PH, Value: 4.2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := qualityLog.CompleteTask(tt.taskId, "", tt.inputs, nil); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+	if len(qualityLog.Read()) != 0 {
+		t.Errorf("Expected no measurements recorded")
+	}
+}
+
+func TestCompleteBatchTaskUsesBatchStyle(t *testing.T) {
+	th := NewTaskHolder("")
+	bh, _ := NewBatchHolder("", th)
+	qualityLog, _ := NewQualityLog("", th)
+	qualityLog.StyleLookup = bh.BatchStyle
+
+	batch, _ := bh.CreateBatch(Batch{Style: "Lager", VolumeLiters: 500, BrewDate: timeNow().Add(48 * time.Hour), Fermenter: "FV1"}, nil)
+	tasks, _ := bh.BatchTasks(batch.Id)
+	var gravity Task
+	for _, task := range tasks {
+		if task.Category == Quality {
+			gravity = task
+			break
+		}
+	}
+
+	measurements, err := qualityLog.CompleteTask(gravity.Id, "", []MeasurementInput{{Kind: IBU, Value: 35}}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if measurements[0].Style != "Lager" || measurements[0].BatchId != batch.Id || measurements[0].InSpec {
+		t.Errorf("Expected out of spec lager measurement, got %v", measurements[0])
+	}
+}
+
+func TestQualityLogCSVAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qc.json")
+	th := NewTaskHolder("")
+	qualityLog, _ := NewQualityLog(path, th)
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Micro test"), Category: CategoryPtr(Quality)})
+	qualityLog.CompleteTask(task.Id, "stout", []MeasurementInput{{Kind: Micro, Value: 0, Note: "no growth, 72h"}}, nil)
+
+	reloaded, err := NewQualityLog(path, th)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var buf bytes.Buffer
+	if err := reloaded.WriteCSV(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid csv, got %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected header and one row, got %v", records)
+	}
+	row := records[1]
+	if row[0] != "1" || row[3] != "stout" || row[4] != "micro" || row[8] != "true" || row[10] != "no growth, 72h" || row[12] != "Team" {
+		t.Errorf("Unexpected csv row %v", row)
+	}
+}
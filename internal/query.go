@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultQueryLimit = 50
+	MaxQueryLimit     = 200
+)
+
+type InvalidQueryError struct {
+	Param  string
+	Reason string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return fmt.Sprintf("invalid query parameter %s: %s", e.Param, e.Reason)
+}
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+var taskSortFields = map[string]func(a, b *Task) int{
+	"id":        func(a, b *Task) int { return cmp.Compare(a.Id, b.Id) },
+	"msg":       func(a, b *Task) int { return strings.Compare(strings.ToLower(a.Msg), strings.ToLower(b.Msg)) },
+	"category":  func(a, b *Task) int { return cmp.Compare(a.Category, b.Category) },
+	"done":      func(a, b *Task) int { return compareBool(a.Done, b.Done) },
+	"createdAt": func(a, b *Task) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"plannedAt": func(a, b *Task) int { return a.PlannedAt.Compare(b.PlannedAt) },
+	"assignee":  func(a, b *Task) int { return strings.Compare(a.Assignee, b.Assignee) },
+	"creator":   func(a, b *Task) int { return strings.Compare(a.CreatedBy.UserName, b.CreatedBy.UserName) },
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// ParseSort parses comma separated keys like "-plannedAt,id", minus means descending
+func ParseSort(value string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key := SortKey{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if _, ok := taskSortFields[key.Field]; !ok {
+			return nil, &InvalidQueryError{Param: "sort", Reason: fmt.Sprintf("unknown field %q", key.Field)}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// TaskQuery filters are combined with AND, zero values don't filter.
+// Text matches message case insensitively.
+type TaskQuery struct {
+	Category    *TaskCategory
+	Done        *bool
+	Assignee    string
+	Creator     string
+	Tag         string
+	PlannedFrom time.Time
+	PlannedTo   time.Time
+	Text        string
+	Sort        []SortKey
+	Cursor      string
+	Limit       int
+}
+
+func (q *TaskQuery) matches(task *Task) bool {
+	switch {
+	case q.Category != nil && task.Category != *q.Category:
+		return false
+	case q.Done != nil && task.Done != *q.Done:
+		return false
+	case q.Assignee != "" && !strings.EqualFold(task.Assignee, q.Assignee):
+		return false
+	case q.Creator != "" && !strings.EqualFold(task.CreatedBy.UserName, q.Creator):
+		return false
+	case q.Tag != "" && !task.HasTag(q.Tag):
+		return false
+	case !q.PlannedFrom.IsZero() && task.PlannedAt.Before(q.PlannedFrom):
+		return false
+	case !q.PlannedTo.IsZero() && task.PlannedAt.After(q.PlannedTo):
+		return false
+	case q.Text != "" && !strings.Contains(strings.ToLower(task.Msg), strings.ToLower(q.Text)):
+		return false
+	}
+	return true
+}
+
+// compare orders tasks by the sort keys, id breaks ties so the order is total
+func (q *TaskQuery) compare(a, b *Task) int {
+	for _, key := range q.Sort {
+		c := taskSortFields[key.Field](a, b)
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.Id, b.Id)
+}
+
+// taskCursor keeps sort values of the last returned task, so the next page
+// starts right after it even when tasks were added or deleted meanwhile.
+type taskCursor struct {
+	Id        int          `json:"id"`
+	Msg       string       `json:"msg,omitempty"`
+	Category  TaskCategory `json:"category,omitempty"`
+	Done      bool         `json:"done,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	PlannedAt time.Time    `json:"plannedAt"`
+	Assignee  string       `json:"assignee,omitempty"`
+	Creator   string       `json:"creator,omitempty"`
+}
+
+func encodeCursor(task *Task) string {
+	data, _ := json.Marshal(taskCursor{
+		Id:        task.Id,
+		Msg:       task.Msg,
+		Category:  task.Category,
+		Done:      task.Done,
+		CreatedAt: task.CreatedAt,
+		PlannedAt: task.PlannedAt,
+		Assignee:  task.Assignee,
+		Creator:   task.CreatedBy.UserName,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*Task, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, &InvalidQueryError{Param: "cursor", Reason: "malformed"}
+	}
+	var c taskCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, &InvalidQueryError{Param: "cursor", Reason: "malformed"}
+	}
+	task := &Task{
+		Id:        c.Id,
+		Msg:       c.Msg,
+		Category:  c.Category,
+		Done:      c.Done,
+		CreatedAt: c.CreatedAt,
+		PlannedAt: c.PlannedAt,
+		Assignee:  c.Assignee,
+	}
+	task.CreatedBy.UserName = c.Creator
+	return task, nil
+}
+
+type TaskPage struct {
+	Tasks      []Task
+	Total      int
+	NextCursor string
+}
+
+// Query filters and sorts pointers into the store and copies only the
+// requested page. Total counts all matching tasks regardless of the cursor.
+func (t *TaskHolder) Query(q TaskQuery) (TaskPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	q.Limit = min(q.Limit, MaxQueryLimit)
+	var after *Task
+	if q.Cursor != "" {
+		var err error
+		if after, err = decodeCursor(q.Cursor); err != nil {
+			return TaskPage{}, err
+		}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	var matched []*Task
+	for i := range t.Tasks {
+		if q.matches(&t.Tasks[i]) {
+			matched = append(matched, &t.Tasks[i])
+		}
+	}
+	slices.SortFunc(matched, q.compare)
+
+	page := TaskPage{Total: len(matched)}
+	start := 0
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(matched, after, q.compare)
+		if start < len(matched) && matched[start].Id == after.Id {
+			start++
+		}
+	}
+	end := min(start+q.Limit, len(matched))
+	page.Tasks = make([]Task, 0, end-start)
+	for _, task := range matched[start:end] {
+		page.Tasks = append(page.Tasks, task.clone())
+	}
+	if end < len(matched) {
+		page.NextCursor = encodeCursor(matched[end-1])
+	}
+	return page, nil
+}
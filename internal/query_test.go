@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func provideQueryTaskHolder() *TaskHolder {
+	th := NewTaskHolder("")
+	alice := ProvideMockUser()
+	tasks := []TaskOptional{
+		{Msg: StringPtr("Brew IPA"), Category: CategoryPtr(Brewing), PlannedAt: TimePtr(MockTime.Add(3 * time.Hour)), Assignee: StringPtr("bob"), Tags: []string{"IPA", "brewday"}},
+		{Msg: StringPtr("Check gravity of IPA"), Category: CategoryPtr(Quality), PlannedAt: TimePtr(MockTime.Add(1 * time.Hour)), CreatedBy: alice, Tags: []string{"ipa"}},
+		{Msg: StringPtr("Post on social media"), Category: CategoryPtr(Marketing), PlannedAt: TimePtr(MockTime.Add(2 * time.Hour)), Assignee: StringPtr("Bob")},
+		{Msg: StringPtr("Order hops"), Category: CategoryPtr(Logistics), PlannedAt: TimePtr(MockTime.Add(2 * time.Hour)), CreatedBy: alice},
+	}
+	for _, task := range tasks {
+		th.CreateTask(task)
+	}
+	th.PartialUpdateTask(4, &TaskOptional{Done: BoolPtr(true)})
+	return th
+}
+
+func TestTaskQueryFilters(t *testing.T) {
+	th := provideQueryTaskHolder()
+	creator := ProvideMockUser().UserName
+
+	tests := []struct {
+		name  string
+		query TaskQuery
+		want  []int
+	}{
+		{"no filters", TaskQuery{}, []int{1, 2, 3, 4}},
+		{"category", TaskQuery{Category: CategoryPtr(Quality)}, []int{2}},
+		{"open", TaskQuery{Done: BoolPtr(false)}, []int{1, 2, 3}},
+		{"done", TaskQuery{Done: BoolPtr(true)}, []int{4}},
+		{"assignee ignores case", TaskQuery{Assignee: "BOB"}, []int{1, 3}},
+		{"creator", TaskQuery{Creator: creator}, []int{2, 4}},
+		{"tag", TaskQuery{Tag: "Ipa"}, []int{1, 2}},
+		{"planned range", TaskQuery{PlannedFrom: MockTime.Add(2 * time.Hour), PlannedTo: MockTime.Add(2 * time.Hour)}, []int{3, 4}},
+		{"text", TaskQuery{Text: "ipa"}, []int{1, 2}},
+		{"combined", TaskQuery{Text: "ipa", Category: CategoryPtr(Brewing)}, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := th.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got := taskIds(page.Tasks); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected tasks %v, got %v", tt.want, got)
+			}
+			if page.Total != len(tt.want) || page.NextCursor != "" {
+				t.Errorf("Expected total %d without next cursor, got %d %q", len(tt.want), page.Total, page.NextCursor)
+			}
+		})
+	}
+}
+
+func TestTaskQuerySort(t *testing.T) {
+	th := provideQueryTaskHolder()
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{"plannedAt", []int{2, 3, 4, 1}},
+		{"plannedAt,-id", []int{2, 4, 3, 1}},
+		{"-category", []int{2, 4, 3, 1}},
+		{"done,-msg", []int{3, 2, 1, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			keys, err := ParseSort(tt.sort)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			page, _ := th.Query(TaskQuery{Sort: keys})
+			if got := taskIds(page.Tasks); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected tasks %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	var queryErr *InvalidQueryError
+	if _, err := ParseSort("plannedAt,color"); !errors.As(err, &queryErr) {
+		t.Errorf("Expected InvalidQueryError, got %v", err)
+	}
+}
+
+func TestTaskQueryCursor(t *testing.T) {
+	th := provideQueryTaskHolder()
+	keys, _ := ParseSort("-plannedAt")
+	query := TaskQuery{Sort: keys, Limit: 2}
+
+	first, _ := th.Query(query)
+	if got := taskIds(first.Tasks); !slices.Equal(got, []int{1, 3}) || first.NextCursor == "" || first.Total != 4 {
+		t.Fatalf("Unexpected first page %v, total %d, cursor %q", got, first.Total, first.NextCursor)
+	}
+
+	// deleting the last returned task must not skip or repeat tasks
+	th.DeleteTask(3)
+	query.Cursor = first.NextCursor
+	second, _ := th.Query(query)
+	if got := taskIds(second.Tasks); !slices.Equal(got, []int{4, 2}) || second.NextCursor != "" {
+		t.Errorf("Unexpected second page %v, cursor %q", got, second.NextCursor)
+	}
+
+	query.Cursor = "not a cursor"
+	if _, err := th.Query(query); err == nil {
+		t.Errorf("Expected error for malformed cursor")
+	}
+}
+
+func TestTaskQueryLimit(t *testing.T) {
+	th := NewTaskHolder("")
+	for i := 0; i < MaxQueryLimit+10; i++ {
+		th.CreateTask(TaskOptional{Msg: StringPtr("task")})
+	}
+	page, _ := th.Query(TaskQuery{})
+	if len(page.Tasks) != DefaultQueryLimit {
+		t.Errorf("Expected default limit %d, got %d", DefaultQueryLimit, len(page.Tasks))
+	}
+	page, _ = th.Query(TaskQuery{Limit: 1000})
+	if len(page.Tasks) != MaxQueryLimit || page.Total != MaxQueryLimit+10 {
+		t.Errorf("Expected %d of %d tasks, got %d of %d", MaxQueryLimit, MaxQueryLimit+10, len(page.Tasks), page.Total)
+	}
+}
+
+func taskIds(tasks []Task) []int {
+	ids := make([]int, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.Id
+	}
+	return ids
+}
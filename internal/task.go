@@ -44,6 +44,19 @@ func (e *PastPlannedTimeError) Error() string {
 	return fmt.Sprintf("planned time %v is in the past", e.PlannedTime)
 }
 
+// VersionMismatchError is returned by conditional writes of a task changed meanwhile
+type VersionMismatchError struct {
+	Expected int
+	Current  int
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("task version is %d, expected %d", e.Current, e.Expected)
+}
+
+// AnyVersion disables the version check of conditional writes
+const AnyVersion = -1
+
 type TaskCategory int
 
 const (
@@ -57,14 +70,34 @@ func (tc TaskCategory) String() string {
 	return [...]string{"Brewing", "Marketing", "Logistics", "Quality"}[tc]
 }
 
+// ParseTaskCategory accepts category name in any case or its number
+func ParseTaskCategory(value string) (TaskCategory, error) {
+	value = strings.TrimSpace(value)
+	for category := Brewing; category <= Quality; category++ {
+		if strings.EqualFold(category.String(), value) || fmt.Sprint(int(category)) == value {
+			return category, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid task category: %q", value)
+}
+
 type Task struct {
-	Id        int
-	Msg       string
-	Category  TaskCategory
-	Done      bool
-	CreatedAt time.Time
-	PlannedAt time.Time
-	CreatedBy users.User
+	Id          int
+	Msg         string
+	Category    TaskCategory
+	Done        bool
+	CreatedAt   time.Time
+	PlannedAt   time.Time
+	CreatedBy   users.User
+	Checklist   []ChecklistItem
+	BatchId     int
+	EquipmentId int
+	Assignee    string
+	// AssigneeId is the user id of Assignee when it was assigned, empty for
+	// names without account. Names can be reused, the id can't.
+	AssigneeId string
+	Tags       []string
+	Version    int
 }
 
 func NewTask(id int, task string, category TaskCategory, plannedAt time.Time, user *users.User) Task {
@@ -79,6 +112,7 @@ func NewTask(id int, task string, category TaskCategory, plannedAt time.Time, us
 		CreatedAt: timeNow().Round(0),
 		PlannedAt: plannedAt.Round(0),
 		CreatedBy: *user,
+		Version:   1,
 	}
 }
 
@@ -100,7 +134,54 @@ func (t *Task) String() string {
 		t.Msg,
 		formatDatetime(t.CreatedAt),
 		formatDatetime(t.PlannedAt),
-		t.Done)
+		t.Done) + checklistString(t.Checklist)
+}
+
+func checklistString(checklist []ChecklistItem) string {
+	var sb strings.Builder
+	for _, item := range checklist {
+		mark := " "
+		if item.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(&sb, "\n  %d. [%s] %s", item.Id, mark, item.Text)
+	}
+	return sb.String()
+}
+
+// returns number of done checklist items and total number of items
+func (t *Task) ChecklistProgress() (int, int) {
+	done := 0
+	for _, item := range t.Checklist {
+		if item.Done {
+			done++
+		}
+	}
+	return done, len(t.Checklist)
+}
+
+func (t *Task) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, taskTag := range t.Tags {
+		if taskTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTags lowercases and trims tags, drops empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
 }
 
 func PrintTasks(out io.Writer, tasks ...Task) {
@@ -1,8 +1,11 @@
 package internal
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +25,9 @@ type TaskOptional struct {
 	Category  *TaskCategory `json:"category"`
 	PlannedAt *CustomTime   `json:"plannedAt"`
 	CreatedBy *users.User   `json:"createdBy"`
+	Assignee  *string       `json:"assignee"`
+	Tags      []string      `json:"tags"` // nil keeps tags unchanged
+	UpdatedBy *users.User   `json:"-"`    // passed to listeners as TaskEvent.By
 	// trackerId uuid.UUID
 }
 
@@ -86,10 +92,20 @@ type TaskServiceInterface interface {
 
 // implements TaskService interface
 type TaskHolder struct {
-	latestId  int
-	Tasks     []Task
-	DiskPath  string
-	TasksPipe chan Task
+	latestId         int
+	Tasks            []Task
+	DiskPath         string
+	TasksPipe        chan Task
+	latestTemplateId int
+	Templates        []TaskTemplate
+	revision         int
+	listeners        []TaskListener
+	pendingEvents    []TaskEvent
+	dispatching      bool
+	validators       []TaskValidator
+	// AssigneeLookup returns the user id of an assignee name, main sets it to
+	// the user store. Without it assignees have no id.
+	AssigneeLookup func(userName string) (string, bool)
 	sync.Mutex
 }
 
@@ -118,8 +134,14 @@ func (t *TaskHolder) Add(task Task) {
 }
 
 func (t *TaskHolder) CreateTask(update TaskOptional) *Task {
+	defer t.flushEvents()
 	t.Lock()
 	defer t.Unlock()
+	return t.createTask(update)
+}
+
+// caller holds the lock
+func (t *TaskHolder) createTask(update TaskOptional) *Task {
 	t.latestId++
 
 	var msg string
@@ -138,10 +160,29 @@ func (t *TaskHolder) CreateTask(update TaskOptional) *Task {
 	}
 
 	task := NewTask(t.latestId, msg, category, plannedAt, update.CreatedBy)
+	if update.Assignee != nil {
+		t.assign(&task, *update.Assignee)
+	}
+	if update.Tags != nil {
+		task.Tags = NormalizeTags(update.Tags)
+	}
 	t.Tasks = append(t.Tasks, task)
+	t.queueEvent(TaskCreated, &task, nil, update.CreatedBy)
 	return &task
 }
 
+// ValidateNewTask checks fields of a task about to be created. Unlike updates
+// a planned time in the past is allowed, e.g. to log work already done.
+func ValidateNewTask(update *TaskOptional) error {
+	if update.Msg == nil || strings.TrimSpace(*update.Msg) == "" {
+		return &EmptyTaskValueError{}
+	}
+	if update.Category != nil && !isValidTaskCategory(*update.Category) {
+		return &InvalidCategoryError{Category: *update.Category}
+	}
+	return nil
+}
+
 func (t *TaskHolder) FindTaskById(taskId int) (*Task, error) {
 
 	for i := range t.Tasks {
@@ -152,67 +193,261 @@ func (t *TaskHolder) FindTaskById(taskId int) (*Task, error) {
 	return nil, ErrNotFound
 }
 
+// GetTask returns a copy of the task, safe to use while other requests change it
+func (t *TaskHolder) GetTask(taskId int) (*Task, error) {
+	t.Lock()
+	defer t.Unlock()
+	task, err := t.FindTaskById(taskId)
+	if err != nil {
+		return nil, err
+	}
+	found := task.clone()
+	return &found, nil
+}
+
 func (t *TaskHolder) PartialUpdateTask(taskId int, update *TaskOptional) error {
+	_, err := t.UpdateTaskIfVersion(taskId, AnyVersion, update)
+	return err
+}
+
+// UpdateTaskIfVersion applies update only when the task still has the expected
+// version, AnyVersion skips the check. Returns the updated task.
+func (t *TaskHolder) UpdateTaskIfVersion(taskId int, expectedVersion int, update *TaskOptional) (*Task, error) {
+	defer t.flushEvents()
 	t.Lock()
 	defer t.Unlock()
+	task, err := t.findTaskWithVersion(taskId, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.applyUpdate(task, update); err != nil {
+		return nil, err
+	}
+	updated := task.clone()
+	return &updated, nil
+}
+
+// caller holds the lock
+func (t *TaskHolder) findTaskWithVersion(taskId int, expectedVersion int) (*Task, error) {
 	task, err := t.FindTaskById(taskId)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if expectedVersion != AnyVersion && task.Version != expectedVersion {
+		return nil, &VersionMismatchError{Expected: expectedVersion, Current: task.Version}
+	}
+	return task, nil
+}
+
+// applyUpdate validates the update on a copy, the task is changed only when
+// the whole update is valid. Caller holds the lock.
+func (t *TaskHolder) applyUpdate(task *Task, update *TaskOptional) error {
+	previous := task.clone()
 
+	for _, validate := range t.validators {
+		if err := validate(previous, update); err != nil {
+			return err
+		}
+	}
+
+	updated := task.clone()
 	if update.Done != nil {
-		task.Done = *update.Done
+		updated.Done = *update.Done
 	}
 
 	if update.Msg != nil {
 		if len(*update.Msg) == 0 {
 			return &EmptyTaskValueError{}
 		}
-		task.Msg = *update.Msg
+		updated.Msg = *update.Msg
 	}
 
 	if update.Category != nil {
 		if !isValidTaskCategory(*update.Category) {
 			return &InvalidCategoryError{Category: *update.Category}
 		}
-		task.Category = *update.Category
+		updated.Category = *update.Category
 	}
 
 	if update.PlannedAt != nil {
 		if update.PlannedAt.Time.Before(time.Now()) {
-			return &PastPlannedTimeError{PlannedTime: *&update.PlannedAt.Time}
+			return &PastPlannedTimeError{PlannedTime: update.PlannedAt.Time}
 		}
-		task.PlannedAt = *&update.PlannedAt.Time
+		updated.PlannedAt = update.PlannedAt.Time
+	}
+
+	if update.Assignee != nil && strings.TrimSpace(*update.Assignee) != updated.Assignee {
+		t.assign(&updated, *update.Assignee)
+	}
+
+	if update.Tags != nil {
+		updated.Tags = NormalizeTags(update.Tags)
 	}
 
+	*task = updated
+	t.queueEvent(TaskUpdated, task, &previous, update.UpdatedBy)
 	return nil
 }
 
+// assign sets the assignee and looks up its user id, caller holds the lock
+func (t *TaskHolder) assign(task *Task, assignee string) {
+	task.Assignee = strings.TrimSpace(assignee)
+	task.AssigneeId = ""
+	if task.Assignee != "" && t.AssigneeLookup != nil {
+		task.AssigneeId, _ = t.AssigneeLookup(task.Assignee)
+	}
+}
+
+// ResolveAssignees looks up the user id of assignees saved before tasks kept
+// it, main calls it after setting AssigneeLookup
+func (t *TaskHolder) ResolveAssignees() {
+	t.Lock()
+	defer t.Unlock()
+	for i := range t.Tasks {
+		if t.Tasks[i].Assignee != "" && t.Tasks[i].AssigneeId == "" {
+			t.assign(&t.Tasks[i], t.Tasks[i].Assignee)
+		}
+	}
+}
+
 func (t *TaskHolder) DeleteTask(taskId int) error {
+	return t.DeleteTaskIfVersion(taskId, AnyVersion)
+}
+
+// DeleteTaskIfVersion deletes the task only when it still has the expected version
+func (t *TaskHolder) DeleteTaskIfVersion(taskId int, expectedVersion int) error {
+	defer t.flushEvents()
 	t.Lock()
 	defer t.Unlock()
+	return t.deleteTask(taskId, expectedVersion)
+}
+
+// caller holds the lock
+func (t *TaskHolder) deleteTask(taskId int, expectedVersion int) error {
 	index := -1
-	topId := -1
 	for i, task := range t.Tasks {
 		if task.Id == taskId {
 			index = i
-
-		}
-		if task.Id > topId {
-			topId = task.Id
 		}
 	}
 
 	if index == -1 {
-		return fmt.Errorf("task with ID %d not found", taskId)
+		return fmt.Errorf("task with ID %d: %w", taskId, ErrNotFound)
+	}
+	if expectedVersion != AnyVersion && t.Tasks[index].Version != expectedVersion {
+		return &VersionMismatchError{Expected: expectedVersion, Current: t.Tasks[index].Version}
 	}
 
-	// deletedIndex = t.Tasks[index]
+	t.queueEvent(TaskDeleted, &t.Tasks[index], nil, nil)
 	t.Tasks = append(t.Tasks[:index], t.Tasks[index+1:]...)
 
 	return nil
 }
 
+// PatchTask applies merge patch or JSON patch to the JSON form of the task,
+// as returned by the API. Only fields settable by TaskOptional may change.
+func (t *TaskHolder) PatchTask(taskId int, expectedVersion int, patchType PatchType, patch []byte, user *users.User) (*Task, error) {
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+	task, err := t.findTaskWithVersion(taskId, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := json.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	switch patchType {
+	case MergePatch:
+		doc, err = ApplyMergePatch(doc, patch)
+	case JSONPatch:
+		doc, err = ApplyJSONPatch(doc, patch)
+	default:
+		err = &InvalidPatchError{Reason: fmt.Sprintf("unsupported patch type %q", patchType)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patched Task
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		return nil, err
+	}
+
+	update, err := patchedTaskUpdate(task, &patched)
+	if err != nil {
+		return nil, err
+	}
+	if !update.isEmpty() {
+		update.UpdatedBy = user
+		if err := t.applyUpdate(task, &update); err != nil {
+			return nil, err
+		}
+	}
+	updated := task.clone()
+	return &updated, nil
+}
+
+func (update *TaskOptional) isEmpty() bool {
+	return update.Done == nil && update.Msg == nil && update.Category == nil && update.PlannedAt == nil &&
+		update.CreatedBy == nil && update.Assignee == nil && update.Tags == nil
+}
+
+// patchedTaskUpdate turns changed fields into an update, changes of other fields are rejected
+func patchedTaskUpdate(task *Task, patched *Task) (TaskOptional, error) {
+	readOnly := map[string]bool{
+		"Id":          patched.Id == task.Id,
+		"CreatedAt":   patched.CreatedAt.Equal(task.CreatedAt),
+		"CreatedBy":   patched.CreatedBy == task.CreatedBy,
+		"Checklist":   reflect.DeepEqual(patched.Checklist, task.Checklist) || len(patched.Checklist)+len(task.Checklist) == 0,
+		"BatchId":     patched.BatchId == task.BatchId,
+		"EquipmentId": patched.EquipmentId == task.EquipmentId,
+		"Version":     patched.Version == task.Version,
+	}
+	for field, unchanged := range readOnly {
+		if !unchanged {
+			return TaskOptional{}, &InvalidPatchError{Path: "/" + field, Reason: "field is read-only"}
+		}
+	}
+
+	var update TaskOptional
+	if patched.Done != task.Done {
+		update.Done = BoolPtr(patched.Done)
+	}
+	if patched.Msg != task.Msg {
+		update.Msg = &patched.Msg
+	}
+	if patched.Category != task.Category {
+		update.Category = CategoryPtr(patched.Category)
+	}
+	if !patched.PlannedAt.Equal(task.PlannedAt) {
+		update.PlannedAt = &CustomTime{Time: patched.PlannedAt}
+	}
+	if patched.Assignee != task.Assignee {
+		update.Assignee = &patched.Assignee
+	}
+	if !slices.Equal(patched.Tags, task.Tags) {
+		update.Tags = append([]string{}, patched.Tags...)
+	}
+	return update, nil
+}
+
+// SetTaskEquipment links the task to equipment it depends on, zero unlinks it
+func (t *TaskHolder) SetTaskEquipment(taskId int, equipmentId int) error {
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+	task, err := t.FindTaskById(taskId)
+	if err != nil {
+		return err
+	}
+	previous := task.clone()
+	task.EquipmentId = equipmentId
+	t.queueEvent(TaskUpdated, task, &previous, nil)
+	return nil
+}
+
 func (t *TaskHolder) SearchTaskByWord(word string) ([]Task, error) {
 	var matches []Task
 	for _, task := range t.Tasks {
@@ -223,6 +458,123 @@ func (t *TaskHolder) SearchTaskByWord(word string) ([]Task, error) {
 	return matches, nil
 }
 
+func (t *TaskHolder) ReadTemplates() []TaskTemplate {
+	t.Lock()
+	defer t.Unlock()
+	return append([]TaskTemplate(nil), t.Templates...)
+}
+
+// saveTemplates writes templates next to the tasks file when DiskPath is set,
+// caller holds the lock
+func (t *TaskHolder) saveTemplates() error {
+	if t.DiskPath == "" {
+		return nil
+	}
+	return WriteTemplatesToJson(TemplatesPath(t.DiskPath), t.Templates...)
+}
+
+func (t *TaskHolder) AddTemplate(tmpl TaskTemplate) (*TaskTemplate, error) {
+	if err := tmpl.Validate(); err != nil {
+		return nil, err
+	}
+	t.Lock()
+	defer t.Unlock()
+	if tmpl.Id > t.latestTemplateId {
+		t.latestTemplateId = tmpl.Id
+	} else {
+		t.latestTemplateId++
+		tmpl.Id = t.latestTemplateId
+	}
+	t.Templates = append(t.Templates, tmpl)
+	return &tmpl, t.saveTemplates()
+}
+
+func (t *TaskHolder) FindTemplateById(templateId int) (*TaskTemplate, error) {
+	t.Lock()
+	defer t.Unlock()
+	tmpl, err := t.findTemplateById(templateId)
+	if err != nil {
+		return nil, err
+	}
+	found := *tmpl
+	found.Checklist = append([]string(nil), tmpl.Checklist...)
+	return &found, nil
+}
+
+// caller holds the lock
+func (t *TaskHolder) findTemplateById(templateId int) (*TaskTemplate, error) {
+	for i := range t.Templates {
+		if t.Templates[i].Id == templateId {
+			return &t.Templates[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (t *TaskHolder) DeleteTemplate(templateId int) error {
+	t.Lock()
+	defer t.Unlock()
+	for i := range t.Templates {
+		if t.Templates[i].Id == templateId {
+			t.Templates = append(t.Templates[:i], t.Templates[i+1:]...)
+			return t.saveTemplates()
+		}
+	}
+	return ErrNotFound
+}
+
+// CreateTaskFromTemplate fills template placeholders with vars and plans the
+// task at start plus template offset.
+func (t *TaskHolder) CreateTaskFromTemplate(templateId int, vars map[string]string, start time.Time, user *users.User) (*Task, error) {
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+	tmpl, err := t.findTemplateById(templateId)
+	if err != nil {
+		return nil, err
+	}
+	task, err := t.newTaskFromTemplate(tmpl, vars, start, user)
+	if err != nil {
+		return nil, err
+	}
+
+	t.latestId++
+	task.Id = t.latestId
+	t.Tasks = append(t.Tasks, task)
+	t.queueEvent(TaskCreated, &task, nil, user)
+	return &task, nil
+}
+
+// builds task without id, caller holds the lock and assigns the id
+func (t *TaskHolder) newTaskFromTemplate(tmpl *TaskTemplate, vars map[string]string, start time.Time, user *users.User) (Task, error) {
+	msg, err := tmpl.RenderMsg(vars)
+	if err != nil {
+		return Task{}, err
+	}
+	task := NewTask(0, msg, tmpl.Category, tmpl.PlannedAt(start), user)
+	task.Checklist = NewChecklist(tmpl.Checklist)
+	return task, nil
+}
+
+func (t *TaskHolder) SetChecklistItem(taskId int, itemId int, done bool) error {
+	defer t.flushEvents()
+	t.Lock()
+	defer t.Unlock()
+	task, err := t.FindTaskById(taskId)
+	if err != nil {
+		return err
+	}
+	previous := task.clone()
+	for i := range task.Checklist {
+		if task.Checklist[i].Id == itemId {
+			task.Checklist[i].Done = done
+			t.queueEvent(TaskUpdated, task, &previous, nil)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
 func isValidTaskCategory(category TaskCategory) bool {
 	return Brewing <= category && category <= Quality
 }
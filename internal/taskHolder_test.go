@@ -2,6 +2,7 @@ package internal
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -48,7 +49,7 @@ func TestReadTask(t *testing.T) {
 		if len(allTasks) != 1 {
 			t.Errorf("got %d want 1", len(allTasks))
 		}
-		if allTasks[0] != testTask {
+		if !reflect.DeepEqual(allTasks[0], testTask) {
 			t.Errorf("got %v want %v", allTasks[0], testTask)
 		}
 	})
@@ -62,11 +63,10 @@ func TestCreateTask(t *testing.T) {
 	plannedAt := time.Now()
 
 	updt := TaskOptional{
-		nil,
-		StringPtr(taskValue),
-		CategoryPtr(category),
-		TimePtr(plannedAt),
-		ProvideMockUser(),
+		Msg:       StringPtr(taskValue),
+		Category:  CategoryPtr(category),
+		PlannedAt: TimePtr(plannedAt),
+		CreatedBy: ProvideMockUser(),
 	}
 	task := th.CreateTask(updt)
 
@@ -103,11 +103,10 @@ func TestFindTaskById(t *testing.T) {
 	plannedAt := time.Now()
 
 	updt := TaskOptional{
-		nil,
-		StringPtr(taskValue),
-		CategoryPtr(category),
-		TimePtr(plannedAt),
-		ProvideMockUser(),
+		Msg:       StringPtr(taskValue),
+		Category:  CategoryPtr(category),
+		PlannedAt: TimePtr(plannedAt),
+		CreatedBy: ProvideMockUser(),
 	}
 	task1 := th.CreateTask(updt)
 	updt.Msg = StringPtr("Task 2")
@@ -145,11 +144,10 @@ func TestPartialUpdateTask(t *testing.T) {
 		plannedAt := time.Now()
 
 		updt := TaskOptional{
-			nil,
-			StringPtr(taskValue),
-			CategoryPtr(category),
-			TimePtr(plannedAt),
-			ProvideMockUser(),
+			Msg:       StringPtr(taskValue),
+			Category:  CategoryPtr(category),
+			PlannedAt: TimePtr(plannedAt),
+			CreatedBy: ProvideMockUser(),
 		}
 		initialTask := th.CreateTask(updt)
 
@@ -385,3 +383,51 @@ func assertUnchanged(updatedTask *Task, initialTask *Task, t *testing.T, fields
 		}
 	}
 }
+
+func TestAssigneeLookup(t *testing.T) {
+	th := NewTaskHolder("")
+	th.Add(Task{Id: 1, Msg: "Saved before assignee ids", Assignee: "jane"})
+	ids := map[string]string{"jane": "id-jane", "joe": "id-joe"}
+	th.AssigneeLookup = func(userName string) (string, bool) {
+		id, ok := ids[userName]
+		return id, ok
+	}
+
+	th.ResolveAssignees()
+	if task, _ := th.GetTask(1); task.AssigneeId != "id-jane" {
+		t.Errorf("Expected the saved assignee resolved, got %q", task.AssigneeId)
+	}
+
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA"), Assignee: StringPtr(" joe ")})
+	if task.Assignee != "joe" || task.AssigneeId != "id-joe" {
+		t.Errorf("Expected joe with id, got %q %q", task.Assignee, task.AssigneeId)
+	}
+
+	tests := []struct {
+		name     string
+		assignee string
+		wantId   string
+	}{
+		{"other user", "jane", "id-jane"},
+		{"no account", "the cellar team", ""},
+		{"unassigned", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assignee := tt.assignee
+			if err := th.PartialUpdateTask(task.Id, &TaskOptional{Assignee: &assignee}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if updated, _ := th.GetTask(task.Id); updated.Assignee != tt.assignee || updated.AssigneeId != tt.wantId {
+				t.Errorf("Expected %q with id %q, got %q %q", tt.assignee, tt.wantId, updated.Assignee, updated.AssigneeId)
+			}
+		})
+	}
+
+	// the same name keeps the id it was assigned with
+	ids["jane"] = "id-new-jane"
+	th.PartialUpdateTask(1, &TaskOptional{Assignee: StringPtr("jane")})
+	if task, _ := th.GetTask(1); task.AssigneeId != "id-jane" {
+		t.Errorf("Expected the id kept, got %q", task.AssigneeId)
+	}
+}
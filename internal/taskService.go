@@ -1,5 +1,11 @@
 package internal
 
+import (
+	"time"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
 type ConcurrentTaskService struct {
 	TaskHolder   *TaskHolder
 	taskRequests chan<- TaskRequest
@@ -44,10 +50,38 @@ func (t *ConcurrentTaskService) FindTaskById(taskId int) (*Task, error) {
 	return t.TaskHolder.FindTaskById(taskId)
 }
 
+func (t *ConcurrentTaskService) GetTask(taskId int) (*Task, error) {
+	return t.TaskHolder.GetTask(taskId)
+}
+
+func (t *ConcurrentTaskService) UpdateTaskIfVersion(taskId int, expectedVersion int, update *TaskOptional) (*Task, error) {
+	return t.TaskHolder.UpdateTaskIfVersion(taskId, expectedVersion, update)
+}
+
+func (t *ConcurrentTaskService) PatchTask(taskId int, expectedVersion int, patchType PatchType, patch []byte, user *users.User) (*Task, error) {
+	return t.TaskHolder.PatchTask(taskId, expectedVersion, patchType, patch, user)
+}
+
+func (t *ConcurrentTaskService) DeleteTaskIfVersion(taskId int, expectedVersion int) error {
+	return t.TaskHolder.DeleteTaskIfVersion(taskId, expectedVersion)
+}
+
+func (t *ConcurrentTaskService) Revision() int {
+	return t.TaskHolder.Revision()
+}
+
 func (t *ConcurrentTaskService) PartialUpdateTask(taskId int, update *TaskOptional) error {
 	return t.TaskHolder.PartialUpdateTask(taskId, update)
 }
 
+func (t *ConcurrentTaskService) QueryTasks(query TaskQuery) (TaskPage, error) {
+	return t.TaskHolder.Query(query)
+}
+
+func (t *ConcurrentTaskService) ApplyBulk(operations []BulkOperation, atomic bool, authorize BulkAuthorizer) ([]BulkResult, error) {
+	return t.TaskHolder.ApplyBulk(operations, atomic, authorize)
+}
+
 func (t *ConcurrentTaskService) DeleteTask(taskId int) error {
 	return t.TaskHolder.DeleteTask(taskId)
 }
@@ -60,3 +94,27 @@ func (t *ConcurrentTaskService) Count() (int, int) {
 func (t *ConcurrentTaskService) Read() []Task {
 	return t.TaskHolder.Read()
 }
+
+func (t *ConcurrentTaskService) ReadTemplates() []TaskTemplate {
+	return t.TaskHolder.ReadTemplates()
+}
+
+func (t *ConcurrentTaskService) AddTemplate(tmpl TaskTemplate) (*TaskTemplate, error) {
+	return t.TaskHolder.AddTemplate(tmpl)
+}
+
+func (t *ConcurrentTaskService) FindTemplateById(templateId int) (*TaskTemplate, error) {
+	return t.TaskHolder.FindTemplateById(templateId)
+}
+
+func (t *ConcurrentTaskService) DeleteTemplate(templateId int) error {
+	return t.TaskHolder.DeleteTemplate(templateId)
+}
+
+func (t *ConcurrentTaskService) CreateTaskFromTemplate(templateId int, vars map[string]string, start time.Time, user *users.User) (*Task, error) {
+	return t.TaskHolder.CreateTaskFromTemplate(templateId, vars, start, user)
+}
+
+func (t *ConcurrentTaskService) SetChecklistItem(taskId int, itemId int, done bool) error {
+	return t.TaskHolder.SetChecklistItem(taskId, itemId, done)
+}
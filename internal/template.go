@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+type MissingPlaceholderError struct {
+	Name string
+}
+
+func (e *MissingPlaceholderError) Error() string {
+	return fmt.Sprintf("no value provided for placeholder {{%s}}", e.Name)
+}
+
+type ChecklistItem struct {
+	Id   int    `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// TaskTemplate describes a task that is created over and over again,
+// e.g. the brew day checklist. Msg may contain placeholders like {{beer}}.
+type TaskTemplate struct {
+	Id          int          `json:"id"`
+	Name        string       `json:"name"`
+	Msg         string       `json:"msg"`
+	Category    TaskCategory `json:"category"`
+	OffsetHours int          `json:"offsetHours"`
+	Checklist   []string     `json:"checklist"`
+}
+
+func (tmpl *TaskTemplate) Validate() error {
+	if strings.TrimSpace(tmpl.Name) == "" || strings.TrimSpace(tmpl.Msg) == "" {
+		return &EmptyTaskValueError{}
+	}
+	if !isValidTaskCategory(tmpl.Category) {
+		return &InvalidCategoryError{Category: tmpl.Category}
+	}
+	return nil
+}
+
+// Placeholders returns names of all placeholders used in template message
+func (tmpl *TaskTemplate) Placeholders() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(tmpl.Msg, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+func (tmpl *TaskTemplate) RenderMsg(vars map[string]string) (string, error) {
+	for _, name := range tmpl.Placeholders() {
+		if _, ok := vars[name]; !ok {
+			return "", &MissingPlaceholderError{Name: name}
+		}
+	}
+	msg := placeholderPattern.ReplaceAllStringFunc(tmpl.Msg, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		return vars[name]
+	})
+	return msg, nil
+}
+
+func (tmpl *TaskTemplate) PlannedAt(start time.Time) time.Time {
+	return start.Add(time.Duration(tmpl.OffsetHours) * time.Hour)
+}
+
+func NewChecklist(items []string) []ChecklistItem {
+	if len(items) == 0 {
+		return nil
+	}
+	checklist := make([]ChecklistItem, len(items))
+	for i, text := range items {
+		checklist[i] = ChecklistItem{Id: i + 1, Text: text}
+	}
+	return checklist
+}
+
+// Templates are stored next to the tasks file, tasks.json -> tasks_templates.json
+func TemplatesPath(tasksPath string) string {
+	return strings.TrimSuffix(tasksPath, ".json") + "_templates.json"
+}
+
+func WriteTemplatesToJson(filePath string, templates ...TaskTemplate) error {
+	return saveJsonFile(filePath, templates)
+}
+
+func ReadTemplatesFromJSON(filePath string) ([]TaskTemplate, error) {
+	var templates []TaskTemplate
+	if err := loadJsonFile(filePath, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
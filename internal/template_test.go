@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func provideBrewDayTemplate() TaskTemplate {
+	return TaskTemplate{
+		Name:        "Brew day",
+		Msg:         "Brew {{beer}} in {{ fermenter }}",
+		Category:    Brewing,
+		OffsetHours: 48,
+		Checklist:   []string{"Mill grain", "Mash in", "Boil", "Chill"},
+	}
+}
+
+func TestTemplatePlaceholders(t *testing.T) {
+	tmpl := TaskTemplate{Msg: "Brew {{beer}}, taste {{beer}} and move to {{fermenter}}"}
+	got := tmpl.Placeholders()
+	want := []string{"beer", "fermenter"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTemplateRenderMsg(t *testing.T) {
+	tmpl := provideBrewDayTemplate()
+
+	t.Run("All placeholders provided", func(t *testing.T) {
+		msg, err := tmpl.RenderMsg(map[string]string{"beer": "Hazy IPA", "fermenter": "FV2"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if msg != "Brew Hazy IPA in FV2" {
+			t.Errorf("got %q, want %q", msg, "Brew Hazy IPA in FV2")
+		}
+	})
+
+	t.Run("Missing placeholder", func(t *testing.T) {
+		_, err := tmpl.RenderMsg(map[string]string{"beer": "Hazy IPA"})
+		missingErr, ok := err.(*MissingPlaceholderError)
+		if !ok {
+			t.Fatalf("Expected MissingPlaceholderError, got %v", err)
+		}
+		if missingErr.Name != "fermenter" {
+			t.Errorf("got %q, want fermenter", missingErr.Name)
+		}
+	})
+}
+
+func TestAddTemplate(t *testing.T) {
+	th := NewTaskHolder(filepath.Join(t.TempDir(), "tasks.json"))
+
+	tmpl, err := th.AddTemplate(provideBrewDayTemplate())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tmpl.Id != 1 {
+		t.Errorf("Expected template id 1, got %d", tmpl.Id)
+	}
+
+	_, err = th.AddTemplate(TaskTemplate{Name: "Broken", Msg: "Broken", Category: 42})
+	if _, ok := err.(*InvalidCategoryError); !ok {
+		t.Errorf("Expected InvalidCategoryError, got %v", err)
+	}
+
+	_, err = th.AddTemplate(TaskTemplate{Name: "Empty"})
+	if _, ok := err.(*EmptyTaskValueError); !ok {
+		t.Errorf("Expected EmptyTaskValueError, got %v", err)
+	}
+
+	if len(th.ReadTemplates()) != 1 {
+		t.Errorf("Expected 1 template, got %d", len(th.ReadTemplates()))
+	}
+}
+
+func TestCreateTaskFromTemplate(t *testing.T) {
+	th := NewTaskHolder(filepath.Join(t.TempDir(), "tasks.json"))
+	tmpl, _ := th.AddTemplate(provideBrewDayTemplate())
+	start := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	task, err := th.CreateTaskFromTemplate(tmpl.Id, map[string]string{"beer": "Stout", "fermenter": "FV1"}, start, ProvideMockUser())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if task.Msg != "Brew Stout in FV1" {
+		t.Errorf("Expected msg %q, got %q", "Brew Stout in FV1", task.Msg)
+	}
+	if !task.PlannedAt.Equal(start.Add(48 * time.Hour)) {
+		t.Errorf("Expected plannedAt %v, got %v", start.Add(48*time.Hour), task.PlannedAt)
+	}
+	if len(task.Checklist) != 4 || task.Checklist[3].Id != 4 || task.Checklist[3].Text != "Chill" {
+		t.Errorf("Unexpected checklist %v", task.Checklist)
+	}
+	if _, total := th.Count(); total != 1 {
+		t.Errorf("Expected 1 task in holder, got %d", total)
+	}
+
+	_, err = th.CreateTaskFromTemplate(999, nil, start, nil)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSetChecklistItem(t *testing.T) {
+	th := NewTaskHolder(filepath.Join(t.TempDir(), "tasks.json"))
+	tmpl, _ := th.AddTemplate(provideBrewDayTemplate())
+	task, _ := th.CreateTaskFromTemplate(tmpl.Id, map[string]string{"beer": "Stout", "fermenter": "FV1"}, time.Now(), nil)
+
+	if err := th.SetChecklistItem(task.Id, 2, true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	updated, _ := th.FindTaskById(task.Id)
+	if done, total := updated.ChecklistProgress(); done != 1 || total != 4 {
+		t.Errorf("Expected progress 1/4, got %d/%d", done, total)
+	}
+	if !updated.Checklist[1].Done || updated.Checklist[0].Done {
+		t.Errorf("Expected only item 2 to be done, got %v", updated.Checklist)
+	}
+
+	if err := th.SetChecklistItem(task.Id, 99, true); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTemplatesJsonRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	if got := TemplatesPath(path); got != filepath.Join(filepath.Dir(path), "tasks_templates.json") {
+		t.Fatalf("Unexpected templates path %s", got)
+	}
+
+	tmpl := provideBrewDayTemplate()
+	tmpl.Id = 3
+	err := WriteTemplatesToJson(TemplatesPath(path), tmpl)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	templates, err := ReadTemplatesFromJSON(TemplatesPath(path))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(templates) != 1 || !reflect.DeepEqual(templates[0], tmpl) {
+		t.Errorf("got %v, want %v", templates, tmpl)
+	}
+}
+
+func TestTemplatesSavedOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	th := NewTaskHolder(path)
+	tmpl, _ := th.AddTemplate(provideBrewDayTemplate())
+
+	found, _ := th.FindTemplateById(tmpl.Id)
+	found.Checklist[0] = "changed"
+	if kept, _ := th.FindTemplateById(tmpl.Id); kept.Checklist[0] == "changed" {
+		t.Errorf("Expected a copy of the template, got %v", kept)
+	}
+
+	if saved, err := ReadTemplatesFromJSON(TemplatesPath(path)); err != nil || len(saved) != 1 {
+		t.Fatalf("Expected the added template saved, got %v, %v", saved, err)
+	}
+	if err := th.DeleteTemplate(tmpl.Id); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if saved, err := ReadTemplatesFromJSON(TemplatesPath(path)); err != nil || len(saved) != 0 {
+		t.Errorf("Expected deleting the last template saved, got %v, %v", saved, err)
+	}
+}
@@ -42,11 +42,10 @@ func ProvideTask(t *testing.T) Task {
 func ProvideTaskHolder() *TaskHolder {
 	th := NewTaskHolder("resources/cli_disk_test.json")
 	updt := TaskOptional{
-		nil,
-		StringPtr("Initial Task"),
-		CategoryPtr(Brewing),
-		TimePtr(time.Now().Add(24 * time.Hour)),
-		ProvideMockUser(),
+		Msg:       StringPtr("Initial Task"),
+		Category:  CategoryPtr(Brewing),
+		PlannedAt: TimePtr(time.Now().Add(24 * time.Hour)),
+		CreatedBy: ProvideMockUser(),
 	}
 
 	th.CreateTask(updt)
@@ -56,11 +55,10 @@ func ProvideTaskHolder() *TaskHolder {
 func ProvideTaskHolderWithPath(path string) *TaskHolder {
 	th := NewTaskHolder(path)
 	updt := TaskOptional{
-		nil,
-		StringPtr("Initial Task"),
-		CategoryPtr(Brewing),
-		TimePtr(time.Now().Add(24 * time.Hour)),
-		ProvideMockUser(),
+		Msg:       StringPtr("Initial Task"),
+		Category:  CategoryPtr(Brewing),
+		PlannedAt: TimePtr(time.Now().Add(24 * time.Hour)),
+		CreatedBy: ProvideMockUser(),
 	}
 	th.CreateTask(updt)
 	return th
@@ -69,11 +67,10 @@ func MockNewTaskHolder(diskPath string) *TaskHolder {
 
 	th := NewTaskHolder("resources/cli_disk_test.json")
 	updt := TaskOptional{
-		nil,
-		StringPtr("Initial Task"),
-		CategoryPtr(Brewing),
-		TimePtr(time.Now().Add(24 * time.Hour)),
-		ProvideMockUser(),
+		Msg:       StringPtr("Initial Task"),
+		Category:  CategoryPtr(Brewing),
+		PlannedAt: TimePtr(time.Now().Add(24 * time.Hour)),
+		CreatedBy: ProvideMockUser(),
 	}
 	th.CreateTask(updt)
 	return th
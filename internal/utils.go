@@ -2,9 +2,11 @@ package internal
 
 import (
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"math/rand"
+	"os"
 	"strings"
 	"time"
 )
@@ -103,3 +105,38 @@ func TimePtr(t time.Time) *CustomTime {
 func BoolPtr(b bool) *bool {
 	return &b
 }
+
+func IntPtr(i int) *int {
+	return &i
+}
+
+// saveJsonFile persists any value as indented json, used by stores that
+// save themselves on every change like users.UserStore does
+func saveJsonFile(filePath string, v any) error {
+	if err := validateJSONFile(filePath); err != nil {
+		return err
+	}
+	data, err := jsonMarshal(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write to a file '%s': %w", filePath, err)
+	}
+	return nil
+}
+
+// loadJsonFile reads json file into v, missing file is reported with os.ErrNotExist
+func loadJsonFile(filePath string, v any) error {
+	if err := validateJSONFile(filePath); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file '%s': %w", filePath, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,587 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/logger"
+)
+
+const (
+	MaxWebhookAttempts       = 6
+	DefaultWebhookRetryDelay = 30 * time.Second
+	// delivered and dead records kept each, pending ones are kept all
+	maxDeliveredWebhooks = 1000
+	maxDeadWebhooks      = 1000
+	// deliveries returned by WebhookDeliveries and DeadLetters
+	MaxListedDeliveries = 100
+	webhookTimeout      = 10 * time.Second
+	minWebhookSecret    = 16
+	// deliveries change with every event and attempt, Run saves them at most
+	// this often instead of rewriting the file each time
+	webhookSaveInterval = time.Second
+
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+var (
+	ErrDeliveryNotDead      = errors.New("only dead deliveries can be retried")
+	ErrPrivateWebhookTarget = errors.New("webhook target is not a public address")
+)
+
+// nonPublicNetworks are blocked besides loopback, private, link-local and
+// multicast addresses: "this network" and carrier-grade NAT
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// isPublicIP reports whether webhooks may connect to ip, internal services
+// and cloud metadata at 169.254.169.254 are not
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+type InvalidWebhookError struct {
+	Field string
+}
+
+func (e *InvalidWebhookError) Error() string {
+	return fmt.Sprintf("invalid webhook value: %s", e.Field)
+}
+
+// Webhook subscribes url to task events, all events when Events is empty and
+// tasks of every category when Category is nil
+type Webhook struct {
+	Id        int             `json:"id"`
+	URL       string          `json:"url"`
+	Events    []TaskEventType `json:"events"`
+	Category  *TaskCategory   `json:"category,omitempty"`
+	Secret    string          `json:"secret"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+func (h *Webhook) Validate() error {
+	parsed, err := url.Parse(h.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return &InvalidWebhookError{Field: "url"}
+	}
+	for _, event := range h.Events {
+		switch event {
+		case TaskCreated, TaskUpdated, TaskDeleted:
+		default:
+			return &InvalidWebhookError{Field: "events"}
+		}
+	}
+	if h.Category != nil && !isValidTaskCategory(*h.Category) {
+		return &InvalidWebhookError{Field: "category"}
+	}
+	if len(h.Secret) < minWebhookSecret {
+		return &InvalidWebhookError{Field: "secret"}
+	}
+	return nil
+}
+
+func (h *Webhook) matches(event TaskEvent) bool {
+	if len(h.Events) > 0 && !slices.Contains(h.Events, event.Type) {
+		return false
+	}
+	if h.Category == nil {
+		return true
+	}
+	return event.Task.Category == *h.Category || (event.Previous != nil && event.Previous.Category == *h.Category)
+}
+
+// WebhookDelivery is one event sent to one webhook. Deliveries still failing
+// after MaxWebhookAttempts are dead letters and can be retried by hand.
+type WebhookDelivery struct {
+	Id             int             `json:"id"`
+	WebhookId      int             `json:"webhookId"`
+	Event          TaskEventType   `json:"event"`
+	TaskId         int             `json:"taskId"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+}
+
+// SignWebhook returns the signature header value of body: HMAC-SHA256 of
+// "timestamp.body" with the webhook secret. Receivers recompute it and
+// reject old timestamps to stop replays.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookRegistry queues a delivery for every matching task event and sends
+// them from a background worker started with Run, retrying failures with
+// exponential backoff. Deliveries are persisted, so pending ones survive a
+// restart.
+type WebhookRegistry struct {
+	latestId         int
+	latestDeliveryId int
+	Webhooks         []Webhook         `json:"webhooks"`
+	Deliveries       []WebhookDelivery `json:"deliveries"`
+	DiskPath         string            `json:"-"`
+	// EncodePayload turns an event into the request body, main sets it to
+	// the v1 API format
+	EncodePayload func(event TaskEvent) ([]byte, error) `json:"-"`
+	Client        *http.Client                          `json:"-"`
+	RetryDelay    time.Duration                         `json:"-"`
+	// AllowPrivateNetworks lets webhooks reach loopback, private and
+	// link-local addresses, receivers on the local network need it
+	AllowPrivateNetworks bool `json:"-"`
+	now                  func() time.Time
+	wake                 chan struct{}
+	// dirty deliveries are saved by Flush
+	dirty bool
+	sync.Mutex
+}
+
+func NewWebhookRegistry(diskPath string, taskHolder *TaskHolder) (*WebhookRegistry, error) {
+	registry := &WebhookRegistry{
+		DiskPath:      diskPath,
+		EncodePayload: func(event TaskEvent) ([]byte, error) { return json.Marshal(event) },
+		RetryDelay:    DefaultWebhookRetryDelay,
+		now:           time.Now,
+		wake:          make(chan struct{}, 1),
+	}
+	registry.Client = registry.newClient()
+	if diskPath != "" {
+		err := loadJsonFile(diskPath, registry)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	for _, webhook := range registry.Webhooks {
+		registry.latestId = max(registry.latestId, webhook.Id)
+	}
+	for _, delivery := range registry.Deliveries {
+		registry.latestDeliveryId = max(registry.latestDeliveryId, delivery.Id)
+	}
+	taskHolder.Subscribe(registry.handleTaskEvent)
+	return registry, nil
+}
+
+// newClient connects only to public addresses unless AllowPrivateNetworks,
+// checked on the address dialed so DNS answers and redirects can't point
+// deliveries at internal services. Proxies from the environment are not used.
+func (reg *WebhookRegistry) newClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: reg.checkAddress}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+func (reg *WebhookRegistry) checkAddress(network, address string, _ syscall.RawConn) error {
+	if reg.AllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateWebhookTarget, host)
+	}
+	return nil
+}
+
+// checkTarget rejects webhook URLs naming a non-public host, names resolving
+// to one are stopped by checkAddress when delivering
+func (reg *WebhookRegistry) checkTarget(webhook *Webhook) error {
+	if reg.AllowPrivateNetworks {
+		return nil
+	}
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil {
+		return &InvalidWebhookError{Field: "url"}
+	}
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && !isPublicIP(ip)) {
+		return &InvalidWebhookError{Field: "url"}
+	}
+	return nil
+}
+
+func (reg *WebhookRegistry) save() error {
+	if reg.DiskPath == "" {
+		return nil
+	}
+	if err := saveJsonFile(reg.DiskPath, reg); err != nil {
+		return err
+	}
+	reg.dirty = false
+	return nil
+}
+
+// Flush saves deliveries changed since the last save, Run calls it every
+// webhookSaveInterval and when it stops
+func (reg *WebhookRegistry) Flush() error {
+	reg.Lock()
+	defer reg.Unlock()
+	if !reg.dirty {
+		return nil
+	}
+	return reg.save()
+}
+
+func (reg *WebhookRegistry) Read() []Webhook {
+	reg.Lock()
+	defer reg.Unlock()
+	return append([]Webhook(nil), reg.Webhooks...)
+}
+
+func (reg *WebhookRegistry) FindWebhookById(webhookId int) (*Webhook, error) {
+	reg.Lock()
+	defer reg.Unlock()
+	webhook, err := reg.findWebhookById(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	found := *webhook
+	return &found, nil
+}
+
+func (reg *WebhookRegistry) findWebhookById(webhookId int) (*Webhook, error) {
+	for i := range reg.Webhooks {
+		if reg.Webhooks[i].Id == webhookId {
+			return &reg.Webhooks[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// AddWebhook generates the secret when none is given, it is returned only here
+func (reg *WebhookRegistry) AddWebhook(webhook Webhook) (*Webhook, error) {
+	if webhook.Secret == "" {
+		secret := make([]byte, minWebhookSecret)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+	if err := reg.checkTarget(&webhook); err != nil {
+		return nil, err
+	}
+	reg.Lock()
+	defer reg.Unlock()
+	reg.latestId++
+	webhook.Id = reg.latestId
+	webhook.CreatedAt = reg.now().Round(0)
+	reg.Webhooks = append(reg.Webhooks, webhook)
+	return &webhook, reg.save()
+}
+
+// DeleteWebhook removes the webhook and its pending deliveries, the delivery
+// log of the webhook is kept
+func (reg *WebhookRegistry) DeleteWebhook(webhookId int) error {
+	reg.Lock()
+	defer reg.Unlock()
+	if _, err := reg.findWebhookById(webhookId); err != nil {
+		return err
+	}
+	reg.Webhooks = slices.DeleteFunc(reg.Webhooks, func(webhook Webhook) bool { return webhook.Id == webhookId })
+	reg.Deliveries = slices.DeleteFunc(reg.Deliveries, func(delivery WebhookDelivery) bool {
+		return delivery.WebhookId == webhookId && delivery.Status == DeliveryPending
+	})
+	return reg.save()
+}
+
+// WebhookDeliveries returns the last MaxListedDeliveries deliveries of the
+// webhook, newest first
+func (reg *WebhookRegistry) WebhookDeliveries(webhookId int) ([]WebhookDelivery, error) {
+	reg.Lock()
+	defer reg.Unlock()
+	if _, err := reg.findWebhookById(webhookId); err != nil {
+		return nil, err
+	}
+	return reg.filterDeliveries(func(delivery *WebhookDelivery) bool { return delivery.WebhookId == webhookId }), nil
+}
+
+// DeadLetters returns the last MaxListedDeliveries deliveries that ran out of
+// attempts, newest first
+func (reg *WebhookRegistry) DeadLetters() []WebhookDelivery {
+	reg.Lock()
+	defer reg.Unlock()
+	return reg.filterDeliveries(func(delivery *WebhookDelivery) bool { return delivery.Status == DeliveryDead })
+}
+
+func (reg *WebhookRegistry) filterDeliveries(keep func(*WebhookDelivery) bool) []WebhookDelivery {
+	deliveries := []WebhookDelivery{}
+	for i := len(reg.Deliveries) - 1; i >= 0 && len(deliveries) < MaxListedDeliveries; i-- {
+		if keep(&reg.Deliveries[i]) {
+			deliveries = append(deliveries, reg.Deliveries[i])
+		}
+	}
+	return deliveries
+}
+
+// RetryDelivery queues a dead letter again with fresh attempts
+func (reg *WebhookRegistry) RetryDelivery(deliveryId int) (*WebhookDelivery, error) {
+	reg.Lock()
+	defer reg.Unlock()
+	delivery, err := reg.findDeliveryById(deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reg.findWebhookById(delivery.WebhookId); err != nil {
+		return nil, err
+	}
+	if delivery.Status != DeliveryDead {
+		return nil, ErrDeliveryNotDead
+	}
+	delivery.Status = DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = reg.now().Round(0)
+	retried := *delivery
+	reg.notify()
+	return &retried, reg.save()
+}
+
+func (reg *WebhookRegistry) findDeliveryById(deliveryId int) (*WebhookDelivery, error) {
+	for i := range reg.Deliveries {
+		if reg.Deliveries[i].Id == deliveryId {
+			return &reg.Deliveries[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (reg *WebhookRegistry) handleTaskEvent(event TaskEvent) {
+	reg.Lock()
+	defer reg.Unlock()
+	var payload []byte
+	for _, webhook := range reg.Webhooks {
+		if !webhook.matches(event) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = reg.EncodePayload(event); err != nil {
+				logger.Error.Printf("webhooks: error encoding %s event of task %d: %v", event.Type, event.Task.Id, err)
+				return
+			}
+		}
+		reg.latestDeliveryId++
+		now := reg.now().Round(0)
+		reg.Deliveries = append(reg.Deliveries, WebhookDelivery{
+			Id:            reg.latestDeliveryId,
+			WebhookId:     webhook.Id,
+			Event:         event.Type,
+			TaskId:        event.Task.Id,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+	}
+	if payload == nil {
+		return
+	}
+	reg.dirty = true
+	reg.notify()
+}
+
+// notify wakes the worker, caller holds the lock
+func (reg *WebhookRegistry) notify() {
+	select {
+	case reg.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done, one at a time in creation
+// order, and saves them before returning
+func (reg *WebhookRegistry) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	saveTicker := time.NewTicker(webhookSaveInterval)
+	defer saveTicker.Stop()
+	for {
+		wait := reg.deliverDue(ctx)
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			reg.flush()
+			return
+		case <-reg.wake:
+		case <-timer.C:
+		case <-saveTicker.C:
+			reg.flush()
+		}
+	}
+}
+
+func (reg *WebhookRegistry) flush() {
+	if err := reg.Flush(); err != nil {
+		logger.Error.Printf("webhooks: error saving deliveries: %v", err)
+	}
+}
+
+// deliverDue sends every due delivery and returns time until the next one
+func (reg *WebhookRegistry) deliverDue(ctx context.Context) time.Duration {
+	for ctx.Err() == nil {
+		delivery, webhook, wait := reg.nextDue()
+		if delivery == nil {
+			return wait
+		}
+		statusCode, err := reg.send(ctx, webhook, delivery)
+		reg.recordAttempt(delivery.Id, statusCode, err)
+	}
+	return 0
+}
+
+func (reg *WebhookRegistry) nextDue() (*WebhookDelivery, *Webhook, time.Duration) {
+	reg.Lock()
+	defer reg.Unlock()
+	now := reg.now()
+	wait := time.Hour
+	for i := range reg.Deliveries {
+		delivery := &reg.Deliveries[i]
+		if delivery.Status != DeliveryPending {
+			continue
+		}
+		if delay := delivery.NextAttemptAt.Sub(now); delay > 0 {
+			wait = min(wait, delay)
+			continue
+		}
+		webhook, err := reg.findWebhookById(delivery.WebhookId)
+		if err != nil {
+			continue
+		}
+		due, hook := *delivery, *webhook
+		return &due, &hook, 0
+	}
+	return nil, nil, wait
+}
+
+func (reg *WebhookRegistry) send(ctx context.Context, webhook *Webhook, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := reg.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(delivery.Event))
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.Id))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt schedules the next attempt after RetryDelay doubled with
+// every failure, the last failure turns the delivery into a dead letter
+func (reg *WebhookRegistry) recordAttempt(deliveryId int, statusCode int, sendErr error) {
+	reg.Lock()
+	defer reg.Unlock()
+	delivery, err := reg.findDeliveryById(deliveryId)
+	if err != nil {
+		return
+	}
+	now := reg.now().Round(0)
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	switch {
+	case sendErr == nil:
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = &now
+		reg.trim(DeliveryDelivered, maxDeliveredWebhooks)
+	case delivery.Attempts >= MaxWebhookAttempts:
+		delivery.Status = DeliveryDead
+		delivery.LastError = sendErr.Error()
+		logger.Error.Printf("webhooks: delivery %d to webhook %d failed %d times, giving up: %v", delivery.Id, delivery.WebhookId, delivery.Attempts, sendErr)
+		reg.trim(DeliveryDead, maxDeadWebhooks)
+	default:
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = now.Add(reg.RetryDelay << (delivery.Attempts - 1))
+	}
+	reg.dirty = true
+}
+
+// trim drops the oldest records with status over limit, caller holds the lock
+func (reg *WebhookRegistry) trim(status DeliveryStatus, limit int) {
+	count := 0
+	for _, delivery := range reg.Deliveries {
+		if delivery.Status == status {
+			count++
+		}
+	}
+	reg.Deliveries = slices.DeleteFunc(reg.Deliveries, func(delivery WebhookDelivery) bool {
+		if delivery.Status == status && count > limit {
+			count--
+			return true
+		}
+		return false
+	})
+}
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int // status of each request, 200 once used up
+	sync.Mutex
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.Lock()
+	defer rc.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *webhookReceiver) count() int {
+	rc.Lock()
+	defer rc.Unlock()
+	return len(rc.requests)
+}
+
+func provideWebhookRegistry(t *testing.T, receiver *webhookReceiver) (*WebhookRegistry, *TaskHolder, string) {
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	th := NewTaskHolder("")
+	registry, err := NewWebhookRegistry("", th)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	registry.RetryDelay = time.Millisecond
+	// the receiver listens on loopback
+	registry.AllowPrivateNetworks = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return registry, th, server.URL
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	invalidCategory := TaskCategory(9)
+	tests := []struct {
+		name    string
+		webhook Webhook
+		field   string
+	}{
+		{"valid", Webhook{URL: "https://erp.example/hooks", Secret: "0123456789abcdef"}, ""},
+		{"relative url", Webhook{URL: "/hooks", Secret: "0123456789abcdef"}, "url"},
+		{"ftp url", Webhook{URL: "ftp://erp.example", Secret: "0123456789abcdef"}, "url"},
+		{"unknown event", Webhook{URL: "https://erp.example", Events: []TaskEventType{"archived"}, Secret: "0123456789abcdef"}, "events"},
+		{"unknown category", Webhook{URL: "https://erp.example", Category: &invalidCategory, Secret: "0123456789abcdef"}, "category"},
+		{"short secret", Webhook{URL: "https://erp.example", Secret: "short"}, "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.webhook.Validate()
+			var webhookErr *InvalidWebhookError
+			if tt.field == "" && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.field != "" && (!errors.As(err, &webhookErr) || webhookErr.Field != tt.field) {
+				t.Errorf("Expected invalid %s, got %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	receiver := &webhookReceiver{}
+	registry, th, url := provideWebhookRegistry(t, receiver)
+	webhook, err := registry.AddWebhook(Webhook{URL: url})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(webhook.Secret) != 2*minWebhookSecret {
+		t.Errorf("Expected generated secret, got %q", webhook.Secret)
+	}
+
+	task := th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+	waitFor(t, "delivery", func() bool { return receiver.count() == 1 })
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	timestamp, _ := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+	if got := req.Header.Get(WebhookSignatureHeader); got != SignWebhook(webhook.Secret, timestamp, body) {
+		t.Errorf("Expected valid signature, got %q", got)
+	}
+	if req.Header.Get(WebhookEventHeader) != string(TaskCreated) || req.Header.Get(WebhookDeliveryHeader) != "1" {
+		t.Errorf("Unexpected headers %v", req.Header)
+	}
+
+	waitFor(t, "delivered status", func() bool {
+		deliveries, _ := registry.WebhookDeliveries(webhook.Id)
+		return len(deliveries) == 1 && deliveries[0].Status == DeliveryDelivered
+	})
+	deliveries, _ := registry.WebhookDeliveries(webhook.Id)
+	if deliveries[0].TaskId != task.Id || deliveries[0].Attempts != 1 || deliveries[0].DeliveredAt == nil {
+		t.Errorf("Unexpected delivery %+v", deliveries[0])
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	receiver := &webhookReceiver{}
+	registry, th, url := provideWebhookRegistry(t, receiver)
+	brewing := Brewing
+	registry.AddWebhook(Webhook{URL: url, Events: []TaskEventType{TaskUpdated}, Category: &brewing})
+
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA"), Category: CategoryPtr(Brewing)})
+	post := th.CreateTask(TaskOptional{Msg: StringPtr("Post photo"), Category: CategoryPtr(Marketing)})
+	th.PartialUpdateTask(post.Id, &TaskOptional{Done: BoolPtr(true)})
+	th.PartialUpdateTask(1, &TaskOptional{Done: BoolPtr(true)})
+
+	waitFor(t, "delivery", func() bool { return receiver.count() == 1 })
+	time.Sleep(10 * time.Millisecond)
+	if receiver.count() != 1 || receiver.requests[0].Header.Get(WebhookEventHeader) != string(TaskUpdated) {
+		t.Errorf("Expected only the brewing update delivered, got %d requests", receiver.count())
+	}
+}
+
+func TestWebhookRetriesAndDeadLetter(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{500, 502, 200}}
+	registry, th, url := provideWebhookRegistry(t, receiver)
+	webhook, _ := registry.AddWebhook(Webhook{URL: url})
+
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+	waitFor(t, "delivery after retries", func() bool {
+		deliveries, _ := registry.WebhookDeliveries(webhook.Id)
+		return len(deliveries) == 1 && deliveries[0].Status == DeliveryDelivered
+	})
+	deliveries, _ := registry.WebhookDeliveries(webhook.Id)
+	if deliveries[0].Attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", deliveries[0].Attempts)
+	}
+
+	receiver.Lock()
+	for i := 0; i < MaxWebhookAttempts; i++ {
+		receiver.statuses = append(receiver.statuses, http.StatusServiceUnavailable)
+	}
+	receiver.Unlock()
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew Stout")})
+	waitFor(t, "dead letter", func() bool { return len(registry.DeadLetters()) == 1 })
+
+	dead := registry.DeadLetters()[0]
+	if dead.Attempts != MaxWebhookAttempts || dead.LastStatusCode != http.StatusServiceUnavailable || dead.LastError == "" {
+		t.Errorf("Unexpected dead letter %+v", dead)
+	}
+
+	if _, err := registry.RetryDelivery(deliveries[0].Id); err == nil {
+		t.Error("Expected error retrying delivered delivery")
+	}
+	if _, err := registry.RetryDelivery(dead.Id); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	waitFor(t, "redelivery", func() bool { return len(registry.DeadLetters()) == 0 && receiver.count() == 3+MaxWebhookAttempts+1 })
+}
+
+func TestWebhookPersistence(t *testing.T) {
+	diskPath := filepath.Join(t.TempDir(), "webhooks.json")
+	th := NewTaskHolder("")
+	registry, _ := NewWebhookRegistry(diskPath, th)
+	registry.AddWebhook(Webhook{URL: "https://erp.example/hooks"})
+	th.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+
+	// deliveries are saved by Flush, not with every event
+	if unsaved, _ := NewWebhookRegistry(diskPath, NewTaskHolder("")); len(unsaved.Deliveries) != 0 {
+		t.Errorf("Expected no deliveries saved before Flush, got %d", len(unsaved.Deliveries))
+	}
+	if err := registry.Flush(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	loaded, err := NewWebhookRegistry(diskPath, NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(loaded.Read()) != 1 || len(loaded.Deliveries) != 1 || loaded.Deliveries[0].Status != DeliveryPending {
+		t.Errorf("Expected webhook and pending delivery loaded, got %+v", loaded.Deliveries)
+	}
+	added, _ := loaded.AddWebhook(Webhook{URL: "https://erp.example/other"})
+	if added.Id != 2 {
+		t.Errorf("Expected id 2, got %d", added.Id)
+	}
+
+	if err := loaded.DeleteWebhook(1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(loaded.Deliveries) != 0 {
+		t.Errorf("Expected pending deliveries of deleted webhook dropped, got %d", len(loaded.Deliveries))
+	}
+}
+
+func TestWebhookPrivateTargets(t *testing.T) {
+	registry, err := NewWebhookRegistry("", NewTaskHolder(""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:8080/api", "http://10.0.0.5/hooks", "http://[::1]/hooks"} {
+		var webhookErr *InvalidWebhookError
+		if _, err := registry.AddWebhook(Webhook{URL: target}); !errors.As(err, &webhookErr) || webhookErr.Field != "url" {
+			t.Errorf("Expected %s to be rejected, got %v", target, err)
+		}
+	}
+
+	// names resolving to a private address are stopped when connecting
+	server := httptest.NewServer(&webhookReceiver{})
+	t.Cleanup(server.Close)
+	if _, err := registry.Client.Get(server.URL); !errors.Is(err, ErrPrivateWebhookTarget) {
+		t.Errorf("Expected delivery to loopback refused, got %v", err)
+	}
+	registry.AllowPrivateNetworks = true
+	if _, err := registry.AddWebhook(Webhook{URL: server.URL}); err != nil {
+		t.Errorf("Expected private target allowed, got %v", err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookDeadLettersCapped(t *testing.T) {
+	registry, _ := NewWebhookRegistry("", NewTaskHolder(""))
+	for i := 1; i <= MaxListedDeliveries+20; i++ {
+		registry.Deliveries = append(registry.Deliveries, WebhookDelivery{Id: i, Status: DeliveryDead})
+	}
+	dead := registry.DeadLetters()
+	if len(dead) != MaxListedDeliveries || dead[0].Id != MaxListedDeliveries+20 {
+		t.Errorf("Expected the newest %d dead letters, got %d starting at %d", MaxListedDeliveries, len(dead), dead[0].Id)
+	}
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+// DefaultWorkspace has the task store batches, inventory, equipment, QC,
+// webhooks and task events are bound to
+const DefaultWorkspace = users.DefaultWorkspace
+
+// workspace names end up in file and object names
+var workspaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// UnknownWorkspaceError is returned for workspaces which are not configured
+type UnknownWorkspaceError struct {
+	Name string
+}
+
+func (e *UnknownWorkspaceError) Error() string {
+	return fmt.Sprintf("workspace %q not found", e.Name)
+}
+
+// Workspaces keeps a separate task store per workspace, so sites sharing the
+// binary never see each other's tasks
+type Workspaces struct {
+	services map[string]*ConcurrentTaskService
+	// created are closed by CloseAll, the default service belongs to its creator
+	created []*ConcurrentTaskService
+	mu      sync.RWMutex
+}
+
+// NewWorkspaces serves DefaultWorkspace with defaultService
+func NewWorkspaces(defaultService *ConcurrentTaskService) *Workspaces {
+	return &Workspaces{services: map[string]*ConcurrentTaskService{DefaultWorkspace: defaultService}}
+}
+
+// Add creates workspace name with its own task store, adding an existing
+// workspace keeps its store
+func (ws *Workspaces) Add(name string, store *TaskHolder) error {
+	if !workspaceName.MatchString(name) {
+		return &UnknownWorkspaceError{Name: name}
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, exists := ws.services[name]; exists {
+		return nil
+	}
+	service := NewConcurrentTaskService(store)
+	ws.services[name] = service
+	ws.created = append(ws.created, service)
+	return nil
+}
+
+// Get returns the task service of workspace name
+func (ws *Workspaces) Get(name string) (*ConcurrentTaskService, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	service, exists := ws.services[name]
+	if !exists {
+		return nil, &UnknownWorkspaceError{Name: name}
+	}
+	return service, nil
+}
+
+// Names returns the workspaces sorted by name
+func (ws *Workspaces) Names() []string {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	names := make([]string, 0, len(ws.services))
+	for name := range ws.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CloseAll stops the task services created by Add
+func (ws *Workspaces) CloseAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, service := range ws.created {
+		service.CloseAll()
+	}
+	ws.created = nil
+}
+
+// WorkspaceDiskPath is the task file or GCS object of workspace next to the
+// default one, "tasks.json" becomes "tasks.site-b.json"
+func WorkspaceDiskPath(diskPath, workspace string) string {
+	if diskPath == "" {
+		diskPath = "tasks.json"
+	}
+	return strings.TrimSuffix(diskPath, ".json") + "." + workspace + ".json"
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWorkspaces(t *testing.T) {
+	defaultService := NewConcurrentTaskService(NewTaskHolder(""))
+	t.Cleanup(defaultService.CloseAll)
+	workspaces := NewWorkspaces(defaultService)
+	t.Cleanup(workspaces.CloseAll)
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"site-b", false},
+		{"site-b", false},
+		{"Site B", true},
+		{"../tasks", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := workspaces.Add(tt.name, NewTaskHolder(""))
+			var unknownErr *UnknownWorkspaceError
+			if tt.wantErr != errors.As(err, &unknownErr) {
+				t.Errorf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if names := workspaces.Names(); !reflect.DeepEqual(names, []string{DefaultWorkspace, "site-b"}) {
+		t.Errorf("Expected default and site-b, got %v", names)
+	}
+	if got, _ := workspaces.Get(DefaultWorkspace); got != defaultService {
+		t.Error("Expected the default service for the default workspace")
+	}
+	siteB, err := workspaces.Get("site-b")
+	if err != nil {
+		t.Fatalf("Expected site-b, got %v", err)
+	}
+	siteB.CreateTask(TaskOptional{Msg: StringPtr("Brew IPA")})
+	if len(defaultService.Read()) != 0 {
+		t.Errorf("Expected the default workspace to keep its tasks apart, got %v", defaultService.Read())
+	}
+	if _, err := workspaces.Get("site-c"); err == nil {
+		t.Error("Expected error for unknown workspace")
+	}
+}
+
+func TestWorkspaceDiskPath(t *testing.T) {
+	tests := []struct {
+		diskPath string
+		want     string
+	}{
+		{"resources/tasks.json", "resources/tasks.site-b.json"},
+		{"test-tasks.json", "test-tasks.site-b.json"},
+		{"", "tasks.site-b.json"},
+	}
+	for _, tt := range tests {
+		if got := WorkspaceDiskPath(tt.diskPath, "site-b"); got != tt.want {
+			t.Errorf("Expected %s, got %s", tt.want, got)
+		}
+	}
+}
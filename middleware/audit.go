@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/zhekagigs/golang_todo/audit"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Audit records failed authentication, API token use and scope denials, main
+// opens it. Without it nothing is recorded.
+var Audit *audit.Log
+
+// TrustProxy takes the client address from X-Forwarded-For, set it only
+// behind a proxy which appends the address it saw
+var TrustProxy bool
+
+// RequestIdHeader carries the request id, a valid id from a proxy is kept
+const RequestIdHeader = "X-Request-Id"
+
+var requestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestMiddleware sets the request id and origin recorded in the audit log
+// and sends the id back
+func RequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !requestId.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIdHeader, id)
+		ctx := audit.WithRequest(r.Context(), audit.Request{
+			IP:        clientIP(r),
+			UserAgent: r.UserAgent(),
+			RequestId: id,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIdFromContext returns the id set by RequestMiddleware
+func RequestIdFromContext(ctx context.Context) string {
+	request, _ := audit.RequestFrom(ctx)
+	return request.RequestId
+}
+
+func clientIP(r *http.Request) string {
+	if TrustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// grpcRequest is RequestMiddleware for gRPC calls, the id is read from the
+// "x-request-id" metadata
+func grpcRequest(ctx context.Context) context.Context {
+	var request audit.Request
+	if p, ok := peer.FromContext(ctx); ok {
+		request.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(request.IP); err == nil {
+			request.IP = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if agent := md.Get("user-agent"); len(agent) > 0 {
+			request.UserAgent = agent[0]
+		}
+		if id := md.Get("x-request-id"); len(id) > 0 && requestId.MatchString(id[0]) {
+			request.RequestId = id[0]
+		}
+	}
+	if request.RequestId == "" {
+		request.RequestId = uuid.NewString()
+	}
+	return audit.WithRequest(ctx, request)
+}
+
+// recordCredentials records failed authentication and the use of API tokens.
+// Both come with every request, so repeats are throttled.
+func recordCredentials(ctx context.Context, userId string, scopes []string, err error) {
+	switch {
+	case errors.Is(err, http.ErrNoCookie):
+		Audit.RecordThrottled(ctx, audit.Entry{Event: audit.AuthFailed, Detail: "no credentials"})
+	case err != nil:
+		Audit.RecordThrottled(ctx, audit.Entry{Event: audit.AuthFailed, UserId: userId, Detail: err.Error()})
+	case scopes != nil:
+		Audit.RecordThrottled(ctx, audit.Entry{Event: audit.TokenUsed, UserId: userId, Detail: "scopes " + strings.Join(scopes, ",")})
+	}
+}
+
+// recordDenied records a request refused for lack of a scope or CSRF token
+func recordDenied(ctx context.Context, detail string) {
+	userId, _ := UserFromContext(ctx)
+	Audit.Record(ctx, audit.Entry{Event: audit.PermissionDenied, UserId: userId, Detail: detail})
+}
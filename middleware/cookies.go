@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// CookiePolicy sets the attributes of every cookie the server sets, so login,
+// logout and the CSRF checks agree on them
+type CookiePolicy struct {
+	// Secure cookies are only sent over HTTPS, main sets it from COOKIE_SECURE
+	Secure bool
+	// SameSite of the cookies, Strict keeps them off cross-site requests
+	SameSite http.SameSite
+}
+
+// Cookies is the policy of the server, plain HTTP works for local development
+var Cookies = CookiePolicy{SameSite: http.SameSiteStrictMode}
+
+// New returns cookie name for the whole site, httpOnly hides it from scripts
+func (p CookiePolicy) New(name, value string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   p.Secure,
+		SameSite: p.SameSite,
+	}
+}
+
+// Clear returns a cookie deleting name, browsers only drop cookies set with
+// the same path
+func (p CookiePolicy) Clear(name string, httpOnly bool) *http.Cookie {
+	cookie := p.New(name, "", 0, httpOnly)
+	cookie.MaxAge = -1
+	return cookie
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/zhekagigs/golang_todo/logger"
+)
+
+const (
+	// CSRFHeader carries the CSRF token of fetch requests
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField carries the CSRF token of HTML forms
+	CSRFField = "csrf_token"
+)
+
+// CSRFToken returns the token pages must send with requests made with
+// sessionToken, "" for invalid sessions. It is derived from the session id,
+// so it needs no storage and ends with the session.
+func (m *SessionManager) CSRFToken(sessionToken string) string {
+	claims, err := m.parse(sessionToken)
+	if err != nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte("csrf." + claims.SessionId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFToken returns the token of the session cookie of r for the templates,
+// "" without a session
+func CSRFToken(r *http.Request) string {
+	token, err := extractUserFromCookie(r)
+	if err != nil {
+		return ""
+	}
+	return Sessions.CSRFToken(token)
+}
+
+// CSRFMiddleware rejects unsafe requests made with the session cookie which
+// lack the CSRF token of the session in CSRFHeader or form field CSRFField.
+// Requests with an Authorization header are not checked, browsers never add
+// it to cross-site requests.
+func CSRFMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		session, err := extractUserFromCookie(r)
+		if err != nil || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sent := r.Header.Get(CSRFHeader)
+		if sent == "" {
+			sent = r.PostFormValue(CSRFField)
+		}
+		// invalid sessions are turned away by the auth middleware
+		expected := Sessions.CSRFToken(session)
+		if expected != "" && !hmac.Equal([]byte(sent), []byte(expected)) {
+			logger.Error.Printf("csrf token missing or invalid for %s %s", r.Method, r.URL.Path)
+			recordDenied(r.Context(), "missing or invalid CSRF token")
+			http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/users"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// extractUserFromMetadata reads the credentials like extractUser reads the
+// Authorization header of api requests and returns the user id and scopes
+func extractUserFromMetadata(ctx context.Context) (string, []string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", nil, errors.New("no metadata found")
+	}
+	identityId := md.Get("authorization")
+	if len(identityId) == 0 || identityId[0] == "" {
+		return "", nil, errors.New("no identity metadata found")
+	}
+	return verifyCredentials(identityId[0])
+}
+
+func authenticate(ctx context.Context, method string) (context.Context, error) {
+	ctx = grpcRequest(ctx)
+	userVal, scopes, err := extractUserFromMetadata(ctx)
+	recordCredentials(ctx, userVal, scopes, err)
+	if err != nil {
+		logger.Error.Printf("error extacting user id for %s: %v", method, err)
+		return nil, status.Error(codes.Unauthenticated, "authorization metadata required")
+	}
+	ctx = contextWithCredentials(ctx, userVal, scopes)
+	if scope := grpcMethodScope(method); !HasScope(ctx, scope) {
+		recordDenied(ctx, "api token lacks the "+scope+" scope")
+		return nil, status.Errorf(codes.PermissionDenied, "api token lacks the %s scope", scope)
+	}
+	return ctx, nil
+}
+
+// grpcMethodScope is methodScope for gRPC, Get, List and Watch calls only read
+func grpcMethodScope(fullMethod string) string {
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	for _, prefix := range []string{"Get", "List", "Watch"} {
+		if strings.HasPrefix(name, prefix) {
+			return users.ScopeRead
+		}
+	}
+	return users.ScopeWrite
+}
+
+// UnaryAuthInterceptor is AuthMiddleware for gRPC calls, the session token is
+// read from the "authorization" metadata and its user set with ContextWithUser
+func UnaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamAuthInterceptor is UnaryAuthInterceptor for streaming calls
+func StreamAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &userServerStream{ServerStream: ss, ctx: ctx})
+}
+
+type userServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *userServerStream) Context() context.Context {
+	return s.ctx
+}
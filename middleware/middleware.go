@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
@@ -24,56 +25,116 @@ func (m LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	duration := time.Since(start)
 
 	logger.Info.Printf(
-		"Method: %s, Path: %s, Status: %d, Duration: %v",
+		"Method: %s, Path: %s, Status: %d, Duration: %v, Request: %s",
 		r.Method,
 		r.URL.Path,
 		crw.status,
 		duration,
+		RequestIdFromContext(r.Context()),
 	)
 }
 
 type userKey struct{}
 
-// a real implementation would be signed to make sure
-// the user didn't spoof their identity
-func extractUser(req *http.Request) (string, error) {
+// SessionCookie holds the session token of logged in browsers
+const SessionCookie = "Authorization"
+
+// extractUser returns the user id and API token scopes of the request. Api
+// requests send a session token or "Bearer" API token in the Authorization
+// header, pages and same-site fetches the session cookie.
+func extractUser(req *http.Request) (string, []string, error) {
+	token, err := ExtractSessionToken(req)
+	if err != nil {
+		return "", nil, err
+	}
+	return verifyCredentials(token)
+}
+
+// ExtractSessionToken reads the token without verifying it
+func ExtractSessionToken(req *http.Request) (string, error) {
 	if strings.Contains(req.URL.Path, "api") {
-		identityId, ok := req.Header["Authorization"]
-		if !ok || len(identityId) == 0 || identityId[0] == "" {
-			return "", errors.New("no identity header found")
+		if token := req.Header.Get("Authorization"); token != "" {
+			return token, nil
 		}
-		return identityId[0], nil
-	} else {
-		return extractUserFromCookie(req)
 	}
+	return extractUserFromCookie(req)
 }
 
 func extractUserFromCookie(req *http.Request) (string, error) {
-	identityId, err := req.Cookie("Authorization")
-	if err != nil || len(identityId.Value) == 0 {
+	identityId, err := req.Cookie(SessionCookie)
+	if err != nil {
 		return "", err
 	}
+	if len(identityId.Value) == 0 {
+		return "", errors.New("empty session cookie")
+	}
 	return identityId.Value, nil
 }
 
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		userVal, err := extractUser(r)
+		userVal, scopes, err := extractUser(r)
+		recordCredentials(r.Context(), userVal, scopes, err)
 
 		if err != nil || userVal == "" {
 			logger.Error.Println("error extacting user id", err)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeUnauthorized(w)
+				return
+			}
 			w.Write([]byte("<div>Please go back and login</div> <a href=/tasks> Main Page</a>"))
 			// http.Redirect(w, r, "/tasks", http.StatusUnauthorized)
 			return
 		}
-		ctx := r.Context()
-		ctx = ContextWithUser(ctx, userVal)
+		ctx := contextWithCredentials(r.Context(), userVal, scopes)
+		if scope := methodScope(r.Method); !HasScope(ctx, scope) {
+			logger.Error.Printf("api token of %s lacks scope %s", userVal, scope)
+			recordDenied(ctx, "api token lacks the "+scope+" scope")
+			http.Error(w, "api token lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	}
 }
 
+// writeUnauthorized answers API requests without valid credentials with the
+// same problem details body as the API handlers
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":   "about:blank",
+		"title":  http.StatusText(http.StatusUnauthorized),
+		"status": http.StatusUnauthorized,
+		"detail": "login required",
+	})
+}
+
+// OptionalAuthMiddleware sets the user from the Authorization header or
+// cookie when the credentials are valid, other requests are served
+// anonymously. Scopes are left to the handler.
+func OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			token, _ = extractUserFromCookie(r)
+		}
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userVal, scopes, err := verifyCredentials(token)
+		recordCredentials(r.Context(), userVal, scopes, err)
+		if err == nil {
+			r = r.WithContext(contextWithCredentials(r.Context(), userVal, scopes))
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func ContextWithUser(ctx context.Context, user string) context.Context {
 	return context.WithValue(ctx, userKey{}, user)
 }
@@ -87,3 +148,8 @@ type customResponseWriter struct {
 	http.ResponseWriter
 	status int
 }
+
+// Unwrap lets http.ResponseController flush streamed responses
+func (w *customResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
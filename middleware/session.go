@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSessionTTL = 8 * time.Hour
+
+var (
+	ErrInvalidSession = errors.New("invalid session token")
+	ErrExpiredSession = errors.New("session expired")
+	ErrRevokedSession = errors.New("session revoked")
+)
+
+// Sessions validates the tokens of AuthMiddleware and the gRPC interceptors.
+// The secret is random, main replaces it to keep sessions over restarts.
+var Sessions = NewSessionManager(nil, DefaultSessionTTL)
+
+// SessionManager issues tokens signed with HMAC-SHA256 that carry the user id
+// and expiry. Logged out sessions are kept until they would have expired, so
+// a copied token stops working on logout.
+type SessionManager struct {
+	secret  []byte
+	ttl     time.Duration
+	revoked map[string]time.Time // session id to expiry
+	file    string
+	mu      sync.Mutex
+}
+
+type sessionClaims struct {
+	SessionId string `json:"sid"`
+	UserId    string `json:"uid"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewSessionManager uses a random secret when secret is empty
+func NewSessionManager(secret []byte, ttl time.Duration) *SessionManager {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return &SessionManager{secret: secret, ttl: ttl, revoked: map[string]time.Time{}}
+}
+
+// LoadRevocations keeps logged out sessions in file, so they stay revoked
+// after a restart with the same secret. A missing file is not an error.
+func (m *SessionManager) LoadRevocations(file string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.file = file
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	revoked := map[string]time.Time{}
+	if err := json.Unmarshal(data, &revoked); err != nil {
+		return err
+	}
+	now := time.Now()
+	for sessionId, expiresAt := range revoked {
+		if now.Before(expiresAt) {
+			m.revoked[sessionId] = expiresAt
+		}
+	}
+	return nil
+}
+
+func (m *SessionManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue returns a new token for userId and when it expires
+func (m *SessionManager) Issue(userId string) (string, time.Time) {
+	sessionId := make([]byte, 16)
+	rand.Read(sessionId)
+	expiresAt := time.Now().Add(m.ttl)
+	payload, _ := json.Marshal(sessionClaims{
+		SessionId: base64.RawURLEncoding.EncodeToString(sessionId),
+		UserId:    userId,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + m.sign(encoded), expiresAt
+}
+
+// Verify returns the user id of a valid, unexpired and not revoked token
+func (m *SessionManager) Verify(token string) (string, error) {
+	claims, err := m.parse(token)
+	if err != nil {
+		return "", err
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", ErrExpiredSession
+	}
+	m.mu.Lock()
+	_, revoked := m.revoked[claims.SessionId]
+	m.mu.Unlock()
+	if revoked {
+		return "", ErrRevokedSession
+	}
+	return claims.UserId, nil
+}
+
+// Revoke ends the session of token, expired revocations are dropped on the way
+func (m *SessionManager) Revoke(token string) error {
+	claims, err := m.parse(token)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sessionId, expiresAt := range m.revoked {
+		if !now.Before(expiresAt) {
+			delete(m.revoked, sessionId)
+		}
+	}
+	m.revoked[claims.SessionId] = time.Unix(claims.ExpiresAt, 0)
+	return m.save()
+}
+
+func (m *SessionManager) save() error {
+	if m.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.revoked, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.file, data, 0600)
+}
+
+func (m *SessionManager) parse(token string) (*sessionClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(m.sign(encoded))) {
+		return nil, ErrInvalidSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserId == "" {
+		return nil, ErrInvalidSession
+	}
+	return &claims, nil
+}
+
+func (m *SessionManager) sign(encoded string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
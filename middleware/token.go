@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/zhekagigs/golang_todo/users"
+)
+
+const bearerPrefix = "Bearer "
+
+// TokenVerifier returns the user id and scopes of a personal API token
+type TokenVerifier interface {
+	Verify(token string) (string, []string, error)
+}
+
+// APITokens checks "Bearer" credentials, main sets it to the token store.
+// Without it only session tokens are accepted.
+var APITokens TokenVerifier
+
+type scopesKey struct{}
+
+// UserChecker reports whether a user may still use the server
+type UserChecker interface {
+	Active(userId string) bool
+}
+
+// ActiveUsers turns away the sessions and API tokens of deactivated and
+// deleted users, main sets it to the user store
+var ActiveUsers UserChecker
+
+// ErrInactiveUser is returned for valid credentials of an inactive user
+var ErrInactiveUser = errors.New("user is deactivated or deleted")
+
+// verifyCredentials returns the user id of an Authorization value. Bearer
+// values are API tokens and come with their scopes, session tokens have nil
+// scopes and may do everything their user may.
+func verifyCredentials(value string) (string, []string, error) {
+	userId, scopes, err := verifyToken(value)
+	if err == nil && ActiveUsers != nil && !ActiveUsers.Active(userId) {
+		return "", nil, ErrInactiveUser
+	}
+	return userId, scopes, err
+}
+
+func verifyToken(value string) (string, []string, error) {
+	token, isBearer := strings.CutPrefix(value, bearerPrefix)
+	if !isBearer {
+		userId, err := Sessions.Verify(value)
+		return userId, nil, err
+	}
+	if APITokens == nil {
+		return "", nil, errors.New("api tokens are not enabled")
+	}
+	return APITokens.Verify(strings.TrimSpace(token))
+}
+
+// ContextWithScopes marks the request as made with an API token of scopes
+func ContextWithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// ScopesFromContext returns the scopes of the API token of the request, ok is
+// false for sessions
+func ScopesFromContext(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesKey{}).([]string)
+	return scopes, ok
+}
+
+// HasScope reports whether the request may use scope, sessions have them all
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ScopesFromContext(ctx)
+	return !ok || slices.Contains(scopes, scope)
+}
+
+// contextWithCredentials sets the user and, for API tokens, the scopes
+func contextWithCredentials(ctx context.Context, userId string, scopes []string) context.Context {
+	ctx = ContextWithUser(ctx, userId)
+	if scopes != nil {
+		ctx = ContextWithScopes(ctx, scopes)
+	}
+	return ctx
+}
+
+// methodScope is the scope an HTTP method needs
+func methodScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return users.ScopeRead
+	}
+	return users.ScopeWrite
+}
@@ -0,0 +1,407 @@
+// Package oidc logs users in with the OpenID Connect authorization code flow
+// of an external identity provider. It discovers the provider endpoints,
+// protects the code with PKCE and verifies RS256 signed ID tokens against the
+// keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserNameClaim is the claim used as user name when none is configured
+const DefaultUserNameClaim = "preferred_username"
+
+// clockSkew is how far the clocks of provider and server may differ
+const clockSkew = time.Minute
+
+// Config identifies this server as a client of the provider at Issuer
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider
+	RedirectURL string
+	// Scopes besides "openid", profile and email when empty
+	Scopes []string
+	// UserNameClaim names the user after a claim, DefaultUserNameClaim when empty
+	UserNameClaim string
+}
+
+// ProviderError is returned when the provider can't be reached or answers
+// with an error
+type ProviderError struct {
+	Reason string
+}
+
+func (e *ProviderError) Error() string {
+	return "identity provider: " + e.Reason
+}
+
+// TokenError is returned for ID tokens which fail verification
+type TokenError struct {
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return "invalid id token: " + e.Reason
+}
+
+// Claims are the verified claims of an ID token
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	// raw has every claim, for UserNameClaim
+	raw map[string]any
+}
+
+// audience is a single client id or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// LoginState is kept by the browser between the redirect to the provider and
+// the callback. State stops forged callbacks, Nonce replayed ID tokens and
+// Verifier codes used by someone else.
+type LoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewLoginState returns random values for a new login
+func NewLoginState() LoginState {
+	return LoginState{State: randomString(), Nonce: randomString(), Verifier: randomString()}
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// challenge is the S256 PKCE code challenge of verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Provider talks to the identity provider found by Discover
+type Provider struct {
+	config                Config
+	client                *http.Client
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+	keys                  map[string]*rsa.PublicKey // by key id
+	mu                    sync.Mutex
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Discover reads the endpoints of config.Issuer from its
+// .well-known/openid-configuration, client defaults to a client with a timeout
+func Discover(ctx context.Context, config Config, client *http.Client) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client id and redirect url are required")
+	}
+	if config.UserNameClaim == "" {
+		config.UserNameClaim = DefaultUserNameClaim
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"profile", "email"}
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	var document discoveryDocument
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJson(ctx, client, wellKnown, &document); err != nil {
+		return nil, err
+	}
+	// the issuer must be the one configured, see OpenID Connect Discovery 4.3
+	if document.Issuer != config.Issuer {
+		return nil, &ProviderError{Reason: fmt.Sprintf("issuer %q does not match %q", document.Issuer, config.Issuer)}
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JwksURI == "" {
+		return nil, &ProviderError{Reason: "discovery document lacks endpoints"}
+	}
+	return &Provider{
+		config:                config,
+		client:                client,
+		authorizationEndpoint: document.AuthorizationEndpoint,
+		tokenEndpoint:         document.TokenEndpoint,
+		jwksURI:               document.JwksURI,
+		keys:                  map[string]*rsa.PublicKey{},
+	}, nil
+}
+
+func getJson(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &ProviderError{Reason: err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &ProviderError{Reason: fmt.Sprintf("GET %s answered %s", url, resp.Status)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &ProviderError{Reason: "malformed response of " + url}
+	}
+	return nil
+}
+
+// AuthCodeURL is where the browser logs in, the provider redirects back to
+// RedirectURL with the code
+func (p *Provider) AuthCodeURL(login LoginState) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {challenge(login.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authorizationEndpoint + separator + query.Encode()
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the code of the callback for the verified claims of the
+// ID token
+func (p *Provider) Exchange(ctx context.Context, code string, login LoginState) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {login.Verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// client_secret_basic, the id and secret are form encoded first
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, &ProviderError{Reason: err.Error()}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, &ProviderError{Reason: err.Error()}
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, &ProviderError{Reason: "malformed token response, status " + resp.Status}
+	}
+	if token.Error != "" {
+		return nil, &ProviderError{Reason: strings.TrimSpace(token.Error + " " + token.ErrorDescription)}
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return nil, &ProviderError{Reason: "token response without id token, status " + resp.Status}
+	}
+	return p.Verify(ctx, token.IDToken, login.Nonce)
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of
+// rawIDToken. Only RS256, the algorithm every provider supports, is accepted.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, &TokenError{Reason: "not a JWT"}
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, &TokenError{Reason: "malformed header"}
+	}
+	if header.Algorithm != "RS256" {
+		return nil, &TokenError{Reason: fmt.Sprintf("algorithm %q is not supported", header.Algorithm)}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, &TokenError{Reason: "malformed signature"}
+	}
+	key, err := p.key(ctx, header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return nil, &TokenError{Reason: "bad signature"}
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, &TokenError{Reason: "malformed claims"}
+	}
+	if err := decodeSegment(parts[1], &claims.raw); err != nil {
+		return nil, &TokenError{Reason: "malformed claims"}
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, &TokenError{Reason: fmt.Sprintf("issued by %q", claims.Issuer)}
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return nil, &TokenError{Reason: "issued for another client"}
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, &TokenError{Reason: "authorized party is not this client"}
+	case claims.Subject == "":
+		return nil, &TokenError{Reason: "no subject"}
+	case !now.Before(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, &TokenError{Reason: "expired"}
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, &TokenError{Reason: "issued in the future"}
+	case claims.Nonce != nonce:
+		return nil, &TokenError{Reason: "nonce does not match"}
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// key returns the signing key kid, the keys are fetched again when kid is
+// unknown because providers rotate them
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, &TokenError{Reason: fmt.Sprintf("unknown signing key %q", kid)}
+}
+
+// findKey looks kid up, tokens without kid need a provider with a single key
+func (p *Provider) findKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJson(ctx, p.client, p.jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyId] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// UserName maps the claims to a user name: the configured claim, else the
+// email and at last the subject
+func (p *Provider) UserName(claims *Claims) string {
+	if name, ok := claims.raw[p.config.UserNameClaim].(string); ok && name != "" {
+		return name
+	}
+	if claims.Email != "" {
+		return claims.Email
+	}
+	return claims.Subject
+}
+
+// Issuer is the configured issuer, users are told apart by issuer and subject
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
@@ -0,0 +1,219 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhekagigs/golang_todo/oidc"
+	"github.com/zhekagigs/golang_todo/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:8080/login/oidc/callback"
+
+func setupProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	mock := oidctest.NewProvider("todo", "secret")
+	t.Cleanup(mock.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     "todo",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected discovery to work, got %v", err)
+	}
+	return mock, provider
+}
+
+// authorize follows AuthCodeURL and returns the code the mock redirects with
+func authorize(t *testing.T, provider *oidc.Provider, login oidc.LoginState) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(provider.AuthCodeURL(login))
+	if err != nil {
+		t.Fatalf("Expected authorization to work, got %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect, got %v %v", resp.Status, err)
+	}
+	if location.Query().Get("state") != login.State {
+		t.Fatalf("Expected state %s, got %s", login.State, location.Query().Get("state"))
+	}
+	return location.Query().Get("code")
+}
+
+func TestDiscover(t *testing.T) {
+	mock := oidctest.NewProvider("todo", "secret")
+	defer mock.Close()
+
+	tests := []struct {
+		name    string
+		config  oidc.Config
+		wantErr bool
+	}{
+		{"valid", oidc.Config{Issuer: mock.Issuer(), ClientID: "todo", RedirectURL: redirectURL}, false},
+		{"issuer mismatch", oidc.Config{Issuer: mock.Issuer() + "/other", ClientID: "todo", RedirectURL: redirectURL}, true},
+		{"missing client", oidc.Config{Issuer: mock.Issuer(), RedirectURL: redirectURL}, true},
+		{"unreachable", oidc.Config{Issuer: "http://127.0.0.1:1", ClientID: "todo", RedirectURL: redirectURL}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := oidc.Discover(context.Background(), tt.config, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	mock, provider := setupProvider(t)
+	login := oidc.NewLoginState()
+
+	authURL, err := url.Parse(provider.AuthCodeURL(login))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if !strings.HasPrefix(authURL.String(), mock.Issuer()+"/authorize") {
+		t.Errorf("Expected the authorization endpoint, got %s", authURL)
+	}
+	if query.Get("scope") != "openid profile email" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected openid scope and S256 challenge, got %v", query)
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge") == login.Verifier {
+		t.Errorf("Expected the challenge to hide the verifier, got %s", query.Get("code_challenge"))
+	}
+	if query.Get("nonce") != login.Nonce || query.Get("redirect_uri") != redirectURL {
+		t.Errorf("Expected nonce and redirect uri, got %v", query)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	mock, provider := setupProvider(t)
+	mock.SetClaims(map[string]any{"sub": "248289761001", "preferred_username": "jane", "email": "jane@brewery.test"})
+
+	login := oidc.NewLoginState()
+	claims, err := provider.Exchange(context.Background(), authorize(t, provider, login), login)
+	if err != nil {
+		t.Fatalf("Expected exchange to work, got %v", err)
+	}
+	if claims.Subject != "248289761001" || provider.UserName(claims) != "jane" {
+		t.Errorf("Expected jane, got %s %s", claims.Subject, provider.UserName(claims))
+	}
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		login := oidc.NewLoginState()
+		code := authorize(t, provider, login)
+		login.Verifier = oidc.NewLoginState().Verifier
+		var providerErr *oidc.ProviderError
+		if _, err := provider.Exchange(context.Background(), code, login); !errors.As(err, &providerErr) {
+			t.Errorf("Expected ProviderError, got %v", err)
+		}
+	})
+	t.Run("code used twice", func(t *testing.T) {
+		login := oidc.NewLoginState()
+		code := authorize(t, provider, login)
+		provider.Exchange(context.Background(), code, login)
+		if _, err := provider.Exchange(context.Background(), code, login); err == nil {
+			t.Error("Expected the second exchange to fail")
+		}
+	})
+	t.Run("wrong nonce", func(t *testing.T) {
+		login := oidc.NewLoginState()
+		code := authorize(t, provider, login)
+		login.Nonce = "replayed"
+		var tokenErr *oidc.TokenError
+		if _, err := provider.Exchange(context.Background(), code, login); !errors.As(err, &tokenErr) {
+			t.Errorf("Expected TokenError, got %v", err)
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	mock, provider := setupProvider(t)
+	header := map[string]any{"alg": "RS256", "kid": mock.KeyId}
+	valid := map[string]any{
+		"iss": mock.Issuer(), "aud": "todo", "sub": "u1", "nonce": "n",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+	}
+	with := func(key string, value any) map[string]any {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", mock.IDToken(valid), false},
+		{"audience list", mock.IDToken(with("aud", []string{"todo"})), false},
+		{"other client", mock.IDToken(with("aud", "other")), true},
+		{"several audiences without azp", mock.IDToken(with("aud", []string{"todo", "other"})), true},
+		{"other issuer", mock.IDToken(with("iss", "https://evil.test")), true},
+		{"expired", mock.IDToken(with("exp", time.Now().Add(-time.Hour).Unix())), true},
+		{"issued in the future", mock.IDToken(with("iat", time.Now().Add(time.Hour).Unix())), true},
+		{"wrong nonce", mock.IDToken(with("nonce", "other")), true},
+		{"no subject", mock.IDToken(with("sub", "")), true},
+		{"alg none", mock.Sign(map[string]any{"alg": "none", "kid": mock.KeyId}, valid), true},
+		{"HS256", mock.Sign(map[string]any{"alg": "HS256", "kid": mock.KeyId}, valid), true},
+		{"unknown key", mock.Sign(map[string]any{"alg": "RS256", "kid": "rotated"}, valid), true},
+		{"tampered claims", tamper(mock.Sign(header, valid)), true},
+		{"not a jwt", "abc.def", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.Verify(context.Background(), tt.token, "n")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && claims.Subject != "u1" {
+				t.Errorf("Expected subject u1, got %s", claims.Subject)
+			}
+		})
+	}
+}
+
+// tamper swaps the claims of token for others, keeping the signature
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = strings.TrimRight(parts[1], "=") + "x"
+	return strings.Join(parts, ".")
+}
+
+func TestUserName(t *testing.T) {
+	mock, provider := setupProvider(t)
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   string
+	}{
+		{"preferred username", map[string]any{"sub": "u1", "preferred_username": "jane", "email": "j@b.test"}, "jane"},
+		{"email", map[string]any{"sub": "u1", "email": "j@b.test"}, "j@b.test"},
+		{"subject", map[string]any{"sub": "u1"}, "u1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.Verify(context.Background(), mock.IDToken(tt.claims), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := provider.UserName(claims); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
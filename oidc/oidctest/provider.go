@@ -0,0 +1,201 @@
+// Package oidctest runs a local OpenID Connect provider for tests, like
+// net/http/httptest runs servers
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Provider logs everyone in as the user of SetClaims without asking. It checks
+// client credentials, redirect uri and PKCE verifier like a real provider.
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	KeyId        string
+	key          *rsa.PrivateKey
+	claims       map[string]any
+	codes        map[string]authRequest // one time codes
+	mu           sync.Mutex
+}
+
+type authRequest struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	claims      map[string]any
+}
+
+// NewProvider starts a provider at an httptest URL, which is its issuer.
+// Close it when done.
+func NewProvider(clientID, clientSecret string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: " + err.Error())
+	}
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		KeyId:        "test-key",
+		key:          key,
+		claims:       map[string]any{"sub": "user-1"},
+		codes:        map[string]authRequest{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /keys", p.keys)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer is the URL of the provider
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// SetClaims sets the claims of the user logged in next, "sub" is required
+func (p *Provider) SetClaims(claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = maps.Clone(claims)
+}
+
+// IDToken signs claims with the key of the provider, the standard claims
+// default to a valid token for ClientID
+func (p *Provider) IDToken(claims map[string]any) string {
+	now := time.Now()
+	token := map[string]any{
+		"iss": p.URL,
+		"aud": p.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	maps.Copy(token, claims)
+	return p.Sign(map[string]any{"alg": "RS256", "typ": "JWT", "kid": p.KeyId}, token)
+}
+
+// Sign encodes header and claims as JWT signed with RS256, whatever alg says
+func (p *Provider) Sign(header, claims map[string]any) string {
+	encodedHeader, _ := json.Marshal(header)
+	encodedClaims, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic("oidctest: " + err.Error())
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != p.ClientID ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	code := make([]byte, 16)
+	rand.Read(code)
+	encoded := base64.RawURLEncoding.EncodeToString(code)
+	p.mu.Lock()
+	p.codes[encoded] = authRequest{
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		claims:      maps.Clone(p.claims),
+	}
+	p.mu.Unlock()
+	values := redirect.Query()
+	values.Set("code", encoded)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if clientID, _ = url.QueryUnescape(clientID); clientID != p.ClientID {
+		ok = false
+	}
+	if secret, _ = url.QueryUnescape(secret); secret != p.ClientSecret {
+		ok = false
+	}
+	if !ok {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	p.mu.Lock()
+	request, found := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case r.PostFormValue("grant_type") != "authorization_code":
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	case !found || request.redirectURI != r.PostFormValue("redirect_uri"):
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != request.challenge:
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code verifier does not match"})
+	default:
+		claims := maps.Clone(request.claims)
+		if request.nonce != "" {
+			claims["nonce"] = request.nonce
+		}
+		writeJson(w, http.StatusOK, map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     p.IDToken(claims),
+		})
+	}
+}
+
+func (p *Provider) keys(w http.ResponseWriter, r *http.Request) {
+	public := p.key.PublicKey
+	writeJson(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": p.KeyId,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}}})
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -90,6 +90,19 @@ func NewGCSRepository(ctx context.Context, bucketName, objectName, credentialsFi
 	}, nil
 }
 
+// WithObject returns a repository for another object of the bucket sharing
+// the client, e.g. the tasks of a workspace. Only the first one is closed.
+func (r *GCSRepository) WithObject(objectName string) *GCSRepository {
+	other := *r
+	other.objectName = objectName
+	return &other
+}
+
+// ObjectName is the object tasks are loaded from and saved to
+func (r *GCSRepository) ObjectName() string {
+	return r.objectName
+}
+
 func (r *GCSRepository) Close() error {
 	return r.client.Close()
 }
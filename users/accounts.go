@@ -0,0 +1,290 @@
+package users
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo for Profile.Timezone
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const maxDisplayNameLength = 100
+
+// Profile describes a user to other users, it is kept out of User because
+// tasks copy User into CreatedBy
+type Profile struct {
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email,omitempty"`
+	// Timezone is an IANA name like Europe/Berlin
+	Timezone string `json:"timezone,omitempty"`
+}
+
+type InvalidProfileError struct {
+	Field  string
+	Reason string
+}
+
+func (e *InvalidProfileError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// Validate checks the email address and timezone, empty fields are valid
+func (p Profile) Validate() error {
+	if len(p.DisplayName) > maxDisplayNameLength {
+		return &InvalidProfileError{Field: "displayName", Reason: fmt.Sprintf("longer than %d characters", maxDisplayNameLength)}
+	}
+	if p.Email != "" {
+		address, err := mail.ParseAddress(p.Email)
+		if err != nil || address.Address != p.Email {
+			return &InvalidProfileError{Field: "email", Reason: "not an email address"}
+		}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return &InvalidProfileError{Field: "timezone", Reason: "unknown timezone " + p.Timezone}
+		}
+	}
+	return nil
+}
+
+// Account is a user with what the store keeps apart from User
+type Account struct {
+	User
+	Profile    Profile    `json:"profile"`
+	Active     bool       `json:"active"`
+	Workspaces Membership `json:"-"`
+}
+
+// UserUpdate changes a user, nil fields are kept
+type UserUpdate struct {
+	UserName *string
+	Profile  *Profile
+	// Password replaces the password, "" removes it
+	Password *string
+}
+
+// account is Account of the user named name, the caller holds the lock
+func (s *UserStore) account(name string) Account {
+	return Account{
+		User:       s.Users[name],
+		Profile:    s.profiles[name],
+		Active:     !s.deactivated[name],
+		Workspaces: s.workspaces[name],
+	}
+}
+
+// Account returns the user with userId and its profile
+func (s *UserStore) Account(userId string) (Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return Account{}, false
+	}
+	return s.account(name), true
+}
+
+// ListAccounts returns all users with their profiles sorted by name
+func (s *UserStore) ListAccounts() []Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	accounts := make([]Account, 0, len(s.Users))
+	for name := range s.Users {
+		accounts = append(accounts, s.account(name))
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].UserName < accounts[j].UserName })
+	return accounts
+}
+
+// Active reports whether the user with userId exists and is not deactivated,
+// sessions and API tokens of other users are turned away
+func (s *UserStore) Active(userId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name, ok := s.nameById(userId)
+	return ok && !s.deactivated[name]
+}
+
+// isLastAdmin reports whether the user named name is the only active admin,
+// the caller holds the lock
+func (s *UserStore) isLastAdmin(name string) bool {
+	if s.Users[name].EffectiveRole() != RoleAdmin || s.deactivated[name] {
+		return false
+	}
+	for other, user := range s.Users {
+		if other != name && user.EffectiveRole() == RoleAdmin && !s.deactivated[other] {
+			return false
+		}
+	}
+	return true
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// CreateUser adds a user for admins. Without password the user logs in with
+// single sign-on or after a password is set.
+func (s *UserStore) CreateUser(userName, password string, role Role, profile Profile) (Account, error) {
+	if userName == "" {
+		return Account{}, ErrEmptyUserName
+	}
+	if role == "" {
+		role = DefaultRole
+	}
+	if !role.Valid() {
+		return Account{}, ErrInvalidRole
+	}
+	if err := profile.Validate(); err != nil {
+		return Account{}, err
+	}
+	var hash []byte
+	if password != "" {
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			return Account{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.Users[userName]; exists {
+		return Account{}, ErrUserExists
+	}
+	user := User{UserName: userName, UserId: uuid.New(), Role: role}
+	s.Users[userName] = user
+	s.byId[user.UserId] = userName
+	if hash != nil {
+		s.passwords[userName] = hash
+	}
+	if profile != (Profile{}) {
+		s.profiles[userName] = profile
+	}
+	return s.account(userName), s.Save()
+}
+
+// UpdateUser renames the user with userId and changes its profile and
+// password. Tasks keep the User they copied, so their CreatedBy shows the
+// name the task was created with.
+func (s *UserStore) UpdateUser(userId string, update UserUpdate) (Account, error) {
+	if update.Profile != nil {
+		if err := update.Profile.Validate(); err != nil {
+			return Account{}, err
+		}
+	}
+	var hash []byte
+	if update.Password != nil && *update.Password != "" {
+		var err error
+		if hash, err = hashPassword(*update.Password); err != nil {
+			return Account{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return Account{}, ErrUserNotFound
+	}
+	if update.UserName != nil && *update.UserName != name {
+		if *update.UserName == "" {
+			return Account{}, ErrEmptyUserName
+		}
+		if _, exists := s.Users[*update.UserName]; exists {
+			return Account{}, ErrUserExists
+		}
+		s.rename(name, *update.UserName)
+		name = *update.UserName
+	}
+	if update.Profile != nil {
+		if *update.Profile == (Profile{}) {
+			delete(s.profiles, name)
+		} else {
+			s.profiles[name] = *update.Profile
+		}
+	}
+	if update.Password != nil {
+		if hash == nil {
+			delete(s.passwords, name)
+		} else {
+			s.passwords[name] = hash
+		}
+	}
+	return s.account(name), s.Save()
+}
+
+// rename moves everything kept by user name, the caller holds the lock
+func (s *UserStore) rename(oldName, newName string) {
+	user := s.Users[oldName]
+	user.UserName = newName
+	delete(s.Users, oldName)
+	s.Users[newName] = user
+	s.byId[user.UserId] = newName
+	if hash, ok := s.passwords[oldName]; ok {
+		delete(s.passwords, oldName)
+		s.passwords[newName] = hash
+	}
+	if membership, ok := s.workspaces[oldName]; ok {
+		delete(s.workspaces, oldName)
+		s.workspaces[newName] = membership
+	}
+	if identity, ok := s.identities[oldName]; ok {
+		delete(s.identities, oldName)
+		s.identities[newName] = identity
+	}
+	if profile, ok := s.profiles[oldName]; ok {
+		delete(s.profiles, oldName)
+		s.profiles[newName] = profile
+	}
+	if s.deactivated[oldName] {
+		delete(s.deactivated, oldName)
+		s.deactivated[newName] = true
+	}
+}
+
+// SetActive deactivates or reactivates the user with userId. Deactivated
+// users can't log in and their sessions and API tokens stop working, their
+// tasks keep them as CreatedBy. The last admin can't be deactivated.
+func (s *UserStore) SetActive(userId string, active bool) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return Account{}, ErrUserNotFound
+	}
+	if active {
+		delete(s.deactivated, name)
+	} else {
+		if s.isLastAdmin(name) {
+			return Account{}, ErrLastAdmin
+		}
+		s.deactivated[name] = true
+	}
+	return s.account(name), s.Save()
+}
+
+// DeleteUser removes the user with userId, tasks keep the copy in CreatedBy.
+// The last admin can't be deleted.
+func (s *UserStore) DeleteUser(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if s.isLastAdmin(name) {
+		return ErrLastAdmin
+	}
+	delete(s.byId, s.Users[name].UserId)
+	delete(s.Users, name)
+	delete(s.passwords, name)
+	delete(s.workspaces, name)
+	delete(s.identities, name)
+	delete(s.profiles, name)
+	delete(s.deactivated, name)
+	return s.Save()
+}
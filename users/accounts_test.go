@@ -0,0 +1,180 @@
+package users
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestProfile_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		profile   Profile
+		wantField string
+	}{
+		{"empty", Profile{}, ""},
+		{"complete", Profile{DisplayName: "Jane Doe", Email: "jane@brewery.test", Timezone: "Europe/Berlin"}, ""},
+		{"email with name", Profile{Email: "Jane <jane@brewery.test>"}, "email"},
+		{"not an email", Profile{Email: "jane"}, "email"},
+		{"unknown timezone", Profile{Timezone: "Mars/Olympus"}, "timezone"},
+		{"long display name", Profile{DisplayName: string(make([]byte, maxDisplayNameLength+1))}, "displayName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.Validate()
+			var profileErr *InvalidProfileError
+			if tt.wantField == "" && err != nil {
+				t.Fatalf("Validate() error = %v, want nil", err)
+			}
+			if tt.wantField != "" && (!errors.As(err, &profileErr) || profileErr.Field != tt.wantField) {
+				t.Fatalf("Validate() error = %v, want invalid %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestUserStore_CreateUpdateUser(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	profile := Profile{DisplayName: "Jane Doe", Email: "jane@brewery.test"}
+	jane, err := store.CreateUser("jane", "hoppy-ipa", "", profile)
+	if err != nil || jane.Role != DefaultRole || jane.Profile != profile || !jane.Active {
+		t.Fatalf("CreateUser() = %v, %v, want active brewer with profile", jane, err)
+	}
+	store.SetWorkspaces(jane.UserId.String(), []string{"north"})
+
+	createTests := []struct {
+		name     string
+		userName string
+		password string
+		role     Role
+		profile  Profile
+		wantErr  error
+	}{
+		{"taken name", "jane", "", "", Profile{}, ErrUserExists},
+		{"empty name", "", "", "", Profile{}, ErrEmptyUserName},
+		{"weak password", "joe", "ipa", "", Profile{}, ErrWeakPassword},
+		{"invalid role", "joe", "", "owner", Profile{}, ErrInvalidRole},
+	}
+	for _, tt := range createTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.CreateUser(tt.userName, tt.password, tt.role, tt.profile); err != tt.wantErr {
+				t.Errorf("CreateUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	newName := "jane.doe"
+	updated, err := store.UpdateUser(jane.UserId.String(), UserUpdate{UserName: &newName})
+	if err != nil || updated.UserName != newName || updated.UserId != jane.UserId || updated.Profile != profile {
+		t.Fatalf("UpdateUser() = %v, %v, want jane renamed", updated, err)
+	}
+	if _, found := store.GetUser("jane"); found {
+		t.Error("Expected the old name to be free")
+	}
+	if _, err := store.Authenticate(newName, "hoppy-ipa"); err != nil {
+		t.Errorf("Authenticate() after rename error = %v, want nil", err)
+	}
+	if membership := store.Membership(newName); len(membership) != 1 || membership[0] != "north" {
+		t.Errorf("Membership() after rename = %v, want [north]", membership)
+	}
+
+	store.Register("joe", "stout-lover")
+	joe, _ := store.GetUser("joe")
+	invalid := Profile{Timezone: "Mars/Olympus"}
+	updateTests := []struct {
+		name    string
+		userId  string
+		update  UserUpdate
+		wantErr error
+	}{
+		{"taken name", jane.UserId.String(), UserUpdate{UserName: stringPtr("joe")}, ErrUserExists},
+		{"empty name", jane.UserId.String(), UserUpdate{UserName: stringPtr("")}, ErrEmptyUserName},
+		{"weak password", jane.UserId.String(), UserUpdate{Password: stringPtr("ipa")}, ErrWeakPassword},
+		{"unknown user", "not-a-uuid", UserUpdate{}, ErrUserNotFound},
+		{"invalid profile", joe.UserId.String(), UserUpdate{Profile: &invalid}, &InvalidProfileError{}},
+	}
+	for _, tt := range updateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.UpdateUser(tt.userId, tt.update)
+			var profileErr *InvalidProfileError
+			if errors.As(tt.wantErr, &profileErr) {
+				if !errors.As(err, &profileErr) {
+					t.Errorf("UpdateUser() error = %v, want invalid profile", err)
+				}
+			} else if err != tt.wantErr {
+				t.Errorf("UpdateUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// an empty password removes it, the user logs in with single sign-on
+	store.UpdateUser(joe.UserId.String(), UserUpdate{Password: stringPtr(""), Profile: &Profile{}})
+	if _, err := store.Authenticate("joe", "stout-lover"); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() without password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	reloaded, _ := NewUserStore(tmpFile)
+	if account, found := reloaded.Account(jane.UserId.String()); !found || account.UserName != newName || account.Profile != profile {
+		t.Errorf("Account() after reload = %v, %v, want renamed jane with profile", account, found)
+	}
+}
+
+func TestUserStore_SetActiveDeleteUser(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	admin, _ := store.CreateUser("admin", "", RoleAdmin, Profile{})
+	brewer, _ := store.CreateUser("brewer", "hoppy-ipa", RoleBrewer, Profile{})
+
+	if _, err := store.SetActive(admin.UserId.String(), false); err != ErrLastAdmin {
+		t.Errorf("SetActive() last admin error = %v, want %v", err, ErrLastAdmin)
+	}
+	if err := store.DeleteUser(admin.UserId.String()); err != ErrLastAdmin {
+		t.Errorf("DeleteUser() last admin error = %v, want %v", err, ErrLastAdmin)
+	}
+
+	account, err := store.SetActive(brewer.UserId.String(), false)
+	if err != nil || account.Active || store.Active(brewer.UserId.String()) {
+		t.Fatalf("SetActive(false) = %v, %v, want deactivated", account, err)
+	}
+	if _, err := store.Authenticate("brewer", "hoppy-ipa"); err != ErrUserDeactivated {
+		t.Errorf("Authenticate() deactivated error = %v, want %v", err, ErrUserDeactivated)
+	}
+	if _, err := store.Authenticate("brewer", "wrong-password"); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() deactivated with wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	// a deactivated admin doesn't count as the other admin
+	store.SetRole(brewer.UserId.String(), RoleAdmin)
+	if _, err := store.SetRole(admin.UserId.String(), RoleBrewer); err != ErrLastAdmin {
+		t.Errorf("SetRole() with deactivated admin error = %v, want %v", err, ErrLastAdmin)
+	}
+
+	reloaded, _ := NewUserStore(tmpFile)
+	if reloaded.Active(brewer.UserId.String()) {
+		t.Error("Expected the user to stay deactivated after reload")
+	}
+	if _, err := store.SetActive(brewer.UserId.String(), true); err != nil || !store.Active(brewer.UserId.String()) {
+		t.Errorf("SetActive(true) error = %v, want active", err)
+	}
+
+	if err := store.DeleteUser(admin.UserId.String()); err != nil {
+		t.Fatalf("DeleteUser() error = %v, want nil", err)
+	}
+	if _, found := store.GetUserById(admin.UserId.String()); found || store.Active(admin.UserId.String()) {
+		t.Error("Expected the deleted user to be gone")
+	}
+	if err := store.DeleteUser(admin.UserId.String()); err != ErrUserNotFound {
+		t.Errorf("DeleteUser() twice error = %v, want %v", err, ErrUserNotFound)
+	}
+	if _, err := store.CreateUser("admin", "", RoleViewer, Profile{}); err != nil {
+		t.Errorf("CreateUser() with the name of a deleted user error = %v, want nil", err)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,222 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ScopeRead allows GET requests, ScopeWrite everything else
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	APITokenPrefix         = "tdt_"
+	DefaultAPITokenDays    = 30
+	MaxAPITokenDays        = 365
+	maxAPITokenNameLength  = 100
+	lastUsedSaveInterval   = time.Minute
+	apiTokenSecretByteSize = 32
+)
+
+var (
+	ErrTokenNotFound = errors.New("api token not found")
+	ErrInvalidToken  = errors.New("invalid api token")
+	ErrExpiredToken  = errors.New("api token expired")
+)
+
+type InvalidTokenValueError struct {
+	Field string
+}
+
+func (e *InvalidTokenValueError) Error() string {
+	return fmt.Sprintf("invalid api token value: %s", e.Field)
+}
+
+// APIToken is a personal token for scripts, only the SHA-256 hash of the
+// secret is stored. The secret is shown once when the token is created.
+type APIToken struct {
+	Id         int        `json:"id"`
+	UserId     string     `json:"userId"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// storedToken keeps the hash in the file, APIToken leaves it out of responses
+type storedToken struct {
+	APIToken
+	Hash string `json:"hash"`
+}
+
+type APITokenStore struct {
+	latestId int
+	tokens   []APIToken
+	// when LastUsedAt of a token was saved, later uses are saved once a minute
+	savedUse map[int]time.Time
+	file     string
+	mu       sync.Mutex
+}
+
+func NewAPITokenStore(file string) (*APITokenStore, error) {
+	store := &APITokenStore{file: file, savedUse: map[int]time.Time{}}
+	if file == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []storedToken
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	for _, token := range stored {
+		token.APIToken.Hash = token.Hash
+		store.tokens = append(store.tokens, token.APIToken)
+		store.latestId = max(store.latestId, token.Id)
+	}
+	return store, nil
+}
+
+func (s *APITokenStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+	stored := make([]storedToken, len(s.tokens))
+	for i, token := range s.tokens {
+		stored[i] = storedToken{APIToken: token, Hash: token.Hash}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0600)
+}
+
+// Create returns the token and its secret, days is the lifetime with 0
+// meaning DefaultAPITokenDays
+func (s *APITokenStore) Create(userId, name string, scopes []string, days int) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		return nil, "", &InvalidTokenValueError{Field: "name"}
+	}
+	if len(scopes) == 0 {
+		return nil, "", &InvalidTokenValueError{Field: "scopes"}
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return nil, "", &InvalidTokenValueError{Field: "scopes"}
+		}
+	}
+	if days == 0 {
+		days = DefaultAPITokenDays
+	}
+	if days < 0 || days > MaxAPITokenDays {
+		return nil, "", &InvalidTokenValueError{Field: "expiresInDays"}
+	}
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	random := make([]byte, apiTokenSecretByteSize)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", err
+	}
+	secret := APITokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latestId++
+	token := APIToken{
+		Id:        s.latestId,
+		UserId:    userId,
+		Name:      name,
+		Scopes:    slices.Compact(scopes),
+		Hash:      hashToken(secret),
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, days),
+	}
+	s.tokens = append(s.tokens, token)
+	return &token, secret, s.save()
+}
+
+// List returns the tokens of userId, oldest first
+func (s *APITokenStore) List(userId string) []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []APIToken{}
+	for _, token := range s.tokens {
+		if token.UserId == userId {
+			list = append(list, token)
+		}
+	}
+	return list
+}
+
+// Revoke deletes a token of userId, tokens of other users are not found
+func (s *APITokenStore) Revoke(userId string, tokenId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, token := range s.tokens {
+		if token.Id == tokenId && token.UserId == userId {
+			s.tokens = slices.Delete(s.tokens, i, i+1)
+			delete(s.savedUse, tokenId)
+			return s.save()
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// Verify returns the user id and scopes of an unexpired token secret and
+// records its use
+func (s *APITokenStore) Verify(secret string) (string, []string, error) {
+	if !strings.HasPrefix(secret, APITokenPrefix) {
+		return "", nil, ErrInvalidToken
+	}
+	hash := hashToken(secret)
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.tokens {
+		token := &s.tokens[i]
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if !now.Before(token.ExpiresAt) {
+			return "", nil, ErrExpiredToken
+		}
+		token.LastUsedAt = &now
+		if now.Sub(s.savedUse[token.Id]) >= lastUsedSaveInterval {
+			s.savedUse[token.Id] = now
+			if err := s.save(); err != nil {
+				return "", nil, err
+			}
+		}
+		return token.UserId, slices.Clone(token.Scopes), nil
+	}
+	return "", nil, ErrInvalidToken
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,89 @@
+package users
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokenStore_CreateVerifyRevoke(t *testing.T) {
+	tmpFile := "test_tokens.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewAPITokenStore(tmpFile)
+	token, secret, err := store.Create("user-1", "sensor", []string{ScopeWrite, ScopeRead, ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !strings.HasPrefix(secret, APITokenPrefix) || len(token.Scopes) != 2 {
+		t.Errorf("Create() got %v %q", token, secret)
+	}
+	if token.ExpiresAt.Sub(token.CreatedAt).Hours() != DefaultAPITokenDays*24 {
+		t.Errorf("Create() expiry = %v, want %d days", token.ExpiresAt, DefaultAPITokenDays)
+	}
+
+	userId, scopes, err := store.Verify(secret)
+	if err != nil || userId != "user-1" || len(scopes) != 2 {
+		t.Errorf("Verify() got %v %v %v", userId, scopes, err)
+	}
+	if _, _, err := store.Verify(secret + "x"); err != ErrInvalidToken {
+		t.Errorf("Verify() wrong secret error = %v", err)
+	}
+
+	reloaded, _ := NewAPITokenStore(tmpFile)
+	list := reloaded.List("user-1")
+	if len(list) != 1 || list[0].LastUsedAt == nil {
+		t.Fatalf("List() after reload got %+v, want token with last use", list)
+	}
+	data, _ := os.ReadFile(tmpFile)
+	if strings.Contains(string(data), secret) {
+		t.Error("save() stored the plain token")
+	}
+
+	if err := reloaded.Revoke("user-2", token.Id); err != ErrTokenNotFound {
+		t.Errorf("Revoke() of other user error = %v", err)
+	}
+	if err := reloaded.Revoke("user-1", token.Id); err != nil {
+		t.Errorf("Revoke() error = %v", err)
+	}
+	if _, _, err := reloaded.Verify(secret); err != ErrInvalidToken {
+		t.Errorf("Verify() revoked error = %v", err)
+	}
+}
+
+func TestAPITokenStore_CreateInvalid(t *testing.T) {
+	store, _ := NewAPITokenStore("")
+
+	tests := []struct {
+		name      string
+		tokenName string
+		scopes    []string
+		days      int
+		wantField string
+	}{
+		{"empty name", " ", []string{ScopeRead}, 0, "name"},
+		{"no scopes", "sensor", nil, 0, "scopes"},
+		{"unknown scope", "sensor", []string{"admin"}, 0, "scopes"},
+		{"too long", "sensor", []string{ScopeRead}, MaxAPITokenDays + 1, "expiresInDays"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := store.Create("user-1", tt.tokenName, tt.scopes, tt.days)
+			invalid, ok := err.(*InvalidTokenValueError)
+			if !ok || invalid.Field != tt.wantField {
+				t.Errorf("Create() error = %v, want invalid %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestAPITokenStore_VerifyExpired(t *testing.T) {
+	store, _ := NewAPITokenStore("")
+	_, secret, _ := store.Create("user-1", "sensor", []string{ScopeRead}, 1)
+	store.tokens[0].ExpiresAt = time.Now().Add(-time.Second)
+
+	if _, _, err := store.Verify(secret); err != ErrExpiredToken {
+		t.Errorf("Verify() error = %v, want %v", err, ErrExpiredToken)
+	}
+}
@@ -5,26 +5,130 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"sync"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+var (
+	ErrEmptyUserName      = errors.New("user name can't be empty")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidCredentials = errors.New("invalid user name or password")
+	ErrWeakPassword       = fmt.Errorf("password must have at least %d characters", minPasswordLength)
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserDeactivated    = errors.New("user is deactivated")
 )
 
 type User struct {
 	UserName string    `json:"userName"`
 	UserId   uuid.UUID `json:"userId"`
+	Role     Role      `json:"role,omitempty"`
+}
+
+// DefaultWorkspace holds the tasks of users without workspaces
+const DefaultWorkspace = "default"
+
+// Membership lists the workspaces of a user, empty means only DefaultWorkspace
+type Membership []string
+
+// Has reports whether the user may use the tasks of workspace
+func (m Membership) Has(workspace string) bool {
+	if len(m) == 0 {
+		return workspace == DefaultWorkspace
+	}
+	return slices.Contains(m, workspace)
+}
+
+// Home is the workspace of requests which don't name one
+func (m Membership) Home() string {
+	if len(m) == 0 || slices.Contains(m, DefaultWorkspace) {
+		return DefaultWorkspace
+	}
+	return m[0]
+}
+
+// Role decides what a user may do, see package authz
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"
+	RoleManager Role = "manager"
+	RoleBrewer  Role = "brewer"
+	RoleViewer  Role = "viewer"
+	// DefaultRole is the role of new users and of users saved before roles
+	DefaultRole = RoleBrewer
+)
+
+var (
+	ErrInvalidRole = errors.New("role must be admin, manager, brewer or viewer")
+	ErrLastAdmin   = errors.New("the last active admin can't be demoted, deactivated or deleted")
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleManager, RoleBrewer, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// EffectiveRole is Role, or DefaultRole for users saved without one
+func (u User) EffectiveRole() Role {
+	if u.Role == "" {
+		return DefaultRole
+	}
+	return u.Role
 }
 
 type UserStore struct {
 	Users map[string]User `json:"users"`
-	mu    sync.RWMutex
-	file  string
+	// password hashes by user name, kept out of User because tasks copy it
+	passwords map[string][]byte
+	// workspaces by user name, kept out of User like the passwords
+	workspaces map[string]Membership
+	// accounts at identity providers by user name, kept out of User as well
+	identities map[string]ExternalIdentity
+	// profiles and deactivated users by user name, see accounts.go
+	profiles    map[string]Profile
+	deactivated map[string]bool
+	// user names by id, so requests find their user without a scan
+	byId map[uuid.UUID]string
+	mu   sync.RWMutex
+	file string
+}
+
+// storedUser is how a user is saved, users without a password can't log in
+type storedUser struct {
+	User
+	PasswordHash string            `json:"passwordHash,omitempty"`
+	Workspaces   Membership        `json:"workspaces,omitempty"`
+	Identity     *ExternalIdentity `json:"identity,omitempty"`
+	Profile      *Profile          `json:"profile,omitempty"`
+	Deactivated  bool              `json:"deactivated,omitempty"`
+}
+
+// ExternalIdentity is the account of a user at an OpenID Connect provider,
+// the subject never changes while names and emails may
+type ExternalIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
 }
 
 func NewUserStore(file string) (*UserStore, error) {
 	store := &UserStore{
-		Users: make(map[string]User),
-		file:  file,
+		Users:       make(map[string]User),
+		passwords:   make(map[string][]byte),
+		workspaces:  make(map[string]Membership),
+		identities:  make(map[string]ExternalIdentity),
+		profiles:    make(map[string]Profile),
+		deactivated: make(map[string]bool),
+		byId:        make(map[uuid.UUID]string),
+		file:        file,
 	}
 	err := store.Load()
 	if err != nil && !os.IsNotExist(err) {
@@ -41,12 +145,50 @@ func (s *UserStore) Load() error {
 		return err
 	}
 
-	return json.Unmarshal(data, &s.Users)
+	var stored map[string]storedUser
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	for name, user := range stored {
+		s.Users[name] = user.User
+		s.byId[user.UserId] = name
+		if user.PasswordHash != "" {
+			s.passwords[name] = []byte(user.PasswordHash)
+		}
+		if len(user.Workspaces) > 0 {
+			s.workspaces[name] = user.Workspaces
+		}
+		if user.Identity != nil {
+			s.identities[name] = *user.Identity
+		}
+		if user.Profile != nil {
+			s.profiles[name] = *user.Profile
+		}
+		if user.Deactivated {
+			s.deactivated[name] = true
+		}
+	}
+	return nil
 }
 
 func (s *UserStore) Save() error {
-
-	data, err := json.MarshalIndent(s.Users, "", "  ")
+	stored := make(map[string]storedUser, len(s.Users))
+	for name, user := range s.Users {
+		record := storedUser{
+			User:         user,
+			PasswordHash: string(s.passwords[name]),
+			Workspaces:   s.workspaces[name],
+			Deactivated:  s.deactivated[name],
+		}
+		if identity, ok := s.identities[name]; ok {
+			record.Identity = &identity
+		}
+		if profile, ok := s.profiles[name]; ok {
+			record.Profile = &profile
+		}
+		stored[name] = record
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -56,27 +198,230 @@ func (s *UserStore) Save() error {
 
 func (s *UserStore) AddUser(username string) (*User, error) {
 	if username == "" {
-		return nil, errors.New("user name can't be empty")
+		return nil, ErrEmptyUserName
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, exists := s.Users[username]; exists {
-		return nil, errors.New("user already exists")
+		return nil, ErrUserExists
 	}
 	newUser := User{UserName: username, UserId: uuid.New()}
 	s.Users[username] = newUser
-	fmt.Println(s.Users)
+	s.byId[newUser.UserId] = username
 	err := s.Save()
 	return &newUser, err
 }
 
+// Register adds a user with a password, the password is stored as a bcrypt hash
+func (s *UserStore) Register(username, password string) (*User, error) {
+	if username == "" {
+		return nil, ErrEmptyUserName
+	}
+	if len(password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.Users[username]; exists {
+		return nil, ErrUserExists
+	}
+	newUser := User{UserName: username, UserId: uuid.New(), Role: DefaultRole}
+	s.Users[username] = newUser
+	s.byId[newUser.UserId] = username
+	s.passwords[username] = hash
+	return &newUser, s.Save()
+}
+
+// SetPassword replaces the password of an existing user, users created
+// before passwords existed need it to log in
+func (s *UserStore) SetPassword(username, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.Users[username]; !exists {
+		return ErrUserNotFound
+	}
+	s.passwords[username] = hash
+	return s.Save()
+}
+
+// HasPassword reports whether username can log in with a password
+func (s *UserStore) HasPassword(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.passwords[username]) > 0
+}
+
+// Authenticate returns the user when password matches. Unknown users,
+// users without password and wrong passwords all get ErrInvalidCredentials,
+// deactivated users with the right password ErrUserDeactivated.
+func (s *UserStore) Authenticate(username, password string) (User, error) {
+	s.mu.RLock()
+	user, exists := s.Users[username]
+	hash := s.passwords[username]
+	deactivated := s.deactivated[username]
+	s.mu.RUnlock()
+	if !exists || hash == nil {
+		// compare anyway so unknown names take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	if deactivated {
+		return User{}, ErrUserDeactivated
+	}
+	return user, nil
+}
+
+// ProvisionUser returns the user of identity, creating it as userName on the
+// first login. Existing users are never linked by name, so an account at the
+// provider can't take over a local user; that name fails with ErrUserExists.
+// Provisioned users have no password and log in only through the provider.
+func (s *UserStore) ProvisionUser(identity ExternalIdentity, userName string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, linked := range s.identities {
+		if linked == identity {
+			if s.deactivated[name] {
+				return User{}, ErrUserDeactivated
+			}
+			return s.Users[name], nil
+		}
+	}
+	if userName == "" {
+		return User{}, ErrEmptyUserName
+	}
+	if _, exists := s.Users[userName]; exists {
+		return User{}, ErrUserExists
+	}
+	user := User{UserName: userName, UserId: uuid.New(), Role: DefaultRole}
+	s.Users[userName] = user
+	s.byId[user.UserId] = userName
+	s.identities[userName] = identity
+	return user, s.Save()
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
 func (s *UserStore) GetUser(username string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	user, exists := s.Users[username]
 	return user, exists
 }
-func (s *UserStore) GetUserById(userId string) (User, bool) {
-	for _, v := range s.Users {
-		if v.UserId.String() == userId {
-			return v, true
+
+// UserId returns the id of the user named userName, tasks keep it as the id
+// of their assignee
+func (s *UserStore) UserId(userName string) (string, bool) {
+	user, exists := s.GetUser(userName)
+	if !exists || user.UserId == uuid.Nil {
+		return "", false
+	}
+	return user.UserId.String(), true
+}
+
+// GetUsers looks up several users at once, names not found are left out
+func (s *UserStore) GetUsers(names []string) map[string]User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := make(map[string]User, len(names))
+	for _, name := range names {
+		if user, exists := s.Users[name]; exists {
+			found[name] = user
 		}
 	}
-	return User{}, false
+	return found
+}
+
+// ListUsers returns all users sorted by name
+func (s *UserStore) ListUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]User, 0, len(s.Users))
+	for _, user := range s.Users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserName < list[j].UserName })
+	return list
+}
+
+// GetUserById finds deactivated users too, see Active
+func (s *UserStore) GetUserById(userId string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return User{}, false
+	}
+	return s.Users[name], true
+}
+
+// nameById is the name of the user with userId, the caller holds the lock
+func (s *UserStore) nameById(userId string) (string, bool) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return "", false
+	}
+	name, ok := s.byId[id]
+	return name, ok
+}
+
+// Membership returns the workspaces of the user named userName
+func (s *UserStore) Membership(userName string) Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.workspaces[userName])
+}
+
+// SetWorkspaces replaces the workspaces of the user with userId, the names
+// are checked by the caller
+func (s *UserStore) SetWorkspaces(userId string, workspaces []string) (Membership, error) {
+	membership := Membership(slices.Clone(workspaces))
+	slices.Sort(membership)
+	membership = slices.Compact(membership)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if len(membership) == 0 {
+		delete(s.workspaces, name)
+	} else {
+		s.workspaces[name] = membership
+	}
+	return slices.Clone(membership), s.Save()
+}
+
+// SetRole changes the role of the user with userId, demoting the last admin
+// fails with ErrLastAdmin
+func (s *UserStore) SetRole(userId string, role Role) (User, error) {
+	if !role.Valid() {
+		return User{}, ErrInvalidRole
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, ok := s.nameById(userId)
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	found := s.Users[name]
+	if role != RoleAdmin && s.isLastAdmin(name) {
+		return User{}, ErrLastAdmin
+	}
+	found.Role = role
+	s.Users[name] = found
+	return found, s.Save()
 }
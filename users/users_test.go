@@ -2,6 +2,7 @@ package users
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -125,3 +126,192 @@ func TestUserStore_Save_Load(t *testing.T) {
 		t.Error("Load() failed to restore user data correctly")
 	}
 }
+
+func TestUserStore_GetUsers(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	store.AddUser("bob")
+	store.AddUser("anna")
+
+	found := store.GetUsers([]string{"anna", "nobody"})
+	if len(found) != 1 || found["anna"].UserName != "anna" {
+		t.Errorf("GetUsers() got = %v, want only anna", found)
+	}
+	list := store.ListUsers()
+	if len(list) != 2 || list[0].UserName != "anna" || list[1].UserName != "bob" {
+		t.Errorf("ListUsers() got = %v, want anna and bob", list)
+	}
+}
+
+func TestUserStore_RegisterAuthenticate(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	store.AddUser("legacy")
+	if _, err := store.Register("brewer", "hoppy-ipa"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"right password", "brewer", "hoppy-ipa", nil},
+		{"wrong password", "brewer", "malty-stout", ErrInvalidCredentials},
+		{"unknown user", "nobody", "hoppy-ipa", ErrInvalidCredentials},
+		{"user without password", "legacy", "", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := store.Authenticate(tt.username, tt.password)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.UserName != tt.username {
+				t.Errorf("Authenticate() user = %v, want %v", user.UserName, tt.username)
+			}
+		})
+	}
+
+	if _, err := store.Register("brewer", "hoppy-ipa"); err != ErrUserExists {
+		t.Errorf("Register() duplicate error = %v, want %v", err, ErrUserExists)
+	}
+	if _, err := store.Register("taster", "short"); err != ErrWeakPassword {
+		t.Errorf("Register() short password error = %v, want %v", err, ErrWeakPassword)
+	}
+
+	if store.HasPassword("legacy") || !store.HasPassword("brewer") {
+		t.Error("Expected only brewer to have a password")
+	}
+	store.SetPassword("legacy", "finally-set")
+	reloaded, _ := NewUserStore(tmpFile)
+	if _, err := reloaded.Authenticate("legacy", "finally-set"); err != nil {
+		t.Errorf("Authenticate() after reload error = %v", err)
+	}
+	data, _ := os.ReadFile(tmpFile)
+	if strings.Contains(string(data), "hoppy-ipa") {
+		t.Error("Save() stored the plain password")
+	}
+}
+
+func TestUserStore_SetRole(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	admin, _ := store.AddUser("admin")
+	brewer, _ := store.AddUser("brewer")
+	if brewer.EffectiveRole() != DefaultRole {
+		t.Fatalf("EffectiveRole() = %v, want %v", brewer.EffectiveRole(), DefaultRole)
+	}
+
+	tests := []struct {
+		name    string
+		userId  string
+		role    Role
+		wantErr error
+	}{
+		{"make admin", admin.UserId.String(), RoleAdmin, nil},
+		{"demote last admin", admin.UserId.String(), RoleViewer, ErrLastAdmin},
+		{"invalid role", brewer.UserId.String(), "owner", ErrInvalidRole},
+		{"unknown user", uuid.NewString(), RoleViewer, ErrUserNotFound},
+		{"second admin", brewer.UserId.String(), RoleAdmin, nil},
+		{"demote admin", admin.UserId.String(), RoleManager, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := store.SetRole(tt.userId, tt.role)
+			if err != tt.wantErr {
+				t.Fatalf("SetRole() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.Role != tt.role {
+				t.Errorf("SetRole() role = %v, want %v", user.Role, tt.role)
+			}
+		})
+	}
+
+	reloaded, _ := NewUserStore(tmpFile)
+	if user, _ := reloaded.GetUser("admin"); user.Role != RoleManager {
+		t.Errorf("Role after reload = %v, want %v", user.Role, RoleManager)
+	}
+}
+
+func TestUserStore_SetWorkspaces(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	user, _ := store.AddUser("brewer")
+	if membership := store.Membership("brewer"); !membership.Has(DefaultWorkspace) || membership.Home() != DefaultWorkspace {
+		t.Errorf("Expected users without workspaces in the default one, got %v", membership)
+	}
+
+	membership, err := store.SetWorkspaces(user.UserId.String(), []string{"site-b", "site-a", "site-b"})
+	if err != nil {
+		t.Fatalf("SetWorkspaces() error = %v", err)
+	}
+	if strings.Join(membership, ",") != "site-a,site-b" {
+		t.Errorf("SetWorkspaces() = %v, want sorted and unique", membership)
+	}
+	if _, err := store.SetWorkspaces(uuid.NewString(), nil); err != ErrUserNotFound {
+		t.Errorf("SetWorkspaces() unknown user error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	reloaded, _ := NewUserStore(tmpFile)
+	membership = reloaded.Membership("brewer")
+	if membership.Has(DefaultWorkspace) || !membership.Has("site-b") || membership.Home() != "site-a" {
+		t.Errorf("Membership after reload = %v", membership)
+	}
+	if reloaded, _ := reloaded.GetUser("brewer"); reloaded != *user {
+		t.Errorf("GetUser() after reload = %v, want %v", reloaded, *user)
+	}
+}
+
+func TestUserStore_ProvisionUser(t *testing.T) {
+	tmpFile := "test_users.json"
+	defer os.Remove(tmpFile)
+
+	store, _ := NewUserStore(tmpFile)
+	store.Register("local", "hoppy-ipa")
+	jane := ExternalIdentity{Issuer: "https://idp.test", Subject: "248289761001"}
+
+	user, err := store.ProvisionUser(jane, "jane")
+	if err != nil || user.UserName != "jane" || user.Role != DefaultRole {
+		t.Fatalf("ProvisionUser() = %v, %v, want new brewer jane", user, err)
+	}
+	renamed, err := store.ProvisionUser(jane, "jane.doe")
+	if err != nil || renamed != user {
+		t.Errorf("ProvisionUser() second login = %v, %v, want %v", renamed, err, user)
+	}
+	if _, err := store.Authenticate("jane", ""); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() provisioned user error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	tests := []struct {
+		name     string
+		identity ExternalIdentity
+		userName string
+		wantErr  error
+	}{
+		{"local user not linked by name", ExternalIdentity{Issuer: "https://idp.test", Subject: "2"}, "local", ErrUserExists},
+		{"same subject of another issuer", ExternalIdentity{Issuer: "https://other.test", Subject: "248289761001"}, "jane", ErrUserExists},
+		{"empty name", ExternalIdentity{Issuer: "https://idp.test", Subject: "3"}, "", ErrEmptyUserName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.ProvisionUser(tt.identity, tt.userName); err != tt.wantErr {
+				t.Errorf("ProvisionUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	reloaded, _ := NewUserStore(tmpFile)
+	if found, err := reloaded.ProvisionUser(jane, ""); err != nil || found != user {
+		t.Errorf("ProvisionUser() after reload = %v, %v, want %v", found, err, user)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/zhekagigs/golang_todo/internal"
 	"github.com/zhekagigs/golang_todo/logger"
+	"github.com/zhekagigs/golang_todo/users"
 )
 
 //go:embed templates/*.html
@@ -19,18 +20,33 @@ var templateFiles embed.FS
 // 	Tasks []in.Task
 // }
 
+// Renderer renders the pages, csrfToken is sent back by their forms and
+// fetches, see middleware.CSRFMiddleware
 type Renderer interface {
-	RenderTaskList(http.ResponseWriter, []internal.Task) error
-	RenderCreateForm(http.ResponseWriter) error
-	RenderTaskUpdate(http.ResponseWriter, *internal.Task) error
+	RenderTaskList(w http.ResponseWriter, tasks []internal.Task, csrfToken string, liveUpdates bool) error
+	RenderCreateForm(w http.ResponseWriter, csrfToken string) error
+	RenderTaskUpdate(w http.ResponseWriter, task *internal.Task, csrfToken string) error
+}
+
+// UserRenderer renders the admin pages of users
+type UserRenderer interface {
+	RenderUserList(w http.ResponseWriter, accounts []users.Account, csrfToken string) error
+	RenderUserEdit(w http.ResponseWriter, account users.Account, csrfToken string) error
 }
 
 type TaskRenderer struct {
 	templates *template.Template
+	// SingleSignOn shows the link to /login/oidc in the login popup
+	SingleSignOn bool
 }
 
 type TaskListData struct {
-	Tasks []internal.Task
+	Tasks        []internal.Task
+	SingleSignOn bool
+	CSRFToken    string
+	// LiveUpdates follows task events, they are only sent to logged in
+	// users of the default workspace
+	LiveUpdates bool
 }
 
 func renderErrCheck(err error) error {
@@ -46,6 +62,19 @@ func NewRenderer() (*TaskRenderer, error) {
 			return t.Format("Jan 02, 2006 15:04")
 		},
 		"toLowerCase": strings.ToLower,
+		"checklistProgress": func(t internal.Task) string {
+			done, total := t.ChecklistProgress()
+			return fmt.Sprintf("%d/%d", done, total)
+		},
+		"workspaces": func(m users.Membership) string {
+			if len(m) == 0 {
+				return users.DefaultWorkspace
+			}
+			return strings.Join(m, ", ")
+		},
+		"roles": func() []users.Role {
+			return []users.Role{users.RoleAdmin, users.RoleManager, users.RoleBrewer, users.RoleViewer}
+		},
 	}
 
 	tmpl, err := template.New("").Funcs(funcMap).ParseFS(templateFiles, "templates/*.html")
@@ -56,28 +85,62 @@ func NewRenderer() (*TaskRenderer, error) {
 	return &TaskRenderer{templates: tmpl}, nil
 }
 
-func (r *TaskRenderer) RenderTaskList(w http.ResponseWriter, tasks []internal.Task) error {
+func (r *TaskRenderer) RenderTaskList(w http.ResponseWriter, tasks []internal.Task, csrfToken string, liveUpdates bool) error {
 	logger.Info.Printf("Rendering Task List")
 	data := TaskListData{
-		Tasks: tasks,
+		Tasks:        tasks,
+		SingleSignOn: r.SingleSignOn,
+		CSRFToken:    csrfToken,
+		LiveUpdates:  liveUpdates,
 	}
 
 	err := r.templates.ExecuteTemplate(w, "index.html", data)
 	return renderErrCheck(err)
 }
 
-func (r *TaskRenderer) RenderCreateForm(w http.ResponseWriter) error {
-	err := r.templates.ExecuteTemplate(w, "create.html", nil)
+func (r *TaskRenderer) RenderCreateForm(w http.ResponseWriter, csrfToken string) error {
+	data := struct {
+		CSRFToken string
+	}{
+		CSRFToken: csrfToken,
+	}
+	err := r.templates.ExecuteTemplate(w, "create.html", data)
 	return renderErrCheck(err)
 }
 
-func (r *TaskRenderer) RenderTaskUpdate(w http.ResponseWriter, task *internal.Task) error {
+func (r *TaskRenderer) RenderTaskUpdate(w http.ResponseWriter, task *internal.Task, csrfToken string) error {
 	logger.Info.Println("Rendering update task form")
 	data := struct {
-		Task *internal.Task
+		Task      *internal.Task
+		CSRFToken string
 	}{
-		Task: task,
+		Task:      task,
+		CSRFToken: csrfToken,
 	}
 	err := r.templates.ExecuteTemplate(w, "update.html", data)
 	return renderErrCheck(err)
 }
+
+func (r *TaskRenderer) RenderUserList(w http.ResponseWriter, accounts []users.Account, csrfToken string) error {
+	data := struct {
+		Accounts  []users.Account
+		CSRFToken string
+	}{
+		Accounts:  accounts,
+		CSRFToken: csrfToken,
+	}
+	err := r.templates.ExecuteTemplate(w, "users.html", data)
+	return renderErrCheck(err)
+}
+
+func (r *TaskRenderer) RenderUserEdit(w http.ResponseWriter, account users.Account, csrfToken string) error {
+	data := struct {
+		Account   users.Account
+		CSRFToken string
+	}{
+		Account:   account,
+		CSRFToken: csrfToken,
+	}
+	err := r.templates.ExecuteTemplate(w, "user.html", data)
+	return renderErrCheck(err)
+}
@@ -9,7 +9,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/zhekagigs/golang_todo/internal"
+	"github.com/zhekagigs/golang_todo/users"
 )
 
 func TestNewRenderer(t *testing.T) {
@@ -33,7 +35,7 @@ func TestTaskRenderer_RenderTaskList(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	err := renderer.RenderTaskList(w, tasks)
+	err := renderer.RenderTaskList(w, tasks, "csrf-123", true)
 	if err != nil {
 		t.Fatalf("RenderTaskList() error = %v", err)
 	}
@@ -48,6 +50,20 @@ func TestTaskRenderer_RenderTaskList(t *testing.T) {
 		if !strings.Contains(body, task.Msg) {
 			t.Errorf("RenderTaskList() body doesn't contain task message: %v", task.Msg)
 		}
+		if !strings.Contains(body, fmt.Sprintf(`data-task-id="%d"`, task.Id)) {
+			t.Errorf("RenderTaskList() row of task %d can't be found by live updates", task.Id)
+		}
+	}
+	if !strings.Contains(body, `new EventSource("/api/tasks/events")`) {
+		t.Error("RenderTaskList() doesn't listen to task events")
+	}
+	w = httptest.NewRecorder()
+	renderer.RenderTaskList(w, tasks, "csrf-123", false)
+	if strings.Contains(w.Body.String(), "EventSource") {
+		t.Error("RenderTaskList() listens to task events without live updates")
+	}
+	if !strings.Contains(body, `<meta name="csrf-token" content="csrf-123"`) {
+		t.Error("RenderTaskList() body doesn't contain the CSRF token")
 	}
 }
 
@@ -55,7 +71,7 @@ func TestTaskRenderer_RenderCreateForm(t *testing.T) {
 	renderer, _ := NewRenderer()
 
 	w := httptest.NewRecorder()
-	err := renderer.RenderCreateForm(w)
+	err := renderer.RenderCreateForm(w, "csrf-123")
 	if err != nil {
 		t.Fatalf("RenderCreateForm() error = %v", err)
 	}
@@ -69,6 +85,9 @@ func TestTaskRenderer_RenderCreateForm(t *testing.T) {
 	if !strings.Contains(body, "<form") || !strings.Contains(body, "create") {
 		t.Errorf("RenderCreateForm() body doesn't contain expected form elements")
 	}
+	if !strings.Contains(body, `<meta name="csrf-token" content="csrf-123"`) {
+		t.Error("RenderCreateForm() body doesn't contain the CSRF token")
+	}
 }
 
 func TestTaskRenderer_RenderTaskUpdate(t *testing.T) {
@@ -81,7 +100,7 @@ func TestTaskRenderer_RenderTaskUpdate(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	err := renderer.RenderTaskUpdate(w, task)
+	err := renderer.RenderTaskUpdate(w, task, "csrf-123")
 	if err != nil {
 		t.Fatalf("RenderTaskUpdate() error = %v", err)
 	}
@@ -95,6 +114,37 @@ func TestTaskRenderer_RenderTaskUpdate(t *testing.T) {
 	if !strings.Contains(body, task.Msg) || !strings.Contains(body, "update") {
 		t.Errorf("RenderTaskUpdate() body doesn't contain expected task details or update form")
 	}
+	if !strings.Contains(body, `name="csrf_token" value="csrf-123"`) {
+		t.Error("RenderTaskUpdate() form doesn't contain the CSRF token")
+	}
+}
+
+func TestTaskRenderer_RenderTaskChecklist(t *testing.T) {
+	renderer, _ := NewRenderer()
+	task := internal.Task{
+		Id:        7,
+		Msg:       "Brew Stout",
+		Category:  internal.Brewing,
+		PlannedAt: time.Now(),
+		Checklist: []internal.ChecklistItem{{Id: 1, Text: "Mash in", Done: true}, {Id: 2, Text: "Boil"}},
+	}
+
+	w := httptest.NewRecorder()
+	if err := renderer.RenderTaskList(w, []internal.Task{task}, "", false); err != nil {
+		t.Fatalf("RenderTaskList() error = %v", err)
+	}
+	if !strings.Contains(w.Body.String(), "(1/2)") {
+		t.Errorf("RenderTaskList() body doesn't contain checklist progress")
+	}
+
+	w = httptest.NewRecorder()
+	if err := renderer.RenderTaskUpdate(w, &task, ""); err != nil {
+		t.Fatalf("RenderTaskUpdate() error = %v", err)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Mash in") || !strings.Contains(body, `data-item="2"`) {
+		t.Errorf("RenderTaskUpdate() body doesn't contain checklist items")
+	}
 }
 
 func TestRenderErrCheck(t *testing.T) {
@@ -119,3 +169,48 @@ func TestRenderErrCheck(t *testing.T) {
 		})
 	}
 }
+
+func TestTaskRenderer_RenderSingleSignOn(t *testing.T) {
+	renderer, _ := NewRenderer()
+	for _, enabled := range []bool{false, true} {
+		renderer.SingleSignOn = enabled
+		w := httptest.NewRecorder()
+		if err := renderer.RenderTaskList(w, nil, "", false); err != nil {
+			t.Fatalf("RenderTaskList() error = %v", err)
+		}
+		if got := strings.Contains(w.Body.String(), `href="/login/oidc"`); got != enabled {
+			t.Errorf("RenderTaskList() single sign-on link = %v, want %v", got, enabled)
+		}
+	}
+}
+
+func TestTaskRenderer_RenderUserPages(t *testing.T) {
+	renderer, _ := NewRenderer()
+	jane := users.Account{
+		User:       users.User{UserName: "jane", UserId: uuid.New(), Role: users.RoleManager},
+		Profile:    users.Profile{DisplayName: "Jane Doe", Email: "jane@brewery.test"},
+		Active:     true,
+		Workspaces: users.Membership{"north", "south"},
+	}
+	joe := users.Account{User: users.User{UserName: "joe", UserId: uuid.New()}}
+
+	w := httptest.NewRecorder()
+	if err := renderer.RenderUserList(w, []users.Account{jane, joe}, "csrf-123"); err != nil {
+		t.Fatalf("RenderUserList() error = %v", err)
+	}
+	for _, want := range []string{"Jane Doe", "jane@brewery.test", "north, south", "Deactivated", "/admin/users/" + joe.UserId.String(), "csrf-123"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("RenderUserList() output missing %q", want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	if err := renderer.RenderUserEdit(w, jane, "csrf-123"); err != nil {
+		t.Fatalf("RenderUserEdit() error = %v", err)
+	}
+	for _, want := range []string{`value="Jane Doe"`, `<option value="manager" selected>`, "Deactivate", "/api/users/" + jane.UserId.String(), "csrf-123"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("RenderUserEdit() output missing %q", want)
+		}
+	}
+}